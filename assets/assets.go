@@ -0,0 +1,252 @@
+// Package assets serves static files under content-hashed names, so browsers
+// can cache them forever and still see a change the moment a file does.
+// Files are hashed and compressed with gzip and brotli once at startup.
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Hex characters of the SHA-256 put in a file's name.
+const hashLength = 12
+
+// Anything smaller isn't worth compressing.
+const minCompressSize = 512
+
+type variant struct {
+	encoding string
+	data     []byte
+}
+
+type asset struct {
+	name        string
+	hashedName  string
+	contentType string
+	etag        string
+	// The original first, then compressed variants in order of preference.
+	variants []variant
+}
+
+type Assets struct {
+	prefix string
+	// Assets by their plain and hashed names.
+	byName   map[string]*asset
+	byHashed map[string]*asset
+	// Set in development, files are served straight from disk.
+	dev http.Handler
+}
+
+// Hash and compress every file in fsys, to be served under prefix (like
+// "/static").
+func New(fsys fs.FS, prefix string) (*Assets, error) {
+	a := &Assets{
+		prefix:   strings.TrimSuffix(prefix, "/"),
+		byName:   make(map[string]*asset),
+		byHashed: make(map[string]*asset),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		as, err := newAsset(name, data)
+		if err != nil {
+			return fmt.Errorf("error preparing asset %s: %w", name, err)
+		}
+
+		a.byName[as.name] = as
+		a.byHashed[as.hashedName] = as
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error loading assets: %w", err)
+	}
+
+	return a, nil
+}
+
+// Serve files from dir on disk as they are, without hashed names or caching,
+// so edits show up on the next reload.
+func NewDev(dir, prefix string) *Assets {
+	return &Assets{
+		prefix: strings.TrimSuffix(prefix, "/"),
+		dev:    http.FileServer(http.Dir(dir)),
+	}
+}
+
+func newAsset(name string, data []byte) (*asset, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])[:hashLength]
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	as := &asset{
+		name:        name,
+		hashedName:  hashedName(name, hash),
+		contentType: contentType,
+		etag:        hash,
+		variants:    []variant{{data: data}},
+	}
+
+	if len(data) < minCompressSize || !compressible(contentType) {
+		return as, nil
+	}
+
+	var br bytes.Buffer
+	bw := brotli.NewWriterLevel(&br, brotli.BestCompression)
+	if _, err := bw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, err
+	}
+
+	var gz bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := gw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, v := range []variant{{"br", br.Bytes()}, {"gzip", gz.Bytes()}} {
+		if len(v.data) < len(data) {
+			as.variants = append(as.variants, v)
+		}
+	}
+
+	return as, nil
+}
+
+// "css/site.css" becomes "css/site.3f2a9c1b04de.css".
+func hashedName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "javascript") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "xml")
+}
+
+// The url to link to name with, including its hash. Unknown names get their
+// plain url so a typo shows up as a 404 rather than a panic.
+func (a *Assets) URL(name string) string {
+	name = strings.TrimPrefix(name, "/")
+
+	if as, ok := a.byName[name]; ok {
+		return a.prefix + "/" + as.hashedName
+	}
+
+	return a.prefix + "/" + name
+}
+
+// Serves requests under the prefix. Hashed names are cached for a year, plain
+// names (for links from outside, like team.txt) are revalidated every time.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutPrefix(r.URL.Path, a.prefix+"/")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if a.dev != nil {
+		w.Header().Set("Cache-Control", "no-cache")
+		http.StripPrefix(a.prefix, a.dev).ServeHTTP(w, r)
+		return
+	}
+
+	as, hashed := a.byHashed[name]
+	if hashed {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else if as, ok = a.byName[name]; ok {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		http.NotFound(w, r)
+		return
+	}
+
+	v := as.pick(r.Header.Get("Accept-Encoding"))
+
+	h := w.Header()
+	h.Set("Content-Type", as.contentType)
+	h.Add("Vary", "Accept-Encoding")
+	if v.encoding != "" {
+		h.Set("Content-Encoding", v.encoding)
+		h.Set("ETag", fmt.Sprintf(`"%s-%s"`, as.etag, v.encoding))
+	} else {
+		h.Set("ETag", fmt.Sprintf(`"%s"`, as.etag))
+	}
+
+	// The ETag is all a client needs to revalidate, so no modification time.
+	http.ServeContent(w, r, as.name, time.Time{}, bytes.NewReader(v.data))
+}
+
+// The best variant the client accepts, falling back to the original.
+func (as *asset) pick(acceptEncoding string) variant {
+	accepted := acceptedEncodings(acceptEncoding)
+
+	for _, v := range as.variants[1:] {
+		if accepted[v.encoding] {
+			return v
+		}
+	}
+
+	return as.variants[0]
+}
+
+// Encodings from an Accept-Encoding header, leaving out any with q=0.
+func acceptedEncodings(header string) map[string]bool {
+	accepted := make(map[string]bool)
+
+	for _, part := range strings.Split(header, ",") {
+		enc, params, _ := strings.Cut(part, ";")
+		enc = strings.ToLower(strings.TrimSpace(enc))
+		if enc == "" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		accepted[enc] = q > 0
+	}
+
+	return accepted
+}
@@ -0,0 +1,125 @@
+package assets_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/assets"
+)
+
+var css = strings.Repeat("body { color: black; }\n", 100)
+
+func testAssets(t *testing.T) *assets.Assets {
+	t.Helper()
+
+	a, err := assets.New(fstest.MapFS{
+		"site.css":  {Data: []byte(css)},
+		"team.txt":  {Data: []byte("goose\n")},
+		"img/a.png": {Data: []byte("\x89PNG\r\n\x1a\nnot really")},
+	}, "/static")
+	require.NoError(t, err)
+
+	return a
+}
+
+func get(a *assets.Assets, path string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestURLsAreHashed(t *testing.T) {
+	t.Parallel()
+	a := testAssets(t)
+
+	require.Regexp(t, regexp.MustCompile(`^/static/site\.[0-9a-f]{12}\.css$`), a.URL("site.css"))
+	require.Regexp(t, regexp.MustCompile(`^/static/img/a\.[0-9a-f]{12}\.png$`), a.URL("/img/a.png"))
+	require.Equal(t, "/static/missing.js", a.URL("missing.js"))
+}
+
+func TestServeHashedAssets(t *testing.T) {
+	t.Parallel()
+	a := testAssets(t)
+
+	rec := get(a, a.URL("site.css"))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, css, rec.Body.String())
+	require.Equal(t, "public, max-age=31536000, immutable", rec.Header().Get("Cache-Control"))
+	require.Equal(t, "text/css; charset=utf-8", rec.Header().Get("Content-Type"))
+	require.Empty(t, rec.Header().Get("Content-Encoding"))
+
+	rec = get(a, "/static/site.css")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+
+	rec = get(a, "/static/site.000000000000.css")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServePrecompressed(t *testing.T) {
+	t.Parallel()
+	a := testAssets(t)
+
+	rec := get(a, a.URL("site.css"), "Accept-Encoding", "gzip, deflate, br")
+	require.Equal(t, "br", rec.Header().Get("Content-Encoding"))
+	body, err := io.ReadAll(brotli.NewReader(rec.Body))
+	require.NoError(t, err)
+	require.Equal(t, css, string(body))
+
+	rec = get(a, a.URL("site.css"), "Accept-Encoding", "gzip, br;q=0")
+	require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	gr, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	body, err = io.ReadAll(gr)
+	require.NoError(t, err)
+	require.Equal(t, css, string(body))
+
+	// Too small, or not worth it.
+	rec = get(a, a.URL("team.txt"), "Accept-Encoding", "gzip, br")
+	require.Empty(t, rec.Header().Get("Content-Encoding"))
+	rec = get(a, a.URL("img/a.png"), "Accept-Encoding", "gzip, br")
+	require.Empty(t, rec.Header().Get("Content-Encoding"))
+}
+
+func TestRevalidate(t *testing.T) {
+	t.Parallel()
+	a := testAssets(t)
+
+	rec := get(a, a.URL("site.css"), "Accept-Encoding", "br")
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	rec = get(a, a.URL("site.css"), "Accept-Encoding", "br", "If-None-Match", etag)
+	require.Equal(t, http.StatusNotModified, rec.Code)
+
+	// A different encoding is a different representation.
+	rec = get(a, a.URL("site.css"), "Accept-Encoding", "gzip", "If-None-Match", etag)
+	require.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestDevServesFromDisk(t *testing.T) {
+	t.Parallel()
+
+	a := assets.NewDev("testdata", "/static")
+	require.Equal(t, "/static/hello.txt", a.URL("hello.txt"))
+
+	rec := get(a, "/static/hello.txt")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+	require.True(t, bytes.HasPrefix(rec.Body.Bytes(), []byte("hello")))
+}
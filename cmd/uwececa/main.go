@@ -15,7 +15,9 @@ import (
 	"uwece.ca/app/db"
 	"uwece.ca/app/mailer"
 	"uwece.ca/app/models"
+	"uwece.ca/app/oidc"
 	"uwece.ca/app/site"
+	"uwece.ca/app/utils"
 	"uwece.ca/app/utils/shutdown"
 )
 
@@ -47,18 +49,76 @@ func run(ctx context.Context) error {
 		slog.Info("started in development mode")
 	}
 
+	if cfg.Core.SecretKey == "" {
+		if !cfg.Core.Development {
+			return errors.New("UWECECA_SECRET_KEY must be set outside development")
+		}
+
+		cfg.Core.SecretKey = string(utils.NewToken())
+		slog.Warn("no secret key configured, signed links will stop working after a restart")
+	}
+
 	db, err := db.New(cfg.DB.Location)
 	if err != nil {
 		return fmt.Errorf("error opening db: %w", err)
 	}
 
+	// A plain go build leaves FTS5 out of sqlite, which the search migration
+	// needs. Say how to fix that before it fails.
+	if ok, err := models.SearchSupported(ctx, db); err != nil {
+		return fmt.Errorf("error checking for fts5: %w", err)
+	} else if !ok {
+		return errors.New("sqlite was built without fts5, which search needs: build with -tags sqlite_fts5")
+	}
+
 	if err := db.RunMigrations(models.Migrations); err != nil {
 		return fmt.Errorf("error running db migrations: %w", err)
 	}
 
-	mailer := mailer.New(cfg)
+	mailer, err := mailer.New(cfg)
+	if err != nil {
+		return fmt.Errorf("error setting up mailer: %w", err)
+	}
+
+	shutdown.AddFunc(func() {
+		if err := mailer.Close(); err != nil {
+			slog.Warn("failed to close mailer", "error", err)
+		}
+
+		slog.Debug("closed mailer")
+	})
 
-	mainsite := site.New(cfg, db, mailer)
+	var idp *oidc.Provider
+	if cfg.OIDC.Enabled {
+		idp, err = oidc.Discover(ctx, oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.Core.BaseURL() + "/login/oidc/callback",
+			Scopes:       cfg.OIDC.Scopes,
+		})
+		if err != nil {
+			return fmt.Errorf("error setting up oidc provider: %w", err)
+		}
+
+		slog.Info("oidc login enabled", "issuer", idp.Issuer())
+	}
+
+	mainsite, err := site.New(cfg, db, mailer, idp)
+	if err != nil {
+		return fmt.Errorf("error setting up site: %w", err)
+	}
+
+	mainsite.StartWorkers(ctx)
+	shutdown.AddFunc(func() {
+		mainsite.StopWorkers()
+		slog.Debug("stopped background workers")
+	})
+	shutdown.AddFunc(func() {
+		if err := mainsite.Close(); err != nil {
+			slog.Warn("failed to close site", "error", err)
+		}
+	})
 
 	startServer(mainsite.Routes(), cfg.Core.Addr)
 
@@ -2,14 +2,23 @@ package config
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+	_ "time/tzdata"
 
 	envconfig "github.com/sethvargo/go-envconfig"
 )
 
 type Config struct {
-	Core   Core   `env:",prefix=UWECECA_"`
-	DB     DB     `env:",prefix=UWECECA_DB_"`
-	Mailer Mailer `env:",prefix=UWECECA_MAILER_"`
+	Core     Core     `env:",prefix=UWECECA_"`
+	DB       DB       `env:",prefix=UWECECA_DB_"`
+	Mailer   Mailer   `env:",prefix=UWECECA_MAILER_"`
+	OIDC     OIDC     `env:",prefix=UWECECA_OIDC_"`
+	Password Password `env:",prefix=UWECECA_PASSWORD_"`
+	Inbound  Inbound  `env:",prefix=UWECECA_INBOUND_"`
 }
 
 type Core struct {
@@ -17,6 +26,62 @@ type Core struct {
 	Addr        string `env:"ADDR,default=localhost:3000"`
 	BaseDomain  string `env:"DOMAIN,default=localhost:3000"`
 	EmailDomain string `env:"EMAIL_DOMAIN,default=connect.uwaterloo.ca"`
+
+	// NetIDs allowed into the admin console.
+	Admins []string `env:"ADMINS"`
+
+	// Key for signing links sent to users, such as unsubscribe links, and the
+	// Message-IDs bounce reports are checked against. Required outside
+	// development, where a random key is used when unset so links stop working
+	// after a restart.
+	SecretKey string `env:"SECRET_KEY"`
+
+	// Where uploaded files are stored, named by their SHA-256.
+	MediaDir string `env:"MEDIA_DIR,default=media"`
+	// Bytes of uploads a site can store, resized copies included. Sites can
+	// be given their own quota in the db.
+	MediaQuota int64 `env:"MEDIA_QUOTA,default=104857600"`
+	// Largest single upload, in bytes.
+	MaxUploadSize int64 `env:"MAX_UPLOAD_SIZE,default=10485760"`
+
+	// The zone times typed in by users are in, such as when a post goes live.
+	TimeZone string `env:"TIME_ZONE,default=America/Toronto"`
+
+	// How many revisions of a site's home page, navbar and stylesheet are
+	// kept, and for how long. The live version is always kept, and 0 turns
+	// either limit off.
+	RevisionLimit  int           `env:"REVISION_LIMIT,default=50"`
+	RevisionMaxAge time.Duration `env:"REVISION_MAX_AGE,default=2160h"`
+}
+
+func (c Core) IsAdmin(netID string) bool {
+	return slices.Contains(c.Admins, netID)
+}
+
+// The zone for TimeZone, UTC if it isn't one (Load refuses those).
+func (c Core) Location() *time.Location {
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// The url of the main site, without a trailing slash.
+func (c Core) BaseURL() string {
+	scheme := "https"
+	if c.Development {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s", scheme, c.BaseDomain)
+}
+
+// The url of a student's site, without a trailing slash.
+func (c Core) SiteURL(subdomain string) string {
+	scheme, domain, _ := strings.Cut(c.BaseURL(), "://")
+	return fmt.Sprintf("%s://%s.%s", scheme, subdomain, domain)
 }
 
 type DB struct {
@@ -24,11 +89,88 @@ type DB struct {
 }
 
 type Mailer struct {
-	Host        string `env:"HOST,default=localhost"`
-	Port        int    `env:"PORT,default=1025"`
-	Username    string `env:"USERNAME,required"`
-	Password    string `env:"PASSWORD,required"`
-	FromAddress string `env:"FROM_ADDR,required"`
+	// One of smtp, file or memory.
+	Transport   string `env:"TRANSPORT,default=smtp"`
+	FromAddress string `env:"FROM_ADDR,default=noreply@uwece.ca"`
+
+	Host     string `env:"HOST,default=localhost"`
+	Port     int    `env:"PORT,default=1025"`
+	Username string `env:"USERNAME"`
+	Password string `env:"PASSWORD"`
+	// One of none, opportunistic, starttls or implicit.
+	TLS string `env:"TLS,default=opportunistic"`
+	// SMTP connections are kept open and shared between sends.
+	MaxConns    int           `env:"MAX_CONNS,default=4"`
+	IdleTimeout time.Duration `env:"IDLE_TIMEOUT,default=30s"`
+
+	// Most broadcast emails sent per second.
+	BroadcastRate int `env:"BROADCAST_RATE,default=5"`
+
+	// Maildir that bounces and spam complaints are delivered to, checked every
+	// BOUNCE_POLL_INTERVAL. Bounce processing is off when unset.
+	BounceMaildir      string        `env:"BOUNCE_MAILDIR"`
+	BouncePollInterval time.Duration `env:"BOUNCE_POLL_INTERVAL,default=1m"`
+
+	// Where the file transport writes .eml files.
+	OutboxDir string `env:"OUTBOX_DIR,default=outbox"`
+
+	// Outgoing mail is DKIM signed when a domain, selector and key are all set.
+	// The key is a PEM encoded RSA or Ed25519 private key, DKIM_KEY_FILE takes precedence.
+	DKIMDomain   string `env:"DKIM_DOMAIN"`
+	DKIMSelector string `env:"DKIM_SELECTOR"`
+	DKIMKey      string `env:"DKIM_KEY"`
+	DKIMKeyFile  string `env:"DKIM_KEY_FILE"`
+}
+
+func (m Mailer) DKIMEnabled() bool {
+	return m.DKIMDomain != "" && m.DKIMSelector != "" && m.DKIMKey != ""
+}
+
+type OIDC struct {
+	Enabled      bool     `env:"ENABLED,default=false"`
+	Issuer       string   `env:"ISSUER"`
+	ClientID     string   `env:"CLIENT_ID"`
+	ClientSecret string   `env:"CLIENT_SECRET"`
+	Scopes       []string `env:"SCOPES,default=openid,profile,email"`
+
+	// Claim holding the user's NetID, anything after an @ is dropped.
+	NetIDClaim string `env:"NETID_CLAIM,default=preferred_username"`
+
+	// Users signing in through the provider have already proven they own their NetID.
+	AutoVerifyUsers bool `env:"AUTO_VERIFY_USERS,default=true"`
+	AutoVerifySites bool `env:"AUTO_VERIFY_SITES,default=false"`
+}
+
+// Argon2id parameters, changing them rehashes passwords as users log in.
+type Password struct {
+	MemoryKiB   uint32 `env:"MEMORY_KIB,default=65536"`
+	Iterations  uint32 `env:"ITERATIONS,default=3"`
+	Parallelism uint8  `env:"PARALLELISM,default=4"`
+
+	// Secret mixed into every password before hashing, and kept out of the database.
+	// PEPPER_FILE takes precedence so the pepper can come from a mounted secret.
+	Pepper     string `env:"PEPPER"`
+	PepperFile string `env:"PEPPER_FILE"`
+
+	// Extra passwords to refuse at signup, one per line, either plaintext or SHA-1 hex.
+	DenyListFile string `env:"DENY_LIST_FILE"`
+}
+
+// Incoming mail, for posting to a blog by email.
+type Inbound struct {
+	// The listener is off when unset, such as localhost:2525.
+	Addr string `env:"ADDR"`
+	// One of smtp or lmtp, LMTP suits being handed mail by a local MTA.
+	Protocol string `env:"PROTOCOL,default=lmtp"`
+	// Sites get a secret address at this domain.
+	Domain string `env:"DOMAIN,default=post.uwece.ca"`
+	// Largest message accepted, in bytes.
+	MaxSize int `env:"MAX_SIZE,default=26214400"`
+	// The authserv-id the MTA handing us mail puts in its Authentication-Results
+	// headers, such as mx.uwece.ca. When set, posts need a DKIM or DMARC pass
+	// from it for the email domain. Unset, the sender check can be forged and
+	// the secret address is the only thing keeping others from posting.
+	AuthServID string `env:"AUTHSERV_ID"`
 }
 
 func Load(ctx context.Context) (*Config, error) {
@@ -37,5 +179,27 @@ func Load(ctx context.Context) (*Config, error) {
 		return nil, err
 	}
 
+	if _, err := time.LoadLocation(cfg.Core.TimeZone); err != nil {
+		return nil, fmt.Errorf("error loading time zone: %w", err)
+	}
+
+	if cfg.Password.PepperFile != "" {
+		pepper, err := os.ReadFile(cfg.Password.PepperFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading password pepper: %w", err)
+		}
+
+		cfg.Password.Pepper = strings.TrimSpace(string(pepper))
+	}
+
+	if cfg.Mailer.DKIMKeyFile != "" {
+		key, err := os.ReadFile(cfg.Mailer.DKIMKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading dkim key: %w", err)
+		}
+
+		cfg.Mailer.DKIMKey = string(key)
+	}
+
 	return &cfg, nil
 }
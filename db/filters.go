@@ -44,6 +44,12 @@ func FilterIs(key string, arg any) Filter    { return newFilter(key, "is", arg)
 func FilterIsNot(key string, arg any) Filter { return newFilter(key, "is not", arg) }
 func FilterIn(key string, arg any) Filter    { return newFilter(key, "in", arg) }
 
+// A sql like pattern. % and _ in arg are wildcards, escape user input first.
+func FilterLike(key string, arg string) Filter { return newFilter(key, "like", arg) }
+
+// A full text query against an fts table (the key), in its query syntax.
+func FilterMatch(key string, arg string) Filter { return newFilter(key, "match", arg) }
+
 func (f Filter) Condition() string {
 	rv := reflect.ValueOf(f.arg)
 	kind := rv.Kind()
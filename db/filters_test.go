@@ -59,3 +59,29 @@ func TestBuildWhereReturnsEmptyWhenFiltersEmpty(t *testing.T) {
 	require.Equal(t, "", where)
 	require.Empty(t, args)
 }
+
+func TestFilterConditionCorrectForLike(t *testing.T) {
+	t.Parallel()
+
+	filter := db.FilterLike("subdomain", "%.28")
+
+	require.Equal(t, "subdomain like ?", filter.Condition())
+	require.Equal(t, []any{"%.28"}, filter.Arg())
+}
+
+func TestFilterConditionCorrectForMatch(t *testing.T) {
+	t.Parallel()
+
+	filter := db.FilterMatch("posts_fts", `"goose"*`)
+
+	require.Equal(t, "posts_fts match ?", filter.Condition())
+	require.Equal(t, []any{`"goose"*`}, filter.Arg())
+}
+
+func TestPageNumber(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, db.Page{Limit: 20, Offset: 0}, db.PageNumber(1, 20))
+	require.Equal(t, db.Page{Limit: 20, Offset: 40}, db.PageNumber(3, 20))
+	require.Equal(t, db.Page{Limit: 20, Offset: 0}, db.PageNumber(0, 20))
+}
@@ -0,0 +1,20 @@
+package db
+
+// A window of rows, for queries that page through results.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// The page number n (from 1) of size rows each.
+func PageNumber(n, size int) Page {
+	if n < 1 {
+		n = 1
+	}
+
+	return Page{Limit: size, Offset: (n - 1) * size}
+}
+
+func BuildPage(p Page) (string, []any) {
+	return " limit ? offset ?", []any{p.Limit, p.Offset}
+}
@@ -0,0 +1,169 @@
+// Package diff compares two texts line by line, for showing what changed
+// between revisions of a page.
+package diff
+
+import "strings"
+
+// What happened to a line going from the old text to the new one.
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+	// A run of unchanged lines left out by Compact.
+	Skip Op = "skip"
+)
+
+type Line struct {
+	Op   Op
+	Text string
+	// Where the line is in the old and new texts, counting from 1. Zero on the
+	// side it isn't in.
+	Old int
+	New int
+	// For Skip, how many lines were left out.
+	Skipped int
+}
+
+// Most lines old times lines new compared one by one, past that the changed
+// middle of the texts is shown as all removed and all added. Keeps the
+// comparison table to a few MB.
+const maxCells = 4_000_000
+
+// The lines of the old text a and the new text b, in order, with the fewest
+// inserts and deletes that turn one into the other.
+func Lines(a, b string) []Line {
+	old, new := split(a), split(b)
+
+	// Edits tend to be small, so the common start and end are taken off
+	// first and only what's between them is compared.
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]Line, 0, len(old)+len(new))
+	for i := range prefix {
+		lines = append(lines, Line{Op: Equal, Text: old[i], Old: i + 1, New: i + 1})
+	}
+
+	lines = appendMiddle(lines, old[prefix:len(old)-suffix], new[prefix:len(new)-suffix], prefix)
+
+	for i := range suffix {
+		o, n := len(old)-suffix+i, len(new)-suffix+i
+		lines = append(lines, Line{Op: Equal, Text: old[o], Old: o + 1, New: n + 1})
+	}
+
+	return lines
+}
+
+// Compares the changed middle of the texts, which starts offset lines in.
+func appendMiddle(lines []Line, old, new []string, offset int) []Line {
+	n, m := len(old), len(new)
+
+	if n*m > maxCells {
+		for i, text := range old {
+			lines = append(lines, Line{Op: Delete, Text: text, Old: offset + i + 1})
+		}
+		for j, text := range new {
+			lines = append(lines, Line{Op: Insert, Text: text, New: offset + j + 1})
+		}
+
+		return lines
+	}
+
+	// lcs[i][j] is the longest common subsequence of old[i:] and new[j:].
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && old[i] == new[j]:
+			lines = append(lines, Line{Op: Equal, Text: old[i], Old: offset + i + 1, New: offset + j + 1})
+			i++
+			j++
+		case j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, Line{Op: Delete, Text: old[i], Old: offset + i + 1})
+			i++
+		default:
+			lines = append(lines, Line{Op: Insert, Text: new[j], New: offset + j + 1})
+			j++
+		}
+	}
+
+	return lines
+}
+
+// Keep the changes and up to context unchanged lines either side of them,
+// longer runs of unchanged lines become a single Skip.
+func Compact(lines []Line, context int) []Line {
+	keep := make([]bool, len(lines))
+	for i, l := range lines {
+		if l.Op == Equal {
+			continue
+		}
+
+		for k := max(i-context, 0); k <= min(i+context, len(lines)-1); k++ {
+			keep[k] = true
+		}
+	}
+
+	var out []Line
+	for i := 0; i < len(lines); {
+		if keep[i] {
+			out = append(out, lines[i])
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(lines) && !keep[i] {
+			i++
+		}
+		out = append(out, Line{Op: Skip, Skipped: i - start})
+	}
+
+	return out
+}
+
+// How many lines were added and removed.
+func Count(lines []Line) (added, removed int) {
+	for _, l := range lines {
+		switch l.Op {
+		case Insert:
+			added++
+		case Delete:
+			removed++
+		}
+	}
+
+	return added, removed
+}
+
+// Lines without their endings. A final line ending doesn't start another
+// line, and Windows line endings count the same as Unix ones.
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,78 @@
+package diff_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/diff"
+)
+
+// Lines as "+text", "-text", " text" or "@n" for a skip.
+func render(lines []diff.Line) []string {
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		switch l.Op {
+		case diff.Insert:
+			out[i] = "+" + l.Text
+		case diff.Delete:
+			out[i] = "-" + l.Text
+		case diff.Skip:
+			out[i] = "@" + strings.Repeat(".", l.Skipped)
+		default:
+			out[i] = " " + l.Text
+		}
+	}
+
+	return out
+}
+
+func TestLines(t *testing.T) {
+	t.Parallel()
+
+	lines := diff.Lines("a\nb\nc\nd\n", "a\nc\nd2\nd\ne")
+	require.Equal(t, []string{" a", "-b", " c", "+d2", " d", "+e"}, render(lines))
+
+	// Numbered on each side they're on.
+	require.Equal(t, diff.Line{Op: diff.Delete, Text: "b", Old: 2}, lines[1])
+	require.Equal(t, diff.Line{Op: diff.Insert, Text: "d2", New: 3}, lines[3])
+	require.Equal(t, diff.Line{Op: diff.Equal, Text: "d", Old: 4, New: 4}, lines[4])
+
+	added, removed := diff.Count(lines)
+	require.Equal(t, 2, added)
+	require.Equal(t, 1, removed)
+
+	require.Equal(t, []string{"+x"}, render(diff.Lines("", "x\n")))
+	require.Equal(t, []string{"-x"}, render(diff.Lines("x", "")))
+	require.Empty(t, diff.Lines("", ""))
+	require.Equal(t, []string{" same"}, render(diff.Lines("same\r\n", "same\n")))
+}
+
+func TestLinesTooLongToCompare(t *testing.T) {
+	t.Parallel()
+
+	var a, b strings.Builder
+	for i := range 3000 {
+		a.WriteString(strings.Repeat("a", i%7+1) + "\n")
+		b.WriteString(strings.Repeat("b", i%5+1) + "\n")
+	}
+
+	lines := diff.Lines("top\n"+a.String()+"end", "top\n"+b.String()+"end")
+	added, removed := diff.Count(lines)
+	require.Equal(t, 3000, added)
+	require.Equal(t, 3000, removed)
+	require.Equal(t, diff.Equal, lines[0].Op)
+	require.Equal(t, diff.Equal, lines[len(lines)-1].Op)
+}
+
+func TestCompact(t *testing.T) {
+	t.Parallel()
+
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10"
+	b := "1\n2\n3\n4\n5\nfive\n7\n8\n9\n10"
+
+	lines := diff.Compact(diff.Lines(a, b), 2)
+	require.Equal(t, []string{"@...", " 4", " 5", "-6", "+five", " 7", " 8", "@.."}, render(lines))
+
+	require.Equal(t, []string{"@..."}, render(diff.Compact(diff.Lines("x\ny\nz", "x\ny\nz"), 3)))
+}
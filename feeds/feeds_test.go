@@ -0,0 +1,246 @@
+package feeds_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/feeds"
+)
+
+var (
+	published = time.Date(2025, 3, 1, 12, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	edited    = published.Add(48 * time.Hour)
+)
+
+func testFeed() feeds.Feed {
+	return feeds.Feed{
+		Title:       "Goose",
+		Description: "Posts by Goose",
+		Link:        "https://goose.28.uwece.ca",
+		FeedURL:     "https://goose.28.uwece.ca/feed.xml",
+		Author:      "Goose",
+		Updated:     edited,
+		Items: []feeds.Item{
+			{
+				Title:     "Fish & <Chips>",
+				Link:      "https://goose.28.uwece.ca/posts/fish-chips",
+				Content:   "<p>Tasty &amp; crispy</p>",
+				Published: published,
+				Updated:   edited,
+			},
+			{
+				Title:     "First",
+				Link:      "https://goose.28.uwece.ca/posts/first",
+				Content:   "<p>Hello</p>",
+				Published: published.Add(-time.Hour),
+				Updated:   published.Add(-time.Hour),
+			},
+		},
+	}
+}
+
+// Fails on anything that isn't well formed XML.
+func wellFormed(t *testing.T, doc []byte) {
+	t.Helper()
+
+	require.True(t, bytes.HasPrefix(doc, []byte(`<?xml version="1.0" encoding="UTF-8"?>`)))
+
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return
+		}
+		require.NoError(t, err)
+	}
+}
+
+func absoluteURL(t *testing.T, s string) {
+	t.Helper()
+
+	u, err := url.Parse(s)
+	require.NoError(t, err)
+	require.True(t, u.IsAbs() && u.Host != "", "%q is not absolute", s)
+}
+
+type rssDoc struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title         string `xml:"title"`
+		Description   string `xml:"description"`
+		LastBuildDate string `xml:"lastBuildDate"`
+		// Both the RSS link and atom:link.
+		Links []struct {
+			XMLName xml.Name
+			Href    string `xml:"href,attr"`
+			Rel     string `xml:"rel,attr"`
+			Value   string `xml:",chardata"`
+		} `xml:"link"`
+		Items []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			PubDate     string `xml:"pubDate"`
+			GUID        struct {
+				IsPermaLink string `xml:"isPermaLink,attr"`
+				Value       string `xml:",chardata"`
+			} `xml:"guid"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// The required elements and formats from the RSS 2.0 specification
+// (https://www.rssboard.org/rss-specification).
+func TestRSS(t *testing.T) {
+	t.Parallel()
+
+	out, err := feeds.RSS(testFeed())
+	require.NoError(t, err)
+	wellFormed(t, out)
+
+	var doc rssDoc
+	require.NoError(t, xml.Unmarshal(out, &doc))
+	require.Equal(t, "2.0", doc.Version)
+
+	ch := doc.Channel
+	require.Equal(t, "Goose", ch.Title)
+	require.Equal(t, "Posts by Goose", ch.Description)
+
+	// Dates are RFC 822, which RFC1123Z is the four digit year form of.
+	built, err := time.Parse(time.RFC1123Z, ch.LastBuildDate)
+	require.NoError(t, err)
+	require.True(t, built.Equal(edited))
+
+	require.Len(t, ch.Links, 2)
+	for _, l := range ch.Links {
+		switch l.XMLName.Space {
+		case "":
+			absoluteURL(t, l.Value)
+		case "http://www.w3.org/2005/Atom":
+			require.Equal(t, "self", l.Rel)
+			require.Equal(t, "https://goose.28.uwece.ca/feed.xml", l.Href)
+		default:
+			t.Fatalf("unexpected link namespace %q", l.XMLName.Space)
+		}
+	}
+
+	require.Len(t, ch.Items, 2)
+	for _, it := range ch.Items {
+		// An item needs a title or a description.
+		require.NotEmpty(t, it.Title+it.Description)
+		absoluteURL(t, it.Link)
+
+		_, err := time.Parse(time.RFC1123Z, it.PubDate)
+		require.NoError(t, err)
+
+		require.Equal(t, "true", it.GUID.IsPermaLink)
+		require.Equal(t, it.Link, it.GUID.Value)
+	}
+
+	// Text and html survive escaping.
+	require.Equal(t, "Fish & <Chips>", ch.Items[0].Title)
+	require.Equal(t, "<p>Tasty &amp; crispy</p>", ch.Items[0].Description)
+}
+
+type atomDoc struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Authors []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Links   []atomLink `xml:"link"`
+	Entries []struct {
+		ID        string     `xml:"id"`
+		Title     string     `xml:"title"`
+		Updated   string     `xml:"updated"`
+		Published string     `xml:"published"`
+		Links     []atomLink `xml:"link"`
+		Content   struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"content"`
+	} `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+func rels(links []atomLink) map[string]string {
+	m := make(map[string]string)
+	for _, l := range links {
+		m[l.Rel] = l.Href
+	}
+
+	return m
+}
+
+// The requirements of RFC 4287.
+func TestAtom(t *testing.T) {
+	t.Parallel()
+
+	out, err := feeds.Atom(testFeed())
+	require.NoError(t, err)
+	wellFormed(t, out)
+
+	var doc atomDoc
+	require.NoError(t, xml.Unmarshal(out, &doc))
+
+	// atom:feed has exactly one id, title and updated, and an author when
+	// entries don't have their own.
+	absoluteURL(t, doc.ID)
+	require.Equal(t, "Goose", doc.Title)
+	updated, err := time.Parse(time.RFC3339, doc.Updated)
+	require.NoError(t, err)
+	require.True(t, updated.Equal(edited))
+	require.Len(t, doc.Authors, 1)
+	require.Equal(t, "Goose", doc.Authors[0].Name)
+
+	// Should have a self link, and at most one alternate per type.
+	links := rels(doc.Links)
+	require.Equal(t, "https://goose.28.uwece.ca/feed.xml", links["self"])
+	require.Equal(t, "https://goose.28.uwece.ca/", links["alternate"])
+
+	require.Len(t, doc.Entries, 2)
+	ids := make(map[string]bool)
+	for _, e := range doc.Entries {
+		absoluteURL(t, e.ID)
+		require.False(t, ids[e.ID], "entry ids must be unique")
+		ids[e.ID] = true
+
+		require.NotEmpty(t, e.Title)
+		_, err := time.Parse(time.RFC3339, e.Updated)
+		require.NoError(t, err)
+		_, err = time.Parse(time.RFC3339, e.Published)
+		require.NoError(t, err)
+
+		require.Equal(t, e.ID, rels(e.Links)["alternate"])
+		require.Equal(t, "html", e.Content.Type)
+	}
+
+	require.Equal(t, "Fish & <Chips>", doc.Entries[0].Title)
+	require.Equal(t, "<p>Tasty &amp; crispy</p>", doc.Entries[0].Content.Value)
+}
+
+func TestEmptyFeed(t *testing.T) {
+	t.Parallel()
+
+	f := testFeed()
+	f.Items = nil
+
+	for _, encode := range []func(feeds.Feed) ([]byte, error){feeds.RSS, feeds.Atom} {
+		out, err := encode(f)
+		require.NoError(t, err)
+		wellFormed(t, out)
+	}
+}
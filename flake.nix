@@ -22,10 +22,11 @@
           pkgs.nixos-shell
           pkgs.coreutils
           pkgs.gcc
-          pkgs.mailpit
         ];
 
         env.CGO_ENABLED = 1;
+        # Search needs sqlite's FTS5, which go-sqlite3 only builds with this tag.
+        env.GOFLAGS = "-tags=sqlite_fts5";
       };
     });
   };
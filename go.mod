@@ -3,6 +3,8 @@ module uwece.ca/app
 go 1.24.5
 
 require (
+	github.com/andybalholm/brotli v1.2.6
+	github.com/fsnotify/fsnotify v1.10.1
 	github.com/go-chi/chi/v5 v5.2.2
 	github.com/gorilla/schema v1.4.1
 	github.com/jmoiron/sqlx v1.4.0
@@ -10,6 +12,9 @@ require (
 	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/sethvargo/go-envconfig v1.3.0
 	github.com/stretchr/testify v1.10.0
+	github.com/yuin/goldmark v1.8.6
+	golang.org/x/image v0.25.0
+	golang.org/x/net v0.43.0
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
 )
 
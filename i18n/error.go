@@ -0,0 +1,33 @@
+package i18n
+
+import "errors"
+
+// An error meant for the user, kept as a message id and parameters so it can
+// be translated wherever it's shown. Error gives the default locale's text.
+type Error struct {
+	ID     string
+	Params []any
+}
+
+func NewError(id string, params ...any) *Error {
+	return &Error{ID: id, Params: params}
+}
+
+func (e *Error) Error() string {
+	return e.In(Default)
+}
+
+func (e *Error) In(locale string) string {
+	return T(locale, e.ID, e.Params...)
+}
+
+// The text to show the user for err in locale: the translation of the
+// *Error it wraps, or err's own text when it doesn't wrap one.
+func Message(locale string, err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.In(locale)
+	}
+
+	return err.Error()
+}
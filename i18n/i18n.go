@@ -0,0 +1,238 @@
+// Package i18n holds the message catalogs for every user-facing string. Each
+// locale is a JSON file in locales mapping a message id to its text, or to
+// "one" and "other" forms for messages that depend on a count. Text can
+// refer to parameters by name, like "Hello {name}".
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Used when nothing better matches, and the fallback for missing messages.
+const Default = "en"
+
+//go:embed locales/*.json
+var localesFS embed.FS
+
+var catalog = mustLoad(localesFS)
+
+// The parameter that picks between plural forms.
+const countParam = "count"
+
+type message struct {
+	One   string
+	Other string
+}
+
+func (m *message) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		m.Other = s
+		return nil
+	}
+
+	var forms struct {
+		One   string `json:"one"`
+		Other string `json:"other"`
+	}
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return errors.New("message must be a string or an object with one and other")
+	}
+	if forms.Other == "" {
+		return errors.New("plural message needs an other form")
+	}
+
+	m.One, m.Other = forms.One, forms.Other
+	return nil
+}
+
+// Whether n takes the "one" form, per locale. French counts zero as singular.
+var pluralRules = map[string]func(n int) bool{
+	"en": func(n int) bool { return n == 1 },
+	"fr": func(n int) bool { return n == 0 || n == 1 },
+}
+
+type Catalog struct {
+	locales  map[string]map[string]message
+	supports []string
+}
+
+func mustLoad(fsys fs.FS) *Catalog {
+	c, err := Load(fsys)
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// Read every locales/<locale>.json in fsys. The default locale must be one
+// of them.
+func Load(fsys fs.FS) (*Catalog, error) {
+	files, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		return nil, fmt.Errorf("error listing locales: %w", err)
+	}
+
+	c := &Catalog{locales: make(map[string]map[string]message)}
+	for _, file := range files {
+		locale := strings.TrimSuffix(path.Base(file), ".json")
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		messages := make(map[string]message)
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("error parsing locale %s: %w", locale, err)
+		}
+
+		c.locales[locale] = messages
+		c.supports = append(c.supports, locale)
+	}
+
+	if _, ok := c.locales[Default]; !ok {
+		return nil, fmt.Errorf("no catalog for the default locale %s", Default)
+	}
+	slices.Sort(c.supports)
+
+	return c, nil
+}
+
+// The locales with a catalog, sorted.
+func (c *Catalog) Locales() []string {
+	return c.supports
+}
+
+func (c *Catalog) Supports(locale string) bool {
+	_, ok := c.locales[locale]
+	return ok
+}
+
+// The ids of every message in locale.
+func (c *Catalog) IDs(locale string) []string {
+	var ids []string
+	for id := range c.locales[locale] {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	return ids
+}
+
+// Translate message id into locale. Params are name, value pairs; a "count"
+// picks the plural form. Messages missing from locale fall back to the
+// default locale, then to the id itself so the gap is visible.
+func (c *Catalog) T(locale, id string, params ...any) string {
+	m, ok := c.locales[locale][id]
+	if !ok {
+		locale = Default
+		if m, ok = c.locales[Default][id]; !ok {
+			return id
+		}
+	}
+
+	text := m.Other
+	if n, ok := count(params); ok && m.One != "" && isOne(locale, n) {
+		text = m.One
+	}
+
+	return interpolate(text, params)
+}
+
+func isOne(locale string, n int) bool {
+	if rule, ok := pluralRules[locale]; ok {
+		return rule(n)
+	}
+
+	return n == 1
+}
+
+func count(params []any) (int, bool) {
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i] != countParam {
+			continue
+		}
+
+		switch n := params[i+1].(type) {
+		case int:
+			return n, true
+		case int64:
+			return int(n), true
+		}
+	}
+
+	return 0, false
+}
+
+func interpolate(text string, params []any) string {
+	if len(params) < 2 || !strings.Contains(text, "{") {
+		return text
+	}
+
+	pairs := make([]string, 0, len(params))
+	for i := 0; i+1 < len(params); i += 2 {
+		pairs = append(pairs, "{"+fmt.Sprint(params[i])+"}", fmt.Sprint(params[i+1]))
+	}
+
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// Pick the best supported locale from an Accept-Language header, or the
+// default when none match. Region subtags are ignored, so fr-CA gets fr.
+func (c *Catalog) Match(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		lang, _, _ := strings.Cut(tag, "-")
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+
+		// Ties go to the earlier tag.
+		if q > bestQ && c.Supports(lang) {
+			best, bestQ = lang, q
+		}
+	}
+
+	return best
+}
+
+// The embedded catalog's locales.
+func Locales() []string {
+	return catalog.Locales()
+}
+
+func Supports(locale string) bool {
+	return catalog.Supports(locale)
+}
+
+// Translate with the embedded catalog, see Catalog.T. Templates call this as
+// t: {{ t .locale "nav.home" }}.
+func T(locale, id string, params ...any) string {
+	return catalog.T(locale, id, params...)
+}
+
+func Match(acceptLanguage string) string {
+	return catalog.Match(acceptLanguage)
+}
+
+// The catalog compiled into the binary.
+func Embedded() *Catalog {
+	return catalog
+}
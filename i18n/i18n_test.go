@@ -0,0 +1,165 @@
+package i18n_test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/i18n"
+)
+
+func catalog(t *testing.T) *i18n.Catalog {
+	t.Helper()
+
+	c, err := i18n.Load(fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{
+			"hello": "Hello {name}",
+			"posts": { "one": "{count} post", "other": "{count} posts" },
+			"only.en": "English only"
+		}`)},
+		"locales/fr.json": {Data: []byte(`{
+			"hello": "Bonjour {name}",
+			"posts": { "one": "{count} billet", "other": "{count} billets" }
+		}`)},
+	})
+	require.NoError(t, err)
+
+	return c
+}
+
+func TestTranslate(t *testing.T) {
+	t.Parallel()
+
+	c := catalog(t)
+	require.Equal(t, []string{"en", "fr"}, c.Locales())
+
+	require.Equal(t, "Hello Goose", c.T("en", "hello", "name", "Goose"))
+	require.Equal(t, "Bonjour Goose", c.T("fr", "hello", "name", "Goose"))
+	require.Equal(t, "Hello {name}", c.T("en", "hello"))
+
+	// Missing from fr falls back to en, missing everywhere gives the id.
+	require.Equal(t, "English only", c.T("fr", "only.en"))
+	require.Equal(t, "no.such.message", c.T("fr", "no.such.message"))
+	require.Equal(t, "Hello Goose", c.T("de", "hello", "name", "Goose"))
+}
+
+func TestPlural(t *testing.T) {
+	t.Parallel()
+
+	c := catalog(t)
+
+	require.Equal(t, "0 posts", c.T("en", "posts", "count", 0))
+	require.Equal(t, "1 post", c.T("en", "posts", "count", 1))
+	require.Equal(t, "2 posts", c.T("en", "posts", "count", 2))
+
+	// French treats zero as singular.
+	require.Equal(t, "0 billet", c.T("fr", "posts", "count", 0))
+	require.Equal(t, "1 billet", c.T("fr", "posts", "count", 1))
+	require.Equal(t, "2 billets", c.T("fr", "posts", "count", 2))
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := i18n.Load(fstest.MapFS{
+		"locales/fr.json": {Data: []byte(`{}`)},
+	})
+	require.Error(t, err, "no default locale")
+
+	_, err = i18n.Load(fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{ "posts": { "one": "{count} post" } }`)},
+	})
+	require.Error(t, err, "plural without other")
+}
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+
+	c := catalog(t)
+
+	for header, want := range map[string]string{
+		"":                             "en",
+		"fr":                           "fr",
+		"fr-CA,fr;q=0.9,en;q=0.8":      "fr",
+		"de-DE,fr;q=0.5,en;q=0.7":      "en",
+		"de-DE,fr;q=0.5":               "fr",
+		"en-GB,en;q=0.9":               "en",
+		"FR-ca":                        "fr",
+		"fr;q=0, en;q=0.1":             "en",
+		"de, *;q=0.5":                  "en",
+		"en;q=0.8, fr;q=0.8, de;q=0.9": "en",
+	} {
+		require.Equal(t, want, c.Match(header), header)
+	}
+}
+
+func TestError(t *testing.T) {
+	t.Parallel()
+
+	err := fmt.Errorf("%w: %w", errors.New("Validation Failed"), i18n.NewError("validation.netid_length", "min", 1, "max", 35))
+
+	require.Equal(t, "Validation Failed: Please provide a valid netID (1 - 35 characters in length).", err.Error())
+	require.Equal(t, "Veuillez fournir un NetID valide (de 1 à 35 caractères).", i18n.Message("fr", err))
+	require.Equal(t, "plain", i18n.Message("fr", errors.New("plain")))
+}
+
+var placeholder = regexp.MustCompile(`\{[a-z_]+\}`)
+
+func placeholders(text string) []string {
+	found := placeholder.FindAllString(text, -1)
+	slices.Sort(found)
+
+	return slices.Compact(found)
+}
+
+// Every locale translates every message, with the same parameters.
+func TestCatalogsComplete(t *testing.T) {
+	t.Parallel()
+
+	c := i18n.Embedded()
+	en := c.IDs(i18n.Default)
+
+	for _, locale := range c.Locales() {
+		require.Equal(t, en, c.IDs(locale), locale)
+
+		for _, id := range en {
+			require.Equal(t, placeholders(c.T(i18n.Default, id)), placeholders(c.T(locale, id)), "%s %s", locale, id)
+		}
+	}
+}
+
+var templateCall = regexp.MustCompile(`\{\{ t [.$a-z]+ "([a-z_.]+)"`)
+
+// Every message the site templates use is in the catalog.
+func TestTemplateMessagesExist(t *testing.T) {
+	t.Parallel()
+
+	ids := i18n.Embedded().IDs(i18n.Default)
+	fsys := os.DirFS("../site/templates")
+
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".html") {
+			return err
+		}
+
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range templateCall.FindAllStringSubmatch(string(data), -1) {
+			_, found := slices.BinarySearch(ids, m[1])
+			require.True(t, found, "%s uses unknown message %s", path, m[1])
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+}
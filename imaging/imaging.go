@@ -0,0 +1,269 @@
+// Package imaging cleans up uploaded images and makes smaller copies of them
+// for responsive pages, in pure Go.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnreadable = errors.New("image could not be read")
+	ErrTooLarge   = errors.New("image has too many pixels")
+)
+
+// Widths of the resized copies. Copies are only made narrower than the
+// original, never wider.
+var Widths = []int{480, 960, 1600}
+
+// Largest image decoded, so a small file can't claim a huge canvas and use up
+// the server's memory.
+const MaxPixels = 40_000_000
+
+const (
+	jpegQuality = 85
+	// For an original that has to be re-encoded to turn it upright.
+	uprightQuality = 92
+)
+
+// A resized copy of an image.
+type Variant struct {
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+type Image struct {
+	// The original, upright and without metadata like EXIF (camera, GPS
+	// location) or text comments.
+	Data   []byte
+	Width  int
+	Height int
+	// Narrowest first.
+	Variants []Variant
+}
+
+// Clean an image of contentType (image/jpeg, image/png, image/gif or
+// image/webp) and make its variants.
+func Process(data []byte, contentType string) (Image, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("%w: %w", ErrUnreadable, err)
+	}
+	if cfg.Width*cfg.Height > MaxPixels || cfg.Width <= 0 || cfg.Height <= 0 {
+		return Image{}, ErrTooLarge
+	}
+
+	img := Image{Width: cfg.Width, Height: cfg.Height}
+
+	orientation := 1
+	switch contentType {
+	case "image/jpeg":
+		orientation = jpegOrientation(data)
+		img.Data, err = stripJPEG(data)
+	case "image/png":
+		img.Data, err = stripPNG(data)
+	case "image/webp":
+		img.Data, err = stripWebP(data)
+	case "image/gif":
+		if img.Data, err = stripGIF(data); err != nil {
+			return Image{}, fmt.Errorf("%w: %w", ErrUnreadable, err)
+		}
+
+		img.Variants, err = gifVariants(img.Data, img.Width, img.Height)
+		if err != nil {
+			return Image{}, err
+		}
+
+		return img, nil
+	default:
+		return Image{}, fmt.Errorf("%w: unsupported type %s", ErrUnreadable, contentType)
+	}
+	if err != nil {
+		return Image{}, fmt.Errorf("%w: %w", ErrUnreadable, err)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("%w: %w", ErrUnreadable, err)
+	}
+
+	// The orientation tag went with the rest of the EXIF, so the pixels have
+	// to be turned to match.
+	if orientation > 1 {
+		src = orient(src, orientation)
+		img.Width, img.Height = src.Bounds().Dx(), src.Bounds().Dy()
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: uprightQuality}); err != nil {
+			return Image{}, fmt.Errorf("error encoding upright image: %w", err)
+		}
+		img.Data = buf.Bytes()
+	}
+
+	for _, w := range Widths {
+		if w >= img.Width {
+			break
+		}
+
+		v, err := resize(src, contentType, w, max(img.Height*w/img.Width, 1))
+		if err != nil {
+			return Image{}, err
+		}
+		img.Variants = append(img.Variants, v)
+	}
+
+	return img, nil
+}
+
+func resize(src image.Image, contentType string, width, height int) (Variant, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	v := Variant{Width: width, Height: height}
+
+	// There's no webp encoder in pure Go, so those become jpegs, or pngs when
+	// they have transparency to keep.
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/png" || (contentType == "image/webp" && !dst.Opaque()) {
+		v.ContentType = "image/png"
+		err = png.Encode(&buf, dst)
+	} else {
+		v.ContentType = "image/jpeg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return Variant{}, fmt.Errorf("error encoding %dpx variant: %w", width, err)
+	}
+	v.Data = buf.Bytes()
+
+	return v, nil
+}
+
+// GIFs are usually animated, so their variants are too. Every frame has to be
+// decoded and resized, so long or large animations only get the original.
+func gifVariants(data []byte, width, height int) ([]Variant, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnreadable, err)
+	}
+	if len(g.Image)*width*height > MaxPixels {
+		return nil, nil
+	}
+
+	var variants []Variant
+	for _, w := range Widths {
+		if w >= width {
+			break
+		}
+
+		v, err := resizeGIF(g, w, max(height*w/width, 1))
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, v)
+	}
+
+	return variants, nil
+}
+
+// Frames can cover only part of the image and build on the ones before, so
+// they're drawn as the animation would show them, and each resized copy is a
+// whole frame.
+func resizeGIF(g *gif.GIF, width, height int) (Variant, error) {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(bounds)
+
+	out := &gif.GIF{LoopCount: g.LoopCount, Delay: g.Delay}
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), canvas, bounds, draw.Src, nil)
+		pm := image.NewPaletted(scaled.Bounds(), frame.Palette)
+		draw.Draw(pm, pm.Bounds(), scaled, image.Point{}, draw.Src)
+
+		out.Image = append(out.Image, pm)
+		// Each frame is whole, so it replaces the last.
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, out); err != nil {
+		return Variant{}, fmt.Errorf("error encoding %dpx variant: %w", width, err)
+	}
+
+	return Variant{Width: width, Height: height, ContentType: "image/gif", Data: buf.Bytes()}, nil
+}
+
+// Turn an image by its EXIF orientation (2 - 8), so it displays upright
+// without the tag.
+func orient(src image.Image, orientation int) image.Image {
+	b := src.Bounds()
+	in := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(in, in.Bounds(), src, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	out := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored.
+				dx, dy = w-1-x, y
+			case 3: // Upside down.
+				dx, dy = w-1-x, h-1-y
+			case 4: // Mirrored and upside down.
+				dx, dy = x, h-1-y
+			case 5: // Mirrored and on its side.
+				dx, dy = y, x
+			case 6: // Turned a quarter counterclockwise.
+				dx, dy = h-1-y, x
+			case 7: // Mirrored and on its other side.
+				dx, dy = h-1-y, w-1-x
+			case 8: // Turned a quarter clockwise.
+				dx, dy = y, w-1-x
+			default:
+				dx, dy = x, y
+			}
+
+			copy(out.Pix[out.PixOffset(dx, dy):][:4], in.Pix[in.PixOffset(x, y):][:4])
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,270 @@
+package imaging_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/imaging"
+)
+
+// A w by h image, red on the left half and blue on the right.
+func halves(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+
+	return img
+}
+
+// An APP1 segment with an EXIF orientation, and a camera model so there's
+// something recognisable to look for.
+func exifSegment(orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("II")
+	_ = binary.Write(&tiff, binary.LittleEndian, uint16(42))
+	_ = binary.Write(&tiff, binary.LittleEndian, uint32(8))
+	_ = binary.Write(&tiff, binary.LittleEndian, uint16(1))
+	// Orientation, a SHORT with one value.
+	_ = binary.Write(&tiff, binary.LittleEndian, []uint16{0x0112, 3})
+	_ = binary.Write(&tiff, binary.LittleEndian, uint32(1))
+	_ = binary.Write(&tiff, binary.LittleEndian, []uint16{orientation, 0})
+	_ = binary.Write(&tiff, binary.LittleEndian, uint32(0))
+	tiff.WriteString("SECRET CAMERA")
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+func jpegWithEXIF(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+
+	// Right after the SOI marker.
+	data := buf.Bytes()
+	return append(append([]byte{0xFF, 0xD8}, exifSegment(orientation)...), data[2:]...)
+}
+
+func TestJPEGMetadataStripped(t *testing.T) {
+	t.Parallel()
+
+	data := jpegWithEXIF(t, halves(2000, 1000), 1)
+	require.Contains(t, string(data), "SECRET CAMERA")
+
+	img, err := imaging.Process(data, "image/jpeg")
+	require.NoError(t, err)
+	require.NotContains(t, string(img.Data), "SECRET CAMERA")
+	require.NotContains(t, string(img.Data), "Exif")
+
+	// Upright already, so only the metadata is cut out.
+	require.Equal(t, len(data)-len(exifSegment(1)), len(img.Data))
+	require.Equal(t, 2000, img.Width)
+	require.Equal(t, 1000, img.Height)
+
+	require.Len(t, img.Variants, len(imaging.Widths))
+	for i, v := range img.Variants {
+		require.Equal(t, imaging.Widths[i], v.Width)
+		require.Equal(t, v.Width/2, v.Height)
+		require.Equal(t, "image/jpeg", v.ContentType)
+
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(v.Data))
+		require.NoError(t, err)
+		require.Equal(t, v.Width, cfg.Width)
+		require.Equal(t, v.Height, cfg.Height)
+	}
+}
+
+func app2Segment(payload string) []byte {
+	segment := []byte{0xFF, 0xE2, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	return append(segment, payload...)
+}
+
+func TestJPEGSecondaryImagesStripped(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, halves(200, 100), nil))
+	primary := buf.Bytes()
+
+	// Laid out like a phone's HDR photo: an ICC profile, an MPF index, then the
+	// gain map after the primary image's EOI, with its own EXIF.
+	icc := app2Segment("ICC_PROFILE\x00\x01\x01colour profile")
+	data := append([]byte{0xFF, 0xD8}, icc...)
+	data = append(data, app2Segment("MPF\x00SECRET INDEX")...)
+	data = append(data, primary[2:]...)
+	data = append(data, jpegWithEXIF(t, halves(50, 50), 1)...)
+
+	img, err := imaging.Process(data, "image/jpeg")
+	require.NoError(t, err)
+	require.NotContains(t, string(img.Data), "SECRET CAMERA")
+	require.NotContains(t, string(img.Data), "SECRET INDEX")
+	require.Equal(t, append(append([]byte{0xFF, 0xD8}, icc...), primary[2:]...), img.Data)
+}
+
+func TestJPEGTurnedUpright(t *testing.T) {
+	t.Parallel()
+
+	// Orientation 6: the camera was on its side, display turned a quarter
+	// clockwise.
+	img, err := imaging.Process(jpegWithEXIF(t, halves(200, 100), 6), "image/jpeg")
+	require.NoError(t, err)
+	require.NotContains(t, string(img.Data), "SECRET CAMERA")
+	require.Equal(t, 100, img.Width)
+	require.Equal(t, 200, img.Height)
+
+	upright, err := jpeg.Decode(bytes.NewReader(img.Data))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 100, 200), upright.Bounds())
+
+	// The red left half is now the top.
+	r, _, b, _ := upright.At(50, 20).RGBA()
+	require.Greater(t, r, b)
+	r, _, b, _ = upright.At(50, 180).RGBA()
+	require.Greater(t, b, r)
+
+	// Too narrow for any variants.
+	require.Empty(t, img.Variants)
+}
+
+// Appends a tEXt chunk before IEND.
+func pngWithText(t *testing.T, img image.Image, text string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	data := buf.Bytes()
+	iend := data[len(data)-12:]
+
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)))
+	chunk = append(chunk, "tEXt"+text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	return append(append(append([]byte(nil), data[:len(data)-12]...), chunk...), iend...)
+}
+
+func TestPNGMetadataStripped(t *testing.T) {
+	t.Parallel()
+
+	data := pngWithText(t, halves(1000, 500), "Comment\x00SECRET CAMERA")
+	_, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	img, err := imaging.Process(data, "image/png")
+	require.NoError(t, err)
+	require.NotContains(t, string(img.Data), "SECRET CAMERA")
+
+	_, err = png.Decode(bytes.NewReader(img.Data))
+	require.NoError(t, err)
+
+	require.Len(t, img.Variants, 2)
+	require.Equal(t, "image/png", img.Variants[0].ContentType)
+	require.Equal(t, 960, img.Variants[1].Width)
+}
+
+// A two frame animation with a comment, an XMP packet and data past the end
+// of it.
+func gifWithMetadata(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	frames := []image.Image{halves(w, h), image.NewUniform(color.RGBA{G: 255, A: 255})}
+	g := &gif.GIF{LoopCount: 3}
+	for _, f := range frames {
+		pm := image.NewPaletted(image.Rect(0, 0, w, h), palette.Plan9)
+		draw.Draw(pm, pm.Bounds(), f, image.Point{}, draw.Src)
+		g.Image = append(g.Image, pm)
+		g.Delay = append(g.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, g))
+	data := buf.Bytes()
+
+	comment := []byte("\x21\xFE\x0DSECRET AUTHOR\x00")
+	xmp := []byte("\x21\xFF\x0BXMP DataXMP\x0DSECRET CAMERA\x00")
+
+	// Both go right after the screen descriptor, there's no global colour table.
+	out := append([]byte(nil), data[:13]...)
+	out = append(append(append(out, comment...), xmp...), data[13:]...)
+
+	return append(out, "SECRET TRAILER"...)
+}
+
+func TestGIFMetadataStripped(t *testing.T) {
+	t.Parallel()
+
+	data := gifWithMetadata(t, 1000, 10)
+	orig, err := gif.DecodeAll(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	img, err := imaging.Process(data, "image/gif")
+	require.NoError(t, err)
+	require.NotContains(t, string(img.Data), "SECRET")
+	require.Contains(t, string(img.Data), "NETSCAPE2.0")
+
+	// The frames themselves are untouched.
+	stripped, err := gif.DecodeAll(bytes.NewReader(img.Data))
+	require.NoError(t, err)
+	require.Equal(t, orig.Image, stripped.Image)
+	require.Equal(t, 3, stripped.LoopCount)
+
+	// The variants are animated too.
+	require.Len(t, img.Variants, 2)
+	for i, v := range img.Variants {
+		require.Equal(t, imaging.Widths[i], v.Width)
+		require.Equal(t, "image/gif", v.ContentType)
+
+		g, err := gif.DecodeAll(bytes.NewReader(v.Data))
+		require.NoError(t, err)
+		require.Len(t, g.Image, 2)
+		require.Equal(t, []int{10, 10}, g.Delay)
+		require.Equal(t, 3, g.LoopCount)
+		require.Equal(t, image.Rect(0, 0, v.Width, v.Height), g.Image[0].Bounds())
+
+		// The first frame's left half is red, the second frame's green.
+		r, gr, _, _ := g.Image[0].At(0, 0).RGBA()
+		require.Greater(t, r, gr)
+		r, gr, _, _ = g.Image[1].At(0, 0).RGBA()
+		require.Greater(t, gr, r)
+	}
+}
+
+func TestProcessRejects(t *testing.T) {
+	t.Parallel()
+
+	_, err := imaging.Process([]byte("not an image"), "image/png")
+	require.ErrorIs(t, err, imaging.ErrUnreadable)
+
+	// A header claiming a canvas far bigger than the file.
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, halves(1, 1)))
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:], 100_000)
+	binary.BigEndian.PutUint32(data[20:], 100_000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+
+	_, err = imaging.Process(data, "image/png")
+	require.ErrorIs(t, err, imaging.ErrTooLarge)
+}
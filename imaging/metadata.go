@@ -0,0 +1,344 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errTruncated = errors.New("truncated image")
+
+// Metadata is cut out of the files as they are, rather than decoding and
+// encoding them again, so the images themselves aren't touched.
+
+// JPEG segments that are kept: APP0 (JFIF), APP2 when it's an ICC colour
+// profile and APP14 (Adobe colour transform). Every other APPn segment and
+// comments carry metadata, EXIF and XMP in APP1, IPTC in APP13 and MPF in
+// APP2.
+func keepJPEGSegment(marker byte, segment []byte) bool {
+	switch {
+	case marker == 0xE2:
+		return len(segment) >= 4 && bytes.HasPrefix(segment[4:], []byte("ICC_PROFILE\x00"))
+	case marker == 0xE0, marker == 0xEE:
+		return true
+	case marker >= 0xE0 && marker <= 0xEF, marker == 0xFE:
+		return false
+	}
+
+	return true
+}
+
+// Calls fn with each marker and its whole segment (marker included), up to
+// the start of the image data, and returns where that is.
+func jpegSegments(data []byte, fn func(marker byte, segment []byte)) (int, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, errors.New("not a jpeg")
+	}
+
+	i := 2
+	for {
+		if i+2 > len(data) {
+			return 0, errTruncated
+		}
+		if data[i] != 0xFF {
+			return 0, errors.New("jpeg marker expected")
+		}
+
+		marker := data[i+1]
+		switch {
+		// Fill bytes before a marker.
+		case marker == 0xFF:
+			i++
+			continue
+		// Markers without a length.
+		case marker == 0x01, marker >= 0xD0 && marker <= 0xD7:
+			fn(marker, data[i:i+2])
+			i += 2
+			continue
+		// Start of scan, everything after is image data.
+		case marker == 0xDA:
+			return i, nil
+		}
+
+		if i+4 > len(data) {
+			return 0, errTruncated
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return 0, errTruncated
+		}
+
+		fn(marker, data[i:end])
+		i = end
+	}
+}
+
+// Phones put secondary images (MPF, HDR gain maps) after the primary one's
+// EOI, each a whole JPEG with metadata of its own, so they go too.
+func stripJPEG(data []byte) ([]byte, error) {
+	out := []byte{0xFF, 0xD8}
+	scan, err := jpegSegments(data, func(marker byte, segment []byte) {
+		if keepJPEGSegment(marker, segment) {
+			out = append(out, segment...)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append(out, data[scan:jpegEnd(data, scan)]...), nil
+}
+
+// Where the image that has its first scan at scan ends, just past its EOI, or
+// the end of data when there isn't one.
+func jpegEnd(data []byte, scan int) int {
+	for i := scan; i+1 < len(data); {
+		if data[i] != 0xFF {
+			i++
+			continue
+		}
+
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			i++
+		// Stuffed bytes in image data, and restart markers.
+		case marker == 0x00, marker >= 0xD0 && marker <= 0xD7:
+			i += 2
+		case marker == 0xD9:
+			return i + 2
+		// The segments between progressive scans, and the scans' own headers.
+		default:
+			if i+4 > len(data) {
+				return len(data)
+			}
+			i += 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		}
+	}
+
+	return len(data)
+}
+
+// The EXIF orientation tag (1 - 8), 1 (upright) when there isn't one.
+func jpegOrientation(data []byte) int {
+	orientation := 1
+	_, _ = jpegSegments(data, func(marker byte, segment []byte) {
+		if marker != 0xE1 || len(segment) < 4 {
+			return
+		}
+
+		if tiff, ok := bytes.CutPrefix(segment[4:], []byte("Exif\x00\x00")); ok {
+			if o := exifOrientation(tiff); o >= 1 && o <= 8 {
+				orientation = o
+			}
+		}
+	})
+
+	return orientation
+}
+
+// Finds tag 0x0112 in the first IFD of a TIFF structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := range count {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+
+		// A SHORT, which sits at the start of the value field.
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+
+	return 0
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Text, EXIF and timestamp chunks. Anything else might change how the image
+// looks, so it stays.
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a png")
+	}
+
+	out := append([]byte(nil), pngSignature...)
+	for i := len(pngSignature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, errTruncated
+		}
+
+		// Length, type, data and a crc.
+		end := i + 12 + int(binary.BigEndian.Uint32(data[i:]))
+		if end > len(data) || end < i+12 {
+			return nil, errTruncated
+		}
+
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	return out, nil
+}
+
+// Flags in a VP8X chunk saying EXIF or XMP chunks follow.
+const (
+	webpEXIFFlag = 0x08
+	webpXMPFlag  = 0x04
+)
+
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("not a webp")
+	}
+
+	out := append([]byte(nil), data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errTruncated
+		}
+
+		// Chunks are padded to an even length.
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2
+		if end > len(data) || end < i+8 {
+			return nil, errTruncated
+		}
+
+		switch fourCC := string(data[i : i+4]); fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= webpEXIFFlag | webpXMPFlag
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8)) //nolint:gosec // smaller than the upload
+
+	return out, nil
+}
+
+// GIF blocks.
+const (
+	gifExtension   = 0x21
+	gifImage       = 0x2C
+	gifTrailer     = 0x3B
+	gifComment     = 0xFE
+	gifApplication = 0xFF
+)
+
+// Comments and application extensions go, apart from NETSCAPE2.0 which says
+// how many times an animation loops. Others carry XMP and editors' data.
+func stripGIF(data []byte) ([]byte, error) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return nil, errors.New("not a gif")
+	}
+
+	// The header, the screen descriptor and the global colour table.
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	if i > len(data) {
+		return nil, errTruncated
+	}
+	out := append([]byte(nil), data[:i]...)
+
+	for {
+		if i >= len(data) {
+			return nil, errTruncated
+		}
+
+		start := i
+		switch data[i] {
+		case gifTrailer:
+			// Anything after it is no part of the image.
+			return append(out, gifTrailer), nil
+		case gifExtension:
+			if i+2 > len(data) {
+				return nil, errTruncated
+			}
+			label := data[i+1]
+
+			end, err := gifSubBlocks(data, i+2)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+
+			if label == gifComment || (label == gifApplication && !bytes.HasPrefix(data[start+2:end], []byte("\x0BNETSCAPE2.0"))) {
+				continue
+			}
+		case gifImage:
+			// The descriptor, a local colour table, and the LZW code size.
+			if i+10 > len(data) {
+				return nil, errTruncated
+			}
+			i += 10
+			if data[i-1]&0x80 != 0 {
+				i += 3 << (data[i-1]&0x07 + 1)
+			}
+			i++
+
+			end, err := gifSubBlocks(data, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		default:
+			return nil, errors.New("gif block expected")
+		}
+
+		out = append(out, data[start:i]...)
+	}
+}
+
+// Where the sub-blocks starting at i end, past their terminator.
+func gifSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errTruncated
+		}
+		if data[i] == 0 {
+			return i + 1, nil
+		}
+		i += 1 + int(data[i])
+	}
+}
@@ -0,0 +1,77 @@
+package inbound
+
+import "strings"
+
+// Report whether one of the Authentication-Results header values (RFC 8601)
+// added by the MTA named authservID shows the message was signed by domain:
+// a DKIM pass for it or a parent domain, or a DMARC pass for it. Results from
+// anyone else are ignored, senders can add their own.
+func AuthenticatedBy(results []string, authservID, domain string) bool {
+	for _, v := range results {
+		resinfos := strings.Split(stripComments(v), ";")
+
+		id := strings.Fields(resinfos[0])
+		if len(id) == 0 || !strings.EqualFold(id[0], authservID) {
+			continue
+		}
+
+		for _, resinfo := range resinfos[1:] {
+			fields := strings.Fields(resinfo)
+			if len(fields) == 0 {
+				continue
+			}
+
+			method, result, _ := strings.Cut(strings.ToLower(fields[0]), "=")
+			if result != "pass" {
+				continue
+			}
+
+			for _, prop := range fields[1:] {
+				key, value, _ := strings.Cut(prop, "=")
+				value = strings.ToLower(strings.Trim(value, `"`))
+				if _, d, ok := strings.Cut(value, "@"); ok {
+					value = d
+				}
+
+				switch {
+				case method == "dkim" && (strings.EqualFold(key, "header.d") || strings.EqualFold(key, "header.i")):
+					if aligned(domain, value) {
+						return true
+					}
+				case method == "dmarc" && strings.EqualFold(key, "header.from"):
+					if strings.EqualFold(domain, value) {
+						return true
+					}
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// Whether a signature for signer covers mail from domain, the same domain or
+// one of its parents.
+func aligned(domain, signer string) bool {
+	domain = strings.ToLower(domain)
+
+	return signer != "" && (domain == signer || strings.HasSuffix(domain, "."+signer))
+}
+
+// Drop (comments), which may nest.
+func stripComments(v string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range v {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package inbound_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/inbound"
+)
+
+func TestAuthenticatedBy(t *testing.T) {
+	t.Parallel()
+
+	const domain = "connect.uwaterloo.ca"
+
+	for _, c := range []struct {
+		results []string
+		want    bool
+	}{
+		{[]string{"mx.uwece.ca; dkim=pass (2048-bit key) header.d=connect.uwaterloo.ca header.s=sel; spf=pass"}, true},
+		// Relaxed alignment, the university signs for its subdomains.
+		{[]string{"mx.uwece.ca 1; dkim=pass header.d=uwaterloo.ca"}, true},
+		{[]string{"mx.uwece.ca; dkim=fail header.d=uwaterloo.ca; dmarc=pass (p=reject) header.from=connect.uwaterloo.ca"}, true},
+		{[]string{`mx.uwece.ca; dkim=pass header.i="@connect.uwaterloo.ca"`}, true},
+		{[]string{"mx.uwece.ca; dkim=fail header.d=connect.uwaterloo.ca"}, false},
+		{[]string{"mx.uwece.ca; dkim=pass header.d=notuwaterloo.ca"}, false},
+		{[]string{"mx.uwece.ca; dkim=pass header.d=evil.connect.uwaterloo.ca"}, false},
+		{[]string{"mx.uwece.ca; spf=pass smtp.mailfrom=connect.uwaterloo.ca"}, false},
+		// Added by the sender or someone else on the way, not our MTA.
+		{[]string{"mx.evil.com; dkim=pass header.d=connect.uwaterloo.ca"}, false},
+		{[]string{"mx.uwece.ca (dkim=pass header.d=connect.uwaterloo.ca); dkim=none"}, false},
+		{[]string{"mx.uwece.ca; none", "mx.uwece.ca; dkim=pass header.d=connect.uwaterloo.ca"}, true},
+		{nil, false},
+	} {
+		require.Equal(t, c.want, inbound.AuthenticatedBy(c.results, "mx.uwece.ca", domain), c.results)
+	}
+}
@@ -0,0 +1,169 @@
+package inbound
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// Deeper nesting than this is not something a mail client sends.
+const maxPartDepth = 10
+
+// The parts of an incoming message worth keeping.
+type Message struct {
+	// Address from the From header.
+	From    string
+	Subject string
+	// Every Authentication-Results header, whoever added them.
+	AuthResults []string
+
+	// The first text/plain and text/html bodies that aren't attachments.
+	Text string
+	HTML string
+
+	Attachments []Attachment
+}
+
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+var wordDecoder = mime.WordDecoder{}
+
+func ParseMessage(data []byte) (Message, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return Message{}, fmt.Errorf("error reading message: %w", err)
+	}
+
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		return Message{}, fmt.Errorf("error parsing from address: %w", err)
+	}
+
+	subject, err := wordDecoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+
+	m := Message{
+		From:        from.Address,
+		Subject:     strings.TrimSpace(subject),
+		AuthResults: msg.Header["Authentication-Results"],
+	}
+
+	header := textproto.MIMEHeader(msg.Header)
+	if err := m.walk(header, msg.Body, 0); err != nil {
+		return Message{}, err
+	}
+
+	return m, nil
+}
+
+func (m *Message) walk(header textproto.MIMEHeader, body io.Reader, depth int) error {
+	if depth > maxPartDepth {
+		return errors.New("message parts nested too deeply")
+	}
+
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// Treat what we can't make sense of as an opaque file.
+		mediaType, params = "application/octet-stream", nil
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("error reading message part: %w", err)
+			}
+
+			if err := m.walk(part.Header, part, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := io.ReadAll(decodeBody(header, body))
+	if err != nil {
+		return fmt.Errorf("error decoding %s part: %w", mediaType, err)
+	}
+
+	disposition, dparams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := dparams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if decoded, err := wordDecoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	switch {
+	case disposition != "attachment" && filename == "" && mediaType == "text/plain":
+		if m.Text == "" {
+			m.Text = string(data)
+		}
+	case disposition != "attachment" && filename == "" && mediaType == "text/html":
+		if m.HTML == "" {
+			m.HTML = string(data)
+		}
+	case len(data) > 0:
+		m.Attachments = append(m.Attachments, Attachment{
+			Filename:    filename,
+			ContentType: mediaType,
+			Data:        data,
+		})
+	}
+
+	return nil
+}
+
+func decodeBody(header textproto.MIMEHeader, body io.Reader) io.Reader {
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+
+	return body
+}
+
+// base64 bodies are wrapped at 76 characters, which the decoder does not expect.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		c, err := n.r.Read(p)
+		j := 0
+		for _, b := range p[:c] {
+			if b != '\r' && b != '\n' && b != ' ' && b != '\t' {
+				p[j] = b
+				j++
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
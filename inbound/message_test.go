@@ -0,0 +1,72 @@
+package inbound_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/inbound"
+)
+
+// Roughly what a phone mail client sends: alternative text and html bodies,
+// with a photo attached.
+const phoneMessage = `From: Goose <goose@connect.uwaterloo.ca>
+To: abc@post.uwece.ca
+Subject: =?UTF-8?Q?Caf=C3=A9_review?=
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/alternative; boundary="inner"
+
+--inner
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+The **coffee** was gr=
+eat.
+
+--inner
+Content-Type: text/html; charset=utf-8
+
+<p>The <b>coffee</b> was great.</p>
+
+--inner--
+
+--outer
+Content-Type: image/png; name="photo.png"
+Content-Disposition: attachment; filename="photo.png"
+Content-Transfer-Encoding: base64
+
+iVBORw0KGgo=
+
+--outer--
+`
+
+func TestParseMessage(t *testing.T) {
+	t.Parallel()
+
+	msg, err := inbound.ParseMessage([]byte(phoneMessage))
+	require.NoError(t, err)
+
+	require.Equal(t, "goose@connect.uwaterloo.ca", msg.From)
+	require.Equal(t, "Café review", msg.Subject)
+	require.Equal(t, "The **coffee** was great.\n", msg.Text)
+	require.Equal(t, "<p>The <b>coffee</b> was great.</p>\n", msg.HTML)
+
+	require.Len(t, msg.Attachments, 1)
+	require.Equal(t, "photo.png", msg.Attachments[0].Filename)
+	require.Equal(t, "image/png", msg.Attachments[0].ContentType)
+	require.Equal(t, []byte("\x89PNG\r\n\x1a\n"), msg.Attachments[0].Data)
+}
+
+func TestParsePlainMessage(t *testing.T) {
+	t.Parallel()
+
+	msg, err := inbound.ParseMessage([]byte("From: goose@connect.uwaterloo.ca\nSubject: Hi\n\nJust text.\n"))
+	require.NoError(t, err)
+	require.Equal(t, "Just text.\n", msg.Text)
+	require.Empty(t, msg.Attachments)
+
+	_, err = inbound.ParseMessage([]byte("Subject: No sender\n\nHi.\n"))
+	require.Error(t, err)
+}
@@ -0,0 +1,413 @@
+// Package inbound receives mail over SMTP or LMTP and hands each message to a
+// Handler. It implements just enough of RFC 5321 and RFC 2033 for a local MTA
+// (or a test) to deliver to it: no TLS, no AUTH, no relaying.
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrServerClosed = errors.New("inbound: server closed")
+
+	errLineTooLong = errors.New("inbound: line too long")
+	errTooBig      = errors.New("inbound: message too big")
+)
+
+const (
+	maxRecipients  = 100
+	commandTimeout = 5 * time.Minute
+	// Longest command line read, CRLF included. RFC 5321 allows 512, this
+	// leaves room for parameters.
+	maxLineLength = 1000
+	// How many times MaxSize of an oversized message is read and thrown away
+	// before giving up on the connection.
+	maxDrain = 4
+)
+
+// Decides what happens to incoming mail.
+type Handler interface {
+	// Called for each RCPT TO, returning an error refuses that recipient.
+	Recipient(ctx context.Context, from, to string) error
+	// Called once for each accepted recipient with the raw message, its
+	// line endings turned into \n.
+	Deliver(ctx context.Context, from, to string, data []byte) error
+}
+
+// Return an *Error from a Handler to choose the reply. Any other error is
+// reported as a temporary failure so the sender tries again later.
+type Error struct {
+	Code     int
+	Enhanced string
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s %s", e.Code, e.Enhanced, e.Message)
+}
+
+// A permanent failure, the sender won't retry.
+func Reject(enhanced, msg string) *Error {
+	return &Error{Code: 550, Enhanced: enhanced, Message: msg}
+}
+
+var errTemporary = &Error{Code: 451, Enhanced: "4.3.0", Message: "Temporary failure, please try again later"}
+
+type Server struct {
+	Handler Handler
+	// Name given in the greeting.
+	Hostname string
+	// Speak LMTP instead of SMTP: LHLO instead of EHLO, and one reply per
+	// recipient after DATA.
+	LMTP bool
+	// Largest message accepted, in bytes.
+	MaxSize int
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Accept connections on l until Close is called.
+func (s *Server) Serve(l net.Listener) error {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		cancel()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.cancel = cancel
+	s.conns = make(map[net.Conn]struct{})
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+
+			return err
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(ctx, conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Stop listening, drop open connections and wait for their handlers to return.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+		s.cancel()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// The state of one connection, reset by RSET and after each message.
+type session struct {
+	s *Server
+	// Buffers at most maxLineLength, so a line can't grow without bound.
+	br    *bufio.Reader
+	r     *textproto.Reader
+	w     *textproto.Writer
+	hello bool
+	from  *string
+	rcpts []string
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	br := bufio.NewReaderSize(conn, maxLineLength)
+	sess := &session{s: s, br: br, r: textproto.NewReader(br), w: textproto.NewWriter(bufio.NewWriter(conn))}
+
+	proto := "ESMTP"
+	if s.LMTP {
+		proto = "LMTP"
+	}
+	if err := sess.reply(220, "%s %s ready", s.Hostname, proto); err != nil {
+		return
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(commandTimeout))
+
+		line, err := sess.readLine()
+		if errors.Is(err, errLineTooLong) {
+			sess.reply(500, "5.5.2 Line too long")
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("inbound connection error", "remote", conn.RemoteAddr(), "error", err)
+			}
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		verb = strings.ToUpper(verb)
+
+		if verb == "QUIT" {
+			sess.reply(221, "2.0.0 Bye")
+			return
+		}
+
+		if err := sess.command(ctx, verb, strings.TrimSpace(arg)); err != nil {
+			return
+		}
+	}
+}
+
+// Read a command line, refusing one longer than maxLineLength.
+func (sess *session) readLine() (string, error) {
+	line, err := sess.br.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (sess *session) command(ctx context.Context, verb, arg string) error {
+	switch verb {
+	case "HELO", "EHLO", "LHLO":
+		if (verb == "LHLO") != sess.s.LMTP {
+			return sess.reply(500, "5.5.1 Unrecognized command")
+		}
+		sess.hello = true
+		sess.reset()
+
+		if verb == "HELO" {
+			return sess.reply(250, "%s", sess.s.Hostname)
+		}
+		return sess.replyLines(250, sess.s.Hostname, "PIPELINING", "8BITMIME", "ENHANCEDSTATUSCODES",
+			fmt.Sprintf("SIZE %d", sess.s.MaxSize))
+
+	case "MAIL":
+		if !sess.hello {
+			return sess.reply(503, "5.5.1 Say hello first")
+		}
+		if sess.from != nil {
+			return sess.reply(503, "5.5.1 Sender already given")
+		}
+		from, ok := pathArg(arg, "FROM:")
+		if !ok {
+			return sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		}
+		sess.from = &from
+		return sess.reply(250, "2.1.0 OK")
+
+	case "RCPT":
+		if sess.from == nil {
+			return sess.reply(503, "5.5.1 Need MAIL first")
+		}
+		to, ok := pathArg(arg, "TO:")
+		if !ok || to == "" {
+			return sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		}
+		if len(sess.rcpts) >= maxRecipients {
+			return sess.reply(452, "4.5.3 Too many recipients")
+		}
+		if err := sess.s.Handler.Recipient(ctx, *sess.from, to); err != nil {
+			return sess.replyError(err)
+		}
+		sess.rcpts = append(sess.rcpts, to)
+		return sess.reply(250, "2.1.5 OK")
+
+	case "DATA":
+		if len(sess.rcpts) == 0 {
+			return sess.reply(503, "5.5.1 Need RCPT first")
+		}
+		return sess.data(ctx)
+
+	case "RSET":
+		sess.reset()
+		return sess.reply(250, "2.0.0 OK")
+
+	case "NOOP":
+		return sess.reply(250, "2.0.0 OK")
+
+	case "VRFY":
+		return sess.reply(252, "2.5.0 Cannot verify, send some mail")
+	}
+
+	return sess.reply(502, "5.5.2 Command not implemented")
+}
+
+func (sess *session) data(ctx context.Context) error {
+	if err := sess.reply(354, "End data with <CR><LF>.<CR><LF>"); err != nil {
+		return err
+	}
+
+	dr := sess.r.DotReader()
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(dr, int64(sess.s.MaxSize)+1))
+	if err != nil {
+		return err
+	}
+
+	from, rcpts := *sess.from, sess.rcpts
+	sess.reset()
+
+	if n > int64(sess.s.MaxSize) {
+		// Read the rest so the connection can carry on, unless it goes on past
+		// maxDrain times the limit, then hang up rather than read forever.
+		limit := int64(sess.s.MaxSize) * maxDrain
+		rest, err := io.Copy(io.Discard, io.LimitReader(dr, limit))
+		if err != nil {
+			return err
+		}
+		err = sess.forEach(rcpts, func(string) error {
+			return &Error{Code: 552, Enhanced: "5.3.4", Message: "Message too big"}
+		})
+		if err == nil && rest == limit {
+			err = errTooBig
+		}
+		return err
+	}
+
+	return sess.forEach(rcpts, func(to string) error {
+		return sess.s.Handler.Deliver(ctx, from, to, buf.Bytes())
+	})
+}
+
+// LMTP replies once per recipient, SMTP once for the whole message with the
+// first failure, if any.
+func (sess *session) forEach(rcpts []string, fn func(to string) error) error {
+	var first error
+	for _, to := range rcpts {
+		err := fn(to)
+		if err != nil {
+			slog.Info("inbound delivery failed", "to", to, "error", err)
+		}
+
+		if sess.s.LMTP {
+			if err := sess.result(err); err != nil {
+				return err
+			}
+		} else if first == nil {
+			first = err
+		}
+	}
+
+	if sess.s.LMTP {
+		return nil
+	}
+	return sess.result(first)
+}
+
+func (sess *session) result(err error) error {
+	if err != nil {
+		return sess.replyError(err)
+	}
+
+	return sess.reply(250, "2.0.0 OK")
+}
+
+func (sess *session) reset() {
+	sess.from = nil
+	sess.rcpts = nil
+}
+
+func (sess *session) reply(code int, format string, args ...any) error {
+	return sess.w.PrintfLine("%d "+format, append([]any{code}, args...)...)
+}
+
+func (sess *session) replyLines(code int, lines ...string) error {
+	for i, line := range lines {
+		sep := "-"
+		if i == len(lines)-1 {
+			sep = " "
+		}
+		if err := sess.w.PrintfLine("%d%s%s", code, sep, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sess *session) replyError(err error) error {
+	var e *Error
+	if !errors.As(err, &e) {
+		slog.Error("error handling inbound mail", "error", err)
+		e = errTemporary
+	}
+
+	return sess.reply(e.Code, "%s %s", e.Enhanced, e.Message)
+}
+
+// Parse "FROM:<a@b> SIZE=10" into the address, ignoring any parameters. The
+// null sender <> is allowed.
+func pathArg(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	path := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(path, "<") {
+		return "", false
+	}
+
+	addr, _, ok := strings.Cut(path[1:], ">")
+	if !ok {
+		return "", false
+	}
+
+	return addr, true
+}
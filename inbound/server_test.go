@@ -0,0 +1,210 @@
+package inbound_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/inbound"
+)
+
+type delivery struct {
+	from, to string
+	data     string
+}
+
+// Accepts mail for @example.com, and fails deliveries to broken@example.com.
+type recordingHandler struct {
+	mu         sync.Mutex
+	deliveries []delivery
+}
+
+func (h *recordingHandler) Recipient(ctx context.Context, from, to string) error {
+	if !strings.HasSuffix(to, "@example.com") {
+		return inbound.Reject("5.1.1", "No such mailbox")
+	}
+
+	return nil
+}
+
+func (h *recordingHandler) Deliver(ctx context.Context, from, to string, data []byte) error {
+	if to == "broken@example.com" {
+		return errors.New("disk on fire")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deliveries = append(h.deliveries, delivery{from: from, to: to, data: string(data)})
+
+	return nil
+}
+
+func startServer(t *testing.T, lmtp bool) (string, *recordingHandler) {
+	t.Helper()
+
+	h := &recordingHandler{}
+	srv := &inbound.Server{Handler: h, Hostname: "post.example.com", LMTP: lmtp, MaxSize: 1024}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+
+	t.Cleanup(func() {
+		require.NoError(t, srv.Close())
+		require.ErrorIs(t, <-done, inbound.ErrServerClosed)
+	})
+
+	return l.Addr().String(), h
+}
+
+const testMessage = "From: a@b.c\r\nSubject: hi\r\n\r\nHello.\r\n.leading dot\r\n"
+
+func TestSMTPDelivery(t *testing.T) {
+	t.Parallel()
+
+	addr, h := startServer(t, false)
+
+	err := smtp.SendMail(addr, nil, "a@b.c", []string{"x@example.com", "y@example.com"}, []byte(testMessage))
+	require.NoError(t, err)
+
+	require.Len(t, h.deliveries, 2)
+	// Dot stuffing is undone and line endings normalized.
+	want := strings.ReplaceAll(testMessage, "\r\n", "\n")
+	require.Equal(t, delivery{from: "a@b.c", to: "x@example.com", data: want}, h.deliveries[0])
+	require.Equal(t, "y@example.com", h.deliveries[1].to)
+}
+
+func TestSMTPRejectsUnknownRecipient(t *testing.T) {
+	t.Parallel()
+
+	addr, h := startServer(t, false)
+
+	err := smtp.SendMail(addr, nil, "a@b.c", []string{"x@elsewhere.com"}, []byte(testMessage))
+	var tpErr *textproto.Error
+	require.ErrorAs(t, err, &tpErr)
+	require.Equal(t, 550, tpErr.Code)
+	require.Empty(t, h.deliveries)
+}
+
+func TestSMTPRejectsLargeMessages(t *testing.T) {
+	t.Parallel()
+
+	addr, h := startServer(t, false)
+
+	c, err := smtp.Dial(addr)
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Mail("a@b.c"))
+	require.NoError(t, c.Rcpt("x@example.com"))
+	w, err := c.Data()
+	require.NoError(t, err)
+	_, err = w.Write([]byte(testMessage + strings.Repeat("a", 2048) + "\r\n"))
+	require.NoError(t, err)
+
+	var tpErr *textproto.Error
+	require.ErrorAs(t, w.Close(), &tpErr)
+	require.Equal(t, 552, tpErr.Code)
+
+	// The connection is still usable afterwards.
+	require.NoError(t, c.Noop())
+	require.Empty(t, h.deliveries)
+}
+
+func TestSMTPHangsUpOnHugeMessages(t *testing.T) {
+	t.Parallel()
+
+	addr, h := startServer(t, false)
+
+	c, err := smtp.Dial(addr)
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Mail("a@b.c"))
+	require.NoError(t, c.Rcpt("x@example.com"))
+	w, err := c.Data()
+	require.NoError(t, err)
+	_, err = w.Write([]byte(testMessage + strings.Repeat("a", 8192) + "\r\n"))
+	require.NoError(t, err)
+
+	var tpErr *textproto.Error
+	require.ErrorAs(t, w.Close(), &tpErr)
+	require.Equal(t, 552, tpErr.Code)
+
+	// Too much to keep reading, the server is gone.
+	require.Error(t, c.Noop())
+	require.Empty(t, h.deliveries)
+}
+
+func TestSMTPRejectsLongLines(t *testing.T) {
+	t.Parallel()
+
+	addr, _ := startServer(t, false)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	tp := textproto.NewConn(conn)
+	_, _, err = tp.ReadResponse(220)
+	require.NoError(t, err)
+
+	require.NoError(t, tp.PrintfLine("EHLO %s", strings.Repeat("a", 4096)))
+	_, _, err = tp.ReadResponse(250)
+	var tpErr *textproto.Error
+	require.ErrorAs(t, err, &tpErr)
+	require.Equal(t, 500, tpErr.Code)
+
+	// Then it hangs up.
+	_, err = tp.ReadLine()
+	require.Error(t, err)
+}
+
+func TestLMTPRepliesPerRecipient(t *testing.T) {
+	t.Parallel()
+
+	addr, h := startServer(t, true)
+
+	conn, err := textproto.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	expect := func(code int) {
+		t.Helper()
+		_, _, err := conn.ReadResponse(code)
+		require.NoError(t, err)
+	}
+	send := func(line string, code int) {
+		t.Helper()
+		require.NoError(t, conn.PrintfLine("%s", line))
+		expect(code)
+	}
+
+	expect(220)
+	send("EHLO client", 500)
+	send("LHLO client", 250)
+	send("MAIL FROM:<a@b.c>", 250)
+	send("RCPT TO:<x@example.com>", 250)
+	send("RCPT TO:<broken@example.com>", 250)
+	send("DATA", 354)
+
+	w := conn.DotWriter()
+	_, err = w.Write([]byte(testMessage))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	expect(250)
+	expect(451)
+	send("QUIT", 221)
+
+	require.Len(t, h.deliveries, 1)
+	require.Equal(t, "x@example.com", h.deliveries[0].to)
+}
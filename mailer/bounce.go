@@ -0,0 +1,216 @@
+package mailer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+var ErrNotAReport = errors.New("message is not a delivery or feedback report")
+
+type ReportKind string
+
+const (
+	// A delivery status notification (RFC 3464).
+	ReportBounce ReportKind = "bounce"
+	// An abuse report from a mailbox provider's feedback loop (RFC 5965).
+	ReportComplaint ReportKind = "complaint"
+)
+
+type Report struct {
+	Kind ReportKind
+	// For bounces, only the recipients that permanently failed. Delays and
+	// successful deliveries are left out.
+	Recipients []ReportRecipient
+	// The reported message's Message-ID, when the report quotes its headers.
+	MessageID string
+}
+
+type ReportRecipient struct {
+	Address    string
+	Status     string
+	Diagnostic string
+}
+
+// Parse a multipart/report message, as sent back by mail servers when
+// delivery fails or by providers when a recipient marks mail as spam.
+func ParseReport(r io.Reader) (Report, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return Report{}, fmt.Errorf("error reading report: %w", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" {
+		return Report{}, ErrNotAReport
+	}
+
+	var report Report
+	var complaint textproto.MIMEHeader
+	var original textproto.MIMEHeader
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Report{}, fmt.Errorf("error reading report part: %w", err)
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		body := partBody(part)
+
+		switch partType {
+		case "message/delivery-status", "message/global-delivery-status":
+			report.Kind = ReportBounce
+			report.Recipients, err = parseDeliveryStatus(body)
+		case "message/feedback-report":
+			report.Kind = ReportComplaint
+			complaint, err = readFields(bufio.NewReader(body))
+		case "message/rfc822", "text/rfc822-headers", "message/global", "message/global-headers":
+			original, err = readFields(bufio.NewReader(body))
+		}
+		if err != nil {
+			return Report{}, fmt.Errorf("error parsing %s: %w", partType, err)
+		}
+	}
+
+	if original != nil {
+		report.MessageID = original.Get("Message-ID")
+	}
+
+	switch report.Kind {
+	case ReportBounce:
+		return report, nil
+	case ReportComplaint:
+		addr := complaint.Get("Original-Rcpt-To")
+		if addr == "" && original != nil {
+			if to, err := mail.ParseAddress(original.Get("To")); err == nil {
+				addr = to.Address
+			}
+		}
+		if addr != "" {
+			report.Recipients = []ReportRecipient{{Address: addr, Diagnostic: complaint.Get("Feedback-Type")}}
+		}
+
+		return report, nil
+	}
+
+	return Report{}, ErrNotAReport
+}
+
+func partBody(part *multipart.Part) io.Reader {
+	// Quoted-printable is already undone by the multipart reader.
+	if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+		return base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: part})
+	}
+
+	return part
+}
+
+// A delivery-status body is a block of per-message fields followed by one
+// block per recipient, each separated by a blank line.
+func parseDeliveryStatus(body io.Reader) ([]ReportRecipient, error) {
+	br := bufio.NewReader(body)
+
+	if _, err := readFields(br); err != nil {
+		return nil, err
+	}
+
+	var recipients []ReportRecipient
+	for {
+		fields, err := readFields(br)
+		if err != nil {
+			return nil, err
+		}
+		if fields == nil {
+			break
+		}
+
+		if !strings.EqualFold(fields.Get("Action"), "failed") {
+			continue
+		}
+
+		addr := addressField(fields.Get("Final-Recipient"))
+		if addr == "" {
+			addr = addressField(fields.Get("Original-Recipient"))
+		}
+		if addr == "" {
+			continue
+		}
+
+		recipients = append(recipients, ReportRecipient{
+			Address:    addr,
+			Status:     fields.Get("Status"),
+			Diagnostic: addressField(fields.Get("Diagnostic-Code")),
+		})
+	}
+
+	return recipients, nil
+}
+
+// Read one block of header style fields, or nil at the end of input.
+func readFields(br *bufio.Reader) (textproto.MIMEHeader, error) {
+	// Skip blank lines between blocks.
+	for {
+		b, err := br.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		if _, err := br.ReadByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	fields, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// Strip the type from fields like "rfc822; goose@connect.uwaterloo.ca".
+func addressField(v string) string {
+	if _, rest, ok := strings.Cut(v, ";"); ok {
+		v = rest
+	}
+
+	return strings.Trim(strings.TrimSpace(v), "<>")
+}
+
+// base64 bodies are wrapped at 76 characters, which the decoder does not expect.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		c, err := n.r.Read(p)
+		j := 0
+		for _, b := range p[:c] {
+			if b != '\r' && b != '\n' {
+				p[j] = b
+				j++
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package mailer_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/mailer"
+)
+
+func parseReportFile(t *testing.T, name string) (mailer.Report, error) {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("testdata", "reports", name))
+	require.NoError(t, err)
+	defer f.Close()
+
+	return mailer.ParseReport(f)
+}
+
+func TestParseDeliveryReport(t *testing.T) {
+	t.Parallel()
+
+	report, err := parseReportFile(t, "bounce.eml")
+	require.NoError(t, err)
+	require.Equal(t, mailer.ReportBounce, report.Kind)
+
+	// The delayed recipient is left out.
+	require.Equal(t, []mailer.ReportRecipient{{
+		Address:    "gone@uwaterloo.ca",
+		Status:     "5.1.1",
+		Diagnostic: "550 5.1.1 <gone@uwaterloo.ca>: Recipient address rejected: User unknown",
+	}}, report.Recipients)
+	require.Equal(t, "<3f9a2c.sig@uwece.ca>", report.MessageID)
+}
+
+func TestParseBase64DeliveryReport(t *testing.T) {
+	t.Parallel()
+
+	report, err := parseReportFile(t, "base64.eml")
+	require.NoError(t, err)
+	require.Equal(t, mailer.ReportBounce, report.Kind)
+	require.Equal(t, []mailer.ReportRecipient{{
+		Address:    "full@uwaterloo.ca",
+		Status:     "5.2.2",
+		Diagnostic: "552 Mailbox full",
+	}}, report.Recipients)
+}
+
+func TestParseFeedbackReport(t *testing.T) {
+	t.Parallel()
+
+	report, err := parseReportFile(t, "complaint.eml")
+	require.NoError(t, err)
+	require.Equal(t, mailer.ReportComplaint, report.Kind)
+	require.Equal(t, []mailer.ReportRecipient{{
+		Address:    "annoyed@uwaterloo.ca",
+		Diagnostic: "abuse",
+	}}, report.Recipients)
+	require.Equal(t, "<3f9a2c.sig@uwece.ca>", report.MessageID)
+}
+
+func TestParseReportRejectsOtherMail(t *testing.T) {
+	t.Parallel()
+
+	_, err := parseReportFile(t, "plain.eml")
+	require.ErrorIs(t, err, mailer.ErrNotAReport)
+}
+
+func TestMaildirProcess(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0o755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new", "2.host"), []byte("second"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new", "1.host"), []byte("first"), 0o644))
+
+	var seen []string
+	n, err := mailer.Maildir(dir).Process(func(name string, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		seen = append(seen, string(b))
+		return err
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{"first", "second"}, seen)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	_, err = os.Stat(filepath.Join(dir, "cur", "1.host:2,S"))
+	require.NoError(t, err)
+
+	// Nothing left to read.
+	n, err = mailer.Maildir(dir).Process(func(string, io.Reader) error { return nil })
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestMaildirProcessLeavesFailedMessages(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0o755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new", "1.host"), []byte("broken"), 0o644))
+
+	_, err := mailer.Maildir(dir).Process(func(string, io.Reader) error {
+		return io.ErrUnexpectedEOF
+	})
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = os.Stat(filepath.Join(dir, "new", "1.host"))
+	require.NoError(t, err)
+}
@@ -0,0 +1,300 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Headers we sign when present. The ones in dkimOversign are listed an extra
+// time so a header of that name added in transit breaks the signature.
+var (
+	dkimHeaders = []string{
+		"from", "to", "cc", "subject", "date", "message-id", "reply-to",
+		"mime-version", "content-type", "content-transfer-encoding",
+		"list-unsubscribe", "list-unsubscribe-post",
+	}
+	dkimOversign = []string{"from", "to", "subject", "date"}
+)
+
+// Signs outgoing mail per RFC 6376 with relaxed/relaxed canonicalization,
+// using either rsa-sha256 or ed25519-sha256 (RFC 8463).
+type DKIMSigner struct {
+	domain   string
+	selector string
+	key      crypto.Signer
+	algo     string
+}
+
+// Parse a PEM encoded RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key.
+func NewDKIMSigner(domain, selector string, keyPEM []byte) (*DKIMSigner, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("dkim private key is not PEM encoded")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported dkim key type %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing dkim private key: %w", err)
+	}
+
+	s := &DKIMSigner{
+		domain:   domain,
+		selector: selector,
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 1024 {
+			return nil, errors.New("dkim rsa keys must be at least 1024 bits")
+		}
+		s.key, s.algo = k, "rsa-sha256"
+	case ed25519.PrivateKey:
+		s.key, s.algo = k, "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("unsupported dkim key %T", key)
+	}
+
+	return s, nil
+}
+
+// Return msg with a DKIM-Signature header prepended. msg must use CRLF line endings.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	headers, body, err := splitMessage(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+
+	var signed []string
+	var names []string
+	for _, name := range dkimHeaders {
+		if findHeaders(headers, name) > 0 {
+			names = append(names, name)
+		}
+	}
+	for _, name := range dkimOversign {
+		if findHeaders(headers, name) > 0 {
+			names = append(names, name)
+		}
+	}
+
+	// Walk the list picking instances from the bottom up, as verifiers will.
+	used := make(map[string]int)
+	for _, name := range names {
+		if h, ok := pickHeader(headers, name, used); ok {
+			signed = append(signed, relaxedHeader(h))
+		}
+	}
+
+	sigHeader := fmt.Sprintf(
+		"DKIM-Signature: v=1; a=%s; c=relaxed/relaxed; d=%s; s=%s;\r\n\tt=%d; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		s.algo, s.domain, s.selector, time.Now().Unix(), strings.Join(names, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]),
+	)
+
+	var data bytes.Buffer
+	for _, h := range signed {
+		data.WriteString(h)
+		data.WriteString("\r\n")
+	}
+	data.WriteString(relaxedHeader(sigHeader))
+
+	sig, err := s.sign(data.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("error creating dkim signature: %w", err)
+	}
+
+	out := make([]byte, 0, len(msg)+len(sigHeader)+len(sig)*2)
+	out = append(out, sigHeader...)
+	out = append(out, foldBase64(base64.StdEncoding.EncodeToString(sig))...)
+	out = append(out, "\r\n"...)
+	out = append(out, msg...)
+
+	return out, nil
+}
+
+// Wraps a transport, signing every message before handing it on.
+type DKIMTransport struct {
+	signer *DKIMSigner
+	next   Transport
+}
+
+func NewDKIMTransport(signer *DKIMSigner, next Transport) *DKIMTransport {
+	return &DKIMTransport{signer: signer, next: next}
+}
+
+func (t *DKIMTransport) Send(env Envelope, msg []byte) error {
+	signed, err := t.signer.Sign(msg)
+	if err != nil {
+		return err
+	}
+
+	return t.next.Send(env, signed)
+}
+
+func (t *DKIMTransport) Close() error {
+	return closeTransport(t.next)
+}
+
+func (s *DKIMSigner) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+
+	if s.algo == "ed25519-sha256" {
+		// RFC 8463 signs the sha256 digest with PureEdDSA.
+		return s.key.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	}
+
+	return s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// Break a long signature over several lines so no line exceeds the SMTP limit.
+func foldBase64(b string) string {
+	const width = 72
+
+	var out strings.Builder
+	for len(b) > width {
+		out.WriteString(b[:width])
+		out.WriteString("\r\n\t")
+		b = b[width:]
+	}
+	out.WriteString(b)
+
+	return out.String()
+}
+
+// Split a message into its (unfolded-but-raw) header fields and body.
+func splitMessage(msg []byte) ([]string, []byte, error) {
+	end := bytes.Index(msg, []byte("\r\n\r\n"))
+	var head, body []byte
+	if end < 0 {
+		if !bytes.HasSuffix(msg, []byte("\r\n")) {
+			return nil, nil, errors.New("message has no header terminator")
+		}
+		head = msg
+	} else {
+		head, body = msg[:end+2], msg[end+4:]
+	}
+
+	var headers []string
+	for _, line := range strings.SplitAfter(string(head), "\r\n") {
+		if line == "" {
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && len(headers) > 0 {
+			headers[len(headers)-1] += line
+			continue
+		}
+
+		headers = append(headers, line)
+	}
+
+	for i, h := range headers {
+		headers[i] = strings.TrimSuffix(h, "\r\n")
+	}
+
+	return headers, body, nil
+}
+
+func headerName(h string) string {
+	name, _, _ := strings.Cut(h, ":")
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func findHeaders(headers []string, name string) int {
+	n := 0
+	for _, h := range headers {
+		if headerName(h) == name {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Select the last not yet used instance of a header.
+func pickHeader(headers []string, name string, used map[string]int) (string, bool) {
+	skip := used[name]
+	used[name]++
+
+	for i := len(headers) - 1; i >= 0; i-- {
+		if headerName(headers[i]) != name {
+			continue
+		}
+
+		if skip == 0 {
+			return headers[i], true
+		}
+		skip--
+	}
+
+	return "", false
+}
+
+// RFC 6376 section 3.4.2.
+func relaxedHeader(h string) string {
+	name, value, _ := strings.Cut(h, ":")
+
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+// RFC 6376 section 3.4.4.
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+
+	for i, l := range lines {
+		l = strings.TrimRightFunc(l, isWSP)
+		var b strings.Builder
+		inSpace := false
+		for _, r := range l {
+			if isWSP(r) {
+				inSpace = true
+				continue
+			}
+			if inSpace {
+				b.WriteByte(' ')
+				inSpace = false
+			}
+			b.WriteRune(r)
+		}
+		lines[i] = b.String()
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
@@ -0,0 +1,270 @@
+package mailer_test
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/mailer"
+)
+
+// A deliberately naive verifier written straight from RFC 6376 section 6,
+// sharing no code with the signer.
+func verifyDKIM(msg string, pub crypto.PublicKey) error {
+	head, body, ok := strings.Cut(msg, "\r\n\r\n")
+	if !ok {
+		return errors.New("no body")
+	}
+
+	var fields []string
+	for _, line := range strings.Split(head, "\r\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			fields[len(fields)-1] += "\r\n" + line
+		} else {
+			fields = append(fields, line)
+		}
+	}
+
+	// Verify the topmost signature.
+	var sigField string
+	for _, f := range fields {
+		if strings.HasPrefix(strings.ToLower(f), "dkim-signature:") {
+			sigField = f
+			break
+		}
+	}
+	if sigField == "" {
+		return errors.New("no signature")
+	}
+
+	tags := map[string]string{}
+	_, value, _ := strings.Cut(sigField, ":")
+	for _, tag := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(k)] = regexp.MustCompile(`\s+`).ReplaceAllString(v, "")
+	}
+
+	if tags["v"] != "1" || tags["c"] != "relaxed/relaxed" {
+		return fmt.Errorf("unexpected tags %v", tags)
+	}
+
+	canonHeader := func(f string) string {
+		name, value, _ := strings.Cut(f, ":")
+		value = strings.ReplaceAll(value, "\r\n", "")
+		value = regexp.MustCompile(`[ \t]+`).ReplaceAllString(value, " ")
+		return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + strings.Trim(value, " \t")
+	}
+
+	lines := strings.Split(body, "\r\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(regexp.MustCompile(`[ \t]+`).ReplaceAllString(lines[i], " "), " ")
+	}
+	canonBody := strings.TrimRight(strings.Join(lines, "\r\n"), "\r\n")
+	if canonBody != "" {
+		canonBody += "\r\n"
+	}
+
+	bh := sha256.Sum256([]byte(canonBody))
+	if base64.StdEncoding.EncodeToString(bh[:]) != tags["bh"] {
+		return errors.New("body hash mismatch")
+	}
+
+	var data strings.Builder
+	seen := map[string]int{}
+	for _, name := range strings.Split(tags["h"], ":") {
+		name = strings.ToLower(name)
+		skip := seen[name]
+		seen[name]++
+		for i := len(fields) - 1; i >= 0; i-- {
+			if strings.ToLower(strings.TrimSpace(strings.SplitN(fields[i], ":", 2)[0])) != name {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			data.WriteString(canonHeader(fields[i]) + "\r\n")
+			break
+		}
+	}
+	stripped := regexp.MustCompile(`(b=)[^;]*$`).ReplaceAllString(sigField, "$1")
+	stripped = regexp.MustCompile(`([;:\s]b=)[^;]*;`).ReplaceAllString(stripped, "$1;")
+	data.WriteString(canonHeader(stripped))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256([]byte(data.String()))
+	switch tags["a"] {
+	case "rsa-sha256":
+		return rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, digest[:], sig)
+	case "ed25519-sha256":
+		if !ed25519.Verify(pub.(ed25519.PublicKey), digest[:], sig) {
+			return errors.New("bad ed25519 signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown algorithm %q", tags["a"])
+}
+
+func pemKey(t *testing.T, key any) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+const dkimTestMessage = "From: UWECECA Team <noreply@uwece.ca>\r\n" +
+	"To: \"Goose\"   <goose@connect.uwaterloo.ca>\r\n" +
+	"Subject: A  subject that is long enough\r\n" +
+	"\tto be folded\r\n" +
+	"Date: Mon, 19 Oct 2026 12:00:00 +0000\r\n" +
+	"Message-ID: <abc@uwece.ca>\r\n" +
+	"X-Unsigned: whatever\r\n" +
+	"\r\n" +
+	"Hello  there, \r\n" +
+	"\r\n" +
+	"Goose.\r\n" +
+	"\r\n" +
+	"\r\n"
+
+// The ed25519 example from RFC 8463 appendix A, checks the verifier itself.
+func TestDKIMVerifierAgainstRFC8463(t *testing.T) {
+	t.Parallel()
+
+	pub, err := base64.StdEncoding.DecodeString("11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=")
+	require.NoError(t, err)
+
+	msg := "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		" d=football.example.com; i=@football.example.com;\r\n" +
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+		" subject : date : message-id : from : subject : date;\r\n" +
+		" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+		" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11BusFa3bT3FY\r\n" +
+		" 5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+		"From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+		"\r\n" +
+		"Hi.\r\n" +
+		"\r\n" +
+		"We lost the game.  Are you hungry yet?\r\n" +
+		"\r\n" +
+		"Joe.\r\n"
+
+	require.NoError(t, verifyDKIM(msg, ed25519.PublicKey(pub)))
+	require.Error(t, verifyDKIM(strings.Replace(msg, "dinner", "lunch", 1), ed25519.PublicKey(pub)))
+}
+
+func TestDKIMSignEd25519(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	s, err := mailer.NewDKIMSigner("uwece.ca", "mail", pemKey(t, priv))
+	require.NoError(t, err)
+
+	signed, err := s.Sign([]byte(dkimTestMessage))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(signed), "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed; d=uwece.ca; s=mail;"))
+	require.True(t, strings.HasSuffix(string(signed), dkimTestMessage))
+
+	require.NoError(t, verifyDKIM(string(signed), pub))
+}
+
+func TestDKIMSignRSA(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pkcs1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	for _, keyPEM := range [][]byte{pkcs1, pemKey(t, key)} {
+		s, err := mailer.NewDKIMSigner("uwece.ca", "mail", keyPEM)
+		require.NoError(t, err)
+
+		signed, err := s.Sign([]byte(dkimTestMessage))
+		require.NoError(t, err)
+		require.NoError(t, verifyDKIM(string(signed), &key.PublicKey))
+
+		for _, line := range strings.Split(string(signed), "\r\n") {
+			require.LessOrEqual(t, len(line), 78)
+		}
+	}
+}
+
+func TestDKIMSurvivesRelaxedChanges(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	s, err := mailer.NewDKIMSigner("uwece.ca", "mail", pemKey(t, priv))
+	require.NoError(t, err)
+
+	signed, err := s.Sign([]byte(dkimTestMessage))
+	require.NoError(t, err)
+
+	// Whitespace and header case changes made by relays are tolerated.
+	relayed := strings.Replace(string(signed), "Subject: A  subject", "SUBJECT:   A subject", 1)
+	relayed = strings.Replace(relayed, "Hello  there, \r\n", "Hello there,\r\n", 1)
+	relayed = strings.TrimSuffix(relayed, "\r\n\r\n") + "\r\n"
+	require.NoError(t, verifyDKIM(relayed, pub))
+
+	// Unsigned headers can change freely.
+	require.NoError(t, verifyDKIM(strings.Replace(string(signed), "whatever", "changed", 1), pub))
+
+	// Content changes and injected headers are not.
+	require.Error(t, verifyDKIM(strings.Replace(string(signed), "Goose.", "Gander.", 1), pub))
+	require.Error(t, verifyDKIM(strings.Replace(string(signed), "X-Unsigned", "Subject: injected\r\nX-Unsigned", 1), pub))
+	require.Error(t, verifyDKIM(strings.Replace(string(signed), "X-Unsigned", "From: evil@example.com\r\nX-Unsigned", 1), pub))
+}
+
+func TestDKIMTransportSignsMail(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	s, err := mailer.NewDKIMSigner("uwece.ca", "mail", pemKey(t, priv))
+	require.NoError(t, err)
+
+	tr := mailer.NewMemoryTransport()
+	m := mailer.NewWithTransport(testConfig(), mailer.NewDKIMTransport(s, tr))
+
+	msg := mailer.VerificationMessage{Name: "Goose", Link: "https://uwece.ca/signup/verify/abcd"}
+	require.NoError(t, m.Send("goose@connect.uwaterloo.ca", "Goose", msg))
+
+	sent := tr.Messages()
+	require.Len(t, sent, 1)
+	require.NoError(t, verifyDKIM(string(sent[0].Data), pub))
+}
+
+func TestNewDKIMSignerRejectsBadKeys(t *testing.T) {
+	t.Parallel()
+
+	_, err := mailer.NewDKIMSigner("uwece.ca", "mail", []byte("not a key"))
+	require.Error(t, err)
+
+	_, err = mailer.NewDKIMSigner("uwece.ca", "mail", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte{1}}))
+	require.Error(t, err)
+}
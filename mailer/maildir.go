@@ -0,0 +1,62 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A mail directory, as written by most local delivery agents. New messages
+// arrive in new/ and are moved to cur/ once read.
+type Maildir string
+
+// Hand each unread message to fn, oldest first, then mark it read. A message
+// is left unread if fn fails on it, and processing stops there.
+func (m Maildir) Process(fn func(name string, r io.Reader) error) (int, error) {
+	newDir := filepath.Join(string(m), "new")
+
+	entries, err := os.ReadDir(newDir)
+	if err != nil {
+		return 0, fmt.Errorf("error listing maildir: %w", err)
+	}
+
+	// Maildir names start with the delivery time.
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	n := 0
+	for _, name := range names {
+		path := filepath.Join(newDir, name)
+
+		f, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Taken by another reader.
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+
+		err = fn(name, f)
+		f.Close()
+		if err != nil {
+			return n, fmt.Errorf("error processing %s: %w", name, err)
+		}
+
+		if err := os.Rename(path, filepath.Join(string(m), "cur", name+":2,S")); err != nil {
+			return n, fmt.Errorf("error marking %s as read: %w", name, err)
+		}
+		n++
+	}
+
+	return n, nil
+}
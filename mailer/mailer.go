@@ -1,8 +1,11 @@
 package mailer
 
 import (
+	"bytes"
 	"embed"
+	"errors"
 	"fmt"
+	"strings"
 
 	"gopkg.in/gomail.v2"
 	"uwece.ca/app/config"
@@ -14,63 +17,83 @@ import (
 var embedFS embed.FS
 
 type Mailer interface {
-	SendVerificationEmail(addr, name string, token utils.Token) error
+	Send(addr, name string, msg Message) error
+	Render(msg Message) (Rendered, error)
+	// Release the transport's connections and stop watching templates, call
+	// once when shutting down.
+	Close() error
 }
 
+// Message-IDs are signed for their recipient, so a bounce or complaint can be
+// checked against mail we really sent without keeping every ID around.
+const messageIDPurpose = "message-id"
+
 type mailer struct {
 	cfg       *config.Config
-	mailer    *gomail.Dialer
+	transport Transport
 	templates *templates.Templates
+	signer    *utils.Signer
 }
 
-func New(cfg *config.Config) Mailer {
-	m := cfg.Mailer
-	d := gomail.NewDialer(m.Host, m.Port, m.Username, m.Password)
+func New(cfg *config.Config) (Mailer, error) {
+	t, err := NewTransport(cfg.Mailer)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Mailer.DKIMEnabled() {
+		signer, err := NewDKIMSigner(cfg.Mailer.DKIMDomain, cfg.Mailer.DKIMSelector, []byte(cfg.Mailer.DKIMKey))
+		if err != nil {
+			return nil, err
+		}
+
+		t = NewDKIMTransport(signer, t)
+	}
+
+	return NewWithTransport(cfg, t), nil
+}
 
+func NewWithTransport(cfg *config.Config, t Transport) Mailer {
+	funcs := templates.Library(cfg.Core)
 	var tmpl *templates.Templates
 	if cfg.Core.Development {
-		tmpl = templates.NewDevTemplates(embedFS, "./mailer/templates")
+		tmpl = templates.NewDevTemplates("./mailer/templates", funcs)
 	} else {
-		tmpl = templates.NewTemplates(embedFS)
+		tmpl = templates.NewTemplates(embedFS, funcs)
 	}
 
 	return &mailer{
 		cfg:       cfg,
-		mailer:    d,
+		transport: t,
 		templates: tmpl,
+		signer:    utils.NewSigner(cfg.Core.SecretKey),
 	}
 }
 
-func (m *mailer) SendVerificationEmail(addr, name string, token utils.Token) error {
-	scheme := "https"
-	if m.cfg.Core.Development {
-		scheme = "http"
-	}
-
-	params := templates.Context{
-		"name": name,
-		"link": fmt.Sprintf("%s://%s/signup/verify/%s", scheme, m.cfg.Core.BaseDomain, token),
-	}
+func (m *mailer) Close() error {
+	return errors.Join(closeTransport(m.transport), m.templates.Close())
+}
 
-	text, err := m.templates.ExecutePlainString("verification.txt", params)
+func (m *mailer) Send(addr, name string, msg Message) error {
+	r, err := m.Render(msg)
 	if err != nil {
-		return fmt.Errorf("error rendering email text template: %w", err)
+		return err
 	}
 
-	html, err := m.templates.ExecutePlainString("verification", params)
-	if err != nil {
-		return fmt.Errorf("error rendering email html template: %w", err)
+	e := email{
+		To:       addr,
+		Name:     name,
+		Subject:  r.Subject,
+		TextBody: r.Text,
+		HtmlBody: r.HTML,
+	}
+	if hm, ok := msg.(HeaderMessage); ok {
+		e.Headers = hm.Headers()
 	}
 
-	err = m.sendMessage(email{
-		To:       addr,
-		Name:     addr,
-		Subject:  "UWECECA - Verification",
-		TextBody: text,
-		HtmlBody: html,
-	})
+	err = m.sendMessage(e)
 	if err != nil {
-		return fmt.Errorf("error sending email: %w", err)
+		return fmt.Errorf("error sending %s email: %w", msg.Template(), err)
 	}
 
 	return nil
@@ -82,6 +105,7 @@ type email struct {
 	Subject  string
 	TextBody string
 	HtmlBody string
+	Headers  map[string]string
 }
 
 func (m *mailer) sendMessage(me email) error {
@@ -90,10 +114,47 @@ func (m *mailer) sendMessage(me email) error {
 	message.SetAddressHeader("From", m.cfg.Mailer.FromAddress, "UWECECA Team")
 	message.SetHeader("Subject", me.Subject)
 	message.SetAddressHeader("To", me.To, me.Name)
+	message.SetHeader("Message-ID", m.messageID(me.To))
+	for k, v := range me.Headers {
+		message.SetHeader(k, v)
+	}
 	message.SetBody("text/plain", me.TextBody)
 	if me.HtmlBody != "" {
 		message.AddAlternative("text/html", me.HtmlBody)
 	}
 
-	return m.mailer.DialAndSend(message)
+	var buf bytes.Buffer
+	if _, err := message.WriteTo(&buf); err != nil {
+		return fmt.Errorf("error encoding message: %w", err)
+	}
+
+	env := Envelope{
+		From: m.cfg.Mailer.FromAddress,
+		To:   []string{me.To},
+	}
+
+	return m.transport.Send(env, buf.Bytes())
+}
+
+func (m *mailer) messageID(to string) string {
+	domain := "localhost"
+	if _, d, ok := strings.Cut(m.cfg.Mailer.FromAddress, "@"); ok {
+		domain = d
+	}
+
+	id := m.signer.Sign(messageIDPurpose+" "+strings.ToLower(to), string(utils.NewToken()[:32]))
+	return fmt.Sprintf("<%s@%s>", id, domain)
+}
+
+// Report whether id is the Message-ID of a message this app sent to addr,
+// going by its signature from signer.
+func SentTo(signer *utils.Signer, id, addr string) bool {
+	id = strings.Trim(strings.TrimSpace(id), "<>")
+	i := strings.LastIndexByte(id, '@')
+	if i < 0 {
+		return false
+	}
+
+	_, ok := signer.Verify(messageIDPurpose+" "+strings.ToLower(addr), id[:i])
+	return ok
 }
@@ -0,0 +1,184 @@
+package mailer_test
+
+import (
+	"bytes"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/config"
+	"uwece.ca/app/mailer"
+	"uwece.ca/app/utils"
+)
+
+func testConfig() *config.Config {
+	return &config.Config{
+		Core: config.Core{BaseDomain: "uwece.ca", EmailDomain: "connect.uwaterloo.ca"},
+		Mailer: config.Mailer{
+			FromAddress: "noreply@uwece.ca",
+		},
+	}
+}
+
+func TestSendVerificationEmail(t *testing.T) {
+	t.Parallel()
+
+	tr := mailer.NewMemoryTransport()
+	m := mailer.NewWithTransport(testConfig(), tr)
+
+	msg := mailer.VerificationMessage{Name: "Goose", Link: "https://uwece.ca/signup/verify/abcd"}
+	require.NoError(t, m.Send("goose@connect.uwaterloo.ca", "Goose", msg))
+
+	sent := tr.Messages()
+	require.Len(t, sent, 1)
+	require.Equal(t, "noreply@uwece.ca", sent[0].Envelope.From)
+	require.Equal(t, []string{"goose@connect.uwaterloo.ca"}, sent[0].Envelope.To)
+	require.Contains(t, string(sent[0].Data), "Subject: UWECECA - Verification")
+	require.Contains(t, string(sent[0].Data), `To: "Goose" <goose@connect.uwaterloo.ca>`)
+	require.Contains(t, string(sent[0].Data), "https://uwece.ca/signup/verify/abcd")
+	require.Contains(t, string(sent[0].Data), "Message-ID: <")
+}
+
+func TestSendAddsMessageHeaders(t *testing.T) {
+	t.Parallel()
+
+	tr := mailer.NewMemoryTransport()
+	m := mailer.NewWithTransport(testConfig(), tr)
+
+	msg := mailer.BroadcastMessage{Name: "Goose", Subject: "Hi", Text: "Hi", UnsubscribeLink: "https://uwece.ca/unsubscribe/1.abcd"}
+	require.NoError(t, m.Send("goose@connect.uwaterloo.ca", "Goose", msg))
+
+	data := string(tr.Messages()[0].Data)
+	require.Contains(t, data, "List-Unsubscribe: <https://uwece.ca/unsubscribe/1.abcd>\r\n")
+	require.Contains(t, data, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+}
+
+func TestMessageIDIsSignedForRecipient(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig()
+	cfg.Core.SecretKey = "test secret"
+	tr := mailer.NewMemoryTransport()
+	m := mailer.NewWithTransport(cfg, tr)
+
+	msg := mailer.VerificationMessage{Name: "Goose", Link: "https://uwece.ca/signup/verify/abcd"}
+	require.NoError(t, m.Send("goose@connect.uwaterloo.ca", "Goose", msg))
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(tr.Messages()[0].Data))
+	require.NoError(t, err)
+	id := parsed.Header.Get("Message-ID")
+
+	signer := utils.NewSigner("test secret")
+	require.True(t, mailer.SentTo(signer, id, "Goose@connect.uwaterloo.ca"))
+	require.False(t, mailer.SentTo(signer, id, "gander@connect.uwaterloo.ca"))
+	require.False(t, mailer.SentTo(utils.NewSigner("other secret"), id, "goose@connect.uwaterloo.ca"))
+	require.False(t, mailer.SentTo(signer, "<3f9a2c.sig@uwece.ca>", "goose@connect.uwaterloo.ca"))
+	require.False(t, mailer.SentTo(signer, "", "goose@connect.uwaterloo.ca"))
+}
+
+func TestFileTransportWritesEml(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "outbox")
+	tr, err := mailer.NewFileTransport(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, tr.Send(mailer.Envelope{From: "a@b.c", To: []string{"d@e.f"}}, []byte("Subject: hi\r\n\r\nbody")))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Equal(t, "Subject: hi\r\n\r\nbody", string(data))
+}
+
+func TestNewTransportSelectsFromConfig(t *testing.T) {
+	t.Parallel()
+
+	tr, err := mailer.NewTransport(config.Mailer{Transport: "memory"})
+	require.NoError(t, err)
+	require.IsType(t, &mailer.MemoryTransport{}, tr)
+
+	tr, err = mailer.NewTransport(config.Mailer{Transport: "file", OutboxDir: t.TempDir()})
+	require.NoError(t, err)
+	require.IsType(t, &mailer.FileTransport{}, tr)
+
+	tr, err = mailer.NewTransport(config.Mailer{Transport: "smtp", TLS: "implicit"})
+	require.NoError(t, err)
+	require.IsType(t, &mailer.SMTPPool{}, tr)
+
+	_, err = mailer.NewTransport(config.Mailer{Transport: "pigeon"})
+	require.Error(t, err)
+
+	_, err = mailer.NewTransport(config.Mailer{Transport: "smtp", TLS: "sometimes"})
+	require.Error(t, err)
+}
+
+func TestSMTPTransportSends(t *testing.T) {
+	t.Parallel()
+
+	srv := newFakeSMTP(t)
+	tr, err := mailer.NewSMTPTransport(mailer.SMTPConfig{Host: "127.0.0.1", Port: srv.port(), TLS: mailer.TLSNone})
+	require.NoError(t, err)
+
+	require.NoError(t, tr.Send(mailer.Envelope{From: "a@b.c", To: []string{"d@e.f"}}, []byte("Subject: hi\r\n\r\nbody\r\n")))
+
+	got := srv.deliveries()
+	require.Len(t, got, 1)
+	require.Equal(t, "a@b.c", got[0].From)
+	require.Equal(t, []string{"d@e.f"}, got[0].To)
+	require.Equal(t, "Subject: hi\r\n\r\nbody\r\n", string(got[0].Data))
+}
+
+func TestSMTPTransportRequiresStartTLS(t *testing.T) {
+	t.Parallel()
+
+	srv := newFakeSMTP(t)
+	tr, err := mailer.NewSMTPTransport(mailer.SMTPConfig{Host: "127.0.0.1", Port: srv.port(), TLS: mailer.TLSStartTLS})
+	require.NoError(t, err)
+
+	err = tr.Send(mailer.Envelope{From: "a@b.c", To: []string{"d@e.f"}}, []byte("Subject: hi\r\n\r\nbody\r\n"))
+	require.ErrorIs(t, err, mailer.ErrStartTLSUnavailable)
+	require.Empty(t, srv.deliveries())
+}
+
+func TestSMTPTransportTimesOut(t *testing.T) {
+	t.Parallel()
+
+	// Accepts connections and never says a word.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	tr, err := mailer.NewSMTPTransport(mailer.SMTPConfig{
+		Host:    "127.0.0.1",
+		Port:    ln.Addr().(*net.TCPAddr).Port,
+		TLS:     mailer.TLSNone,
+		Timeout: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	err = tr.Send(mailer.Envelope{From: "a@b.c", To: []string{"d@e.f"}}, []byte("Subject: hi\r\n\r\nbody\r\n"))
+	var nerr net.Error
+	require.ErrorAs(t, err, &nerr)
+	require.True(t, nerr.Timeout())
+}
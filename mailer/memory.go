@@ -0,0 +1,45 @@
+package mailer
+
+import "sync"
+
+type SentMessage struct {
+	Envelope Envelope
+	Data     []byte
+}
+
+// Records messages instead of sending them, for tests.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []SentMessage
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (t *MemoryTransport) Send(env Envelope, msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = append(t.messages, SentMessage{
+		Envelope: env,
+		Data:     append([]byte(nil), msg...),
+	})
+
+	return nil
+}
+
+// Everything sent so far, oldest first.
+func (t *MemoryTransport) Messages() []SentMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]SentMessage(nil), t.messages...)
+}
+
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
@@ -0,0 +1,144 @@
+package mailer
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// A transactional email. Each message has a template in mailer/templates
+// defining its "subject" and html "content", and optionally a plaintext
+// "text" version. Without one, the text is generated from the rendered html.
+type Message interface {
+	Template() string
+}
+
+type VerificationMessage struct {
+	Name string
+	Link string
+}
+
+func (VerificationMessage) Template() string { return "verification" }
+
+type WelcomeMessage struct {
+	Name        string
+	NewBlogLink string
+}
+
+func (WelcomeMessage) Template() string { return "welcome" }
+
+type SiteApprovedMessage struct {
+	Name      string
+	Subdomain string
+	SiteLink  string
+}
+
+func (SiteApprovedMessage) Template() string { return "site-approved" }
+
+type SiteRejectedMessage struct {
+	Name        string
+	Subdomain   string
+	Reason      string
+	NewBlogLink string
+}
+
+func (SiteRejectedMessage) Template() string { return "site-rejected" }
+
+type PasswordChangedMessage struct {
+	Name      string
+	ChangedAt time.Time
+	LoginLink string
+}
+
+func (PasswordChangedMessage) Template() string { return "password-changed" }
+
+// An announcement written by an admin.
+type BroadcastMessage struct {
+	Name    string
+	Subject string
+	// The rendered markdown, and its source for the plaintext part.
+	Body            template.HTML
+	Text            string
+	UnsubscribeLink string
+}
+
+func (BroadcastMessage) Template() string { return "broadcast" }
+
+// One click unsubscribe, per RFC 8058.
+func (m BroadcastMessage) Headers() map[string]string {
+	return map[string]string{
+		"List-Unsubscribe":      "<" + m.UnsubscribeLink + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// Sent back after a post arrives by email.
+type PostPublishedMessage struct {
+	Name     string
+	Title    string
+	PostLink string
+	// Attachments that were left out of the post.
+	Skipped []string
+}
+
+func (PostPublishedMessage) Template() string { return "post-published" }
+
+// An automatic reply, so vacation responders and the like leave it alone (RFC 3834).
+func (PostPublishedMessage) Headers() map[string]string {
+	return map[string]string{"Auto-Submitted": "auto-replied"}
+}
+
+// Messages that need headers beyond the usual ones.
+type HeaderMessage interface {
+	Message
+	Headers() map[string]string
+}
+
+type Rendered struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+func (m *mailer) Render(msg Message) (Rendered, error) {
+	name := msg.Template()
+
+	body, err := m.templates.ExecuteString(name, "layouts/email", msg)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("error rendering %s html: %w", name, err)
+	}
+
+	subject, err := m.renderText(name, "subject", msg)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("error rendering %s subject: %w", name, err)
+	}
+
+	var text string
+	if m.templates.Defines(name, "text") {
+		text, err = m.renderText(name, "layouts/email.txt", msg)
+	} else {
+		text, err = HTMLToText(body)
+	}
+	if err != nil {
+		return Rendered{}, fmt.Errorf("error rendering %s text: %w", name, err)
+	}
+
+	return Rendered{
+		Subject: strings.Join(strings.Fields(subject), " "),
+		Text:    text,
+		HTML:    body,
+	}, nil
+}
+
+// Templates are html/template, so undo the escaping for plaintext output.
+func (m *mailer) renderText(name, base string, msg Message) (string, error) {
+	out, err := m.templates.ExecuteString(name, base, msg)
+	if err != nil {
+		return "", err
+	}
+
+	text := newlineRun.ReplaceAllString(html.UnescapeString(out), "\n\n")
+	return strings.TrimSpace(text) + "\n", nil
+}
@@ -0,0 +1,89 @@
+package mailer_test
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/mailer"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func TestRenderedMessagesMatchGolden(t *testing.T) {
+	t.Parallel()
+
+	m := mailer.NewWithTransport(testConfig(), mailer.NewMemoryTransport())
+
+	for _, msg := range mailer.Samples() {
+		t.Run(msg.Template(), func(t *testing.T) {
+			t.Parallel()
+
+			r, err := m.Render(msg)
+			require.NoError(t, err)
+
+			got := fmt.Sprintf("Subject: %s\n\n--- text ---\n%s\n--- html ---\n%s", r.Subject, r.Text, r.HTML)
+			path := filepath.Join("testdata", "golden", msg.Template()+".golden")
+
+			if *update {
+				require.NoError(t, os.WriteFile(path, []byte(got), 0o644))
+			}
+
+			want, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, string(want), got)
+		})
+	}
+}
+
+func TestEveryTemplateHasASample(t *testing.T) {
+	t.Parallel()
+
+	entries, err := os.ReadDir("templates")
+	require.NoError(t, err)
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, strings.TrimSuffix(e.Name(), ".html"))
+		}
+	}
+
+	var samples []string
+	for _, msg := range mailer.Samples() {
+		samples = append(samples, msg.Template())
+
+		_, ok := mailer.Sample(msg.Template())
+		require.True(t, ok)
+	}
+
+	require.ElementsMatch(t, names, samples)
+}
+
+func TestRenderGeneratesTextWhenMissing(t *testing.T) {
+	t.Parallel()
+
+	m := mailer.NewWithTransport(testConfig(), mailer.NewMemoryTransport())
+
+	r, err := m.Render(mailer.WelcomeMessage{Name: "Goose", NewBlogLink: "https://uwece.ca/new-blog"})
+	require.NoError(t, err)
+
+	require.Contains(t, r.Text, "You're verified, Goose!")
+	require.Contains(t, r.Text, "Create your blog (https://uwece.ca/new-blog)")
+	require.NotContains(t, r.Text, "<")
+}
+
+func TestRenderTemplatesSubject(t *testing.T) {
+	t.Parallel()
+
+	m := mailer.NewWithTransport(testConfig(), mailer.NewMemoryTransport())
+
+	r, err := m.Render(mailer.SiteApprovedMessage{Name: "Goose", Subdomain: "goose.28"})
+	require.NoError(t, err)
+
+	require.Equal(t, "UWECECA - goose.28 is live!", r.Subject)
+}
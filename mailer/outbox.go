@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"uwece.ca/app/utils"
+)
+
+// Writes every message to a directory as an .eml file instead of sending it.
+type FileTransport struct {
+	dir string
+}
+
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating mail outbox: %w", err)
+	}
+
+	return &FileTransport{dir: dir}, nil
+}
+
+func (t *FileTransport) Send(env Envelope, msg []byte) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), utils.NewToken()[:8])
+
+	if err := os.WriteFile(filepath.Join(t.dir, name), msg, 0o640); err != nil {
+		return fmt.Errorf("error writing message to outbox: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package mailer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	spaceRun   = regexp.MustCompile(`[ \t]+`)
+	newlineRun = regexp.MustCompile(`\n{3,}`)
+)
+
+// Elements that start on their own line.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "table": true, "tr": true, "blockquote": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"ul": true, "ol": true, "li": true, "pre": true, "hr": true,
+}
+
+// Convert an html email into readable plaintext: block
+// elements become paragraphs, list items get bullets, and links keep their
+// targets next to the link text.
+func HTMLToText(src string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		return "", fmt.Errorf("error parsing html: %w", err)
+	}
+
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(strings.ReplaceAll(n.Data, "\n", " "))
+			return
+		case html.ElementNode:
+			switch n.Data {
+			case "head", "style", "script":
+				return
+			case "br":
+				b.WriteString("\n")
+				return
+			case "li":
+				b.WriteString("\n- ")
+			case "blockquote":
+				b.WriteString("\n\n> ")
+			default:
+				if blockElements[n.Data] {
+					b.WriteString("\n\n")
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+
+		if n.Type == html.ElementNode {
+			if n.Data == "a" {
+				if href := attr(n, "href"); href != "" && href != textContent(n) {
+					b.WriteString(" (" + href + ")")
+				}
+			}
+
+			if blockElements[n.Data] && n.Data != "li" {
+				b.WriteString("\n\n")
+			}
+		}
+	}
+	walk(doc)
+
+	lines := strings.Split(b.String(), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(spaceRun.ReplaceAllString(l, " "))
+	}
+
+	text := newlineRun.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text) + "\n", nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,315 @@
+package mailer
+
+import (
+	"errors"
+	"log/slog"
+	"net/smtp"
+	"net/textproto"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrPoolClosed = errors.New("smtp pool is closed")
+
+type PoolConfig struct {
+	// Most connections open at once, senders beyond this wait their turn.
+	MaxConns int
+	// Idle connections are closed after this long, servers drop them eventually anyway.
+	IdleTimeout time.Duration
+	// Reconnect after this many messages on one connection, 0 means never.
+	MaxMessagesPerConn int
+}
+
+// Counters describing the pool's work so far.
+type PoolStats struct {
+	Sent    uint64
+	Failed  uint64
+	Dials   uint64
+	Reused  uint64
+	Retries uint64
+	Open    int
+	Idle    int
+}
+
+// Keeps SMTP connections open between messages, reconnecting when the server
+// hangs up on us.
+type SMTPPool struct {
+	cfg  SMTPConfig
+	pool PoolConfig
+
+	// One token per connection that may be open.
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*pooledConn
+	closed bool
+	done   chan struct{}
+
+	sent, failed, dials, reused, retries atomic.Uint64
+	open                                 atomic.Int64
+}
+
+type pooledConn struct {
+	c        *smtp.Client
+	sent     int
+	lastUsed time.Time
+}
+
+func NewSMTPPool(cfg SMTPConfig, pool PoolConfig) (*SMTPPool, error) {
+	t, err := NewSMTPTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if pool.MaxConns <= 0 {
+		pool.MaxConns = 1
+	}
+	if pool.IdleTimeout <= 0 {
+		pool.IdleTimeout = 30 * time.Second
+	}
+
+	p := &SMTPPool{
+		cfg:   t.cfg,
+		pool:  pool,
+		slots: make(chan struct{}, pool.MaxConns),
+		done:  make(chan struct{}),
+	}
+
+	go p.reap()
+
+	return p, nil
+}
+
+func (p *SMTPPool) Send(env Envelope, msg []byte) error {
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	pc, err := p.get()
+	if err != nil {
+		p.failed.Add(1)
+		return err
+	}
+
+	pc, err = p.sendWith(pc, env, msg)
+	p.put(pc)
+
+	return err
+}
+
+// Send on pc, retrying once on a fresh connection if a reused one turns out to
+// be dead. Returns the connection to keep using, or nil if it was discarded.
+func (p *SMTPPool) sendWith(pc *pooledConn, env Envelope, msg []byte) (*pooledConn, error) {
+	reused := pc.sent > 0
+
+	err := sendOn(pc.c, env, msg)
+	if err == nil {
+		pc.sent++
+		p.sent.Add(1)
+		return pc, nil
+	}
+
+	// The server answered, so the connection is fine and only this message failed.
+	var perr *textproto.Error
+	if errors.As(err, &perr) {
+		p.failed.Add(1)
+		if pc.c.Reset() != nil {
+			p.discard(pc)
+			return nil, err
+		}
+
+		return pc, err
+	}
+
+	p.discard(pc)
+
+	if !reused {
+		p.failed.Add(1)
+		return nil, err
+	}
+
+	p.retries.Add(1)
+	pc, err = p.dial()
+	if err != nil {
+		p.failed.Add(1)
+		return nil, err
+	}
+
+	return p.sendWith(pc, env, msg)
+}
+
+// Take an idle connection, or open a new one. Callers must hold a slot.
+func (p *SMTPPool) get() (*pooledConn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+
+	var pc *pooledConn
+	if n := len(p.idle); n > 0 {
+		pc = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+	}
+	p.mu.Unlock()
+
+	if pc != nil {
+		// Cheap check that the server has not hung up while we were idle. Like
+		// every command it gives up after the connection's timeout, so a server
+		// that has gone quiet is dropped instead of holding the slot.
+		if time.Since(pc.lastUsed) < p.pool.IdleTimeout && pc.c.Noop() == nil {
+			p.reused.Add(1)
+			return pc, nil
+		}
+
+		p.discard(pc)
+	}
+
+	return p.dial()
+}
+
+func (p *SMTPPool) dial() (*pooledConn, error) {
+	c, err := p.cfg.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	p.dials.Add(1)
+	p.open.Add(1)
+
+	return &pooledConn{c: c, lastUsed: time.Now()}, nil
+}
+
+// Return a connection to the idle list, or close it if it has done its share.
+func (p *SMTPPool) put(pc *pooledConn) {
+	if pc == nil {
+		return
+	}
+
+	if p.spent(pc) {
+		p.quit(pc)
+		return
+	}
+
+	pc.lastUsed = time.Now()
+
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.quit(pc)
+		return
+	}
+	p.idle = append(p.idle, pc)
+	p.mu.Unlock()
+}
+
+func (p *SMTPPool) spent(pc *pooledConn) bool {
+	return p.pool.MaxMessagesPerConn > 0 && pc.sent >= p.pool.MaxMessagesPerConn
+}
+
+func (p *SMTPPool) quit(pc *pooledConn) {
+	if err := pc.c.Quit(); err != nil {
+		pc.c.Close()
+	}
+	p.open.Add(-1)
+}
+
+func (p *SMTPPool) discard(pc *pooledConn) {
+	pc.c.Close()
+	p.open.Add(-1)
+}
+
+// How often a pool that has been busy logs its stats.
+const poolStatsInterval = time.Hour
+
+// Close connections that have been idle too long, and now and then log what
+// the pool has been up to.
+func (p *SMTPPool) reap() {
+	ticker := time.NewTicker(p.pool.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	statsTicker := time.NewTicker(poolStatsInterval)
+	defer statsTicker.Stop()
+
+	var logged PoolStats
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-statsTicker.C:
+			logged = p.logStats(logged)
+			continue
+		case <-ticker.C:
+		}
+
+		var stale []*pooledConn
+
+		p.mu.Lock()
+		fresh := p.idle[:0]
+		for _, pc := range p.idle {
+			if time.Since(pc.lastUsed) >= p.pool.IdleTimeout {
+				stale = append(stale, pc)
+			} else {
+				fresh = append(fresh, pc)
+			}
+		}
+		p.idle = fresh
+		p.mu.Unlock()
+
+		for _, pc := range stale {
+			p.quit(pc)
+		}
+	}
+}
+
+func (p *SMTPPool) Stats() PoolStats {
+	p.mu.Lock()
+	idle := len(p.idle)
+	p.mu.Unlock()
+
+	return PoolStats{
+		Sent:    p.sent.Load(),
+		Failed:  p.failed.Load(),
+		Dials:   p.dials.Load(),
+		Reused:  p.reused.Load(),
+		Retries: p.retries.Load(),
+		Open:    int(p.open.Load()),
+		Idle:    idle,
+	}
+}
+
+// Log the stats if anything was sent since last, returning what was logged.
+func (p *SMTPPool) logStats(last PoolStats) PoolStats {
+	s := p.Stats()
+	if s.Sent == last.Sent && s.Failed == last.Failed {
+		return last
+	}
+
+	slog.Info("smtp pool stats", "sent", s.Sent, "failed", s.Failed, "dials", s.Dials, "reused", s.Reused, "retries", s.Retries, "open", s.Open, "idle", s.Idle)
+
+	return s
+}
+
+// Politely close every idle connection. Sends already in progress finish, and
+// their connections are closed when returned.
+func (p *SMTPPool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	close(p.done)
+	p.mu.Unlock()
+
+	for _, pc := range idle {
+		p.quit(pc)
+	}
+
+	s := p.Stats()
+	slog.Debug("closed smtp pool", "sent", s.Sent, "failed", s.Failed, "dials", s.Dials, "reused", s.Reused, "retries", s.Retries)
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package mailer_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/mailer"
+)
+
+func newTestPool(t *testing.T, srv *fakeSMTP, cfg mailer.PoolConfig) *mailer.SMTPPool {
+	t.Helper()
+
+	p, err := mailer.NewSMTPPool(mailer.SMTPConfig{Host: "127.0.0.1", Port: srv.port(), TLS: mailer.TLSNone}, cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+
+	return p
+}
+
+func testEnvelope(i int) (mailer.Envelope, []byte) {
+	return mailer.Envelope{From: "a@b.c", To: []string{fmt.Sprintf("user%d@e.f", i)}},
+		[]byte(fmt.Sprintf("Subject: message %d\r\n\r\nbody\r\n", i))
+}
+
+func TestPoolReusesConnection(t *testing.T) {
+	t.Parallel()
+
+	srv := newFakeSMTP(t)
+	p := newTestPool(t, srv, mailer.PoolConfig{MaxConns: 2})
+
+	for i := range 5 {
+		require.NoError(t, p.Send(testEnvelope(i)))
+	}
+
+	require.Len(t, srv.deliveries(), 5)
+	require.Equal(t, 1, srv.connections())
+
+	s := p.Stats()
+	require.Equal(t, uint64(5), s.Sent)
+	require.Equal(t, uint64(1), s.Dials)
+	require.Equal(t, uint64(4), s.Reused)
+	require.Equal(t, 1, s.Idle)
+}
+
+func TestPoolReconnectsAfterServerHangsUp(t *testing.T) {
+	t.Parallel()
+
+	srv := newFakeSMTP(t)
+	p := newTestPool(t, srv, mailer.PoolConfig{MaxConns: 1})
+
+	require.NoError(t, p.Send(testEnvelope(0)))
+	srv.dropAll()
+	require.NoError(t, p.Send(testEnvelope(1)))
+
+	require.Len(t, srv.deliveries(), 2)
+	require.Equal(t, uint64(2), p.Stats().Dials)
+	require.Equal(t, 1, p.Stats().Open)
+}
+
+func TestPoolReconnectsAfterServerStalls(t *testing.T) {
+	t.Parallel()
+
+	srv := newFakeSMTP(t)
+	p, err := mailer.NewSMTPPool(mailer.SMTPConfig{
+		Host:    "127.0.0.1",
+		Port:    srv.port(),
+		TLS:     mailer.TLSNone,
+		Timeout: 50 * time.Millisecond,
+	}, mailer.PoolConfig{MaxConns: 1})
+	require.NoError(t, err)
+	t.Cleanup(func() { p.Close() })
+
+	require.NoError(t, p.Send(testEnvelope(0)))
+
+	// The idle connection stays open but goes quiet, checking it out gives up on
+	// it instead of waiting forever.
+	srv.stallAll()
+	require.NoError(t, p.Send(testEnvelope(1)))
+
+	require.Len(t, srv.deliveries(), 2)
+	require.Equal(t, uint64(2), p.Stats().Dials)
+	require.Equal(t, 1, p.Stats().Open)
+}
+
+func TestPoolKeepsConnectionAfterRejection(t *testing.T) {
+	t.Parallel()
+
+	srv := newFakeSMTP(t)
+	p := newTestPool(t, srv, mailer.PoolConfig{MaxConns: 1})
+
+	srv.mu.Lock()
+	srv.failNext = 1
+	srv.mu.Unlock()
+
+	require.ErrorContains(t, p.Send(testEnvelope(0)), "try again later")
+	require.NoError(t, p.Send(testEnvelope(1)))
+
+	require.Len(t, srv.deliveries(), 1)
+	require.Equal(t, 1, srv.connections())
+	require.Equal(t, uint64(1), p.Stats().Failed)
+}
+
+func TestPoolCapsConnections(t *testing.T) {
+	t.Parallel()
+
+	srv := newFakeSMTP(t)
+	p := newTestPool(t, srv, mailer.PoolConfig{MaxConns: 3})
+
+	var wg sync.WaitGroup
+	for i := range 30 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, p.Send(testEnvelope(i)))
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, srv.deliveries(), 30)
+	require.LessOrEqual(t, srv.peakConnections(), 3)
+	require.LessOrEqual(t, p.Stats().Open, 3)
+}
+
+func TestPoolClose(t *testing.T) {
+	t.Parallel()
+
+	srv := newFakeSMTP(t)
+	p := newTestPool(t, srv, mailer.PoolConfig{MaxConns: 1})
+
+	require.NoError(t, p.Send(testEnvelope(0)))
+	require.NoError(t, p.Close())
+
+	require.ErrorIs(t, p.Send(testEnvelope(1)), mailer.ErrPoolClosed)
+	require.Eventually(t, func() bool { return p.Stats().Open == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestPoolClosesIdleConnections(t *testing.T) {
+	t.Parallel()
+
+	srv := newFakeSMTP(t)
+	p := newTestPool(t, srv, mailer.PoolConfig{MaxConns: 1, IdleTimeout: 50 * time.Millisecond})
+
+	require.NoError(t, p.Send(testEnvelope(0)))
+	require.Eventually(t, func() bool { return p.Stats().Idle == 0 }, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, p.Send(testEnvelope(1)))
+	require.Equal(t, uint64(2), p.Stats().Dials)
+}
@@ -0,0 +1,58 @@
+package mailer
+
+import "time"
+
+// Every message with made up but realistic data, for the golden files and the
+// development email gallery. Add new messages here too.
+func Samples() []Message {
+	return []Message{
+		VerificationMessage{
+			Name: "Goose",
+			Link: "https://uwece.ca/signup/verify/abcd",
+		},
+		WelcomeMessage{
+			Name:        "Goose",
+			NewBlogLink: "https://uwece.ca/new-blog",
+		},
+		SiteApprovedMessage{
+			Name:      "Goose",
+			Subdomain: "goose.28",
+			SiteLink:  "https://goose.28.uwece.ca",
+		},
+		SiteRejectedMessage{
+			Name:        "Goose",
+			Subdomain:   "goose.28",
+			Reason:      "Subdomains can't impersonate course staff.",
+			NewBlogLink: "https://uwece.ca/new-blog",
+		},
+		PasswordChangedMessage{
+			Name:      "O'Goose",
+			ChangedAt: time.Date(2026, time.January, 5, 15, 4, 0, 0, time.UTC),
+			LoginLink: "https://uwece.ca/login",
+		},
+		BroadcastMessage{
+			Name:            "Goose",
+			Subject:         "Grad photos",
+			Body:            "<h1>Grad photos</h1>\n<p>Bring a <strong>tie</strong>.</p>\n",
+			Text:            "# Grad photos\n\nBring a **tie**.",
+			UnsubscribeLink: "https://uwece.ca/unsubscribe/1.abcd",
+		},
+		PostPublishedMessage{
+			Name:     "Goose",
+			Title:    "Notes from the co-op fair",
+			PostLink: "https://goose.28.uwece.ca/posts/notes-from-the-co-op-fair",
+			Skipped:  []string{"slides.pptx"},
+		},
+	}
+}
+
+// The sample for a template name.
+func Sample(name string) (Message, bool) {
+	for _, msg := range Samples() {
+		if msg.Template() == name {
+			return msg, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,196 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+type TLSMode string
+
+const (
+	// Plaintext only, for local relays.
+	TLSNone TLSMode = "none"
+	// Upgrade with STARTTLS when the server offers it.
+	TLSOpportunistic TLSMode = "opportunistic"
+	// Refuse to send unless STARTTLS succeeds.
+	TLSStartTLS TLSMode = "starttls"
+	// TLS from the first byte, usually port 465.
+	TLSImplicit TLSMode = "implicit"
+)
+
+const smtpDialTimeout = 10 * time.Second
+
+// Longest wait on any one read or write once connected, so a server that stops
+// answering mid-session can't hang a sender forever.
+const smtpIOTimeout = time.Minute
+
+var ErrStartTLSUnavailable = errors.New("smtp server does not support STARTTLS")
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	TLS      TLSMode
+	// Longest wait on any one read or write, smtpIOTimeout when unset.
+	Timeout time.Duration
+
+	// Only set in tests.
+	TLSConfig *tls.Config
+}
+
+// Opens a fresh connection for every message.
+type SMTPTransport struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPTransport(cfg SMTPConfig) (*SMTPTransport, error) {
+	switch cfg.TLS {
+	case "":
+		cfg.TLS = TLSOpportunistic
+	case TLSNone, TLSOpportunistic, TLSStartTLS, TLSImplicit:
+	default:
+		return nil, fmt.Errorf("unknown smtp tls mode %q", cfg.TLS)
+	}
+
+	return &SMTPTransport{cfg: cfg}, nil
+}
+
+func (t *SMTPTransport) Send(env Envelope, msg []byte) error {
+	c, err := t.cfg.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := sendOn(c, env, msg); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (cfg SMTPConfig) addr() string {
+	return net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+}
+
+func (cfg SMTPConfig) tlsConfig() *tls.Config {
+	if cfg.TLSConfig != nil {
+		return cfg.TLSConfig
+	}
+
+	return &tls.Config{ServerName: cfg.Host, MinVersion: tls.VersionTLS12}
+}
+
+// Connect, negotiate TLS as configured and authenticate.
+func (cfg SMTPConfig) dial() (*smtp.Client, error) {
+	raw, err := net.DialTimeout("tcp", cfg.addr(), smtpDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to smtp server: %w", err)
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = smtpIOTimeout
+	}
+
+	// The TLS handshake, STARTTLS included, runs over this too, so it's covered.
+	var conn net.Conn = &deadlineConn{Conn: raw, timeout: timeout}
+	if cfg.TLS == TLSImplicit {
+		conn = tls.Client(conn, cfg.tlsConfig())
+	}
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error starting smtp session: %w", err)
+	}
+
+	if err := cfg.negotiate(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Pushes the deadline forward before every read and write, so each SMTP command
+// gets its own timeout however long the connection stays open.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(b)
+}
+
+func (cfg SMTPConfig) negotiate(c *smtp.Client) error {
+	if cfg.TLS == TLSOpportunistic || cfg.TLS == TLSStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(cfg.tlsConfig()); err != nil {
+				return fmt.Errorf("error negotiating STARTTLS: %w", err)
+			}
+		} else if cfg.TLS == TLSStartTLS {
+			return ErrStartTLSUnavailable
+		}
+	}
+
+	if cfg.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server does not support authentication")
+		}
+
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return fmt.Errorf("error authenticating with smtp server: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Run a single mail transaction on an established connection.
+func sendOn(c *smtp.Client, env Envelope, msg []byte) error {
+	if err := c.Mail(env.From); err != nil {
+		return fmt.Errorf("smtp MAIL FROM rejected: %w", err)
+	}
+
+	for _, to := range env.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("smtp RCPT TO rejected for %s: %w", to, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA rejected: %w", err)
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return fmt.Errorf("error writing message body: %w", err)
+	}
+
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp server rejected message: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package mailer_test
+
+import (
+	"bytes"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A tiny SMTP server that accepts everything and remembers what it was sent.
+type fakeSMTP struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	messages []fakeDelivery
+	conns    int
+	live     map[net.Conn]struct{}
+	peak     int
+	// Reply to DATA with a failure for the next n messages.
+	failNext int
+	// Connections that still read commands but never answer them again.
+	stalled map[net.Conn]bool
+}
+
+type fakeDelivery struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fakeSMTP{ln: ln, live: make(map[net.Conn]struct{}), stalled: make(map[net.Conn]bool)}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.conns++
+			s.live[conn] = struct{}{}
+			s.peak = max(s.peak, len(s.live))
+			s.mu.Unlock()
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTP) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) deliveries() []fakeDelivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]fakeDelivery(nil), s.messages...)
+}
+
+func (s *fakeSMTP) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.conns
+}
+
+// Most connections that were open at the same time.
+func (s *fakeSMTP) peakConnections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.peak
+}
+
+// Hang up on every client, like a server restart.
+func (s *fakeSMTP) dropAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.live {
+		c.Close()
+	}
+}
+
+// Stop answering every client connected now, like a server that has wedged
+// without hanging up. New connections are served as usual.
+func (s *fakeSMTP) stallAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.live {
+		s.stalled[c] = true
+	}
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.live, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 fake.test ESMTP")
+
+	var cur fakeDelivery
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		stalled := s.stalled[conn]
+		s.mu.Unlock()
+		if stalled {
+			continue
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250-fake.test")
+			_ = tp.PrintfLine("250 8BITMIME")
+		case "MAIL":
+			cur = fakeDelivery{From: trimAddr(arg)}
+			_ = tp.PrintfLine("250 ok")
+		case "RCPT":
+			cur.To = append(cur.To, trimAddr(arg))
+			_ = tp.PrintfLine("250 ok")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			cur.Data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+
+			s.mu.Lock()
+			fail := s.failNext > 0
+			if fail {
+				s.failNext--
+			} else {
+				s.messages = append(s.messages, cur)
+			}
+			s.mu.Unlock()
+
+			if fail {
+				_ = tp.PrintfLine("451 try again later")
+			} else {
+				_ = tp.PrintfLine("250 queued")
+			}
+		case "RSET", "NOOP":
+			cur = fakeDelivery{}
+			_ = tp.PrintfLine("250 ok")
+		case "QUIT":
+			// Forget the connection before replying, the client may dial again right away.
+			s.mu.Lock()
+			delete(s.live, conn)
+			s.mu.Unlock()
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func trimAddr(arg string) string {
+	_, addr, _ := strings.Cut(arg, ":")
+	addr, _, _ = strings.Cut(addr, " ")
+	return strings.Trim(addr, "<>")
+}
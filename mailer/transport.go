@@ -0,0 +1,51 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+
+	"uwece.ca/app/config"
+)
+
+// Who a message is from and to at the SMTP level, as opposed to its headers.
+type Envelope struct {
+	From string
+	To   []string
+}
+
+// Delivers a fully rendered RFC 5322 message.
+type Transport interface {
+	Send(env Envelope, msg []byte) error
+}
+
+// Release whatever a transport holds open, for those that hold anything.
+func closeTransport(t Transport) error {
+	if c, ok := t.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// Build the transport selected by the config.
+func NewTransport(cfg config.Mailer) (Transport, error) {
+	switch cfg.Transport {
+	case "smtp":
+		return NewSMTPPool(SMTPConfig{
+			Host:     cfg.Host,
+			Port:     cfg.Port,
+			Username: cfg.Username,
+			Password: cfg.Password,
+			TLS:      TLSMode(cfg.TLS),
+		}, PoolConfig{
+			MaxConns:    cfg.MaxConns,
+			IdleTimeout: cfg.IdleTimeout,
+		})
+	case "file":
+		return NewFileTransport(cfg.OutboxDir)
+	case "memory":
+		return NewMemoryTransport(), nil
+	}
+
+	return nil, fmt.Errorf("unknown mail transport %q (expected smtp, file or memory)", cfg.Transport)
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"uwece.ca/app/db"
+)
+
+// Who a broadcast goes to.
+const (
+	AudienceAll           = "all"
+	AudienceVerifiedSites = "verified-sites"
+	AudienceCohort        = "cohort"
+)
+
+type Broadcast struct {
+	Id       int    `db:"id"`
+	AuthorId *int   `db:"author_id"`
+	Subject  string `db:"subject"`
+	// Markdown.
+	Body     string `db:"body"`
+	Audience string `db:"audience"`
+	// Graduating year for AudienceCohort, as in the last part of a subdomain.
+	Cohort *int `db:"cohort"`
+
+	CreatedAt  time.Time  `db:"created_at"`
+	FinishedAt *time.Time `db:"finished_at"`
+
+	// Delivery counts, only filled by GetBroadcasts.
+	Queued int `db:"queued"`
+	Sent   int `db:"sent"`
+	Failed int `db:"failed"`
+}
+
+type NewBroadcast struct {
+	AuthorId int
+	Subject  string
+	Body     string
+	Audience string
+	Cohort   *int
+}
+
+func InsertBroadcast(ctx context.Context, d db.Ex, nb NewBroadcast) (Broadcast, error) {
+	query := `insert into broadcasts (author_id, subject, body, audience, cohort) values (?, ?, ?, ?, ?) returning *`
+
+	var b Broadcast
+	err := db.GetContext(ctx, d, &b, query, nb.AuthorId, nb.Subject, nb.Body, nb.Audience, nb.Cohort)
+	if err != nil {
+		return Broadcast{}, db.HandleError(err)
+	}
+
+	return b, nil
+}
+
+func GetBroadcast(ctx context.Context, d db.Ex, filters ...db.Filter) (Broadcast, error) {
+	if len(filters) == 0 {
+		return Broadcast{}, errors.New("get broadcast called without filters")
+	}
+	where, args := db.BuildWhere(filters)
+
+	var b Broadcast
+	if err := db.GetContext(ctx, d, &b, `select * from broadcasts`+where, args...); err != nil {
+		return Broadcast{}, db.HandleError(err)
+	}
+
+	return b, nil
+}
+
+// Newest first, with delivery counts.
+func GetBroadcasts(ctx context.Context, d db.Ex, filters ...db.Filter) ([]Broadcast, error) {
+	where, args := db.BuildWhere(filters)
+
+	query := `
+		select
+			broadcasts.*,
+			(select count(*) from broadcast_deliveries bd where bd.broadcast_id = broadcasts.id) as queued,
+			(select count(*) from broadcast_deliveries bd where bd.broadcast_id = broadcasts.id and bd.sent_at is not null) as sent,
+			(select count(*) from broadcast_deliveries bd where bd.broadcast_id = broadcasts.id and bd.failed_at is not null) as failed
+		from broadcasts` + where + ` order by id desc`
+
+	var bs []Broadcast
+	if err := db.SelectContext(ctx, d, &bs, query, args...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return bs, nil
+}
+
+// Mark broadcasts with nothing left to send as finished.
+func FinishBroadcasts(ctx context.Context, d db.Ex, now time.Time) error {
+	query := `
+		update broadcasts set finished_at = ?
+		where finished_at is null and not exists (
+			select 1 from broadcast_deliveries bd
+			where bd.broadcast_id = broadcasts.id and bd.sent_at is null and bd.failed_at is null
+		)`
+
+	if _, err := d.ExecContext(ctx, query, now); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
+
+// Verified users who have not unsubscribed and can still be mailed, narrowed
+// down by audience.
+func recipientQuery(audience string, cohort *int) (string, []any, error) {
+	query := `
+		select users.id from users
+		left join sites on sites.user_id = users.id
+		where users.verified_at is not null and users.unsubscribed_at is null and users.undeliverable_at is null`
+
+	switch audience {
+	case AudienceAll:
+		return query, nil, nil
+	case AudienceVerifiedSites:
+		return query + ` and sites.verified_at is not null`, nil, nil
+	case AudienceCohort:
+		if cohort == nil {
+			return "", nil, errors.New("cohort audience without a cohort")
+		}
+		return query + ` and sites.subdomain like '%.' || ?`, []any{*cohort}, nil
+	}
+
+	return "", nil, errors.New("unknown broadcast audience")
+}
+
+func CountBroadcastRecipients(ctx context.Context, d db.Ex, audience string, cohort *int) (int, error) {
+	query, args, err := recipientQuery(audience, cohort)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	if err := db.GetContext(ctx, d, &n, `select count(*) from (`+query+`)`, args...); err != nil {
+		return 0, db.HandleError(err)
+	}
+
+	return n, nil
+}
+
+type BroadcastDelivery struct {
+	Id            int        `db:"id"`
+	BroadcastId   int        `db:"broadcast_id"`
+	UserId        int        `db:"user_id"`
+	Attempts      int        `db:"attempts"`
+	LastError     *string    `db:"last_error"`
+	NextAttemptAt time.Time  `db:"next_attempt_at"`
+	SentAt        *time.Time `db:"sent_at"`
+	FailedAt      *time.Time `db:"failed_at"`
+}
+
+// Queue a delivery for everyone in the broadcast's audience, returning how many were queued.
+func QueueBroadcastDeliveries(ctx context.Context, d db.Ex, b Broadcast) (int, error) {
+	query, args, err := recipientQuery(b.Audience, b.Cohort)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := d.ExecContext(ctx,
+		`insert into broadcast_deliveries (broadcast_id, user_id) select ?, id from (`+query+`)`,
+		append([]any{b.Id}, args...)...,
+	)
+	if err != nil {
+		return 0, db.HandleError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// The oldest delivery that is due to be attempted.
+func NextBroadcastDelivery(ctx context.Context, d db.Ex, now time.Time) (BroadcastDelivery, error) {
+	query := `
+		select * from broadcast_deliveries
+		where sent_at is null and failed_at is null and next_attempt_at <= ?
+		order by id limit 1`
+
+	var bd BroadcastDelivery
+	if err := db.GetContext(ctx, d, &bd, query, now); err != nil {
+		return BroadcastDelivery{}, db.HandleError(err)
+	}
+
+	return bd, nil
+}
+
+func GetBroadcastDeliveries(ctx context.Context, d db.Ex, filters ...db.Filter) ([]BroadcastDelivery, error) {
+	where, args := db.BuildWhere(filters)
+
+	var bds []BroadcastDelivery
+	if err := db.SelectContext(ctx, d, &bds, `select * from broadcast_deliveries`+where, args...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return bds, nil
+}
+
+func UpdateBroadcastDeliveries(ctx context.Context, d db.Ex, updates []db.UpdateData, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		slog.Debug("calling broadcast delivery update without filters")
+	}
+	where, args := db.BuildWhere(filters)
+	keys, values := db.BuildUpdate(updates)
+
+	values = append(values, args...)
+
+	if _, err := d.ExecContext(ctx, `update broadcast_deliveries`+keys+where, values...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
+
+func DeleteBroadcastDeliveries(ctx context.Context, d db.Ex, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("delete broadcast deliveries called without filters")
+	}
+	where, args := db.BuildWhere(filters)
+
+	if _, err := d.ExecContext(ctx, `delete from broadcast_deliveries`+where, args...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
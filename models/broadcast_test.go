@@ -0,0 +1,159 @@
+package models_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/db"
+	"uwece.ca/app/db/dbtest"
+	"uwece.ca/app/models"
+)
+
+// Seed users covering each audience: a verified 28 with a verified site, a
+// verified 29 with an unverified site, a verified user with no site, an
+// unverified user, an unsubscribed user and a user whose mail bounces.
+func seedAudience(t *testing.T, d db.Ex) map[string]int {
+	t.Helper()
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	ids := map[string]int{}
+	for _, netID := range []string{"verified28", "verified29", "nosite", "unverified", "unsubscribed", "bounced"} {
+		usr, err := models.InsertUser(ctx, d, models.NewUser{NetID: netID, Name: netID, Password: "hi"})
+		require.NoError(t, err)
+		ids[netID] = usr.Id
+
+		if netID != "unverified" {
+			require.NoError(t, models.UpdateUser(ctx, d, db.Updates(db.Update("verified_at", now)), db.FilterEq("id", usr.Id)))
+		}
+	}
+
+	require.NoError(t, models.UpdateUser(ctx, d, db.Updates(db.Update("unsubscribed_at", now)), db.FilterEq("id", ids["unsubscribed"])))
+	require.NoError(t, models.UpdateUser(ctx, d, db.Updates(db.Update("undeliverable_at", now)), db.FilterEq("id", ids["bounced"])))
+
+	site, err := models.InsertSite(ctx, d, models.NewSite{UserId: ids["verified28"], Subdomain: "goose.28"})
+	require.NoError(t, err)
+	require.NoError(t, models.UpdateSites(ctx, d, db.Updates(db.Update("verified_at", now)), db.FilterEq("id", site.Id)))
+
+	_, err = models.InsertSite(ctx, d, models.NewSite{UserId: ids["verified29"], Subdomain: "gander.29"})
+	require.NoError(t, err)
+
+	_, err = models.InsertSite(ctx, d, models.NewSite{UserId: ids["unsubscribed"], Subdomain: "quiet.28"})
+	require.NoError(t, err)
+
+	_, err = models.InsertSite(ctx, d, models.NewSite{UserId: ids["bounced"], Subdomain: "lost.28"})
+	require.NoError(t, err)
+
+	return ids
+}
+
+func TestCountBroadcastRecipients(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	seedAudience(t, d)
+	ctx := context.Background()
+
+	n, err := models.CountBroadcastRecipients(ctx, d, models.AudienceAll, nil)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	n, err = models.CountBroadcastRecipients(ctx, d, models.AudienceVerifiedSites, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	cohort := 28
+	n, err = models.CountBroadcastRecipients(ctx, d, models.AudienceCohort, &cohort)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	_, err = models.CountBroadcastRecipients(ctx, d, models.AudienceCohort, nil)
+	require.Error(t, err)
+
+	_, err = models.CountBroadcastRecipients(ctx, d, "everyone-ever", nil)
+	require.Error(t, err)
+}
+
+func TestBroadcastQueueLifecycle(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	ids := seedAudience(t, d)
+	ctx := context.Background()
+
+	cohort := 29
+	b, err := models.InsertBroadcast(ctx, d, models.NewBroadcast{
+		AuthorId: ids["nosite"],
+		Subject:  "Hello 29s",
+		Body:     "# Hi",
+		Audience: models.AudienceCohort,
+		Cohort:   &cohort,
+	})
+	require.NoError(t, err)
+
+	n, err := models.QueueBroadcastDeliveries(ctx, d, b)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	bd, err := models.NextBroadcastDelivery(ctx, d, time.Now().UTC())
+	require.NoError(t, err)
+	require.Equal(t, ids["verified29"], bd.UserId)
+
+	// Not finished while a delivery is pending.
+	require.NoError(t, models.FinishBroadcasts(ctx, d, time.Now().UTC()))
+	bs, err := models.GetBroadcasts(ctx, d)
+	require.NoError(t, err)
+	require.Len(t, bs, 1)
+	require.Nil(t, bs[0].FinishedAt)
+	require.Equal(t, 1, bs[0].Queued)
+	require.Equal(t, 0, bs[0].Sent)
+
+	require.NoError(t, models.UpdateBroadcastDeliveries(ctx, d,
+		db.Updates(db.Update("sent_at", time.Now().UTC())), db.FilterEq("id", bd.Id)))
+
+	_, err = models.NextBroadcastDelivery(ctx, d, time.Now().UTC())
+	require.ErrorIs(t, err, db.ErrNoRows)
+
+	require.NoError(t, models.FinishBroadcasts(ctx, d, time.Now().UTC()))
+	bs, err = models.GetBroadcasts(ctx, d, db.FilterEq("id", b.Id))
+	require.NoError(t, err)
+	require.NotNil(t, bs[0].FinishedAt)
+	require.Equal(t, 1, bs[0].Sent)
+}
+
+func TestNextBroadcastDeliveryWaitsForRetry(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	ids := seedAudience(t, d)
+	ctx := context.Background()
+
+	b, err := models.InsertBroadcast(ctx, d, models.NewBroadcast{
+		AuthorId: ids["nosite"],
+		Subject:  "Hello",
+		Body:     "Hi",
+		Audience: models.AudienceVerifiedSites,
+	})
+	require.NoError(t, err)
+	_, err = models.QueueBroadcastDeliveries(ctx, d, b)
+	require.NoError(t, err)
+
+	bd, err := models.NextBroadcastDelivery(ctx, d, time.Now().UTC())
+	require.NoError(t, err)
+
+	retryAt := time.Now().UTC().Add(time.Minute)
+	require.NoError(t, models.UpdateBroadcastDeliveries(ctx, d,
+		db.Updates(db.Update("attempts", 1), db.Update("next_attempt_at", retryAt)), db.FilterEq("id", bd.Id)))
+
+	_, err = models.NextBroadcastDelivery(ctx, d, time.Now().UTC())
+	require.ErrorIs(t, err, db.ErrNoRows)
+
+	bd, err = models.NextBroadcastDelivery(ctx, d, retryAt.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, 1, bd.Attempts)
+}
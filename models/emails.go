@@ -61,3 +61,16 @@ func GetEmails(ctx context.Context, d db.Ex, filters ...db.Filter) ([]Email, err
 
 	return emails, nil
 }
+
+func DeleteEmails(ctx context.Context, d db.Ex, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("must provide filters to delete_emails")
+	}
+	where, args := db.BuildWhere(filters)
+
+	if _, err := d.ExecContext(ctx, `delete from emails`+where, args...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
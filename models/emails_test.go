@@ -136,3 +136,27 @@ func TestGetEmailsNoErrorNotFound(t *testing.T) {
 
 	require.Empty(t, s)
 }
+
+func TestDeleteEmails(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	id := SeedUser(t, d)
+
+	_, err := models.InsertEmail(context.Background(), d, models.NewEmail{UserId: id, Token: "1234"})
+	require.NoError(t, err)
+
+	require.NoError(t, models.DeleteEmails(context.Background(), d, db.FilterEq("user_id", id)))
+
+	s, err := models.GetEmails(context.Background(), d)
+	require.NoError(t, err)
+
+	require.Empty(t, s)
+}
+
+func TestDeleteEmailsExitsWithNoFilters(t *testing.T) {
+	t.Parallel()
+
+	require.Error(t, models.DeleteEmails(context.Background(), nil))
+}
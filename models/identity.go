@@ -0,0 +1,78 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"uwece.ca/app/db"
+)
+
+// A link between a user and an account at an external identity provider.
+type Identity struct {
+	Id      int    `db:"id"`
+	UserId  int    `db:"user_id"`
+	Issuer  string `db:"issuer"`
+	Subject string `db:"subject"`
+
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type NewIdentity struct {
+	UserId  int
+	Issuer  string
+	Subject string
+}
+
+func InsertIdentity(ctx context.Context, d db.Ex, ni NewIdentity) (Identity, error) {
+	query := `insert into identities (user_id, issuer, subject) values (?, ?, ?) returning *`
+
+	var identity Identity
+	err := db.GetContext(ctx, d, &identity, query, ni.UserId, ni.Issuer, ni.Subject)
+	if err != nil {
+		return Identity{}, db.HandleError(err)
+	}
+
+	return identity, nil
+}
+
+func GetIdentity(ctx context.Context, d db.Ex, filters ...db.Filter) (Identity, error) {
+	if len(filters) == 0 {
+		return Identity{}, errors.New("must provide filters to get_identity")
+	}
+
+	where, args := db.BuildWhere(filters)
+
+	var identity Identity
+	err := db.GetContext(ctx, d, &identity, `select * from identities`+where, args...)
+	if err != nil {
+		return Identity{}, db.HandleError(err)
+	}
+
+	return identity, nil
+}
+
+func GetIdentities(ctx context.Context, d db.Ex, filters ...db.Filter) ([]Identity, error) {
+	where, args := db.BuildWhere(filters)
+
+	var identities []Identity
+	err := db.SelectContext(ctx, d, &identities, `select * from identities`+where, args...)
+	if err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return identities, nil
+}
+
+func DeleteIdentities(ctx context.Context, d db.Ex, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("must provide filters to delete_identities")
+	}
+	where, args := db.BuildWhere(filters)
+
+	if _, err := d.ExecContext(ctx, `delete from identities`+where, args...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
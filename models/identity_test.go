@@ -0,0 +1,133 @@
+package models_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/db"
+	"uwece.ca/app/db/dbtest"
+	"uwece.ca/app/models"
+)
+
+func TestInsertIdentity(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	id := SeedUser(t, d)
+
+	ni := models.NewIdentity{
+		UserId:  id,
+		Issuer:  "https://login.example.com",
+		Subject: "1234",
+	}
+
+	i, err := models.InsertIdentity(context.Background(), d, ni)
+	require.NoError(t, err)
+
+	require.Equal(t, ni.UserId, i.UserId)
+	require.Equal(t, ni.Issuer, i.Issuer)
+	require.Equal(t, ni.Subject, i.Subject)
+}
+
+func TestInsertIdentityGivesConflictError(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	id := SeedUser(t, d)
+
+	ni := models.NewIdentity{
+		UserId:  id,
+		Issuer:  "https://login.example.com",
+		Subject: "1234",
+	}
+
+	_, err := models.InsertIdentity(context.Background(), d, ni)
+	require.NoError(t, err)
+
+	_, err = models.InsertIdentity(context.Background(), d, ni)
+	require.ErrorIs(t, err, db.ErrUnique)
+}
+
+func TestGetIdentity(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	id := SeedUser(t, d)
+
+	i, err := models.InsertIdentity(context.Background(), d, models.NewIdentity{
+		UserId:  id,
+		Issuer:  "https://login.example.com",
+		Subject: "1234",
+	})
+	require.NoError(t, err)
+
+	i2, err := models.GetIdentity(context.Background(), d, db.FilterEq("issuer", i.Issuer), db.FilterEq("subject", i.Subject))
+	require.NoError(t, err)
+
+	require.Equal(t, i, i2)
+}
+
+func TestGetIdentityExistsWithNoFilters(t *testing.T) {
+	t.Parallel()
+
+	_, err := models.GetIdentity(context.Background(), nil)
+	require.Error(t, err)
+}
+
+func TestGetIdentityGivesNotFoundError(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+
+	_, err := models.GetIdentity(context.Background(), d, db.FilterEq("user_id", 10000))
+	require.ErrorIs(t, err, db.ErrNoRows)
+}
+
+func TestGetIdentities(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	id := SeedUser(t, d)
+
+	for _, sub := range []string{"1", "2"} {
+		_, err := models.InsertIdentity(context.Background(), d, models.NewIdentity{
+			UserId:  id,
+			Issuer:  "https://login.example.com",
+			Subject: sub,
+		})
+		require.NoError(t, err)
+	}
+
+	i, err := models.GetIdentities(context.Background(), d, db.FilterEq("user_id", id))
+	require.NoError(t, err)
+
+	require.Len(t, i, 2)
+}
+
+func TestDeleteIdentities(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	id := SeedUser(t, d)
+
+	_, err := models.InsertIdentity(context.Background(), d, models.NewIdentity{
+		UserId:  id,
+		Issuer:  "https://login.example.com",
+		Subject: "1234",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, models.DeleteIdentities(context.Background(), d, db.FilterEq("user_id", id)))
+
+	i, err := models.GetIdentities(context.Background(), d)
+	require.NoError(t, err)
+
+	require.Empty(t, i)
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"uwece.ca/app/db"
+)
+
+// A file uploaded to a site. The contents live on disk, named by their hash,
+// so the same file uploaded twice is only stored once.
+type Media struct {
+	Id     int `db:"id"`
+	SiteId int `db:"site_id"`
+
+	// Hex encoded SHA-256 of the contents.
+	Hash        string `db:"hash"`
+	Filename    string `db:"filename"`
+	ContentType string `db:"content_type"`
+	Size        int64  `db:"size"`
+	// In pixels, 0 for anything that isn't an image.
+	Width  int `db:"width"`
+	Height int `db:"height"`
+
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type NewMedia struct {
+	SiteId      int
+	Hash        string
+	Filename    string
+	ContentType string
+	Size        int64
+	Width       int
+	Height      int
+}
+
+func InsertMedia(ctx context.Context, d db.Ex, nm NewMedia) (Media, error) {
+	query := `
+		insert into media (site_id, hash, filename, content_type, size, width, height)
+		values (?, ?, ?, ?, ?, ?, ?)
+		returning *`
+
+	var m Media
+	if err := db.GetContext(ctx, d, &m, query, nm.SiteId, nm.Hash, nm.Filename, nm.ContentType, nm.Size, nm.Width, nm.Height); err != nil {
+		return Media{}, db.HandleError(err)
+	}
+
+	return m, nil
+}
+
+func GetMedia(ctx context.Context, d db.Ex, filters ...db.Filter) ([]Media, error) {
+	where, args := db.BuildWhere(filters)
+
+	var ms []Media
+	if err := db.SelectContext(ctx, d, &ms, `select * from media`+where+` order by id`, args...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return ms, nil
+}
+
+func DeleteMedia(ctx context.Context, d db.Ex, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("delete media called without filters")
+	}
+	where, args := db.BuildWhere(filters)
+
+	if _, err := d.ExecContext(ctx, `delete from media`+where, args...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
+
+// A smaller copy of an image, stored like any other file.
+type MediaVariant struct {
+	Id      int `db:"id"`
+	MediaId int `db:"media_id"`
+
+	Hash        string `db:"hash"`
+	ContentType string `db:"content_type"`
+	Width       int    `db:"width"`
+	Height      int    `db:"height"`
+	Size        int64  `db:"size"`
+}
+
+type NewMediaVariant struct {
+	MediaId     int
+	Hash        string
+	ContentType string
+	Width       int
+	Height      int
+	Size        int64
+}
+
+func InsertMediaVariant(ctx context.Context, d db.Ex, nv NewMediaVariant) (MediaVariant, error) {
+	query := `
+		insert into media_variants (media_id, hash, content_type, width, height, size)
+		values (?, ?, ?, ?, ?, ?)
+		returning *`
+
+	var v MediaVariant
+	if err := db.GetContext(ctx, d, &v, query, nv.MediaId, nv.Hash, nv.ContentType, nv.Width, nv.Height, nv.Size); err != nil {
+		return MediaVariant{}, db.HandleError(err)
+	}
+
+	return v, nil
+}
+
+// Narrowest first.
+func GetMediaVariants(ctx context.Context, d db.Ex, filters ...db.Filter) ([]MediaVariant, error) {
+	where, args := db.BuildWhere(filters)
+
+	var vs []MediaVariant
+	if err := db.SelectContext(ctx, d, &vs, `select * from media_variants`+where+` order by media_id, width`, args...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return vs, nil
+}
+
+func DeleteMediaVariants(ctx context.Context, d db.Ex, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("delete media variants called without filters")
+	}
+	where, args := db.BuildWhere(filters)
+
+	if _, err := d.ExecContext(ctx, `delete from media_variants`+where, args...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
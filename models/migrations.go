@@ -68,4 +68,225 @@ var Migrations = []db.Migration{
 		`)
 		return err
 	}),
+	db.FuncMigration("0005_add_identities", func(tx db.Ex) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS identities (
+				id integer primary key AUTOINCREMENT,
+				user_id integer not null references users (id),
+				issuer varchar(255) not null,
+				subject varchar(255) not null,
+    			created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+				unique (issuer, subject)
+			);
+
+			create index identities_user_id_idx on identities (user_id);
+		`)
+		return err
+	}),
+	db.FuncMigration("0006_add_broadcasts", func(tx db.Ex) error {
+		_, err := tx.Exec(`
+			ALTER TABLE users ADD COLUMN unsubscribed_at timestamp;
+
+			CREATE TABLE IF NOT EXISTS broadcasts (
+				id integer primary key AUTOINCREMENT,
+				author_id integer references users (id) on delete set null,
+				subject varchar(255) not null,
+				body varchar not null,
+				audience varchar(32) not null,
+				cohort integer,
+    			created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				finished_at timestamp
+			);
+
+			CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+				id integer primary key AUTOINCREMENT,
+				broadcast_id integer not null references broadcasts (id),
+				user_id integer not null references users (id),
+				attempts integer not null default 0,
+				last_error varchar,
+				next_attempt_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				sent_at timestamp,
+				failed_at timestamp,
+
+				unique (broadcast_id, user_id)
+			);
+
+			create index broadcast_deliveries_pending_idx on broadcast_deliveries (sent_at, failed_at, next_attempt_at);
+			create index broadcast_deliveries_user_id_idx on broadcast_deliveries (user_id);
+		`)
+		return err
+	}),
+	db.FuncMigration("0007_add_undeliverable_users", func(tx db.Ex) error {
+		_, err := tx.Exec(`
+			ALTER TABLE users ADD COLUMN undeliverable_at timestamp;
+			ALTER TABLE users ADD COLUMN undeliverable_reason varchar;
+		`)
+		return err
+	}),
+	db.FuncMigration("0008_add_posts", func(tx db.Ex) error {
+		_, err := tx.Exec(`
+			ALTER TABLE sites ADD COLUMN post_token varchar(64);
+			create unique index sites_post_token_idx on sites (post_token);
+
+			CREATE TABLE IF NOT EXISTS posts (
+				id integer primary key AUTOINCREMENT,
+				site_id integer not null references sites (id),
+				title varchar(255) not null,
+				slug varchar(255) not null,
+				body varchar not null,
+    			created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    			updated_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+				unique (site_id, slug)
+			);
+
+			CREATE TABLE IF NOT EXISTS media (
+				id integer primary key AUTOINCREMENT,
+				site_id integer not null references sites (id),
+				hash varchar(64) not null,
+				filename varchar(255) not null,
+				content_type varchar(255) not null,
+				size integer not null,
+    			created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+
+			create index media_site_id_idx on media (site_id);
+			create index media_hash_idx on media (hash);
+		`)
+		return err
+	}),
+	db.FuncMigration("0009_add_site_themes", func(tx db.Ex) error {
+		// An empty theme means the default one.
+		_, err := tx.Exec(`
+			alter table sites add column theme varchar(64) not null default '';
+			alter table sites add column theme_options varchar not null default '{}';
+		`)
+		return err
+	}),
+	db.FuncMigration("0010_add_user_locale", func(tx db.Ex) error {
+		// Empty means follow the browser's Accept-Language.
+		_, err := tx.Exec(`alter table users add column locale varchar(16) not null default ''`)
+		return err
+	}),
+	db.FuncMigration("0011_add_site_unlisted", func(tx db.Ex) error {
+		_, err := tx.Exec(`alter table sites add column unlisted_at timestamp`)
+		return err
+	}),
+	db.FuncMigration("0012_add_search", func(tx db.Ex) error {
+		// Full text indexes over posts and sites. They're external content
+		// tables, so they only store the index and read the text back out of
+		// posts and sites, and triggers keep them in step with every write.
+		// The rebuilds fill them from what's already there.
+		_, err := tx.Exec(`
+			create virtual table if not exists posts_fts using fts5(
+				title, body,
+				content='posts', content_rowid='id',
+				tokenize='unicode61 remove_diacritics 2'
+			);
+
+			create trigger if not exists posts_fts_insert after insert on posts begin
+				insert into posts_fts (rowid, title, body) values (new.id, new.title, new.body);
+			end;
+
+			create trigger if not exists posts_fts_delete after delete on posts begin
+				insert into posts_fts (posts_fts, rowid, title, body) values ('delete', old.id, old.title, old.body);
+			end;
+
+			create trigger if not exists posts_fts_update after update of title, body on posts begin
+				insert into posts_fts (posts_fts, rowid, title, body) values ('delete', old.id, old.title, old.body);
+				insert into posts_fts (rowid, title, body) values (new.id, new.title, new.body);
+			end;
+
+			create virtual table if not exists sites_fts using fts5(
+				subdomain, home_content,
+				content='sites', content_rowid='id',
+				tokenize='unicode61 remove_diacritics 2'
+			);
+
+			create trigger if not exists sites_fts_insert after insert on sites begin
+				insert into sites_fts (rowid, subdomain, home_content) values (new.id, new.subdomain, new.home_content);
+			end;
+
+			create trigger if not exists sites_fts_delete after delete on sites begin
+				insert into sites_fts (sites_fts, rowid, subdomain, home_content) values ('delete', old.id, old.subdomain, old.home_content);
+			end;
+
+			create trigger if not exists sites_fts_update after update of subdomain, home_content on sites begin
+				insert into sites_fts (sites_fts, rowid, subdomain, home_content) values ('delete', old.id, old.subdomain, old.home_content);
+				insert into sites_fts (rowid, subdomain, home_content) values (new.id, new.subdomain, new.home_content);
+			end;
+
+			insert into posts_fts (posts_fts) values ('rebuild');
+			insert into sites_fts (sites_fts) values ('rebuild');
+		`)
+		return err
+	}),
+	db.FuncMigration("0013_add_media_variants", func(tx db.Ex) error {
+		// A null quota means the configured default.
+		_, err := tx.Exec(`
+			alter table media add column width integer not null default 0;
+			alter table media add column height integer not null default 0;
+			create index media_site_id_hash_idx on media (site_id, hash);
+
+			CREATE TABLE IF NOT EXISTS media_variants (
+				id integer primary key AUTOINCREMENT,
+				media_id integer not null references media (id),
+				hash varchar(64) not null,
+				content_type varchar(255) not null,
+				width integer not null,
+				height integer not null,
+				size integer not null,
+
+				unique (media_id, width)
+			);
+
+			create index media_variants_hash_idx on media_variants (hash);
+
+			alter table sites add column media_bytes integer not null default 0;
+			alter table sites add column media_quota integer;
+
+			update sites set media_bytes = (select coalesce(sum(size), 0) from media where media.site_id = sites.id);
+		`)
+		return err
+	}),
+	db.FuncMigration("0014_add_publishing", func(tx db.Ex) error {
+		// Posts already up were published when they were written. A site's
+		// home page draft waits beside the live one until it's published.
+		_, err := tx.Exec(`
+			alter table posts add column status varchar(16) not null default 'published'
+				check (status in ('draft', 'scheduled', 'published'));
+			alter table posts add column published_at timestamp;
+			update posts set published_at = created_at;
+			create index posts_status_published_at_idx on posts (status, published_at);
+
+			alter table sites add column home_draft varchar;
+			alter table sites add column home_publish_at timestamp;
+			create index sites_home_publish_at_idx on sites (home_publish_at);
+		`)
+		return err
+	}),
+	db.FuncMigration("0015_add_revisions", func(tx db.Ex) error {
+		// Every site starts with its current content as the first revision.
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS revisions (
+				id integer primary key AUTOINCREMENT,
+				site_id integer not null references sites (id),
+				field varchar(32) not null check (field in ('home_content', 'navbar', 'custom_stylesheet')),
+				content varchar not null,
+				author_id integer references users (id),
+				created_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+
+			create index revisions_site_id_field_idx on revisions (site_id, field, id);
+
+			insert into revisions (site_id, field, content, author_id, created_at)
+				select id, 'home_content', coalesce(home_content, ''), user_id, updated_at from sites;
+			insert into revisions (site_id, field, content, author_id, created_at)
+				select id, 'navbar', coalesce(navbar, ''), user_id, updated_at from sites;
+			insert into revisions (site_id, field, content, author_id, created_at)
+				select id, 'custom_stylesheet', coalesce(custom_stylesheet, ''), user_id, updated_at from sites;
+		`)
+		return err
+	}),
 }
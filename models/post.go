@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"uwece.ca/app/db"
+)
+
+// Where a post is on its way to readers. Only published posts are public.
+const (
+	PostDraft     = "draft"
+	PostScheduled = "scheduled"
+	PostPublished = "published"
+)
+
+type Post struct {
+	Id     int `db:"id"`
+	SiteId int `db:"site_id"`
+
+	Title string `db:"title"`
+	// Unique within a site, the post is served at /posts/{slug}.
+	Slug string `db:"slug"`
+	// Markdown.
+	Body string `db:"body"`
+
+	Status string `db:"status"`
+	// When the post went live, or for scheduled posts when it will. Nil for
+	// drafts.
+	PublishedAt *time.Time `db:"published_at"`
+
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+type NewPost struct {
+	SiteId int
+	Title  string
+	Slug   string
+	Body   string
+
+	// Published from now when empty.
+	Status      string
+	PublishedAt *time.Time
+}
+
+func InsertPost(ctx context.Context, d db.Ex, np NewPost) (Post, error) {
+	if np.Status == "" {
+		now := time.Now().UTC()
+		np.Status, np.PublishedAt = PostPublished, &now
+	}
+
+	query := `insert into posts (site_id, title, slug, body, status, published_at) values (?, ?, ?, ?, ?, ?) returning *`
+
+	var post Post
+	if err := db.GetContext(ctx, d, &post, query, np.SiteId, np.Title, np.Slug, np.Body, np.Status, np.PublishedAt); err != nil {
+		return Post{}, db.HandleError(err)
+	}
+
+	return post, nil
+}
+
+func GetPost(ctx context.Context, d db.Ex, filters ...db.Filter) (Post, error) {
+	if len(filters) == 0 {
+		return Post{}, errors.New("get post called without filters")
+	}
+	where, args := db.BuildWhere(filters)
+
+	var post Post
+	if err := db.GetContext(ctx, d, &post, `select * from posts`+where, args...); err != nil {
+		return Post{}, db.HandleError(err)
+	}
+
+	return post, nil
+}
+
+// Newest first, by when they went live. Drafts go by when they were written.
+func GetPosts(ctx context.Context, d db.Ex, filters ...db.Filter) ([]Post, error) {
+	where, args := db.BuildWhere(filters)
+
+	var posts []Post
+	if err := db.SelectContext(ctx, d, &posts, `select * from posts`+where+` order by coalesce(published_at, created_at) desc, id desc`, args...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return posts, nil
+}
+
+// The newest limit posts.
+func GetRecentPosts(ctx context.Context, d db.Ex, limit int, filters ...db.Filter) ([]Post, error) {
+	where, args := db.BuildWhere(filters)
+	args = append(args, limit)
+
+	var posts []Post
+	if err := db.SelectContext(ctx, d, &posts, `select * from posts`+where+` order by coalesce(published_at, created_at) desc, id desc limit ?`, args...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return posts, nil
+}
+
+func UpdatePosts(ctx context.Context, d db.Ex, updates []db.UpdateData, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("update posts called without filters")
+	}
+	where, args := db.BuildWhere(filters)
+	keys, values := db.BuildUpdate(updates)
+
+	values = append(values, args...)
+
+	if _, err := d.ExecContext(ctx, `update posts`+keys+where, values...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
+
+// Put scheduled posts due by now live, and return how many there were.
+func PublishScheduledPosts(ctx context.Context, d db.Ex, now time.Time) (int, error) {
+	query := `update posts set status = ?, updated_at = ? where status = ? and published_at <= ?`
+
+	res, err := d.ExecContext(ctx, query, PostPublished, now, PostScheduled, now)
+	if err != nil {
+		return 0, db.HandleError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, db.HandleError(err)
+	}
+
+	return int(n), nil
+}
+
+// When the next scheduled post is due, nil when none are.
+func NextScheduledPost(ctx context.Context, d db.Ex) (*time.Time, error) {
+	var at []time.Time
+	query := `select published_at from posts where status = ? order by published_at limit 1`
+	if err := db.SelectContext(ctx, d, &at, query, PostScheduled); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	if len(at) == 0 {
+		return nil, nil
+	}
+
+	return &at[0], nil
+}
+
+func DeletePosts(ctx context.Context, d db.Ex, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("delete posts called without filters")
+	}
+	where, args := db.BuildWhere(filters)
+
+	if _, err := d.ExecContext(ctx, `delete from posts`+where, args...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package models_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/db"
+	"uwece.ca/app/db/dbtest"
+	"uwece.ca/app/models"
+)
+
+func TestPostInsertAndGet(t *testing.T) {
+	t.Parallel()
+	d := dbtest.GetTestDB(t)
+
+	require.NoError(t, d.RunMigrations(models.Migrations))
+
+	siteID := SeedSite(t, d)
+	ctx := context.Background()
+
+	np := models.NewPost{SiteId: siteID, Title: "First", Slug: "first", Body: "Hi."}
+	post, err := models.InsertPost(ctx, d, np)
+	require.NoError(t, err)
+	require.Equal(t, np.Title, post.Title)
+	require.Equal(t, np.Body, post.Body)
+
+	got, err := models.GetPost(ctx, d, db.FilterEq("site_id", siteID), db.FilterEq("slug", "first"))
+	require.NoError(t, err)
+	require.Equal(t, post.Id, got.Id)
+
+	_, err = models.InsertPost(ctx, d, models.NewPost{SiteId: siteID, Title: "Again", Slug: "first", Body: "Hi."})
+	require.ErrorIs(t, err, db.ErrUnique)
+
+	second, err := models.InsertPost(ctx, d, models.NewPost{SiteId: siteID, Title: "Second", Slug: "second", Body: "Hi."})
+	require.NoError(t, err)
+
+	posts, err := models.GetPosts(ctx, d, db.FilterEq("site_id", siteID))
+	require.NoError(t, err)
+	require.Len(t, posts, 2)
+	require.Equal(t, second.Id, posts[0].Id)
+
+	require.NoError(t, models.DeletePosts(ctx, d, db.FilterEq("site_id", siteID)))
+	_, err = models.GetPost(ctx, d, db.FilterEq("id", post.Id))
+	require.ErrorIs(t, err, db.ErrNoRows)
+}
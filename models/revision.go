@@ -0,0 +1,124 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"uwece.ca/app/db"
+)
+
+// The site columns that keep revisions, as stored in Revision.Field.
+const (
+	RevisionHome       = "home_content"
+	RevisionNavbar     = "navbar"
+	RevisionStylesheet = "custom_stylesheet"
+)
+
+// A saved version of one piece of a site's content. Revisions are only ever
+// added, rolling back adds the old content again as the newest.
+type Revision struct {
+	Id     int    `db:"id"`
+	SiteId int    `db:"site_id"`
+	Field  string `db:"field"`
+	// The whole content as saved, not a diff.
+	Content string `db:"content"`
+	// Who saved it, nil if that isn't known.
+	AuthorId *int `db:"author_id"`
+
+	CreatedAt time.Time `db:"created_at"`
+
+	// The author's name, only filled by GetRevisions.
+	AuthorName string `db:"author_name"`
+}
+
+type NewRevision struct {
+	SiteId   int
+	Field    string
+	Content  string
+	AuthorId *int
+}
+
+func InsertRevision(ctx context.Context, d db.Ex, nr NewRevision) (Revision, error) {
+	query := `insert into revisions (site_id, field, content, author_id, created_at) values (?, ?, ?, ?, ?) returning *`
+
+	var rev Revision
+	if err := db.GetContext(ctx, d, &rev, query, nr.SiteId, nr.Field, nr.Content, nr.AuthorId, time.Now().UTC()); err != nil {
+		return Revision{}, db.HandleError(err)
+	}
+
+	return rev, nil
+}
+
+// The newest revision matching filters.
+func GetRevision(ctx context.Context, d db.Ex, filters ...db.Filter) (Revision, error) {
+	if len(filters) == 0 {
+		return Revision{}, errors.New("get revision called without filters")
+	}
+	where, args := db.BuildWhere(filters)
+
+	var rev Revision
+	if err := db.GetContext(ctx, d, &rev, `select * from revisions`+where+` order by id desc limit 1`, args...); err != nil {
+		return Revision{}, db.HandleError(err)
+	}
+
+	return rev, nil
+}
+
+// Newest first, with their authors' names. Columns in filters are qualified
+// with their table (revisions or users).
+func GetRevisions(ctx context.Context, d db.Ex, filters ...db.Filter) ([]Revision, error) {
+	where, args := db.BuildWhere(filters)
+
+	query := `
+		select revisions.*, coalesce(users.name, '') as author_name from revisions
+		left join users on users.id = revisions.author_id` + where + `
+		order by revisions.id desc`
+
+	var revs []Revision
+	if err := db.SelectContext(ctx, d, &revs, query, args...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return revs, nil
+}
+
+// Delete revisions past the newest keep of each site's field, and those
+// from before cutoff. The newest of each, the live content, always stays.
+func PruneRevisions(ctx context.Context, d db.Ex, keep int, cutoff time.Time, filters ...db.Filter) (int, error) {
+	where, args := db.BuildWhere(filters)
+
+	query := `
+		delete from revisions where id in (
+			select id from (
+				select id, created_at, row_number() over (partition by site_id, field order by id desc) as n
+				from revisions` + where + `
+			)
+			where n > 1 and (n > ? or created_at < ?)
+		)`
+
+	res, err := d.ExecContext(ctx, query, append(args, keep, cutoff)...)
+	if err != nil {
+		return 0, db.HandleError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, db.HandleError(err)
+	}
+
+	return int(n), nil
+}
+
+func DeleteRevisions(ctx context.Context, d db.Ex, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("delete revisions called without filters")
+	}
+	where, args := db.BuildWhere(filters)
+
+	if _, err := d.ExecContext(ctx, `delete from revisions`+where, args...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"uwece.ca/app/db"
+)
+
+// Wrapped around every matched term in highlights and snippets. Control
+// characters never turn up in posts, so callers can escape the text and then
+// swap these for markup.
+const (
+	MatchStart = "\x02"
+	MatchEnd   = "\x03"
+)
+
+// Between a snippet and the rest of the text it was cut from.
+const snippetEllipsis = "…"
+
+// Whether sqlite was built with FTS5, which mattn/go-sqlite3 only does with
+// the sqlite_fts5 build tag.
+func SearchSupported(ctx context.Context, d db.Ex) (bool, error) {
+	var supported bool
+	if err := db.GetContext(ctx, d, &supported, `select sqlite_compileoption_used('ENABLE_FTS5')`); err != nil {
+		return false, db.HandleError(err)
+	}
+
+	return supported, nil
+}
+
+// A post matching a search, with the matched terms between MatchStart and
+// MatchEnd.
+type PostMatch struct {
+	Id        int       `db:"id"`
+	SiteId    int       `db:"site_id"`
+	Subdomain string    `db:"subdomain"`
+	Slug      string    `db:"slug"`
+	Title     string    `db:"title"`
+	CreatedAt time.Time `db:"created_at"`
+	// See Post.PublishedAt.
+	PublishedAt *time.Time `db:"published_at"`
+
+	// The whole title, highlighted.
+	TitleHighlight string `db:"title_highlight"`
+	// A few words of the body around the best matches.
+	Snippet string `db:"snippet"`
+}
+
+// Matches in a title count for more than the same words in a body.
+const postMatchSelect = `
+	select
+		posts.id, posts.site_id, sites.subdomain, posts.slug, posts.title, posts.created_at, posts.published_at,
+		highlight(posts_fts, 0, '` + MatchStart + `', '` + MatchEnd + `') as title_highlight,
+		snippet(posts_fts, 1, '` + MatchStart + `', '` + MatchEnd + `', '` + snippetEllipsis + `', 24) as snippet
+	from posts_fts
+	join posts on posts.id = posts_fts.rowid
+	join sites on sites.id = posts.site_id`
+
+// Best match first. Filters need a posts_fts match, and columns are qualified
+// with their table (posts or sites).
+func SearchPosts(ctx context.Context, d db.Ex, page db.Page, filters ...db.Filter) ([]PostMatch, error) {
+	where, args := db.BuildWhere(filters)
+	limit, pageArgs := db.BuildPage(page)
+
+	query := postMatchSelect + where + ` order by bm25(posts_fts, 5.0, 1.0), posts.id desc` + limit
+
+	var matches []PostMatch
+	if err := db.SelectContext(ctx, d, &matches, query, append(args, pageArgs...)...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return matches, nil
+}
+
+func CountPostMatches(ctx context.Context, d db.Ex, filters ...db.Filter) (int, error) {
+	where, args := db.BuildWhere(filters)
+
+	query := `select count(*) from posts_fts
+		join posts on posts.id = posts_fts.rowid
+		join sites on sites.id = posts.site_id` + where
+
+	var count int
+	if err := db.GetContext(ctx, d, &count, query, args...); err != nil {
+		return 0, db.HandleError(err)
+	}
+
+	return count, nil
+}
+
+// A site matching a search by its name or home page.
+type SiteMatch struct {
+	Id        int    `db:"id"`
+	Subdomain string `db:"subdomain"`
+	// A few words of the home page around the best matches, between
+	// MatchStart and MatchEnd.
+	Snippet string `db:"snippet"`
+}
+
+// Best match first. Filters need a sites_fts match, and columns are qualified
+// with sites.
+func SearchSites(ctx context.Context, d db.Ex, page db.Page, filters ...db.Filter) ([]SiteMatch, error) {
+	where, args := db.BuildWhere(filters)
+	limit, pageArgs := db.BuildPage(page)
+
+	query := `
+		select
+			sites.id, sites.subdomain,
+			coalesce(snippet(sites_fts, 1, '` + MatchStart + `', '` + MatchEnd + `', '` + snippetEllipsis + `', 24), '') as snippet
+		from sites_fts
+		join sites on sites.id = sites_fts.rowid` + where + `
+		order by bm25(sites_fts, 5.0, 1.0), sites.id` + limit
+
+	var matches []SiteMatch
+	if err := db.SelectContext(ctx, d, &matches, query, append(args, pageArgs...)...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,92 @@
+package models_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/db"
+	"uwece.ca/app/db/dbtest"
+	"uwece.ca/app/models"
+)
+
+func searchPosts(t *testing.T, d db.Ex, match string) []models.PostMatch {
+	t.Helper()
+
+	matches, err := models.SearchPosts(context.Background(), d, db.PageNumber(1, 10), db.FilterMatch("posts_fts", match))
+	require.NoError(t, err)
+
+	return matches
+}
+
+func TestSearchFollowsPosts(t *testing.T) {
+	t.Parallel()
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	ctx := context.Background()
+
+	siteID := SeedSite(t, d)
+	post, err := models.InsertPost(ctx, d, models.NewPost{SiteId: siteID, Title: "Co-op fair", Slug: "co-op-fair", Body: "Met lots of recruiters."})
+	require.NoError(t, err)
+	_, err = models.InsertPost(ctx, d, models.NewPost{SiteId: siteID, Title: "Recruiters", Slug: "recruiters", Body: "A list."})
+	require.NoError(t, err)
+
+	matches := searchPosts(t, d, "recruiters")
+	require.Len(t, matches, 2)
+	// A title match ranks above a body match.
+	require.Equal(t, "recruiters", matches[0].Slug)
+	require.Equal(t, models.MatchStart+"Recruiters"+models.MatchEnd, matches[0].TitleHighlight)
+	require.Equal(t, "Met lots of "+models.MatchStart+"recruiters"+models.MatchEnd+".", matches[1].Snippet)
+	require.Equal(t, "zach.30", matches[1].Subdomain)
+
+	count, err := models.CountPostMatches(ctx, d, db.FilterMatch("posts_fts", "recruiters"))
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+
+	require.NoError(t, models.UpdatePosts(ctx, d, db.Updates(db.Update("body", "Met nobody.")), db.FilterEq("id", post.Id)))
+	require.Len(t, searchPosts(t, d, "recruiters"), 1)
+	require.Len(t, searchPosts(t, d, "nobody"), 1)
+
+	require.NoError(t, models.DeletePosts(ctx, d, db.FilterEq("id", post.Id)))
+	require.Empty(t, searchPosts(t, d, "nobody"))
+}
+
+func TestSearchFollowsSites(t *testing.T) {
+	t.Parallel()
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	ctx := context.Background()
+
+	siteID := SeedSite(t, d)
+	require.NoError(t, models.UpdateSites(ctx, d, db.Updates(db.Update("home_content", "I like géotechnique.")), db.FilterEq("id", siteID)))
+
+	// Accents are folded.
+	matches, err := models.SearchSites(ctx, d, db.PageNumber(1, 10), db.FilterMatch("sites_fts", "geotechnique"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, "I like "+models.MatchStart+"géotechnique"+models.MatchEnd+".", matches[0].Snippet)
+
+	// Names are indexed too.
+	matches, err = models.SearchSites(ctx, d, db.PageNumber(1, 10), db.FilterMatch("sites_fts", "zach"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, "zach.30", matches[0].Subdomain)
+}
+
+func TestSearchMigrationBackfills(t *testing.T) {
+	t.Parallel()
+	added := slices.IndexFunc(models.Migrations, func(m db.Migration) bool { return m.Name == "0012_add_search" })
+	require.Positive(t, added)
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations[:added]))
+	ctx := context.Background()
+
+	siteID := SeedSite(t, d)
+	// As the schema was then.
+	_, err := d.ExecContext(ctx, `insert into posts (site_id, title, slug, body) values (?, 'Old', 'old', 'Written before search.')`, siteID)
+	require.NoError(t, err)
+
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	require.Len(t, searchPosts(t, d, "search"), 1)
+}
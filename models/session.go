@@ -61,3 +61,16 @@ func GetSessions(ctx context.Context, d db.Ex, filters ...db.Filter) ([]Session,
 
 	return sessions, nil
 }
+
+func DeleteSessions(ctx context.Context, d db.Ex, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("must provide filters to delete_sessions")
+	}
+	where, args := db.BuildWhere(filters)
+
+	if _, err := d.ExecContext(ctx, `delete from sessions`+where, args...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
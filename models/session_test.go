@@ -8,6 +8,7 @@ import (
 	"uwece.ca/app/db"
 	"uwece.ca/app/db/dbtest"
 	"uwece.ca/app/models"
+	"uwece.ca/app/utils"
 )
 
 func SeedUser(t *testing.T, d db.Ex) int {
@@ -145,3 +146,31 @@ func TestGetSessionsNoErrorNotFound(t *testing.T) {
 
 	require.Empty(t, s)
 }
+
+func TestDeleteSessions(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	id := SeedUser(t, d)
+
+	for _, token := range []string{"1234", "5678"} {
+		_, err := models.InsertSession(context.Background(), d, models.NewSession{UserId: id, Token: utils.Token(token)})
+		require.NoError(t, err)
+	}
+
+	err := models.DeleteSessions(context.Background(), d, db.FilterEq("user_id", id), db.FilterNotEq("token", "1234"))
+	require.NoError(t, err)
+
+	s, err := models.GetSessions(context.Background(), d)
+	require.NoError(t, err)
+
+	require.Len(t, s, 1)
+	require.Equal(t, utils.Token("1234"), s[0].Token)
+}
+
+func TestDeleteSessionsExitsWithNoFilters(t *testing.T) {
+	t.Parallel()
+
+	require.Error(t, models.DeleteSessions(context.Background(), nil))
+}
@@ -18,6 +18,28 @@ type Site struct {
 	Navbar           string `db:"navbar"`
 	CustomStylesheet string `db:"custom_stylesheet"`
 
+	// Unpublished changes to HomeContent, nil when there are none.
+	HomeDraft *string `db:"home_draft"`
+	// When HomeDraft goes live, nil until it's scheduled.
+	HomePublishAt *time.Time `db:"home_publish_at"`
+
+	// Directory name of the blog theme, "" for the default.
+	Theme string `db:"theme"`
+	// JSON object of the theme's option values.
+	ThemeOptions string `db:"theme_options"`
+
+	// Local part of the secret address for posting by email, nil until the
+	// owner turns it on.
+	PostToken *string `db:"post_token"`
+
+	// Bytes of uploads stored for the site, variants included.
+	MediaBytes int64 `db:"media_bytes"`
+	// Most MediaBytes can reach, nil for the configured default.
+	MediaQuota *int64 `db:"media_quota"`
+
+	// Set when the owner leaves the site out of the public directory.
+	UnlistedAt *time.Time `db:"unlisted_at"`
+
 	VerifiedAt *time.Time `db:"verified_at"`
 	UpdatedAt  time.Time  `db:"updated_at"`
 	CreatedAt  time.Time  `db:"created_at"`
@@ -78,6 +100,34 @@ func GetSites(ctx context.Context, d db.Ex, filters ...db.Filter) ([]Site, error
 	return site, nil
 }
 
+// Sites in the order the directory shows them: newest graduating year first,
+// then by name.
+func GetSitesPage(ctx context.Context, d db.Ex, page db.Page, filters ...db.Filter) ([]Site, error) {
+	where, args := db.BuildWhere(filters)
+	limit, pageArgs := db.BuildPage(page)
+
+	query := `select * from sites` + where + `
+		order by cast(substr(subdomain, instr(subdomain, '.') + 1) as integer) desc, subdomain` + limit
+
+	var sites []Site
+	if err := db.SelectContext(ctx, d, &sites, query, append(args, pageArgs...)...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return sites, nil
+}
+
+func CountSites(ctx context.Context, d db.Ex, filters ...db.Filter) (int, error) {
+	where, args := db.BuildWhere(filters)
+
+	var count int
+	if err := db.GetContext(ctx, d, &count, `select count(*) from sites`+where, args...); err != nil {
+		return 0, db.HandleError(err)
+	}
+
+	return count, nil
+}
+
 func UpdateSites(ctx context.Context, d db.Ex, updates []db.UpdateData, filters ...db.Filter) error {
 	keys, values := db.BuildUpdate(updates)
 	where, args := db.BuildWhere(filters)
@@ -90,3 +140,92 @@ func UpdateSites(ctx context.Context, d db.Ex, updates []db.UpdateData, filters
 
 	return nil
 }
+
+// Swap in home page drafts scheduled for now or earlier, each saved as a
+// revision by the site's owner unless it's the same as the newest one, and
+// return the sites that were published. Run it in a transaction.
+func PublishScheduledHomes(ctx context.Context, d db.Ex, now time.Time) ([]int, error) {
+	revisions := `
+		insert into revisions (site_id, field, content, author_id, created_at)
+		select id, ?, home_draft, user_id, ? from sites
+		where home_draft is not null and home_publish_at <= ? and home_draft is not (
+			select content from revisions
+			where revisions.site_id = sites.id and revisions.field = ?
+			order by revisions.id desc limit 1
+		)`
+
+	if _, err := d.ExecContext(ctx, revisions, RevisionHome, now, now, RevisionHome); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	query := `
+		update sites set home_content = home_draft, home_draft = null, home_publish_at = null, updated_at = ?
+		where home_draft is not null and home_publish_at <= ?
+		returning id`
+
+	var ids []int
+	if err := db.SelectContext(ctx, d, &ids, query, now, now); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return ids, nil
+}
+
+// When the next scheduled home page is due, nil when none are.
+func NextScheduledHome(ctx context.Context, d db.Ex) (*time.Time, error) {
+	var at []time.Time
+	query := `select home_publish_at from sites where home_draft is not null and home_publish_at is not null order by home_publish_at limit 1`
+	if err := db.SelectContext(ctx, d, &at, query); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	if len(at) == 0 {
+		return nil, nil
+	}
+
+	return &at[0], nil
+}
+
+// Count size more bytes against a site's uploads, unless that takes it past
+// its quota (defaultQuota when it has none), in which case nothing changes
+// and false is returned.
+func ReserveSiteMedia(ctx context.Context, d db.Ex, siteID int, size, defaultQuota int64) (bool, error) {
+	query := `
+		update sites set media_bytes = media_bytes + ?
+		where id = ? and media_bytes + ? <= coalesce(media_quota, ?)`
+
+	res, err := d.ExecContext(ctx, query, size, siteID, size, defaultQuota)
+	if err != nil {
+		return false, db.HandleError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, db.HandleError(err)
+	}
+
+	return n == 1, nil
+}
+
+// Stop counting size bytes against a site's uploads.
+func ReleaseSiteMedia(ctx context.Context, d db.Ex, siteID int, size int64) error {
+	query := `update sites set media_bytes = max(media_bytes - ?, 0) where id = ?`
+	if _, err := d.ExecContext(ctx, query, size, siteID); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
+
+func DeleteSites(ctx context.Context, d db.Ex, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("must define filters")
+	}
+	where, args := db.BuildWhere(filters)
+
+	if _, err := d.ExecContext(ctx, `delete from sites`+where, args...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
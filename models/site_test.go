@@ -120,3 +120,61 @@ func TestUpdateSites(t *testing.T) {
 
 	require.True(t, now.Before(*site.VerifiedAt))
 }
+
+func TestDeleteSites(t *testing.T) {
+	t.Parallel()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+	id := SeedSite(t, d)
+
+	require.NoError(t, models.DeleteSites(context.Background(), d, db.FilterEq("id", id)))
+
+	_, err := models.GetSite(context.Background(), d, db.FilterEq("id", id))
+	require.ErrorIs(t, err, db.ErrNoRows)
+}
+
+func TestDeleteSitesErrorOnNoFilters(t *testing.T) {
+	t.Parallel()
+
+	require.Error(t, models.DeleteSites(context.Background(), nil))
+}
+
+func TestGetSitesPageOrdersByYearThenName(t *testing.T) {
+	t.Parallel()
+	d := dbtest.GetTestDB(t)
+
+	require.NoError(t, d.RunMigrations(models.Migrations))
+
+	for i, sub := range []string{"bob.28", "amy.30", "cat.28", "dan.29", "eve.30"} {
+		usr, err := models.InsertUser(context.Background(), d, models.NewUser{NetID: sub[:3] + string(rune('0'+i)), Password: "hi"})
+		require.NoError(t, err)
+
+		_, err = models.InsertSite(context.Background(), d, models.NewSite{UserId: usr.Id, Subdomain: sub})
+		require.NoError(t, err)
+	}
+
+	subdomains := func(sites []models.Site) []string {
+		var out []string
+		for _, s := range sites {
+			out = append(out, s.Subdomain)
+		}
+		return out
+	}
+
+	first, err := models.GetSitesPage(context.Background(), d, db.PageNumber(1, 3))
+	require.NoError(t, err)
+	require.Equal(t, []string{"amy.30", "eve.30", "dan.29"}, subdomains(first))
+
+	second, err := models.GetSitesPage(context.Background(), d, db.PageNumber(2, 3))
+	require.NoError(t, err)
+	require.Equal(t, []string{"bob.28", "cat.28"}, subdomains(second))
+
+	filtered, err := models.GetSitesPage(context.Background(), d, db.PageNumber(1, 10), db.FilterLike("subdomain", "%.28"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"bob.28", "cat.28"}, subdomains(filtered))
+
+	count, err := models.CountSites(context.Background(), d, db.FilterLike("subdomain", "%.28"))
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+}
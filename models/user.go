@@ -15,6 +15,15 @@ type User struct {
 	Name  string `db:"name"`
 
 	Password string `db:"password"`
+	// The language the site is shown in, empty to use the browser's.
+	Locale string `db:"locale"`
+
+	// Set when the user opts out of announcement emails.
+	UnsubscribedAt *time.Time `db:"unsubscribed_at"`
+	// Set when mail to the user hard bounces or is reported as spam, no
+	// more mail is sent until an admin clears it.
+	UndeliverableAt     *time.Time `db:"undeliverable_at"`
+	UndeliverableReason *string    `db:"undeliverable_reason"`
 
 	VerifiedAt *time.Time `db:"verified_at"`
 	CreatedAt  time.Time  `db:"created_at"`
@@ -55,6 +64,17 @@ func GetUser(ctx context.Context, d db.Ex, filters ...db.Filter) (User, error) {
 	return usr, nil
 }
 
+func GetUsers(ctx context.Context, d db.Ex, filters ...db.Filter) ([]User, error) {
+	where, args := db.BuildWhere(filters)
+
+	var usrs []User
+	if err := db.SelectContext(ctx, d, &usrs, `select * from users`+where+` order by id`, args...); err != nil {
+		return nil, db.HandleError(err)
+	}
+
+	return usrs, nil
+}
+
 func UpdateUser(ctx context.Context, d db.Ex, updates []db.UpdateData, filters ...db.Filter) error {
 	if len(filters) == 0 {
 		slog.Debug("calling user update without filters")
@@ -70,3 +90,16 @@ func UpdateUser(ctx context.Context, d db.Ex, updates []db.UpdateData, filters .
 
 	return nil
 }
+
+func DeleteUsers(ctx context.Context, d db.Ex, filters ...db.Filter) error {
+	if len(filters) == 0 {
+		return errors.New("delete users called without filters")
+	}
+	where, args := db.BuildWhere(filters)
+
+	if _, err := d.ExecContext(ctx, `delete from users`+where, args...); err != nil {
+		return db.HandleError(err)
+	}
+
+	return nil
+}
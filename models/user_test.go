@@ -104,3 +104,35 @@ func TestUpdateUser(t *testing.T) {
 	require.NotNil(t, usr.VerifiedAt)
 	require.True(t, start.Before(usr.UpdatedAt))
 }
+
+func TestDeleteUsers(t *testing.T) {
+	t.Parallel()
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+
+	id := SeedUser(t, d)
+
+	require.NoError(t, models.DeleteUsers(context.Background(), d, db.FilterEq("id", id)))
+
+	_, err := models.GetUser(context.Background(), d, db.FilterEq("id", id))
+	require.ErrorIs(t, err, db.ErrNoRows)
+}
+
+func TestDeleteUsersExitsWithNoFilters(t *testing.T) {
+	t.Parallel()
+
+	require.Error(t, models.DeleteUsers(context.Background(), nil))
+}
+
+func TestDeleteUsersGivesForeignKeyError(t *testing.T) {
+	t.Parallel()
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+
+	id := SeedUser(t, d)
+	_, err := models.InsertSession(context.Background(), d, models.NewSession{UserId: id, Token: "1234"})
+	require.NoError(t, err)
+
+	err = models.DeleteUsers(context.Background(), d, db.FilterEq("id", id))
+	require.ErrorIs(t, err, db.ErrForeignKey)
+}
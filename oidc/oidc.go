@@ -0,0 +1,347 @@
+// Package oidc is a small OpenID Connect relying party: discovery, the
+// authorization code flow with PKCE, and id token validation against the
+// provider's published keys.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrIssuerMismatch   = errors.New("issuer mismatch")
+	ErrAudienceMismatch = errors.New("id token not issued for this client")
+	ErrTokenExpired     = errors.New("id token expired")
+	ErrNonceMismatch    = errors.New("id token nonce mismatch")
+	ErrStateMismatch    = errors.New("authorization state mismatch")
+	ErrNoIDToken        = errors.New("token response did not include an id token")
+)
+
+// Tolerated clock difference between us and the provider.
+const clockSkew = 2 * time.Minute
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// Defaults to a client with a short timeout.
+	HTTPClient *http.Client
+}
+
+// The subset of the discovery document we care about.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+type Provider struct {
+	cfg  Config
+	meta Metadata
+	keys *keySet
+}
+
+// Fetch the provider's discovery document and signing keys.
+func Discover(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid"}
+	}
+	if !slices.Contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+
+	wellKnown := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+
+	var meta Metadata
+	if err := getJSON(ctx, cfg.HTTPClient, wellKnown, &meta); err != nil {
+		return nil, fmt.Errorf("error fetching discovery document: %w", err)
+	}
+
+	if meta.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("%w: configured %q, provider reports %q", ErrIssuerMismatch, cfg.Issuer, meta.Issuer)
+	}
+
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing required endpoints")
+	}
+
+	if len(meta.CodeChallengeMethods) != 0 && !slices.Contains(meta.CodeChallengeMethods, "S256") {
+		return nil, errors.New("provider does not support S256 pkce")
+	}
+
+	p := &Provider{
+		cfg:  cfg,
+		meta: meta,
+		keys: newKeySet(meta.JWKSURI, cfg.HTTPClient),
+	}
+
+	if err := p.keys.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.meta.Issuer
+}
+
+// Per-login secrets, kept client side between the redirect to the provider and the callback.
+type AuthRequest struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+func NewAuthRequest() AuthRequest {
+	return AuthRequest{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString(),
+	}
+}
+
+// Serialize into a single cookie-safe value.
+func (a AuthRequest) Encode() string {
+	return a.State + "." + a.Nonce + "." + a.Verifier
+}
+
+func DecodeAuthRequest(s string) (AuthRequest, bool) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return AuthRequest{}, false
+	}
+
+	return AuthRequest{State: parts[0], Nonce: parts[1], Verifier: parts[2]}, true
+}
+
+func (a AuthRequest) challenge() string {
+	sum := sha256.Sum256([]byte(a.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// The url to send the user's browser to.
+func (p *Provider) AuthCodeURL(req AuthRequest) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	v.Set("state", req.State)
+	v.Set("nonce", req.Nonce)
+	v.Set("code_challenge", req.challenge())
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return p.meta.AuthorizationEndpoint + sep + v.Encode()
+}
+
+type IDToken struct {
+	Issuer   string
+	Subject  string
+	Audience []string
+	Expiry   time.Time
+	IssuedAt time.Time
+	Nonce    string
+
+	// Every claim in the token, including the ones above.
+	Claims map[string]any
+}
+
+// Get a claim as a string, or "" if it is missing or not a string.
+func (t *IDToken) StringClaim(name string) string {
+	v, _ := t.Claims[name].(string)
+	return v
+}
+
+// Handle the provider's redirect back to us: check state, redeem the code and validate the id token.
+func (p *Provider) HandleCallback(ctx context.Context, query url.Values, req AuthRequest) (*IDToken, error) {
+	if e := query.Get("error"); e != "" {
+		return nil, fmt.Errorf("provider returned error %q: %s", e, query.Get("error_description"))
+	}
+
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(req.State)) != 1 {
+		return nil, ErrStateMismatch
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		return nil, errors.New("callback is missing an authorization code")
+	}
+
+	return p.Exchange(ctx, code, req)
+}
+
+// Redeem an authorization code and validate the resulting id token.
+func (p *Provider) Exchange(ctx context.Context, code string, req AuthRequest) (*IDToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", req.Verifier)
+	form.Set("client_id", p.cfg.ClientID)
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	hreq.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		hreq.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	res, err := p.cfg.HTTPClient.Do(hreq)
+	if err != nil {
+		return nil, fmt.Errorf("error calling token endpoint: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("error reading token response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", res.StatusCode, body)
+	}
+
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return nil, fmt.Errorf("error decoding token response: %w", err)
+	}
+
+	if tok.IDToken == "" {
+		return nil, ErrNoIDToken
+	}
+
+	return p.Verify(ctx, tok.IDToken, req.Nonce)
+}
+
+// Validate an id token's signature and standard claims.
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	payload, err := p.keys.verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+
+	t := &IDToken{
+		Claims: claims,
+	}
+	t.Issuer, _ = claims["iss"].(string)
+	t.Subject, _ = claims["sub"].(string)
+	t.Nonce, _ = claims["nonce"].(string)
+	t.Expiry = numericDate(claims["exp"])
+	t.IssuedAt = numericDate(claims["iat"])
+
+	switch aud := claims["aud"].(type) {
+	case string:
+		t.Audience = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				t.Audience = append(t.Audience, s)
+			}
+		}
+	}
+
+	if t.Issuer != p.meta.Issuer {
+		return nil, ErrIssuerMismatch
+	}
+
+	if t.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	if !slices.Contains(t.Audience, p.cfg.ClientID) {
+		return nil, ErrAudienceMismatch
+	}
+
+	if azp, ok := claims["azp"].(string); ok && azp != p.cfg.ClientID {
+		return nil, ErrAudienceMismatch
+	}
+
+	now := time.Now()
+	if t.Expiry.IsZero() || now.After(t.Expiry.Add(clockSkew)) {
+		return nil, ErrTokenExpired
+	}
+
+	if !t.IssuedAt.IsZero() && t.IssuedAt.After(now.Add(clockSkew)) {
+		return nil, errors.New("id token issued in the future")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(t.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
+	return t, nil
+}
+
+func numericDate(v any) time.Time {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(f), 0)
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, dest any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, url)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(dest)
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("error reading random bytes for oidc request: %v", err))
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,438 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/oidc"
+)
+
+// An in-process identity provider implementing just enough of OIDC to log someone in.
+type fakeProvider struct {
+	t   *testing.T
+	srv *httptest.Server
+	key *rsa.PrivateKey
+	kid string
+	// Published as "P-256" and "P-384", by their curves' names.
+	ecKeys map[string]*ecdsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]fakeGrant
+	claims map[string]any
+}
+
+type fakeGrant struct {
+	challenge string
+	nonce     string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &fakeProvider{
+		t:      t,
+		key:    key,
+		kid:    "key-1",
+		ecKeys: map[string]*ecdsa.PrivateKey{},
+		codes:  make(map[string]fakeGrant),
+		claims: map[string]any{},
+	}
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		require.NoError(t, err)
+		p.ecKeys[curve.Params().Name] = ecKey
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                           p.srv.URL,
+			"authorization_endpoint":           p.srv.URL + "/authorize",
+			"token_endpoint":                   p.srv.URL + "/token",
+			"jwks_uri":                         p.srv.URL + "/jwks",
+			"code_challenge_methods_supported": []string{"S256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		keys := []map[string]any{{
+			"kty": "RSA",
+			"kid": p.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}}
+		for name, ecKey := range p.ecKeys {
+			size := (ecKey.Curve.Params().BitSize + 7) / 8
+			keys = append(keys, map[string]any{
+				"kty": "EC",
+				"kid": name,
+				"use": "sig",
+				"crv": name,
+				"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, size))),
+				"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, size))),
+			})
+		}
+
+		writeJSON(w, map[string]any{"keys": keys})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		require.Equal(t, "S256", q.Get("code_challenge_method"))
+		require.Equal(t, "code", q.Get("response_type"))
+
+		code := base64.RawURLEncoding.EncodeToString([]byte(q.Get("state")))[:16]
+
+		p.mu.Lock()
+		p.codes[code] = fakeGrant{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+		p.mu.Unlock()
+
+		redirect, err := url.Parse(q.Get("redirect_uri"))
+		require.NoError(t, err)
+		rq := redirect.Query()
+		rq.Set("code", code)
+		rq.Set("state", q.Get("state"))
+		redirect.RawQuery = rq.Encode()
+
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+
+		p.mu.Lock()
+		grant, ok := p.codes[r.Form.Get("code")]
+		delete(p.codes, r.Form.Get("code"))
+		p.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]any{"error": "invalid_grant"})
+			return
+		}
+
+		claims := map[string]any{
+			"iss":                p.srv.URL,
+			"sub":                "subject-1",
+			"aud":                "client",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              grant.nonce,
+			"preferred_username": "jdoe@uwaterloo.ca",
+		}
+
+		p.mu.Lock()
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		p.mu.Unlock()
+
+		writeJSON(w, map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     p.sign(claims),
+		})
+	})
+
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+
+	return p
+}
+
+func (p *fakeProvider) sign(claims map[string]any) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": p.kid, "typ": "JWT"})
+	require.NoError(p.t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(p.t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	require.NoError(p.t, err)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Sign with the EC key for curve, claiming alg whatever the curve is.
+func (p *fakeProvider) signEC(alg, curve string, claims map[string]any) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": curve, "typ": "JWT"})
+	require.NoError(p.t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(p.t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var digest []byte
+	if alg == "ES384" {
+		sum := sha512.Sum384([]byte(signed))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256([]byte(signed))
+		digest = sum[:]
+	}
+
+	key := p.ecKeys[curve]
+	r, sv, err := ecdsa.Sign(rand.Reader, key, digest)
+	require.NoError(p.t, err)
+	size := (key.Curve.Params().BitSize + 7) / 8
+	sig := append(r.FillBytes(make([]byte, size)), sv.FillBytes(make([]byte, size))...)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (p *fakeProvider) setClaim(k string, v any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.claims[k] = v
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Walk the browser side of the flow: follow the authorize redirect and return the callback query.
+func authorize(t *testing.T, p *oidc.Provider, req oidc.AuthRequest) url.Values {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	res, err := client.Get(p.AuthCodeURL(req))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusFound, res.StatusCode)
+
+	loc, err := url.Parse(res.Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, "/callback", loc.Path)
+
+	return loc.Query()
+}
+
+func discover(t *testing.T, fp *fakeProvider) *oidc.Provider {
+	t.Helper()
+
+	p, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:      fp.srv.URL,
+		ClientID:    "client",
+		RedirectURL: "http://app.test/callback",
+		Scopes:      []string{"profile"},
+	})
+	require.NoError(t, err)
+
+	return p
+}
+
+func TestLoginFlow(t *testing.T) {
+	t.Parallel()
+
+	fp := newFakeProvider(t)
+	p := discover(t, fp)
+	req := oidc.NewAuthRequest()
+
+	tok, err := p.HandleCallback(context.Background(), authorize(t, p, req), req)
+	require.NoError(t, err)
+
+	require.Equal(t, fp.srv.URL, tok.Issuer)
+	require.Equal(t, "subject-1", tok.Subject)
+	require.Equal(t, "jdoe@uwaterloo.ca", tok.StringClaim("preferred_username"))
+}
+
+func TestAuthCodeURLRequestsOpenIDScope(t *testing.T) {
+	t.Parallel()
+
+	p := discover(t, newFakeProvider(t))
+
+	u, err := url.Parse(p.AuthCodeURL(oidc.NewAuthRequest()))
+	require.NoError(t, err)
+
+	require.Equal(t, "openid profile", u.Query().Get("scope"))
+}
+
+func TestDiscoverRejectsIssuerMismatch(t *testing.T) {
+	t.Parallel()
+
+	fp := newFakeProvider(t)
+
+	_, err := oidc.Discover(context.Background(), oidc.Config{Issuer: fp.srv.URL + "/", ClientID: "client"})
+	require.ErrorIs(t, err, oidc.ErrIssuerMismatch)
+}
+
+func TestCallbackRejectsWrongState(t *testing.T) {
+	t.Parallel()
+
+	p := discover(t, newFakeProvider(t))
+	req := oidc.NewAuthRequest()
+	q := authorize(t, p, req)
+	q.Set("state", "forged")
+
+	_, err := p.HandleCallback(context.Background(), q, req)
+	require.ErrorIs(t, err, oidc.ErrStateMismatch)
+}
+
+func TestCallbackRejectsWrongVerifier(t *testing.T) {
+	t.Parallel()
+
+	p := discover(t, newFakeProvider(t))
+	req := oidc.NewAuthRequest()
+	q := authorize(t, p, req)
+
+	req.Verifier = "stolen-code-without-verifier"
+	_, err := p.HandleCallback(context.Background(), q, req)
+	require.ErrorContains(t, err, "invalid_grant")
+}
+
+func TestCallbackRejectsWrongNonce(t *testing.T) {
+	t.Parallel()
+
+	p := discover(t, newFakeProvider(t))
+	req := oidc.NewAuthRequest()
+	q := authorize(t, p, req)
+
+	req.Nonce = "replayed"
+	_, err := p.HandleCallback(context.Background(), q, req)
+	require.ErrorIs(t, err, oidc.ErrNonceMismatch)
+}
+
+func TestCallbackRejectsOtherAudience(t *testing.T) {
+	t.Parallel()
+
+	fp := newFakeProvider(t)
+	fp.setClaim("aud", []string{"someone-else"})
+	p := discover(t, fp)
+	req := oidc.NewAuthRequest()
+
+	_, err := p.HandleCallback(context.Background(), authorize(t, p, req), req)
+	require.ErrorIs(t, err, oidc.ErrAudienceMismatch)
+}
+
+func TestCallbackRejectsExpiredToken(t *testing.T) {
+	t.Parallel()
+
+	fp := newFakeProvider(t)
+	fp.setClaim("exp", time.Now().Add(-time.Hour).Unix())
+	p := discover(t, fp)
+	req := oidc.NewAuthRequest()
+
+	_, err := p.HandleCallback(context.Background(), authorize(t, p, req), req)
+	require.ErrorIs(t, err, oidc.ErrTokenExpired)
+}
+
+func TestVerifyRejectsTamperedToken(t *testing.T) {
+	t.Parallel()
+
+	fp := newFakeProvider(t)
+	p := discover(t, fp)
+
+	raw := fp.sign(map[string]any{
+		"iss":   fp.srv.URL,
+		"sub":   "subject-1",
+		"aud":   "client",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "n",
+	})
+	forged := fp.sign(map[string]any{
+		"iss":   fp.srv.URL,
+		"sub":   "admin",
+		"aud":   "client",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "n",
+	})
+
+	_, err := p.Verify(context.Background(), raw, "n")
+	require.NoError(t, err)
+
+	// Graft the forged claims onto the original signature.
+	rawParts := strings.Split(raw, ".")
+	forgedParts := strings.Split(forged, ".")
+	_, err = p.Verify(context.Background(), rawParts[0]+"."+forgedParts[1]+"."+rawParts[2], "n")
+	require.ErrorIs(t, err, oidc.ErrBadSignature)
+}
+
+func TestVerifyBindsECAlgorithmsToCurves(t *testing.T) {
+	t.Parallel()
+
+	fp := newFakeProvider(t)
+	p := discover(t, fp)
+	claims := map[string]any{
+		"iss":   fp.srv.URL,
+		"sub":   "subject-1",
+		"aud":   "client",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "n",
+	}
+
+	for _, tc := range []struct {
+		alg   string
+		curve string
+		ok    bool
+	}{
+		{"ES256", "P-256", true},
+		{"ES384", "P-384", true},
+		{"ES256", "P-384", false},
+		{"ES384", "P-256", false},
+	} {
+		_, err := p.Verify(context.Background(), fp.signEC(tc.alg, tc.curve, claims), "n")
+		if tc.ok {
+			require.NoError(t, err, "%s with %s", tc.alg, tc.curve)
+		} else {
+			require.ErrorIs(t, err, oidc.ErrBadSignature, "%s with %s", tc.alg, tc.curve)
+		}
+	}
+}
+
+func TestVerifyRejectsUnknownKey(t *testing.T) {
+	t.Parallel()
+
+	fp := newFakeProvider(t)
+	p := discover(t, fp)
+
+	fp.mu.Lock()
+	fp.kid = "rotated"
+	fp.mu.Unlock()
+
+	// The key set was fetched during discovery, so the refetch is rate limited.
+	_, err := p.Verify(context.Background(), fp.sign(map[string]any{"iss": fp.srv.URL}), "")
+	require.ErrorIs(t, err, oidc.ErrUnknownKey)
+}
+
+func TestAuthRequestRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	req := oidc.NewAuthRequest()
+
+	decoded, ok := oidc.DecodeAuthRequest(req.Encode())
+	require.True(t, ok)
+	require.Equal(t, req, decoded)
+
+	_, ok = oidc.DecodeAuthRequest("missing.parts")
+	require.False(t, ok)
+}
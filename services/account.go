@@ -0,0 +1,347 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"uwece.ca/app/db"
+	"uwece.ca/app/models"
+)
+
+type exportProfile struct {
+	NetID string `json:"net_id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	// Empty when following the browser's language.
+	Locale string `json:"locale"`
+	// Set when announcement emails are turned off.
+	UnsubscribedAt *time.Time `json:"unsubscribed_at"`
+	// Set when mail to the account bounced.
+	UndeliverableAt *time.Time `json:"undeliverable_at"`
+	VerifiedAt      *time.Time `json:"verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+type exportSession struct {
+	Expires time.Time `json:"expires"`
+}
+
+type exportVerificationEmail struct {
+	Expires time.Time `json:"expires"`
+}
+
+type exportIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportRevision struct {
+	Field     string    `json:"field"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportSite struct {
+	Subdomain    string          `json:"subdomain"`
+	Theme        string          `json:"theme"`
+	ThemeOptions json.RawMessage `json:"theme_options"`
+	UnlistedAt   *time.Time      `json:"unlisted_at"`
+	VerifiedAt   *time.Time      `json:"verified_at"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// Write a zip archive of everything stored about a user. Secrets (the password
+// hash and session or verification tokens) are left out, everything else is
+// included as is.
+func (s *UserService) Export(ctx context.Context, usrID int, w io.Writer) error {
+	usr, err := models.GetUser(ctx, s.db, db.FilterEq("id", usrID))
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return ErrUserDoesNotExist
+		}
+
+		return fmt.Errorf("error fetching user for export: %w", err)
+	}
+
+	sessions, err := models.GetSessions(ctx, s.db, db.FilterEq("user_id", usrID))
+	if err != nil {
+		return fmt.Errorf("error fetching sessions for export: %w", err)
+	}
+
+	emails, err := models.GetEmails(ctx, s.db, db.FilterEq("user_id", usrID))
+	if err != nil {
+		return fmt.Errorf("error fetching verification emails for export: %w", err)
+	}
+
+	identities, err := models.GetIdentities(ctx, s.db, db.FilterEq("user_id", usrID))
+	if err != nil {
+		return fmt.Errorf("error fetching identities for export: %w", err)
+	}
+
+	sites, err := models.GetSites(ctx, s.db, db.FilterEq("user_id", usrID))
+	if err != nil {
+		return fmt.Errorf("error fetching site for export: %w", err)
+	}
+
+	a := archive{zw: zip.NewWriter(w)}
+
+	a.json("profile.json", exportProfile{
+		NetID:           usr.NetID,
+		Email:           s.GetEmail(usr.NetID),
+		Name:            usr.Name,
+		Locale:          usr.Locale,
+		UnsubscribedAt:  usr.UnsubscribedAt,
+		UndeliverableAt: usr.UndeliverableAt,
+		VerifiedAt:      usr.VerifiedAt,
+		CreatedAt:       usr.CreatedAt,
+		UpdatedAt:       usr.UpdatedAt,
+	})
+
+	exSessions := make([]exportSession, len(sessions))
+	for i, v := range sessions {
+		exSessions[i] = exportSession{Expires: v.Expires}
+	}
+	a.json("sessions.json", exSessions)
+
+	exEmails := make([]exportVerificationEmail, len(emails))
+	for i, v := range emails {
+		exEmails[i] = exportVerificationEmail{Expires: v.Expires}
+	}
+	a.json("verification-emails.json", exEmails)
+
+	exIdentities := make([]exportIdentity, len(identities))
+	for i, v := range identities {
+		exIdentities[i] = exportIdentity{Issuer: v.Issuer, Subject: v.Subject, CreatedAt: v.CreatedAt}
+	}
+	a.json("identities.json", exIdentities)
+
+	for _, site := range sites {
+		a.json("site/site.json", exportSite{
+			Subdomain:    site.Subdomain,
+			Theme:        site.Theme,
+			ThemeOptions: json.RawMessage(site.ThemeOptions),
+			UnlistedAt:   site.UnlistedAt,
+			VerifiedAt:   site.VerifiedAt,
+			CreatedAt:    site.CreatedAt,
+			UpdatedAt:    site.UpdatedAt,
+		})
+		a.file("site/home.md", site.HomeContent)
+		if site.HomeDraft != nil {
+			a.file("site/home-draft.md", *site.HomeDraft)
+		}
+		a.file("site/navbar.md", site.Navbar)
+		a.file("site/stylesheet.css", site.CustomStylesheet)
+
+		revisions, err := models.GetRevisions(ctx, s.db, db.FilterEq("revisions.site_id", site.Id))
+		if err != nil {
+			return fmt.Errorf("error fetching revisions for export: %w", err)
+		}
+		exRevisions := make([]exportRevision, len(revisions))
+		for i, v := range revisions {
+			exRevisions[i] = exportRevision{Field: v.Field, Content: v.Content, CreatedAt: v.CreatedAt}
+		}
+		a.json("site/revisions.json", exRevisions)
+
+		posts, err := models.GetPosts(ctx, s.db, db.FilterEq("site_id", site.Id))
+		if err != nil {
+			return fmt.Errorf("error fetching posts for export: %w", err)
+		}
+		for _, post := range posts {
+			a.file("site/posts/"+post.Slug+".md", "# "+post.Title+"\n\n"+post.Body)
+		}
+
+		media, err := models.GetMedia(ctx, s.db, db.FilterEq("site_id", site.Id))
+		if err != nil {
+			return fmt.Errorf("error fetching media for export: %w", err)
+		}
+		for _, m := range media {
+			a.copy(fmt.Sprintf("site/media/%d-%s", m.Id, m.Filename), mediaPath(s.config, m.Hash))
+		}
+	}
+
+	return a.close()
+}
+
+// A zip writer that remembers its first error.
+type archive struct {
+	zw  *zip.Writer
+	err error
+}
+
+func (a *archive) file(name, contents string) {
+	if a.err != nil {
+		return
+	}
+
+	f, err := a.zw.Create(name)
+	if err != nil {
+		a.err = err
+		return
+	}
+
+	_, a.err = io.WriteString(f, contents)
+}
+
+// Copy a file in without reading it all into memory.
+func (a *archive) copy(name, path string) {
+	if a.err != nil {
+		return
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		a.err = err
+		return
+	}
+	defer src.Close()
+
+	f, err := a.zw.Create(name)
+	if err != nil {
+		a.err = err
+		return
+	}
+
+	_, a.err = io.Copy(f, src)
+}
+
+func (a *archive) json(name string, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+
+	a.file(name, string(data))
+}
+
+func (a *archive) close() error {
+	if a.err != nil {
+		return fmt.Errorf("error writing export archive: %w", a.err)
+	}
+
+	return a.zw.Close()
+}
+
+type AccountDeleteRequest struct {
+	Password string
+	// Accounts without a password confirm by typing their NetID instead.
+	NetID string
+}
+
+// Permanently remove a user and their site. The NetID and subdomain become
+// available again once this returns.
+func (s *UserService) DeleteAccount(ctx context.Context, usrID int, req AccountDeleteRequest) error {
+	usr, err := models.GetUser(ctx, s.db, db.FilterEq("id", usrID))
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return ErrUserDoesNotExist
+		}
+
+		return fmt.Errorf("error fetching user for deletion: %w", err)
+	}
+
+	if HasPassword(usr) {
+		ok, _, err := s.passwords.Verify(req.Password, usr.Password)
+		if err != nil {
+			return fmt.Errorf("error verifiying password: %w", err)
+		}
+
+		if !ok {
+			return ErrUserWrongPassword
+		}
+	} else if req.NetID != usr.NetID {
+		return ErrNetIDMismatch
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Children before parents, the foreign keys are enforced.
+	if err := models.DeleteSessions(ctx, tx, db.FilterEq("user_id", usrID)); err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+
+	if err := models.DeleteEmails(ctx, tx, db.FilterEq("user_id", usrID)); err != nil {
+		return fmt.Errorf("error deleting verification emails: %w", err)
+	}
+
+	if err := models.DeleteBroadcastDeliveries(ctx, tx, db.FilterEq("user_id", usrID)); err != nil {
+		return fmt.Errorf("error deleting broadcast deliveries: %w", err)
+	}
+
+	if err := models.DeleteIdentities(ctx, tx, db.FilterEq("user_id", usrID)); err != nil {
+		return fmt.Errorf("error deleting identities: %w", err)
+	}
+
+	sites, err := models.GetSites(ctx, tx, db.FilterEq("user_id", usrID))
+	if err != nil {
+		return fmt.Errorf("error fetching site for deletion: %w", err)
+	}
+	siteIDs := make([]int, len(sites))
+	for i, v := range sites {
+		siteIDs[i] = v.Id
+	}
+
+	if err := models.DeletePosts(ctx, tx, db.FilterIn("site_id", siteIDs)); err != nil {
+		return fmt.Errorf("error deleting posts: %w", err)
+	}
+
+	media, err := models.GetMedia(ctx, tx, db.FilterIn("site_id", siteIDs))
+	if err != nil {
+		return fmt.Errorf("error fetching media for deletion: %w", err)
+	}
+	mediaIDs := make([]int, len(media))
+	for i, v := range media {
+		mediaIDs[i] = v.Id
+	}
+
+	variants, err := models.GetMediaVariants(ctx, tx, db.FilterIn("media_id", mediaIDs))
+	if err != nil {
+		return fmt.Errorf("error fetching media variants for deletion: %w", err)
+	}
+	if err := models.DeleteMediaVariants(ctx, tx, db.FilterIn("media_id", mediaIDs)); err != nil {
+		return fmt.Errorf("error deleting media variants: %w", err)
+	}
+	if err := models.DeleteMedia(ctx, tx, db.FilterIn("site_id", siteIDs)); err != nil {
+		return fmt.Errorf("error deleting media: %w", err)
+	}
+
+	if err := models.DeleteRevisions(ctx, tx, db.FilterIn("site_id", siteIDs)); err != nil {
+		return fmt.Errorf("error deleting revisions: %w", err)
+	}
+
+	if err := models.DeleteSites(ctx, tx, db.FilterEq("user_id", usrID)); err != nil {
+		return fmt.Errorf("error deleting site: %w", err)
+	}
+
+	if err := models.DeleteUsers(ctx, tx, db.FilterEq("id", usrID)); err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Files can be shared with other sites, only the unreferenced ones go.
+	hashes := make([]string, 0, len(media)+len(variants))
+	for _, v := range media {
+		hashes = append(hashes, v.Hash)
+	}
+	for _, v := range variants {
+		hashes = append(hashes, v.Hash)
+	}
+	pruneMediaFiles(ctx, s.db, s.config, hashes)
+
+	return nil
+}
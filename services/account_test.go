@@ -0,0 +1,167 @@
+package services_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/db"
+	"uwece.ca/app/models"
+	"uwece.ca/app/services"
+)
+
+// Tables holding something of a user's, all emptied by deleting them.
+var accountTables = []string{
+	"users", "sessions", "emails", "identities", "broadcast_deliveries",
+	"sites", "posts", "media", "media_variants", "revisions",
+}
+
+// Give goose a verified account and a site with a bit of everything on it.
+func (e userEnv) populate(t *testing.T) (models.User, models.Media) {
+	t.Helper()
+	ctx := context.Background()
+
+	usr := e.signup(t)
+	_, err := e.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: testPassword})
+	require.NoError(t, err)
+	_, err = models.InsertIdentity(ctx, e.db, models.NewIdentity{UserId: usr.Id, Issuer: "https://idp.uwaterloo.ca", Subject: "1234"})
+	require.NoError(t, err)
+
+	blogs := services.NewBlogService(e.db, e.cfg, nil)
+	require.NoError(t, blogs.New(ctx, services.BlogNewRequest{Name: "goose", Year: 28}, usr.Id))
+	site, err := blogs.LoadBlogFromUser(ctx, usr.Id)
+	require.NoError(t, err)
+	require.NoError(t, blogs.SetNavbar(ctx, site.Id, usr.Id, services.BlogNavbarRequest{Navbar: "[Home](/) [Hello](/posts/hello)"}))
+
+	_, err = services.NewPostService(e.db, e.cfg).Create(ctx, site.Id, services.PostNewRequest{Title: "Hello", Body: "First post."})
+	require.NoError(t, err)
+
+	m, err := services.NewMediaService(e.db, e.cfg).Store(ctx, site.Id, "photo.jpg", photo(t, 1600, 1200))
+	require.NoError(t, err)
+
+	// Admins can delete their accounts too, their broadcasts stay.
+	b, err := models.InsertBroadcast(ctx, e.db, models.NewBroadcast{AuthorId: usr.Id, Subject: "Hi", Body: "Hello.", Audience: models.AudienceAll})
+	require.NoError(t, err)
+	_, err = models.QueueBroadcastDeliveries(ctx, e.db, b)
+	require.NoError(t, err)
+
+	return usr, m
+}
+
+func (e userEnv) count(t *testing.T, table string) int {
+	t.Helper()
+
+	var n int
+	require.NoError(t, db.GetContext(context.Background(), e.db, &n, `select count(*) from `+table))
+
+	return n
+}
+
+// Files under the media dir.
+func (e userEnv) files(t *testing.T) []string {
+	t.Helper()
+
+	var files []string
+	err := filepath.WalkDir(e.cfg.Core.MediaDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+
+		return err
+	})
+	require.NoError(t, err)
+
+	return files
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	usr, m := env.populate(t)
+
+	var buf bytes.Buffer
+	require.NoError(t, env.users.Export(context.Background(), usr.Id, &buf))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+
+		files[f.Name] = string(data)
+	}
+
+	for _, name := range []string{
+		"profile.json", "sessions.json", "verification-emails.json", "identities.json",
+		"site/site.json", "site/home.md", "site/navbar.md", "site/stylesheet.css",
+		"site/revisions.json", "site/posts/hello.md", "site/media/" + strconv.Itoa(m.Id) + "-photo.jpg",
+	} {
+		require.Contains(t, files, name)
+	}
+
+	require.Contains(t, files["profile.json"], `"net_id": "goose"`)
+	require.Equal(t, "# Hello\n\nFirst post.", files["site/posts/hello.md"])
+	require.Equal(t, "[Home](/) [Hello](/posts/hello)", files["site/navbar.md"])
+	require.Contains(t, files["site/revisions.json"], `"content": "[Home](/)"`)
+
+	// Secrets stay out.
+	for name, data := range files {
+		require.NotContains(t, data, usr.Password, name)
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+	usr, _ := env.populate(t)
+
+	err := env.users.DeleteAccount(ctx, usr.Id, services.AccountDeleteRequest{Password: "wrong password", NetID: "goose"})
+	require.ErrorIs(t, err, services.ErrUserWrongPassword)
+	require.Equal(t, 1, env.count(t, "users"))
+
+	require.NoError(t, env.users.DeleteAccount(ctx, usr.Id, services.AccountDeleteRequest{Password: testPassword}))
+
+	for _, table := range accountTables {
+		require.Zero(t, env.count(t, table), table)
+	}
+	require.Equal(t, 1, env.count(t, "broadcasts"))
+	require.Empty(t, env.files(t))
+
+	// The NetID and subdomain are free again.
+	usr = env.signup(t)
+	require.NoError(t, services.NewBlogService(env.db, env.cfg, nil).New(ctx, services.BlogNewRequest{Name: "goose", Year: 28}, usr.Id))
+}
+
+func TestDeleteAccountWithoutPassword(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+
+	_, err := env.users.LoginExternal(ctx, services.ExternalIdentity{Issuer: "https://idp.uwaterloo.ca", Subject: "1234", NetID: "goose"})
+	require.NoError(t, err)
+	usr := env.user(t, "goose")
+	require.False(t, services.HasPassword(usr))
+
+	// Nothing to guess for a password login either.
+	_, err = env.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: "anything"})
+	require.ErrorIs(t, err, services.ErrUserWrongPassword)
+
+	err = env.users.DeleteAccount(ctx, usr.Id, services.AccountDeleteRequest{NetID: "gander"})
+	require.ErrorIs(t, err, services.ErrNetIDMismatch)
+
+	require.NoError(t, env.users.DeleteAccount(ctx, usr.Id, services.AccountDeleteRequest{NetID: "goose"}))
+	require.Zero(t, env.count(t, "users"))
+	require.Zero(t, env.count(t, "identities"))
+}
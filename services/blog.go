@@ -2,12 +2,19 @@ package services
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
+	"uwece.ca/app/config"
 	"uwece.ca/app/db"
+	"uwece.ca/app/i18n"
 	"uwece.ca/app/models"
+	"uwece.ca/app/themes"
+	"uwece.ca/app/utils"
 )
 
 var (
@@ -15,13 +22,36 @@ var (
 	ErrBlogDoesNotExist      = errors.New("blog does not exist")
 )
 
+// Largest custom stylesheet a site can have, in bytes.
+const maxStylesheetSize = 64 << 10
+
+// Longest a site's navbar can be, in bytes.
+const maxNavbarLength = 5_000
+
+// How long a preview link, and the preview it starts, lasts.
+const previewDuration = time.Hour
+
+const previewPurpose = "preview"
+
+// Graduating years (two digit) a site can be created for.
+const (
+	minGradYear = 24
+	maxGradYear = 30
+)
+
 type BlogService struct {
-	db *db.DB
+	db     *db.DB
+	config *config.Config
+	themes *themes.Registry
+	signer *utils.Signer
 }
 
-func NewBlogService(db *db.DB) *BlogService {
+func NewBlogService(db *db.DB, config *config.Config, themes *themes.Registry) *BlogService {
 	return &BlogService{
-		db: db,
+		db:     db,
+		config: config,
+		themes: themes,
+		signer: utils.NewSigner(config.Core.SecretKey),
 	}
 }
 
@@ -39,15 +69,15 @@ func (b BlogNewRequest) Validate() error {
 		return r
 	}, b.Name)
 	if b.Name != filteredName {
-		return errors.New("Please submit a valid name (a-z).")
+		return i18n.NewError("validation.blog_name_chars")
 	}
 
 	if len(b.Name) == 0 || len(b.Name) >= 35 {
-		return fmt.Errorf("Please submit a valid name (1 - 35 characters in length).")
+		return i18n.NewError("validation.blog_name_length", "min", 1, "max", 35)
 	}
 
-	if b.Year < 24 || b.Year > 30 {
-		return fmt.Errorf("Please submit a valid year. (24 - 30).")
+	if b.Year < minGradYear || b.Year > maxGradYear {
+		return i18n.NewError("validation.year_range", "min", minGradYear, "max", maxGradYear)
 	}
 
 	return nil
@@ -55,12 +85,18 @@ func (b BlogNewRequest) Validate() error {
 
 func (s *BlogService) New(ctx context.Context, req BlogNewRequest, usrID int) error {
 	if err := req.Validate(); err != nil {
-		return fmt.Errorf("%w: %v", ErrValidationFailed, err)
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
 	}
 
 	subdomain := fmt.Sprintf("%s.%d", req.Name, req.Year)
 
-	_, err := models.InsertSite(ctx, s.db, models.NewSite{
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	site, err := models.InsertSite(ctx, tx, models.NewSite{
 		UserId:      usrID,
 		Subdomain:   subdomain,
 		Navbar:      "[Home](/)",
@@ -74,6 +110,52 @@ func (s *BlogService) New(ctx context.Context, req BlogNewRequest, usrID int) er
 		return fmt.Errorf("error inserting website into database: %w", err)
 	}
 
+	// The starting content is the first revision of each field, so the first
+	// edit has something to be compared with and rolled back to.
+	initial := map[string]string{
+		models.RevisionHome:       site.HomeContent,
+		models.RevisionNavbar:     site.Navbar,
+		models.RevisionStylesheet: site.CustomStylesheet,
+	}
+	for _, field := range revisionFields {
+		nr := models.NewRevision{SiteId: site.Id, Field: field, Content: initial[field], AuthorId: &usrID}
+		if _, err := models.InsertRevision(ctx, tx, nr); err != nil {
+			return fmt.Errorf("error inserting first revisions: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if s.config.OIDC.AutoVerifySites {
+		if err := s.verifyIfLinked(ctx, site); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Owners who signed in through the identity provider don't need manual verification.
+func (s *BlogService) verifyIfLinked(ctx context.Context, site models.Site) error {
+	identities, err := models.GetIdentities(ctx, s.db, db.FilterEq("user_id", site.UserId))
+	if err != nil {
+		return fmt.Errorf("error fetching identities for site owner: %w", err)
+	}
+
+	if len(identities) == 0 {
+		return nil
+	}
+
+	updates := db.Updates(
+		db.Update("updated_at", time.Now()),
+		db.Update("verified_at", time.Now()),
+	)
+	if err := models.UpdateSites(ctx, s.db, updates, db.FilterEq("id", site.Id)); err != nil {
+		return fmt.Errorf("error setting site as verified: %w", err)
+	}
+
 	return nil
 }
 
@@ -89,3 +171,266 @@ func (s *BlogService) LoadBlogFromUser(ctx context.Context, usrID int) (models.S
 
 	return site, nil
 }
+
+// Sites the public can see. Serving, sitemaps and listings all go through
+// this so they can't disagree about which sites are live.
+func publicSites(filters ...db.Filter) []db.Filter {
+	return publicSitesIn("", filters...)
+}
+
+// publicSites for queries joining sites to other tables, with the columns
+// qualified by the name sites goes by in them.
+func publicSitesIn(table string, filters ...db.Filter) []db.Filter {
+	prefix := ""
+	if table != "" {
+		prefix = table + "."
+	}
+
+	return append(filters, db.FilterIsNot(prefix+"verified_at", nil))
+}
+
+// The site served at subdomain. Sites waiting for verification aren't served,
+// so they don't exist here either.
+func (s *BlogService) LoadBlogBySubdomain(ctx context.Context, subdomain string) (models.Site, error) {
+	site, err := models.GetSite(ctx, s.db, publicSites(db.FilterEq("subdomain", subdomain))...)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return models.Site{}, ErrBlogDoesNotExist
+		}
+
+		return models.Site{}, fmt.Errorf("error fetching site from database: %w", err)
+	}
+
+	return site, nil
+}
+
+func (s *BlogService) Themes() []themes.Theme {
+	return s.themes.List()
+}
+
+func (s *BlogService) GetTheme(id string) (themes.Theme, bool) {
+	return s.themes.Get(id)
+}
+
+// The site's theme and its option values. A theme that has since been
+// removed falls back to the default, and stored values that no longer fit
+// fall back to the theme's defaults.
+func (s *BlogService) Theme(site models.Site) (themes.Theme, map[string]string) {
+	t := s.themes.Lookup(site.Theme)
+
+	var stored map[string]string
+	if err := json.Unmarshal([]byte(site.ThemeOptions), &stored); err != nil {
+		stored = nil
+	}
+
+	options, err := t.Resolve(stored)
+	if err != nil {
+		options, _ = t.Resolve(nil)
+	}
+
+	return t, options
+}
+
+// Everything a blog's pages link to: the theme's stylesheet, then the
+// owner's own CSS so it can override any of it.
+func (s *BlogService) Stylesheet(site models.Site) string {
+	t, options := s.Theme(site)
+
+	css := t.CSS(options)
+	if site.CustomStylesheet != "" {
+		css += "\n/* Custom stylesheet */\n" + site.CustomStylesheet + "\n"
+	}
+
+	return css
+}
+
+type BlogThemeRequest struct {
+	Theme   string
+	Options map[string]string
+}
+
+func (s *BlogService) SetTheme(ctx context.Context, siteID int, req BlogThemeRequest) error {
+	t, ok := s.themes.Get(req.Theme)
+	if !ok {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, i18n.NewError("validation.theme_unknown"))
+	}
+
+	options, err := t.Resolve(req.Options)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return fmt.Errorf("error encoding theme options: %w", err)
+	}
+
+	updates := db.Updates(
+		db.Update("updated_at", time.Now()),
+		db.Update("theme", t.ID),
+		db.Update("theme_options", string(encoded)),
+	)
+	if err := models.UpdateSites(ctx, s.db, updates, db.FilterEq("id", siteID)); err != nil {
+		return fmt.Errorf("error updating site theme: %w", err)
+	}
+
+	return nil
+}
+
+type BlogStylesheetRequest struct {
+	Stylesheet string
+}
+
+func (b BlogStylesheetRequest) Validate() error {
+	if len(b.Stylesheet) > maxStylesheetSize {
+		return i18n.NewError("validation.stylesheet_size", "max", maxStylesheetSize>>10)
+	}
+
+	return nil
+}
+
+func (s *BlogService) SetStylesheet(ctx context.Context, siteID, authorID int, req BlogStylesheetRequest) error {
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	return saveContent(ctx, s.db, s.config, siteID, authorID, models.RevisionStylesheet, req.Stylesheet)
+}
+
+type BlogNavbarRequest struct {
+	Navbar string
+}
+
+func (b BlogNavbarRequest) Validate() error {
+	if len(b.Navbar) > maxNavbarLength {
+		return i18n.NewError("validation.navbar_length", "max", "5,000")
+	}
+
+	return nil
+}
+
+func (s *BlogService) SetNavbar(ctx context.Context, siteID, authorID int, req BlogNavbarRequest) error {
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	return saveContent(ctx, s.db, s.config, siteID, authorID, models.RevisionNavbar, req.Navbar)
+}
+
+// The name a blog is signed with, its owner's.
+func (s *BlogService) Author(ctx context.Context, site models.Site) (string, error) {
+	owner, err := models.GetUser(ctx, s.db, db.FilterEq("id", site.UserId))
+	if err != nil {
+		return "", fmt.Errorf("error fetching site owner: %w", err)
+	}
+
+	return owner.Name, nil
+}
+
+type BlogHomeRequest struct {
+	Content string
+	// One of models.PostDraft, PostScheduled or PostPublished.
+	Status string
+	// When a scheduled home page goes live, as a datetime-local input sends
+	// it.
+	PublishAt string
+}
+
+func (b BlogHomeRequest) Validate() error {
+	if len(b.Content) > 100_000 {
+		return i18n.NewError("validation.home_length", "max", "100,000")
+	}
+
+	switch b.Status {
+	case models.PostDraft, models.PostScheduled, models.PostPublished:
+	default:
+		return i18n.NewError("validation.post_status")
+	}
+
+	return nil
+}
+
+// Publish the home page, or keep it as a draft (scheduled or not) beside the
+// live one. Only publishing saves a revision.
+func (s *BlogService) SetHome(ctx context.Context, siteID, authorID int, req BlogHomeRequest) error {
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	now := time.Now().UTC()
+	var updates []db.UpdateData
+	switch req.Status {
+	case models.PostDraft:
+		updates = db.Updates(
+			db.Update("home_draft", req.Content),
+			db.Update("home_publish_at", nil),
+		)
+	case models.PostScheduled:
+		at, err := parseScheduleTime(s.config, req.PublishAt, now)
+		if err != nil {
+			return err
+		}
+
+		updates = db.Updates(
+			db.Update("home_draft", req.Content),
+			db.Update("home_publish_at", at),
+		)
+	default:
+		return saveContent(ctx, s.db, s.config, siteID, authorID, models.RevisionHome, req.Content,
+			db.Update("home_draft", nil),
+			db.Update("home_publish_at", nil),
+		)
+	}
+
+	if err := models.UpdateSites(ctx, s.db, updates, db.FilterEq("id", siteID)); err != nil {
+		return fmt.Errorf("error updating site home page: %w", err)
+	}
+
+	return nil
+}
+
+// Throw away the home page draft, and its schedule.
+func (s *BlogService) DiscardHomeDraft(ctx context.Context, siteID int) error {
+	updates := db.Updates(
+		db.Update("home_draft", nil),
+		db.Update("home_publish_at", nil),
+	)
+	if err := models.UpdateSites(ctx, s.db, updates, db.FilterEq("id", siteID)); err != nil {
+		return fmt.Errorf("error discarding site home page draft: %w", err)
+	}
+
+	return nil
+}
+
+// A link that shows the owner their site with drafts and scheduled posts,
+// for the next previewDuration. Blogs are on their own subdomains, away from
+// the owner's session, so the link carries its own signed permission.
+func (s *BlogService) PreviewLink(site models.Site) string {
+	value := fmt.Sprintf("%d-%d", site.Id, time.Now().Add(previewDuration).Unix())
+	return s.config.Core.SiteURL(site.Subdomain) + "/preview/" + s.signer.Sign(previewPurpose, value)
+}
+
+// Check a token from PreviewLink is for site, and return when it runs out.
+func (s *BlogService) CheckPreview(site models.Site, token string) (time.Time, bool) {
+	value, ok := s.signer.Verify(previewPurpose, token)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	id, expiry, ok := strings.Cut(value, "-")
+	if !ok || id != strconv.Itoa(site.Id) {
+		return time.Time{}, false
+	}
+
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	expires := time.Unix(unix, 0)
+	if !time.Now().Before(expires) {
+		return time.Time{}, false
+	}
+
+	return expires, true
+}
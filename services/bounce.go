@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/mailer"
+	"uwece.ca/app/models"
+	"uwece.ca/app/utils"
+)
+
+type BounceService struct {
+	db     *db.DB
+	config *config.Config
+	signer *utils.Signer
+}
+
+func NewBounceService(db *db.DB, config *config.Config) *BounceService {
+	return &BounceService{db: db, config: config, signer: utils.NewSigner(config.Core.SecretKey)}
+}
+
+func (s *BounceService) Enabled() bool {
+	return s.config.Mailer.BounceMaildir != ""
+}
+
+// Check the bounce Maildir every BouncePollInterval until ctx is cancelled.
+func (s *BounceService) Run(ctx context.Context) {
+	interval := max(s.config.Mailer.BouncePollInterval, time.Second)
+
+	for {
+		if _, err := s.ProcessMaildir(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("error processing bounces", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Read every new message in the bounce Maildir, returning how many were read.
+// Only failing to record a report leaves its message to be tried again.
+func (s *BounceService) ProcessMaildir(ctx context.Context) (int, error) {
+	md := mailer.Maildir(s.config.Mailer.BounceMaildir)
+
+	return md.Process(func(name string, r io.Reader) error {
+		report, err := mailer.ParseReport(r)
+		switch {
+		// Anything else landing in the mailbox is read and forgotten.
+		case errors.Is(err, mailer.ErrNotAReport):
+			slog.Debug("ignoring message in bounce maildir", "name", name)
+			return nil
+		// It won't read any better next time, and would hold up everything
+		// after it.
+		case err != nil:
+			slog.Warn("skipping unreadable report in bounce maildir", "name", name, "error", err)
+			return nil
+		}
+
+		return s.record(ctx, report)
+	})
+}
+
+// Mark the users a delivery or feedback report is about. Hard bounces make an
+// address undeliverable, spam complaints unsubscribe it from announcements.
+// Anyone can send a report, so it only counts for a recipient when it quotes
+// the Message-ID of mail we sent them.
+func (s *BounceService) ProcessReport(ctx context.Context, r io.Reader) error {
+	report, err := mailer.ParseReport(r)
+	if err != nil {
+		return err
+	}
+
+	return s.record(ctx, report)
+}
+
+func (s *BounceService) record(ctx context.Context, report mailer.Report) error {
+	now := time.Now().UTC()
+	for _, rcpt := range report.Recipients {
+		netID, ok := s.netID(rcpt.Address)
+		if !ok {
+			slog.Debug("ignoring report for unknown address", "address", rcpt.Address)
+			continue
+		}
+
+		if !mailer.SentTo(s.signer, report.MessageID, rcpt.Address) {
+			slog.Warn("ignoring report for mail we didn't send", "address", rcpt.Address, "message_id", report.MessageID)
+			continue
+		}
+
+		// Keep the time of the first report.
+		var column string
+		updates := db.Updates(db.Update("updated_at", time.Now()))
+		switch report.Kind {
+		case mailer.ReportBounce:
+			// Only permanent (5.x.x) failures, anything else is retried by the sender.
+			if rcpt.Status != "" && !strings.HasPrefix(rcpt.Status, "5") {
+				continue
+			}
+			column = "undeliverable_at"
+			updates = append(updates, db.Update("undeliverable_reason", bounceReason(rcpt)))
+		case mailer.ReportComplaint:
+			column = "unsubscribed_at"
+		}
+		updates = append(updates, db.Update(column, now))
+
+		err := models.UpdateUser(ctx, s.db, updates, db.FilterEq("net_id", netID), db.FilterIs(column, nil))
+		if err != nil {
+			return fmt.Errorf("error recording %s: %w", report.Kind, err)
+		}
+
+		slog.Info("processed mail report", "kind", report.Kind, "net_id", netID, "status", rcpt.Status)
+	}
+
+	return nil
+}
+
+// The NetID for an address on our email domain.
+func (s *BounceService) netID(addr string) (string, bool) {
+	local, domain, ok := strings.Cut(addr, "@")
+	if !ok || !strings.EqualFold(domain, s.config.Core.EmailDomain) || local == "" {
+		return "", false
+	}
+
+	return local, true
+}
+
+func bounceReason(rcpt mailer.ReportRecipient) string {
+	reason := strings.TrimSpace(rcpt.Status + " " + rcpt.Diagnostic)
+	if len(reason) > 500 {
+		reason = reason[:500]
+	}
+
+	return reason
+}
+
+// Users whose mail has been bouncing.
+func (s *BounceService) Undeliverable(ctx context.Context) ([]models.User, error) {
+	usrs, err := models.GetUsers(ctx, s.db, db.FilterIsNot("undeliverable_at", nil))
+	if err != nil {
+		return nil, fmt.Errorf("error fetching undeliverable users: %w", err)
+	}
+
+	return usrs, nil
+}
+
+// Start mailing a user again, once their mailbox has been sorted out.
+func (s *BounceService) MarkDeliverable(ctx context.Context, usrID int) error {
+	updates := db.Updates(
+		db.Update("updated_at", time.Now()),
+		db.Update("undeliverable_at", nil),
+		db.Update("undeliverable_reason", nil),
+	)
+	if err := models.UpdateUser(ctx, s.db, updates, db.FilterEq("id", usrID)); err != nil {
+		return fmt.Errorf("error marking user deliverable: %w", err)
+	}
+
+	return nil
+}
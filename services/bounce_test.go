@@ -0,0 +1,100 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/services"
+)
+
+// A hard bounce for goose, quoting the headers of the message with messageID.
+func bounceReport(messageID string) string {
+	return strings.ReplaceAll(fmt.Sprintf(`From: Mail Delivery System <MAILER-DAEMON@mx.uwaterloo.ca>
+To: noreply@uwece.ca
+Subject: Undelivered Mail Returned to Sender
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="B1"
+
+--B1
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.uwaterloo.ca
+
+Final-Recipient: rfc822; goose@connect.uwaterloo.ca
+Action: failed
+Status: 5.1.1
+Diagnostic-Code: smtp; 550 5.1.1 User unknown
+
+--B1
+Content-Type: text/rfc822-headers
+
+From: <noreply@uwece.ca>
+To: <goose@connect.uwaterloo.ca>
+Message-ID: %s
+
+--B1--
+`, messageID), "\n", "\r\n")
+}
+
+func TestBounceReportNeedsSentMessage(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+	bounces := services.NewBounceService(env.db, env.cfg)
+
+	env.signup(t)
+	sent := env.mail.Messages()
+	require.NotEmpty(t, sent)
+	msg, err := mail.ReadMessage(bytes.NewReader(sent[0].Data))
+	require.NoError(t, err)
+
+	// Made up IDs, or none at all, don't count.
+	for _, id := range []string{"<3f9a2c.sig@uwece.ca>", ""} {
+		require.NoError(t, bounces.ProcessReport(ctx, strings.NewReader(bounceReport(id))))
+		require.Nil(t, env.user(t, "goose").UndeliverableAt)
+	}
+
+	require.NoError(t, bounces.ProcessReport(ctx, strings.NewReader(bounceReport(msg.Header.Get("Message-ID")))))
+	usr := env.user(t, "goose")
+	require.NotNil(t, usr.UndeliverableAt)
+	require.Equal(t, "5.1.1 550 5.1.1 User unknown", *usr.UndeliverableReason)
+}
+
+func TestBounceMaildirSkipsMalformedReports(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, sub), 0o755))
+	}
+	env.cfg.Mailer.BounceMaildir = dir
+	bounces := services.NewBounceService(env.db, env.cfg)
+
+	env.signup(t)
+	msg, err := mail.ReadMessage(bytes.NewReader(env.mail.Messages()[0].Data))
+	require.NoError(t, err)
+
+	// A broken delivery status, sorted ahead of a good report.
+	report := bounceReport(msg.Header.Get("Message-ID"))
+	malformed := strings.Replace(report, "Reporting-MTA: dns;", "Reporting-MTA dns", 1)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new", "1.host"), []byte(malformed), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "new", "2.host"), []byte(report), 0o644))
+
+	n, err := bounces.ProcessMaildir(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NotNil(t, env.user(t, "goose").UndeliverableAt)
+
+	entries, err := os.ReadDir(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/i18n"
+	"uwece.ca/app/mailer"
+	"uwece.ca/app/models"
+	"uwece.ca/app/utils"
+)
+
+var (
+	ErrBadUnsubscribeLink = errors.New("invalid unsubscribe link")
+)
+
+const (
+	unsubscribePurpose = "unsubscribe"
+
+	// Give up on a delivery after this many failures, waiting a little longer after each.
+	broadcastMaxAttempts = 3
+	broadcastRetryDelay  = time.Minute
+
+	// How often the queue is checked when nothing wakes it up.
+	broadcastPollInterval = 30 * time.Second
+)
+
+type BroadcastService struct {
+	db     *db.DB
+	mailer mailer.Mailer
+	config *config.Config
+	users  *UserService
+	signer *utils.Signer
+
+	// Nudges the worker when a broadcast is queued.
+	wake chan struct{}
+}
+
+func NewBroadcastService(db *db.DB, mailer mailer.Mailer, config *config.Config, users *UserService) *BroadcastService {
+	return &BroadcastService{
+		db:     db,
+		mailer: mailer,
+		config: config,
+		users:  users,
+		signer: utils.NewSigner(config.Core.SecretKey),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+type BroadcastRequest struct {
+	Subject  string
+	Body     string
+	Audience string
+	Cohort   int
+}
+
+func (b BroadcastRequest) Validate() error {
+	if len(strings.TrimSpace(b.Subject)) == 0 || len(b.Subject) > 200 {
+		return i18n.NewError("validation.subject_length", "min", 1, "max", 200)
+	}
+
+	if len(strings.TrimSpace(b.Body)) == 0 || len(b.Body) > 50_000 {
+		return i18n.NewError("validation.message_length", "max", "50,000")
+	}
+
+	switch b.Audience {
+	case models.AudienceAll, models.AudienceVerifiedSites:
+	case models.AudienceCohort:
+		if b.Cohort < minGradYear || b.Cohort > maxGradYear {
+			return i18n.NewError("validation.year_range", "min", minGradYear, "max", maxGradYear)
+		}
+	default:
+		return i18n.NewError("validation.audience_required")
+	}
+
+	return nil
+}
+
+func (b BroadcastRequest) cohort() *int {
+	if b.Audience != models.AudienceCohort {
+		return nil
+	}
+
+	return &b.Cohort
+}
+
+type BroadcastPreview struct {
+	Rendered   mailer.Rendered
+	Recipients int
+}
+
+// Render the message as the author would receive it, and count who it would go to.
+func (s *BroadcastService) Preview(ctx context.Context, author models.User, req BroadcastRequest) (BroadcastPreview, error) {
+	if err := req.Validate(); err != nil {
+		return BroadcastPreview{}, fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	n, err := models.CountBroadcastRecipients(ctx, s.db, req.Audience, req.cohort())
+	if err != nil {
+		return BroadcastPreview{}, fmt.Errorf("error counting broadcast recipients: %w", err)
+	}
+
+	msg, err := s.message(author, req.Subject, req.Body)
+	if err != nil {
+		return BroadcastPreview{}, err
+	}
+
+	r, err := s.mailer.Render(msg)
+	if err != nil {
+		return BroadcastPreview{}, err
+	}
+
+	return BroadcastPreview{Rendered: r, Recipients: n}, nil
+}
+
+// Save a broadcast and queue it for everyone in its audience.
+func (s *BroadcastService) Create(ctx context.Context, authorID int, req BroadcastRequest) (int, error) {
+	if err := req.Validate(); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	b, err := models.InsertBroadcast(ctx, tx, models.NewBroadcast{
+		AuthorId: authorID,
+		Subject:  req.Subject,
+		Body:     req.Body,
+		Audience: req.Audience,
+		Cohort:   req.cohort(),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error inserting broadcast: %w", err)
+	}
+
+	n, err := models.QueueBroadcastDeliveries(ctx, tx, b)
+	if err != nil {
+		return 0, fmt.Errorf("error queueing broadcast: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	slog.Info("queued broadcast", "broadcast_id", b.Id, "author_id", authorID, "recipients", n)
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return n, nil
+}
+
+func (s *BroadcastService) Broadcasts(ctx context.Context) ([]models.Broadcast, error) {
+	bs, err := models.GetBroadcasts(ctx, s.db)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching broadcasts: %w", err)
+	}
+
+	return bs, nil
+}
+
+// Work through the delivery queue until ctx is cancelled, sending at most
+// BroadcastRate messages a second.
+func (s *BroadcastService) Run(ctx context.Context) {
+	rate := max(s.config.Mailer.BroadcastRate, 1)
+	limit := time.NewTicker(time.Second / time.Duration(rate))
+	defer limit.Stop()
+
+	// Broadcasts by id, they don't change once queued.
+	broadcasts := make(map[int]models.Broadcast)
+
+	for {
+		bd, err := models.NextBroadcastDelivery(ctx, s.db, time.Now().UTC())
+		if err != nil {
+			if !errors.Is(err, db.ErrNoRows) {
+				slog.Error("error fetching next broadcast delivery", "error", err)
+			}
+
+			if err := models.FinishBroadcasts(ctx, s.db, time.Now().UTC()); err != nil && ctx.Err() == nil {
+				slog.Error("error finishing broadcasts", "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			case <-time.After(broadcastPollInterval):
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-limit.C:
+		}
+
+		b, ok := broadcasts[bd.BroadcastId]
+		if !ok {
+			b, err = models.GetBroadcast(ctx, s.db, db.FilterEq("id", bd.BroadcastId))
+			if err != nil {
+				slog.Error("error fetching broadcast", "broadcast_id", bd.BroadcastId, "error", err)
+				continue
+			}
+			broadcasts[b.Id] = b
+		}
+
+		s.deliver(ctx, b, bd)
+	}
+}
+
+func (s *BroadcastService) deliver(ctx context.Context, b models.Broadcast, bd models.BroadcastDelivery) {
+	now := time.Now().UTC()
+
+	sendErr := func() error {
+		usr, err := models.GetUser(ctx, s.db, db.FilterEq("id", bd.UserId))
+		if err != nil {
+			return fmt.Errorf("error fetching recipient: %w", err)
+		}
+
+		// Opted out after the broadcast was queued.
+		if usr.UnsubscribedAt != nil {
+			return errUnsubscribed
+		}
+		if usr.UndeliverableAt != nil {
+			return errUndeliverable
+		}
+
+		msg, err := s.message(usr, b.Subject, b.Body)
+		if err != nil {
+			return err
+		}
+
+		return s.mailer.Send(s.users.GetEmail(usr.NetID), usr.Name, msg)
+	}()
+
+	var updates []db.UpdateData
+	switch {
+	case sendErr == nil:
+		updates = db.Updates(db.Update("sent_at", now))
+	case errors.Is(sendErr, errUnsubscribed) || errors.Is(sendErr, errUndeliverable) || bd.Attempts+1 >= broadcastMaxAttempts:
+		slog.Warn("giving up on broadcast delivery", "broadcast_id", b.Id, "user_id", bd.UserId, "error", sendErr)
+		updates = db.Updates(
+			db.Update("attempts", bd.Attempts+1),
+			db.Update("last_error", sendErr.Error()),
+			db.Update("failed_at", now),
+		)
+	default:
+		slog.Warn("broadcast delivery failed, will retry", "broadcast_id", b.Id, "user_id", bd.UserId, "error", sendErr)
+		updates = db.Updates(
+			db.Update("attempts", bd.Attempts+1),
+			db.Update("last_error", sendErr.Error()),
+			db.Update("next_attempt_at", now.Add(time.Duration(bd.Attempts+1)*broadcastRetryDelay)),
+		)
+	}
+
+	if err := models.UpdateBroadcastDeliveries(ctx, s.db, updates, db.FilterEq("id", bd.Id)); err != nil {
+		slog.Error("error recording broadcast delivery", "delivery_id", bd.Id, "error", err)
+	}
+}
+
+var (
+	errUnsubscribed  = errors.New("recipient unsubscribed")
+	errUndeliverable = errors.New("recipient address is undeliverable")
+)
+
+func (s *BroadcastService) message(usr models.User, subject, body string) (mailer.BroadcastMessage, error) {
+	html, err := utils.RenderMarkdown(body)
+	if err != nil {
+		return mailer.BroadcastMessage{}, fmt.Errorf("error rendering broadcast markdown: %w", err)
+	}
+
+	return mailer.BroadcastMessage{
+		Name:            usr.Name,
+		Subject:         subject,
+		Body:            html,
+		Text:            body,
+		UnsubscribeLink: s.UnsubscribeLink(usr.Id),
+	}, nil
+}
+
+func (s *BroadcastService) UnsubscribeLink(usrID int) string {
+	return s.config.Core.BaseURL() + "/unsubscribe/" + s.signer.Sign(unsubscribePurpose, strconv.Itoa(usrID))
+}
+
+// Opt the user named by a signed unsubscribe token out of broadcasts.
+func (s *BroadcastService) Unsubscribe(ctx context.Context, token string) error {
+	value, ok := s.signer.Verify(unsubscribePurpose, token)
+	if !ok {
+		return ErrBadUnsubscribeLink
+	}
+
+	usrID, err := strconv.Atoi(value)
+	if err != nil {
+		return ErrBadUnsubscribeLink
+	}
+
+	return s.SetSubscribed(ctx, usrID, false)
+}
+
+type AccountAnnouncementsRequest struct {
+	Subscribed bool
+}
+
+func (s *BroadcastService) SetSubscribed(ctx context.Context, usrID int, subscribed bool) error {
+	var at *time.Time
+	if !subscribed {
+		now := time.Now().UTC()
+		at = &now
+	}
+
+	updates := db.Updates(
+		db.Update("updated_at", time.Now()),
+		db.Update("unsubscribed_at", at),
+	)
+	if err := models.UpdateUser(ctx, s.db, updates, db.FilterEq("id", usrID)); err != nil {
+		return fmt.Errorf("error updating subscription: %w", err)
+	}
+
+	return nil
+}
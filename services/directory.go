@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"uwece.ca/app/db"
+	"uwece.ca/app/models"
+)
+
+const directoryPageSize = 30
+
+type DirectoryRequest struct {
+	// Part of a site's name.
+	Query string
+	// Two digit graduating year, 0 for all of them.
+	Year int
+	Page int
+}
+
+type DirectoryEntry struct {
+	Name string
+	URL  string
+}
+
+// The sites on a page of the directory for one graduating year. A year can
+// carry on over several pages.
+type DirectoryYear struct {
+	Year  int
+	Sites []DirectoryEntry
+}
+
+type Directory struct {
+	Years []DirectoryYear
+	Total int
+	Page  int
+	Pages int
+}
+
+// Two digit graduating years, newest first.
+func GradYears() []int {
+	var years []int
+	for y := maxGradYear; y >= minGradYear; y-- {
+		years = append(years, y)
+	}
+
+	return years
+}
+
+// A page of public sites whose owners haven't left the directory, grouped by
+// the graduating year in their subdomain.
+func (s *BlogService) Directory(ctx context.Context, req DirectoryRequest) (Directory, error) {
+	filters := publicSites(
+		db.FilterIs("unlisted_at", nil),
+		db.FilterLike("subdomain", directoryPattern(req.Query, req.Year)),
+	)
+
+	total, err := models.CountSites(ctx, s.db, filters...)
+	if err != nil {
+		return Directory{}, fmt.Errorf("error counting directory sites: %w", err)
+	}
+
+	dir := Directory{
+		Total: total,
+		Page:  max(req.Page, 1),
+		Pages: max((total+directoryPageSize-1)/directoryPageSize, 1),
+	}
+
+	sites, err := models.GetSitesPage(ctx, s.db, db.PageNumber(dir.Page, directoryPageSize), filters...)
+	if err != nil {
+		return Directory{}, fmt.Errorf("error fetching directory sites: %w", err)
+	}
+
+	for _, site := range sites {
+		name, year := splitSubdomain(site.Subdomain)
+		if len(dir.Years) == 0 || dir.Years[len(dir.Years)-1].Year != year {
+			dir.Years = append(dir.Years, DirectoryYear{Year: year})
+		}
+
+		current := &dir.Years[len(dir.Years)-1]
+		current.Sites = append(current.Sites, DirectoryEntry{
+			Name: name,
+			URL:  s.config.Core.SiteURL(site.Subdomain),
+		})
+	}
+
+	return dir, nil
+}
+
+// Matches subdomains ("name.year") with query somewhere in the name. Names
+// are only ever a-z and dashes, so anything else in the query (including
+// like wildcards) is dropped.
+func directoryPattern(query string, year int) string {
+	query = strings.Map(func(r rune) rune {
+		if (r < 'a' || r > 'z') && r != '-' {
+			return -1
+		}
+
+		return r
+	}, strings.ToLower(query))
+
+	suffix := ".%"
+	if year != 0 {
+		suffix = "." + strconv.Itoa(year)
+	}
+
+	return "%" + query + "%" + suffix
+}
+
+func splitSubdomain(subdomain string) (string, int) {
+	name, year, _ := strings.Cut(subdomain, ".")
+	y, _ := strconv.Atoi(year)
+
+	return name, y
+}
+
+type BlogDirectoryRequest struct {
+	Listed bool
+}
+
+func (s *BlogService) SetListed(ctx context.Context, siteID int, req BlogDirectoryRequest) error {
+	var unlistedAt *time.Time
+	if !req.Listed {
+		now := time.Now()
+		unlistedAt = &now
+	}
+
+	updates := db.Updates(
+		db.Update("updated_at", time.Now()),
+		db.Update("unlisted_at", unlistedAt),
+	)
+	if err := models.UpdateSites(ctx, s.db, updates, db.FilterEq("id", siteID)); err != nil {
+		return fmt.Errorf("error updating site listing: %w", err)
+	}
+
+	return nil
+}
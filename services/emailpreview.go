@@ -0,0 +1,73 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+
+	"uwece.ca/app/i18n"
+	"uwece.ca/app/mailer"
+)
+
+var ErrUnknownEmail = errors.New("unknown email template")
+
+// Renders and sends sample copies of every email, for working on the
+// templates in development.
+type EmailPreviewService struct {
+	mailer mailer.Mailer
+}
+
+func NewEmailPreviewService(mailer mailer.Mailer) *EmailPreviewService {
+	return &EmailPreviewService{mailer: mailer}
+}
+
+// Template names, in the order they are listed.
+func (s *EmailPreviewService) Names() []string {
+	samples := mailer.Samples()
+
+	names := make([]string, len(samples))
+	for i, msg := range samples {
+		names[i] = msg.Template()
+	}
+
+	return names
+}
+
+func (s *EmailPreviewService) Preview(name string) (mailer.Rendered, error) {
+	msg, ok := mailer.Sample(name)
+	if !ok {
+		return mailer.Rendered{}, ErrUnknownEmail
+	}
+
+	return s.mailer.Render(msg)
+}
+
+type EmailTestRequest struct {
+	Address string
+}
+
+func (r EmailTestRequest) Validate() error {
+	if _, err := mail.ParseAddress(r.Address); err != nil {
+		return i18n.NewError("validation.email_address")
+	}
+
+	return nil
+}
+
+func (s *EmailPreviewService) SendTest(name string, req EmailTestRequest) error {
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	msg, ok := mailer.Sample(name)
+	if !ok {
+		return ErrUnknownEmail
+	}
+
+	addr, _ := mail.ParseAddress(req.Address)
+	if err := s.mailer.Send(addr.Address, addr.Name, msg); err != nil {
+		return fmt.Errorf("error sending test email: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"uwece.ca/app/db"
+	"uwece.ca/app/feeds"
+	"uwece.ca/app/i18n"
+	"uwece.ca/app/models"
+	"uwece.ca/app/utils"
+)
+
+// How many of the newest posts a feed carries.
+const feedLength = 20
+
+// A site's latest posts as a feed, served from feedPath (like "/feed.xml").
+func (s *PostService) Feed(ctx context.Context, site models.Site, author, feedPath string) (feeds.Feed, error) {
+	posts, err := models.GetRecentPosts(ctx, s.db, feedLength, publishedPosts(db.FilterEq("site_id", site.Id))...)
+	if err != nil {
+		return feeds.Feed{}, fmt.Errorf("error fetching posts for feed: %w", err)
+	}
+
+	siteURL := s.config.Core.SiteURL(site.Subdomain)
+	f := feeds.Feed{
+		Title:       author,
+		Description: i18n.T(i18n.Default, "feed.description", "author", author, "site", site.Subdomain+"."+s.config.Core.BaseDomain),
+		Link:        siteURL,
+		FeedURL:     siteURL + feedPath,
+		Author:      author,
+		Updated:     site.CreatedAt,
+	}
+
+	for _, p := range posts {
+		content, err := utils.RenderMarkdown(p.Body)
+		if err != nil {
+			return feeds.Feed{}, fmt.Errorf("error rendering post %d for feed: %w", p.Id, err)
+		}
+
+		published := p.CreatedAt
+		if p.PublishedAt != nil {
+			published = *p.PublishedAt
+		}
+
+		f.Items = append(f.Items, feeds.Item{
+			Title:     p.Title,
+			Link:      s.URL(site, p),
+			Content:   string(content),
+			Published: published,
+			Updated:   p.UpdatedAt,
+		})
+
+		if p.UpdatedAt.After(f.Updated) {
+			f.Updated = p.UpdatedAt
+		}
+	}
+
+	return f, nil
+}
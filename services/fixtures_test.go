@@ -0,0 +1,56 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/db/dbtest"
+	"uwece.ca/app/models"
+	"uwece.ca/app/services"
+)
+
+// A migrated database with goose and their blog, goose.28, made the way
+// signing up and creating a blog would.
+type testSite struct {
+	db   *db.DB
+	cfg  *config.Config
+	user models.User
+	site models.Site
+}
+
+// Set up goose.28 with core as the config, filling in what every test needs.
+func newTestSite(t *testing.T, core config.Core) testSite {
+	t.Helper()
+	ctx := context.Background()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+
+	core.BaseDomain = "uwece.ca"
+	core.SecretKey = "test secret"
+	core.TimeZone = "America/Toronto"
+	ts := testSite{db: d, cfg: &config.Config{Core: core}}
+
+	var err error
+	ts.user, err = models.InsertUser(ctx, d, models.NewUser{NetID: "goose", Name: "Goose", Password: "hi"})
+	require.NoError(t, err)
+
+	blogs := services.NewBlogService(d, ts.cfg, nil)
+	require.NoError(t, blogs.New(ctx, services.BlogNewRequest{Name: "goose", Year: 28}, ts.user.Id))
+	ts.site, err = blogs.LoadBlogFromUser(ctx, ts.user.Id)
+	require.NoError(t, err)
+
+	return ts
+}
+
+func (ts testSite) reload(t *testing.T) models.Site {
+	t.Helper()
+
+	site, err := models.GetSite(context.Background(), ts.db, db.FilterEq("id", ts.site.Id))
+	require.NoError(t, err)
+
+	return site
+}
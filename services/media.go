@@ -0,0 +1,450 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/i18n"
+	"uwece.ca/app/imaging"
+	"uwece.ca/app/models"
+)
+
+var (
+	ErrMediaTypeNotAllowed = errors.New("media type not allowed")
+	ErrMediaQuotaExceeded  = errors.New("media quota exceeded")
+	ErrMediaDoesNotExist   = errors.New("media does not exist")
+)
+
+// Sniffed content types that may be uploaded.
+var allowedMediaTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+type MediaService struct {
+	db     *db.DB
+	config *config.Config
+}
+
+func NewMediaService(db *db.DB, config *config.Config) *MediaService {
+	return &MediaService{db: db, config: config}
+}
+
+// A file to write under its hash.
+type mediaFile struct {
+	hash string
+	data []byte
+}
+
+func newMediaFile(data []byte) mediaFile {
+	sum := sha256.Sum256(data)
+	return mediaFile{hash: hex.EncodeToString(sum[:]), data: data}
+}
+
+func mediaHashes(files []mediaFile) []string {
+	hashes := make([]string, len(files))
+	for i, f := range files {
+		hashes[i] = f.hash
+	}
+
+	return hashes
+}
+
+// Uploads hold their files' locks from writing them until their rows are
+// committed, and pruning takes them before checking for rows, so a file is
+// never removed while an upload is about to refer to it. Striped by the
+// hash's first byte.
+var mediaLocks [64]sync.Mutex
+
+// Lock the stripes for hashes, in order so two callers can't deadlock.
+func lockMedia(hashes []string) (unlock func()) {
+	stripes := make([]int, 0, len(hashes))
+	for _, hash := range hashes {
+		b, _ := strconv.ParseUint(hash[:2], 16, 8)
+		stripes = append(stripes, int(b)%len(mediaLocks))
+	}
+	slices.Sort(stripes)
+	stripes = slices.Compact(stripes)
+
+	for _, i := range stripes {
+		mediaLocks[i].Lock()
+	}
+
+	return func() {
+		for _, i := range slices.Backward(stripes) {
+			mediaLocks[i].Unlock()
+		}
+	}
+}
+
+// Save a file for a site. The content type is sniffed from the data, the
+// name given is only kept for display. Images have their metadata removed and
+// get resized copies, which count towards the site's quota too.
+func (s *MediaService) Store(ctx context.Context, siteID int, filename string, data []byte) (models.Media, error) {
+	if int64(len(data)) > s.config.Core.MaxUploadSize {
+		return models.Media{}, fmt.Errorf("%w: %w", ErrValidationFailed,
+			i18n.NewError("validation.media_size", "max", s.config.Core.MaxUploadSize>>20))
+	}
+
+	contentType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if !allowedMediaTypes[contentType] {
+		return models.Media{}, ErrMediaTypeNotAllowed
+	}
+
+	var img imaging.Image
+	if strings.HasPrefix(contentType, "image/") {
+		var err error
+		img, err = imaging.Process(data, contentType)
+		if errors.Is(err, imaging.ErrTooLarge) {
+			return models.Media{}, fmt.Errorf("%w: %w", ErrValidationFailed,
+				i18n.NewError("validation.media_pixels", "max", imaging.MaxPixels/1_000_000))
+		}
+		if err != nil {
+			slog.Debug("unreadable image uploaded", "site_id", siteID, "error", err)
+			return models.Media{}, fmt.Errorf("%w: %w", ErrValidationFailed, i18n.NewError("validation.media_unreadable"))
+		}
+		data = img.Data
+	}
+
+	files := []mediaFile{newMediaFile(data)}
+	for _, v := range img.Variants {
+		files = append(files, newMediaFile(v.Data))
+	}
+
+	m, err := s.insert(ctx, siteID, cleanFilename(filename, contentType), contentType, img, files)
+	if err != nil {
+		// Files from a failed upload go again, unless another upload has them.
+		// Pruning waits for any upload still writing the same files.
+		pruneMediaFiles(context.WithoutCancel(ctx), s.db, s.config, mediaHashes(files))
+
+		return models.Media{}, err
+	}
+
+	return m, nil
+}
+
+// Write an upload's files, the original then its variants, and insert their
+// rows.
+func (s *MediaService) insert(ctx context.Context, siteID int, filename, contentType string, img imaging.Image, files []mediaFile) (models.Media, error) {
+	var total int64
+	for _, f := range files {
+		total += int64(len(f.data))
+	}
+
+	unlock := lockMedia(mediaHashes(files))
+	defer unlock()
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return models.Media{}, err
+	}
+	defer tx.Rollback()
+
+	ok, err := models.ReserveSiteMedia(ctx, tx, siteID, total, s.config.Core.MediaQuota)
+	if err != nil {
+		return models.Media{}, fmt.Errorf("error reserving media quota: %w", err)
+	}
+	if !ok {
+		return models.Media{}, ErrMediaQuotaExceeded
+	}
+
+	for _, f := range files {
+		if err := writeMediaFile(mediaPath(s.config, f.hash), f.data); err != nil {
+			return models.Media{}, fmt.Errorf("error writing media file: %w", err)
+		}
+	}
+
+	m, err := models.InsertMedia(ctx, tx, models.NewMedia{
+		SiteId:      siteID,
+		Hash:        files[0].hash,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(files[0].data)),
+		Width:       img.Width,
+		Height:      img.Height,
+	})
+	if err != nil {
+		return models.Media{}, fmt.Errorf("error inserting media: %w", err)
+	}
+
+	for i, v := range img.Variants {
+		_, err := models.InsertMediaVariant(ctx, tx, models.NewMediaVariant{
+			MediaId:     m.Id,
+			Hash:        files[i+1].hash,
+			ContentType: v.ContentType,
+			Width:       v.Width,
+			Height:      v.Height,
+			Size:        int64(len(v.Data)),
+		})
+		if err != nil {
+			return models.Media{}, fmt.Errorf("error inserting media variant: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Media{}, err
+	}
+
+	return m, nil
+}
+
+// A site's uploads, newest first, and how much of its quota they use.
+type MediaLibrary struct {
+	Items []MediaItem
+	Used  int64
+	Quota int64
+}
+
+type MediaItem struct {
+	models.Media
+	URL string
+	// What to paste into a post or the home page.
+	Markdown string
+}
+
+func (s *MediaService) Library(ctx context.Context, site models.Site) (MediaLibrary, error) {
+	media, err := models.GetMedia(ctx, s.db, db.FilterEq("site_id", site.Id))
+	if err != nil {
+		return MediaLibrary{}, fmt.Errorf("error fetching media: %w", err)
+	}
+
+	lib := MediaLibrary{Used: site.MediaBytes, Quota: s.Quota(site)}
+	for i := len(media) - 1; i >= 0; i-- {
+		m := media[i]
+		lib.Items = append(lib.Items, MediaItem{Media: m, URL: MediaURL(m), Markdown: MediaMarkdown(m)})
+	}
+
+	return lib, nil
+}
+
+// Share of the quota used, from 0 to 100.
+func (l MediaLibrary) Percent() int {
+	if l.Quota <= 0 {
+		return 100
+	}
+
+	return int(min(l.Used*100/l.Quota, 100))
+}
+
+func (s *MediaService) Quota(site models.Site) int64 {
+	if site.MediaQuota != nil {
+		return *site.MediaQuota
+	}
+
+	return s.config.Core.MediaQuota
+}
+
+// Remove one of a site's uploads, and its resized copies.
+func (s *MediaService) Delete(ctx context.Context, siteID, mediaID int) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	media, err := models.GetMedia(ctx, tx, db.FilterEq("id", mediaID), db.FilterEq("site_id", siteID))
+	if err != nil {
+		return fmt.Errorf("error fetching media: %w", err)
+	}
+	if len(media) == 0 {
+		return ErrMediaDoesNotExist
+	}
+
+	variants, err := models.GetMediaVariants(ctx, tx, db.FilterEq("media_id", mediaID))
+	if err != nil {
+		return fmt.Errorf("error fetching media variants: %w", err)
+	}
+
+	hashes := []string{media[0].Hash}
+	size := media[0].Size
+	for _, v := range variants {
+		hashes = append(hashes, v.Hash)
+		size += v.Size
+	}
+
+	if err := models.DeleteMediaVariants(ctx, tx, db.FilterEq("media_id", mediaID)); err != nil {
+		return fmt.Errorf("error deleting media variants: %w", err)
+	}
+	if err := models.DeleteMedia(ctx, tx, db.FilterEq("id", mediaID)); err != nil {
+		return fmt.Errorf("error deleting media: %w", err)
+	}
+	if err := models.ReleaseSiteMedia(ctx, tx, siteID, size); err != nil {
+		return fmt.Errorf("error releasing media quota: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	pruneMediaFiles(ctx, s.db, s.config, hashes)
+
+	return nil
+}
+
+// A stored file, ready to serve.
+type MediaContent struct {
+	// Of the contents, for an ETag.
+	Hash        string
+	Path        string
+	ContentType string
+	Filename    string
+	CreatedAt   time.Time
+}
+
+// The upload with hash on a site, or its resized copy width pixels wide.
+// Widths without a copy (like those wider than the image) get the original.
+func (s *MediaService) Open(ctx context.Context, siteID int, hash string, width int) (MediaContent, error) {
+	media, err := models.GetMedia(ctx, s.db, db.FilterEq("site_id", siteID), db.FilterEq("hash", hash))
+	if err != nil {
+		return MediaContent{}, fmt.Errorf("error fetching media: %w", err)
+	}
+	if len(media) == 0 {
+		return MediaContent{}, ErrMediaDoesNotExist
+	}
+	m := media[0]
+
+	content := MediaContent{
+		Hash:        m.Hash,
+		Path:        mediaPath(s.config, m.Hash),
+		ContentType: m.ContentType,
+		Filename:    m.Filename,
+		CreatedAt:   m.CreatedAt,
+	}
+
+	if width > 0 {
+		variants, err := models.GetMediaVariants(ctx, s.db, db.FilterEq("media_id", m.Id), db.FilterEq("width", width))
+		if err != nil {
+			return MediaContent{}, fmt.Errorf("error fetching media variant: %w", err)
+		}
+		if len(variants) > 0 {
+			content.Hash = variants[0].Hash
+			content.Path = mediaPath(s.config, variants[0].Hash)
+			content.ContentType = variants[0].ContentType
+		}
+	}
+
+	return content, nil
+}
+
+// Path of a file on the site it was uploaded to.
+func MediaURL(m models.Media) string {
+	return "/media/" + m.Hash + "/" + url.PathEscape(m.Filename)
+}
+
+// An image for images, a link for anything else.
+func MediaMarkdown(m models.Media) string {
+	if strings.HasPrefix(m.ContentType, "image/") {
+		return fmt.Sprintf("![%s](%s)", m.Filename, MediaURL(m))
+	}
+
+	return fmt.Sprintf("[%s](%s)", m.Filename, MediaURL(m))
+}
+
+// Files are sharded by the first byte of their hash, to keep directories small.
+func mediaPath(cfg *config.Config, hash string) string {
+	return filepath.Join(cfg.Core.MediaDir, hash[:2], hash)
+}
+
+func writeMediaFile(path string, data []byte) error {
+	// Already stored by an earlier upload.
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write then rename, so a half written file is never served.
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Remove stored files that no media or variant row refers to any more.
+func pruneMediaFiles(ctx context.Context, d db.Ex, cfg *config.Config, hashes []string) {
+	unlock := lockMedia(hashes)
+	defer unlock()
+
+	for _, hash := range hashes {
+		ms, err := models.GetMedia(ctx, d, db.FilterEq("hash", hash))
+		if err != nil {
+			slog.Error("error checking media references", "hash", hash, "error", err)
+			continue
+		}
+		vs, err := models.GetMediaVariants(ctx, d, db.FilterEq("hash", hash))
+		if err != nil {
+			slog.Error("error checking media variant references", "hash", hash, "error", err)
+			continue
+		}
+		if len(ms) > 0 || len(vs) > 0 {
+			continue
+		}
+
+		if err := os.Remove(mediaPath(cfg, hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("error removing media file", "hash", hash, "error", err)
+		}
+	}
+}
+
+// Keep names to something safe to put in a url and a Content-Disposition header.
+func cleanFilename(name, contentType string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		case r == ' ':
+			return '-'
+		}
+		return -1
+	}, filepath.Base(name))
+	name = strings.Trim(name, ".-")
+
+	if len(name) > 100 {
+		ext := filepath.Ext(name)
+		if len(ext) > 10 {
+			ext = ""
+		}
+		name = name[:100-len(ext)] + ext
+	}
+
+	if name == "" {
+		name = "file"
+		if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+			name += exts[0]
+		}
+	}
+
+	return name
+}
@@ -0,0 +1,176 @@
+package services_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/imaging"
+	"uwece.ca/app/models"
+	"uwece.ca/app/services"
+)
+
+type mediaEnv struct {
+	testSite
+	svc *services.MediaService
+}
+
+func newMediaEnv(t *testing.T, quota int64) mediaEnv {
+	t.Helper()
+
+	ts := newTestSite(t, config.Core{
+		MediaDir:      t.TempDir(),
+		MediaQuota:    quota,
+		MaxUploadSize: 1 << 20,
+	})
+
+	return mediaEnv{testSite: ts, svc: services.NewMediaService(ts.db, ts.cfg)}
+}
+
+func (e mediaEnv) stored(hash string) bool {
+	_, err := os.Stat(filepath.Join(e.cfg.Core.MediaDir, hash[:2], hash))
+	return err == nil
+}
+
+// A noisy photo, so it doesn't compress down to nothing.
+func photo(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, color.RGBA{R: uint8(x * y), G: uint8(x ^ y), B: uint8(x + y), A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+
+	return buf.Bytes()
+}
+
+func TestMediaStoreMakesVariants(t *testing.T) {
+	t.Parallel()
+	env := newMediaEnv(t, 1<<20)
+	ctx := context.Background()
+
+	m, err := env.svc.Store(ctx, env.site.Id, "Beach Day.jpg", photo(t, 1000, 500))
+	require.NoError(t, err)
+	require.Equal(t, "Beach-Day.jpg", m.Filename)
+	require.Equal(t, 1000, m.Width)
+	require.Equal(t, 500, m.Height)
+	require.True(t, env.stored(m.Hash))
+
+	variants, err := models.GetMediaVariants(ctx, env.db, db.FilterEq("media_id", m.Id))
+	require.NoError(t, err)
+	// Only the widths narrower than the photo.
+	require.Len(t, variants, 2)
+	require.Equal(t, imaging.Widths[0], variants[0].Width)
+	require.Equal(t, 240, variants[0].Height)
+
+	// The quota counts the copies too.
+	total := m.Size
+	for _, v := range variants {
+		require.True(t, env.stored(v.Hash))
+		total += v.Size
+	}
+	require.Equal(t, total, env.reload(t).MediaBytes)
+
+	// A copy by width, and the original for widths without one.
+	content, err := env.svc.Open(ctx, env.site.Id, m.Hash, 960)
+	require.NoError(t, err)
+	require.Equal(t, variants[1].Hash, content.Hash)
+	content, err = env.svc.Open(ctx, env.site.Id, m.Hash, 1600)
+	require.NoError(t, err)
+	require.Equal(t, m.Hash, content.Hash)
+
+	// Other sites can't serve it.
+	_, err = env.svc.Open(ctx, env.site.Id+1, m.Hash, 0)
+	require.ErrorIs(t, err, services.ErrMediaDoesNotExist)
+
+	require.NoError(t, env.svc.Delete(ctx, env.site.Id, m.Id))
+	require.Zero(t, env.reload(t).MediaBytes)
+	require.False(t, env.stored(m.Hash))
+	for _, v := range variants {
+		require.False(t, env.stored(v.Hash))
+	}
+}
+
+func TestMediaQuota(t *testing.T) {
+	t.Parallel()
+	data := photo(t, 300, 300)
+	env := newMediaEnv(t, int64(len(data))+100)
+	ctx := context.Background()
+
+	_, err := env.svc.Store(ctx, env.site.Id, "one.jpg", data)
+	require.NoError(t, err)
+	used := env.reload(t).MediaBytes
+
+	_, err = env.svc.Store(ctx, env.site.Id, "two.jpg", photo(t, 301, 300))
+	require.ErrorIs(t, err, services.ErrMediaQuotaExceeded)
+	require.Equal(t, used, env.reload(t).MediaBytes)
+
+	// A site's own quota wins over the default.
+	require.NoError(t, models.UpdateSites(ctx, env.db, db.Updates(db.Update("media_quota", 1<<20)), db.FilterEq("id", env.site.Id)))
+	_, err = env.svc.Store(ctx, env.site.Id, "two.jpg", photo(t, 301, 300))
+	require.NoError(t, err)
+}
+
+func TestMediaSharedFiles(t *testing.T) {
+	t.Parallel()
+	env := newMediaEnv(t, 1<<20)
+	ctx := context.Background()
+
+	usr, err := models.InsertUser(ctx, env.db, models.NewUser{NetID: "gander", Name: "Gander", Password: "hi"})
+	require.NoError(t, err)
+	other, err := models.InsertSite(ctx, env.db, models.NewSite{UserId: usr.Id, Subdomain: "gander.28"})
+	require.NoError(t, err)
+
+	data := photo(t, 300, 300)
+	mine, err := env.svc.Store(ctx, env.site.Id, "photo.jpg", data)
+	require.NoError(t, err)
+	theirs, err := env.svc.Store(ctx, other.Id, "photo.jpg", data)
+	require.NoError(t, err)
+	require.Equal(t, mine.Hash, theirs.Hash)
+
+	// Deleting one site's copy leaves the file for the other.
+	require.NoError(t, env.svc.Delete(ctx, env.site.Id, mine.Id))
+	require.True(t, env.stored(theirs.Hash))
+
+	// So does a failed upload of the same photo.
+	_, err = env.svc.Store(ctx, env.site.Id, "photo.jpg", append(data, make([]byte, 2<<20)...))
+	require.ErrorIs(t, err, services.ErrValidationFailed)
+	require.NoError(t, models.UpdateSites(ctx, env.db, db.Updates(db.Update("media_quota", 1)), db.FilterEq("id", env.site.Id)))
+	_, err = env.svc.Store(ctx, env.site.Id, "photo.jpg", data)
+	require.ErrorIs(t, err, services.ErrMediaQuotaExceeded)
+	require.True(t, env.stored(theirs.Hash))
+
+	require.NoError(t, env.svc.Delete(ctx, other.Id, theirs.Id))
+	require.False(t, env.stored(theirs.Hash))
+}
+
+func TestMediaStoreRejects(t *testing.T) {
+	t.Parallel()
+	env := newMediaEnv(t, 1<<20)
+	ctx := context.Background()
+
+	_, err := env.svc.Store(ctx, env.site.Id, "notes.txt", []byte("just some text"))
+	require.ErrorIs(t, err, services.ErrMediaTypeNotAllowed)
+
+	_, err = env.svc.Store(ctx, env.site.Id, "big.pdf", append([]byte("%PDF-1.4\n"), make([]byte, 1<<20)...))
+	require.ErrorIs(t, err, services.ErrValidationFailed)
+
+	// Sniffs as a jpeg, but isn't one.
+	_, err = env.svc.Store(ctx, env.site.Id, "broken.jpg", []byte("\xff\xd8\xff\xe0 not really"))
+	require.ErrorIs(t, err, services.ErrValidationFailed)
+
+	require.Zero(t, env.reload(t).MediaBytes)
+}
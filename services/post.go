@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/i18n"
+	"uwece.ca/app/models"
+)
+
+var ErrPostDoesNotExist = errors.New("post does not exist")
+
+type PostService struct {
+	db     *db.DB
+	config *config.Config
+}
+
+func NewPostService(db *db.DB, config *config.Config) *PostService {
+	return &PostService{db: db, config: config}
+}
+
+type PostNewRequest struct {
+	Title string
+	Body  string
+
+	// One of models.PostDraft, PostScheduled or PostPublished, empty
+	// publishes.
+	Status string
+	// When a scheduled post goes live, as a datetime-local input sends it.
+	PublishAt string
+}
+
+func (p PostNewRequest) Validate() error {
+	if len(strings.TrimSpace(p.Title)) == 0 || len(p.Title) > 200 {
+		return i18n.NewError("validation.post_title_length", "min", 1, "max", 200)
+	}
+
+	if len(p.Body) > 100_000 {
+		return i18n.NewError("validation.post_body_length", "max", "100,000")
+	}
+
+	switch p.Status {
+	case "", models.PostDraft, models.PostScheduled, models.PostPublished:
+	default:
+		return i18n.NewError("validation.post_status")
+	}
+
+	return nil
+}
+
+// The status a request asks for and when the post goes live with it. A
+// post that's already up keeps the time it went live.
+func (s *PostService) publishing(req PostNewRequest, current *models.Post) (string, *time.Time, error) {
+	now := time.Now().UTC()
+
+	switch req.Status {
+	case models.PostDraft:
+		return models.PostDraft, nil, nil
+	case models.PostScheduled:
+		at, err := parseScheduleTime(s.config, req.PublishAt, now)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return models.PostScheduled, &at, nil
+	}
+
+	if current != nil && current.Status == models.PostPublished {
+		return models.PostPublished, current.PublishedAt, nil
+	}
+
+	return models.PostPublished, &now, nil
+}
+
+func (s *PostService) Create(ctx context.Context, siteID int, req PostNewRequest) (models.Post, error) {
+	if err := req.Validate(); err != nil {
+		return models.Post{}, fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	status, publishedAt, err := s.publishing(req, nil)
+	if err != nil {
+		return models.Post{}, err
+	}
+
+	// Two posts with the same title get numbered slugs.
+	base := slugify(req.Title)
+	for i := 1; ; i++ {
+		slug := base
+		if i > 1 {
+			slug = fmt.Sprintf("%s-%d", base, i)
+		}
+
+		post, err := models.InsertPost(ctx, s.db, models.NewPost{
+			SiteId: siteID,
+			Title:  strings.TrimSpace(req.Title),
+			Slug:   slug,
+			Body:   req.Body,
+
+			Status:      status,
+			PublishedAt: publishedAt,
+		})
+		if errors.Is(err, db.ErrUnique) && i < 100 {
+			continue
+		}
+		if err != nil {
+			return models.Post{}, fmt.Errorf("error inserting post: %w", err)
+		}
+
+		return post, nil
+	}
+}
+
+// Save changes to a post. Its slug stays the same so links to it keep
+// working. Posts don't keep revisions, the old title and body are gone.
+func (s *PostService) Update(ctx context.Context, siteID, postID int, req PostNewRequest) (models.Post, error) {
+	if err := req.Validate(); err != nil {
+		return models.Post{}, fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	post, err := s.GetByID(ctx, siteID, postID)
+	if err != nil {
+		return models.Post{}, err
+	}
+
+	status, publishedAt, err := s.publishing(req, &post)
+	if err != nil {
+		return models.Post{}, err
+	}
+
+	updates := db.Updates(
+		db.Update("updated_at", time.Now().UTC()),
+		db.Update("title", strings.TrimSpace(req.Title)),
+		db.Update("body", req.Body),
+		db.Update("status", status),
+		db.Update("published_at", publishedAt),
+	)
+	if err := models.UpdatePosts(ctx, s.db, updates, db.FilterEq("id", post.Id)); err != nil {
+		return models.Post{}, fmt.Errorf("error updating post: %w", err)
+	}
+
+	return s.GetByID(ctx, siteID, postID)
+}
+
+// Posts readers can see. Drafts and scheduled posts stay out of pages, feeds,
+// sitemaps and search, only their owner sees them in preview.
+func publishedPosts(filters ...db.Filter) []db.Filter {
+	return append(filters, db.FilterEq("status", models.PostPublished))
+}
+
+// A site's published posts, newest first.
+func (s *PostService) List(ctx context.Context, siteID int) ([]models.Post, error) {
+	return s.list(ctx, publishedPosts(db.FilterEq("site_id", siteID))...)
+}
+
+// Every one of a site's posts, drafts and scheduled ones too, newest first.
+func (s *PostService) ListAll(ctx context.Context, siteID int) ([]models.Post, error) {
+	return s.list(ctx, db.FilterEq("site_id", siteID))
+}
+
+func (s *PostService) list(ctx context.Context, filters ...db.Filter) ([]models.Post, error) {
+	posts, err := models.GetPosts(ctx, s.db, filters...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching posts: %w", err)
+	}
+
+	return posts, nil
+}
+
+// A published post by its slug.
+func (s *PostService) Get(ctx context.Context, siteID int, slug string) (models.Post, error) {
+	return s.get(ctx, publishedPosts(db.FilterEq("site_id", siteID), db.FilterEq("slug", slug))...)
+}
+
+// A post by its slug, whatever its status.
+func (s *PostService) GetAny(ctx context.Context, siteID int, slug string) (models.Post, error) {
+	return s.get(ctx, db.FilterEq("site_id", siteID), db.FilterEq("slug", slug))
+}
+
+// A post by its id, whatever its status.
+func (s *PostService) GetByID(ctx context.Context, siteID, postID int) (models.Post, error) {
+	return s.get(ctx, db.FilterEq("site_id", siteID), db.FilterEq("id", postID))
+}
+
+func (s *PostService) get(ctx context.Context, filters ...db.Filter) (models.Post, error) {
+	post, err := models.GetPost(ctx, s.db, filters...)
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return models.Post{}, ErrPostDoesNotExist
+		}
+
+		return models.Post{}, fmt.Errorf("error fetching post: %w", err)
+	}
+
+	return post, nil
+}
+
+// Where a post can be read.
+func (s *PostService) URL(site models.Site, post models.Post) string {
+	return s.config.Core.SiteURL(site.Subdomain) + "/posts/" + post.Slug
+}
+
+// Lowercase letters and digits, with dashes between words.
+func slugify(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+
+		if b.Len() >= 60 {
+			break
+		}
+	}
+
+	if b.Len() == 0 {
+		return "post"
+	}
+
+	return b.String()
+}
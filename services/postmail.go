@@ -0,0 +1,231 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/inbound"
+	"uwece.ca/app/mailer"
+	"uwece.ca/app/models"
+	"uwece.ca/app/utils"
+)
+
+// Hex characters in a site's secret posting address.
+const postTokenLength = 24
+
+// Turns mail sent to a site's secret address into a post. It is the
+// inbound.Handler behind the posting listener.
+type PostMailService struct {
+	db     *db.DB
+	mailer mailer.Mailer
+	config *config.Config
+	users  *UserService
+	posts  *PostService
+	media  *MediaService
+}
+
+func NewPostMailService(db *db.DB, mailer mailer.Mailer, config *config.Config, users *UserService) *PostMailService {
+	return &PostMailService{
+		db:     db,
+		mailer: mailer,
+		config: config,
+		users:  users,
+		posts:  NewPostService(db, config),
+		media:  NewMediaService(db, config),
+	}
+}
+
+func (s *PostMailService) Enabled() bool {
+	return s.config.Inbound.Addr != ""
+}
+
+// Listen for mail until ctx is cancelled.
+func (s *PostMailService) Run(ctx context.Context) {
+	srv := &inbound.Server{
+		Handler:  s,
+		Hostname: s.config.Inbound.Domain,
+		LMTP:     s.config.Inbound.Protocol == "lmtp",
+		MaxSize:  s.config.Inbound.MaxSize,
+	}
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	slog.Info("listening for posts by email", "addr", s.config.Inbound.Addr, "protocol", s.config.Inbound.Protocol)
+	if err := srv.ListenAndServe(s.config.Inbound.Addr); err != nil && !errors.Is(err, inbound.ErrServerClosed) {
+		slog.Error("failed to start inbound mail listener", "error", err)
+	}
+}
+
+// The secret address for a site, or "" when posting by email is off.
+func (s *PostMailService) Address(site models.Site) string {
+	if site.PostToken == nil {
+		return ""
+	}
+
+	return *site.PostToken + "@" + s.config.Inbound.Domain
+}
+
+type PostByEmailRequest struct {
+	Enabled bool
+}
+
+// Turn posting by email on for a site, replacing any previous address.
+func (s *PostMailService) NewAddress(ctx context.Context, siteID int) error {
+	token := string(utils.NewToken())[:postTokenLength]
+
+	return s.setToken(ctx, siteID, &token)
+}
+
+func (s *PostMailService) Disable(ctx context.Context, siteID int) error {
+	return s.setToken(ctx, siteID, nil)
+}
+
+func (s *PostMailService) setToken(ctx context.Context, siteID int, token *string) error {
+	updates := db.Updates(
+		db.Update("updated_at", time.Now()),
+		db.Update("post_token", token),
+	)
+	if err := models.UpdateSites(ctx, s.db, updates, db.FilterEq("id", siteID)); err != nil {
+		return fmt.Errorf("error updating posting address: %w", err)
+	}
+
+	return nil
+}
+
+func (s *PostMailService) siteFor(ctx context.Context, to string) (models.Site, error) {
+	token, domain, ok := strings.Cut(strings.ToLower(to), "@")
+	if !ok || domain != strings.ToLower(s.config.Inbound.Domain) {
+		return models.Site{}, inbound.Reject("5.7.1", "Relaying denied")
+	}
+
+	site, err := models.GetSite(ctx, s.db, db.FilterEq("post_token", token))
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return models.Site{}, inbound.Reject("5.1.1", "No such mailbox")
+		}
+
+		return models.Site{}, fmt.Errorf("error fetching site for posting address: %w", err)
+	}
+
+	return site, nil
+}
+
+func (s *PostMailService) Recipient(ctx context.Context, from, to string) error {
+	_, err := s.siteFor(ctx, to)
+	return err
+}
+
+// Only the site owner may post, both the envelope sender and the From header
+// have to be their NetID address. Both are easy to forge, so unless the MTA's
+// Authentication-Results are trusted with Inbound.AuthServID, knowing the
+// secret address is what really decides who can post.
+func (s *PostMailService) Deliver(ctx context.Context, from, to string, data []byte) error {
+	site, err := s.siteFor(ctx, to)
+	if err != nil {
+		return err
+	}
+
+	owner, err := models.GetUser(ctx, s.db, db.FilterEq("id", site.UserId))
+	if err != nil {
+		return fmt.Errorf("error fetching site owner: %w", err)
+	}
+	ownerEmail := s.users.GetEmail(owner.NetID)
+
+	msg, err := inbound.ParseMessage(data)
+	if err != nil {
+		return inbound.Reject("5.6.0", "Could not read message")
+	}
+
+	if !strings.EqualFold(from, ownerEmail) || !strings.EqualFold(msg.From, ownerEmail) {
+		slog.Warn("post by email from wrong sender", "site_id", site.Id, "from", from, "header_from", msg.From)
+		return inbound.Reject("5.7.1", "Sender is not allowed to post here")
+	}
+
+	authservID := s.config.Inbound.AuthServID
+	if authservID != "" && !inbound.AuthenticatedBy(msg.AuthResults, authservID, s.config.Core.EmailDomain) {
+		slog.Warn("post by email failed authentication", "site_id", site.Id, "from", from, "results", msg.AuthResults)
+		return inbound.Reject("5.7.1", "Sender could not be authenticated")
+	}
+
+	body := msg.Text
+	if body == "" && msg.HTML != "" {
+		if body, err = mailer.HTMLToText(msg.HTML); err != nil {
+			return inbound.Reject("5.6.0", "Could not read message")
+		}
+	}
+	body = stripSignature(body)
+
+	var skipped []string
+	for _, a := range msg.Attachments {
+		m, err := s.media.Store(ctx, site.Id, a.Filename, a.Data)
+		if errors.Is(err, ErrMediaTypeNotAllowed) || errors.Is(err, ErrMediaQuotaExceeded) || errors.Is(err, ErrValidationFailed) {
+			skipped = append(skipped, cmp.Or(a.Filename, "unnamed "+a.ContentType))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		body += "\n\n" + MediaMarkdown(m)
+	}
+
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return inbound.Reject("5.6.0", "Message is empty")
+	}
+
+	title := msg.Subject
+	if title == "" {
+		title = "Untitled post"
+	}
+	if len(title) > 200 {
+		title = strings.ToValidUTF8(title[:200], "")
+	}
+
+	post, err := s.posts.Create(ctx, site.Id, PostNewRequest{Title: title, Body: body})
+	if err != nil {
+		if errors.Is(err, ErrValidationFailed) {
+			return inbound.Reject("5.6.0", "Message is too long to post")
+		}
+
+		return err
+	}
+
+	slog.Info("post created by email", "site_id", site.Id, "post_id", post.Id)
+
+	if owner.UndeliverableAt != nil {
+		return nil
+	}
+
+	reply := mailer.PostPublishedMessage{
+		Name:     owner.Name,
+		Title:    post.Title,
+		PostLink: s.posts.URL(site, post),
+		Skipped:  skipped,
+	}
+	if err := s.mailer.Send(ownerEmail, owner.Name, reply); err != nil {
+		slog.Warn("error sending post confirmation", "post_id", post.Id, "error", err)
+	}
+
+	return nil
+}
+
+// Drop everything after a "-- " signature separator, and any trailing blank lines.
+func stripSignature(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	if i := strings.Index(body, "\n-- \n"); i >= 0 {
+		body = body[:i]
+	}
+
+	return strings.TrimSpace(body)
+}
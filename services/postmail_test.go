@@ -0,0 +1,199 @@
+package services_test
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/db/dbtest"
+	"uwece.ca/app/inbound"
+	"uwece.ca/app/mailer"
+	"uwece.ca/app/models"
+	"uwece.ca/app/services"
+)
+
+const ownerEmail = "goose@connect.uwaterloo.ca"
+
+// A one pixel png, base64 encoded and wrapped like a mail client would.
+const pixelPNG = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwAD\r\nhgGAWjR9awAAAABJRU5ErkJggg=="
+
+func postMessage(from string) string {
+	return strings.ReplaceAll(`From: Goose <`+from+`>
+To: post@post.uwece.ca
+Subject: Co-op fair notes
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain; charset=utf-8
+
+Met *lots* of recruiters.
+
+-- 
+Sent from my phone
+--b
+Content-Type: image/png
+Content-Disposition: attachment; filename="booth photo.png"
+Content-Transfer-Encoding: base64
+
+`+pixelPNG+`
+--b
+Content-Type: application/vnd.ms-powerpoint
+Content-Disposition: attachment; filename="slides.ppt"
+
+not really slides
+--b--
+`, "\n", "\r\n")
+}
+
+type postMailEnv struct {
+	db      *db.DB
+	cfg     *config.Config
+	mail    *mailer.MemoryTransport
+	site    models.Site
+	addr    string
+	address string
+}
+
+// Run the posting listener in process, with posting turned on for goose.28.
+func startPostMail(t *testing.T) postMailEnv {
+	t.Helper()
+	ctx := context.Background()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+
+	cfg := &config.Config{
+		Core: config.Core{
+			BaseDomain:    "uwece.ca",
+			EmailDomain:   "connect.uwaterloo.ca",
+			MediaDir:      t.TempDir(),
+			MediaQuota:    1 << 20,
+			MaxUploadSize: 1 << 20,
+		},
+		Mailer:  config.Mailer{FromAddress: "noreply@uwece.ca"},
+		Inbound: config.Inbound{Domain: "post.uwece.ca", MaxSize: 1 << 20},
+	}
+	tr := mailer.NewMemoryTransport()
+	m := mailer.NewWithTransport(cfg, tr)
+	users, err := services.NewUserService(d, m, cfg)
+	require.NoError(t, err)
+	svc := services.NewPostMailService(d, m, cfg, users)
+
+	usr, err := models.InsertUser(ctx, d, models.NewUser{NetID: "goose", Name: "Goose", Password: "hi"})
+	require.NoError(t, err)
+	site, err := models.InsertSite(ctx, d, models.NewSite{UserId: usr.Id, Subdomain: "goose.28"})
+	require.NoError(t, err)
+
+	require.NoError(t, svc.NewAddress(ctx, site.Id))
+	site, err = models.GetSite(ctx, d, db.FilterEq("id", site.Id))
+	require.NoError(t, err)
+
+	srv := &inbound.Server{Handler: svc, Hostname: "post.uwece.ca", MaxSize: cfg.Inbound.MaxSize}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	return postMailEnv{db: d, cfg: cfg, mail: tr, site: site, addr: l.Addr().String(), address: svc.Address(site)}
+}
+
+func TestPostByEmail(t *testing.T) {
+	t.Parallel()
+
+	env := startPostMail(t)
+	ctx := context.Background()
+
+	err := smtp.SendMail(env.addr, nil, ownerEmail, []string{env.address}, []byte(postMessage(ownerEmail)))
+	require.NoError(t, err)
+
+	posts, err := models.GetPosts(ctx, env.db, db.FilterEq("site_id", env.site.Id))
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+	require.Equal(t, "Co-op fair notes", posts[0].Title)
+	require.Equal(t, "co-op-fair-notes", posts[0].Slug)
+
+	// The signature is dropped, the image is linked and the slides are skipped.
+	media, err := models.GetMedia(ctx, env.db, db.FilterEq("site_id", env.site.Id))
+	require.NoError(t, err)
+	require.Len(t, media, 1)
+	require.Equal(t, "booth-photo.png", media[0].Filename)
+	require.Equal(t, "image/png", media[0].ContentType)
+	require.Equal(t, "Met *lots* of recruiters.\n\n![booth-photo.png]("+services.MediaURL(media[0])+")", posts[0].Body)
+
+	_, err = os.Stat(filepath.Join(env.cfg.Core.MediaDir, media[0].Hash[:2], media[0].Hash))
+	require.NoError(t, err)
+
+	sent := env.mail.Messages()
+	require.Len(t, sent, 1)
+	require.Equal(t, []string{ownerEmail}, sent[0].Envelope.To)
+	require.Contains(t, string(sent[0].Data), "Auto-Submitted: auto-replied")
+	require.Contains(t, string(sent[0].Data), "https://goose.28.uwece.ca/posts/co-op-fair-notes")
+	require.Contains(t, string(sent[0].Data), "slides.ppt")
+}
+
+func TestPostByEmailRejectsOtherSenders(t *testing.T) {
+	t.Parallel()
+
+	env := startPostMail(t)
+	ctx := context.Background()
+
+	// A forged From header with someone else's envelope, and the other way around.
+	for _, c := range []struct{ envelope, header string }{
+		{"gander@connect.uwaterloo.ca", ownerEmail},
+		{ownerEmail, "gander@connect.uwaterloo.ca"},
+	} {
+		err := smtp.SendMail(env.addr, nil, c.envelope, []string{env.address}, []byte(postMessage(c.header)))
+		var tpErr *textproto.Error
+		require.ErrorAs(t, err, &tpErr)
+		require.Equal(t, 550, tpErr.Code)
+	}
+
+	posts, err := models.GetPosts(ctx, env.db, db.FilterEq("site_id", env.site.Id))
+	require.NoError(t, err)
+	require.Empty(t, posts)
+	require.Empty(t, env.mail.Messages())
+}
+
+func TestPostByEmailNeedsAuthentication(t *testing.T) {
+	t.Parallel()
+
+	env := startPostMail(t)
+	env.cfg.Inbound.AuthServID = "mx.uwece.ca"
+	ctx := context.Background()
+
+	// The owner's address, but nothing vouches for it.
+	forged := "Authentication-Results: mx.evil.com; dkim=pass header.d=connect.uwaterloo.ca\r\n" + postMessage(ownerEmail)
+	err := smtp.SendMail(env.addr, nil, ownerEmail, []string{env.address}, []byte(forged))
+	var tpErr *textproto.Error
+	require.ErrorAs(t, err, &tpErr)
+	require.Equal(t, 550, tpErr.Code)
+
+	signed := "Authentication-Results: mx.uwece.ca; dkim=pass header.d=uwaterloo.ca\r\n" + postMessage(ownerEmail)
+	require.NoError(t, smtp.SendMail(env.addr, nil, ownerEmail, []string{env.address}, []byte(signed)))
+
+	posts, err := models.GetPosts(ctx, env.db, db.FilterEq("site_id", env.site.Id))
+	require.NoError(t, err)
+	require.Len(t, posts, 1)
+}
+
+func TestPostByEmailRejectsUnknownAddresses(t *testing.T) {
+	t.Parallel()
+
+	env := startPostMail(t)
+
+	for _, to := range []string{"nope@post.uwece.ca", strings.Replace(env.address, "post.uwece.ca", "uwece.ca", 1)} {
+		err := smtp.SendMail(env.addr, nil, ownerEmail, []string{to}, []byte(postMessage(ownerEmail)))
+		var tpErr *textproto.Error
+		require.ErrorAs(t, err, &tpErr)
+		require.Equal(t, 550, tpErr.Code)
+	}
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"time"
+
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/diff"
+	"uwece.ca/app/models"
+)
+
+var ErrRevisionDoesNotExist = errors.New("revision does not exist")
+
+// How often revisions past the retention policy are pruned from every site.
+// Saves prune their own site's as they go, this catches the rest.
+const revisionPruneInterval = time.Hour
+
+// Unchanged lines shown either side of a change in a diff.
+const diffContext = 3
+
+// Fields that keep revisions, in the order the history page shows them. Only
+// the site's own content does, posts are saved over in place.
+var revisionFields = []string{models.RevisionHome, models.RevisionNavbar, models.RevisionStylesheet}
+
+type RevisionService struct {
+	db     *db.DB
+	config *config.Config
+}
+
+func NewRevisionService(db *db.DB, config *config.Config) *RevisionService {
+	return &RevisionService{db: db, config: config}
+}
+
+// Write content to one of a site's fields, along with any other updates, and
+// keep it as a revision unless it's the same as the newest one. The field's
+// revisions past the retention policy are pruned in the same go.
+func saveContent(ctx context.Context, d *db.DB, cfg *config.Config, siteID, authorID int, field, content string, updates ...db.UpdateData) error {
+	tx, err := d.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	updates = append(updates, db.Update("updated_at", time.Now().UTC()), db.Update(field, content))
+	if err := models.UpdateSites(ctx, tx, updates, db.FilterEq("id", siteID)); err != nil {
+		return fmt.Errorf("error updating site %s: %w", field, err)
+	}
+
+	latest, err := models.GetRevision(ctx, tx, db.FilterEq("site_id", siteID), db.FilterEq("field", field))
+	if err != nil && !errors.Is(err, db.ErrNoRows) {
+		return fmt.Errorf("error fetching latest revision: %w", err)
+	}
+
+	if err != nil || latest.Content != content {
+		_, err := models.InsertRevision(ctx, tx, models.NewRevision{
+			SiteId:   siteID,
+			Field:    field,
+			Content:  content,
+			AuthorId: &authorID,
+		})
+		if err != nil {
+			return fmt.Errorf("error inserting revision: %w", err)
+		}
+
+		keep, cutoff := revisionRetention(cfg)
+		if _, err := models.PruneRevisions(ctx, tx, keep, cutoff, db.FilterEq("site_id", siteID), db.FilterEq("field", field)); err != nil {
+			return fmt.Errorf("error pruning revisions: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// How many revisions of a field to keep, and the oldest time to keep them
+// from, by the configured policy.
+func revisionRetention(cfg *config.Config) (int, time.Time) {
+	keep := cfg.Core.RevisionLimit
+	if keep <= 0 {
+		keep = math.MaxInt32
+	}
+
+	var cutoff time.Time
+	if cfg.Core.RevisionMaxAge > 0 {
+		cutoff = time.Now().UTC().Add(-cfg.Core.RevisionMaxAge)
+	}
+
+	return keep, cutoff
+}
+
+type RevisionHistoryRequest struct {
+	Field string
+	// The revisions to compare, the newest and the one before it when unset.
+	From int
+	To   int
+}
+
+// A field's revisions, newest first, and what changed between two of them.
+type RevisionHistory struct {
+	Field     string
+	Fields    []string
+	Revisions []models.Revision
+	// Nil when there's nothing to compare, with only one revision.
+	From *models.Revision
+	To   *models.Revision
+	// The lines that changed from From to To, with some context.
+	Diff    []diff.Line
+	Added   int
+	Removed int
+}
+
+func (s *RevisionService) History(ctx context.Context, siteID int, req RevisionHistoryRequest) (RevisionHistory, error) {
+	h := RevisionHistory{Field: req.Field, Fields: revisionFields}
+	if !slices.Contains(revisionFields, h.Field) {
+		h.Field = models.RevisionHome
+	}
+
+	revs, err := models.GetRevisions(ctx, s.db, db.FilterEq("revisions.site_id", siteID), db.FilterEq("revisions.field", h.Field))
+	if err != nil {
+		return RevisionHistory{}, fmt.Errorf("error fetching revisions: %w", err)
+	}
+	h.Revisions = revs
+
+	to := slices.IndexFunc(revs, func(r models.Revision) bool { return r.Id == req.To })
+	if to < 0 {
+		to = 0
+	}
+	from := slices.IndexFunc(revs, func(r models.Revision) bool { return r.Id == req.From })
+	if from < 0 {
+		from = to + 1
+	}
+
+	if to >= len(revs) || from >= len(revs) {
+		return h, nil
+	}
+
+	h.From, h.To = &revs[from], &revs[to]
+	lines := diff.Lines(h.From.Content, h.To.Content)
+	h.Added, h.Removed = diff.Count(lines)
+	h.Diff = diff.Compact(lines, diffContext)
+
+	return h, nil
+}
+
+// Put an old revision's content back live. It's saved as a new revision, so
+// the history leading up to it stays and the restore can be undone too.
+func (s *RevisionService) Restore(ctx context.Context, siteID, authorID, revID int) (models.Revision, error) {
+	rev, err := models.GetRevision(ctx, s.db, db.FilterEq("id", revID), db.FilterEq("site_id", siteID))
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return models.Revision{}, ErrRevisionDoesNotExist
+		}
+
+		return models.Revision{}, fmt.Errorf("error fetching revision: %w", err)
+	}
+
+	if err := saveContent(ctx, s.db, s.config, siteID, authorID, rev.Field, rev.Content); err != nil {
+		return models.Revision{}, err
+	}
+
+	return rev, nil
+}
+
+// Delete revisions past the retention policy on every site, returning how
+// many there were.
+func (s *RevisionService) Prune(ctx context.Context) (int, error) {
+	keep, cutoff := revisionRetention(s.config)
+
+	n, err := models.PruneRevisions(ctx, s.db, keep, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("error pruning revisions: %w", err)
+	}
+
+	return n, nil
+}
+
+// Prune every revisionPruneInterval until ctx is cancelled.
+func (s *RevisionService) Run(ctx context.Context) {
+	for {
+		if n, err := s.Prune(ctx); err != nil {
+			if ctx.Err() == nil {
+				slog.Error("error pruning revisions", "error", err)
+			}
+		} else if n > 0 {
+			slog.Info("pruned old revisions", "revisions", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(revisionPruneInterval):
+		}
+	}
+}
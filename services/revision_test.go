@@ -0,0 +1,197 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/models"
+	"uwece.ca/app/services"
+)
+
+type revisionEnv struct {
+	testSite
+	blogs     *services.BlogService
+	revisions *services.RevisionService
+	schedule  *services.ScheduleService
+}
+
+func newRevisionEnv(t *testing.T, core config.Core) revisionEnv {
+	t.Helper()
+
+	ts := newTestSite(t, core)
+
+	return revisionEnv{
+		testSite:  ts,
+		blogs:     services.NewBlogService(ts.db, ts.cfg, nil),
+		revisions: services.NewRevisionService(ts.db, ts.cfg),
+		schedule:  services.NewScheduleService(ts.db, ts.cfg),
+	}
+}
+
+// The field's revisions' contents, newest first.
+func (e revisionEnv) contents(t *testing.T, field string) []string {
+	t.Helper()
+
+	h, err := e.revisions.History(context.Background(), e.site.Id, services.RevisionHistoryRequest{Field: field})
+	require.NoError(t, err)
+
+	contents := make([]string, len(h.Revisions))
+	for i, r := range h.Revisions {
+		contents[i] = r.Content
+	}
+
+	return contents
+}
+
+func TestRevisionsKeepEverySave(t *testing.T) {
+	t.Parallel()
+	env := newRevisionEnv(t, config.Core{})
+	ctx := context.Background()
+
+	// New blogs start with their first revisions.
+	require.Equal(t, []string{"[Home](/)"}, env.contents(t, models.RevisionNavbar))
+	require.Equal(t, []string{""}, env.contents(t, models.RevisionStylesheet))
+
+	require.NoError(t, env.blogs.SetStylesheet(ctx, env.site.Id, env.user.Id, services.BlogStylesheetRequest{Stylesheet: "a {}"}))
+	require.NoError(t, env.blogs.SetStylesheet(ctx, env.site.Id, env.user.Id, services.BlogStylesheetRequest{Stylesheet: "a {}"}))
+	require.NoError(t, env.blogs.SetNavbar(ctx, env.site.Id, env.user.Id, services.BlogNavbarRequest{Navbar: "[Home](/) [About](/posts/about)"}))
+
+	// Saving the same thing twice keeps one revision.
+	require.Equal(t, []string{"a {}", ""}, env.contents(t, models.RevisionStylesheet))
+	require.Equal(t, []string{"[Home](/) [About](/posts/about)", "[Home](/)"}, env.contents(t, models.RevisionNavbar))
+
+	// Drafts aren't live, so they aren't revisions until they're published.
+	home := env.contents(t, models.RevisionHome)
+	require.NoError(t, env.blogs.SetHome(ctx, env.site.Id, env.user.Id, services.BlogHomeRequest{Content: "Draft.", Status: models.PostDraft}))
+	require.Equal(t, home, env.contents(t, models.RevisionHome))
+
+	require.NoError(t, env.blogs.SetHome(ctx, env.site.Id, env.user.Id, services.BlogHomeRequest{Content: "Live.", Status: models.PostPublished}))
+	require.Equal(t, append([]string{"Live."}, home...), env.contents(t, models.RevisionHome))
+
+	h, err := env.revisions.History(ctx, env.site.Id, services.RevisionHistoryRequest{Field: models.RevisionHome})
+	require.NoError(t, err)
+	require.Equal(t, "Goose", h.To.AuthorName)
+	require.Equal(t, 1, h.Added)
+	require.Equal(t, 2, h.Removed)
+
+	err = env.blogs.SetNavbar(ctx, env.site.Id, env.user.Id, services.BlogNavbarRequest{Navbar: string(make([]byte, 5001))})
+	require.ErrorIs(t, err, services.ErrValidationFailed)
+}
+
+func TestRevisionHistoryDefaults(t *testing.T) {
+	t.Parallel()
+	env := newRevisionEnv(t, config.Core{})
+	ctx := context.Background()
+
+	// Only one revision, nothing to compare.
+	h, err := env.revisions.History(ctx, env.site.Id, services.RevisionHistoryRequest{Field: models.RevisionStylesheet})
+	require.NoError(t, err)
+	require.Len(t, h.Revisions, 1)
+	require.Nil(t, h.From)
+	require.Nil(t, h.Diff)
+
+	for _, css := range []string{"a {}", "a {}\nb {}", "b {}"} {
+		require.NoError(t, env.blogs.SetStylesheet(ctx, env.site.Id, env.user.Id, services.BlogStylesheetRequest{Stylesheet: css}))
+	}
+
+	// The newest two by default, any two when asked for.
+	h, err = env.revisions.History(ctx, env.site.Id, services.RevisionHistoryRequest{Field: models.RevisionStylesheet})
+	require.NoError(t, err)
+	require.Equal(t, "a {}\nb {}", h.From.Content)
+	require.Equal(t, "b {}", h.To.Content)
+
+	h, err = env.revisions.History(ctx, env.site.Id, services.RevisionHistoryRequest{
+		Field: models.RevisionStylesheet,
+		From:  h.Revisions[3].Id,
+		To:    h.Revisions[2].Id,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "", h.From.Content)
+	require.Equal(t, "a {}", h.To.Content)
+
+	// Unknown fields and other fields' revisions fall back to the defaults.
+	h, err = env.revisions.History(ctx, env.site.Id, services.RevisionHistoryRequest{Field: "password", To: h.To.Id})
+	require.NoError(t, err)
+	require.Equal(t, models.RevisionHome, h.Field)
+	require.Len(t, h.Revisions, 1)
+}
+
+func TestRevisionRestore(t *testing.T) {
+	t.Parallel()
+	env := newRevisionEnv(t, config.Core{})
+	ctx := context.Background()
+
+	require.NoError(t, env.blogs.SetNavbar(ctx, env.site.Id, env.user.Id, services.BlogNavbarRequest{Navbar: "oops"}))
+
+	h, err := env.revisions.History(ctx, env.site.Id, services.RevisionHistoryRequest{Field: models.RevisionNavbar})
+	require.NoError(t, err)
+
+	rev, err := env.revisions.Restore(ctx, env.site.Id, env.user.Id, h.From.Id)
+	require.NoError(t, err)
+	require.Equal(t, models.RevisionNavbar, rev.Field)
+
+	site, err := models.GetSite(ctx, env.db, db.FilterEq("id", env.site.Id))
+	require.NoError(t, err)
+	require.Equal(t, "[Home](/)", site.Navbar)
+
+	// The bad save stays in the history, under the restored version.
+	require.Equal(t, []string{"[Home](/)", "oops", "[Home](/)"}, env.contents(t, models.RevisionNavbar))
+
+	// Only the site's own revisions.
+	_, err = env.revisions.Restore(ctx, env.site.Id+1, env.user.Id, h.From.Id)
+	require.ErrorIs(t, err, services.ErrRevisionDoesNotExist)
+}
+
+func TestRevisionRetention(t *testing.T) {
+	t.Parallel()
+	env := newRevisionEnv(t, config.Core{RevisionLimit: 3, RevisionMaxAge: time.Hour})
+	ctx := context.Background()
+
+	for _, css := range []string{"1", "2", "3", "4"} {
+		require.NoError(t, env.blogs.SetStylesheet(ctx, env.site.Id, env.user.Id, services.BlogStylesheetRequest{Stylesheet: css}))
+	}
+	require.Equal(t, []string{"4", "3", "2"}, env.contents(t, models.RevisionStylesheet))
+
+	// Past the age limit, everything but the live version goes.
+	_, err := env.db.ExecContext(ctx, `update revisions set created_at = ?`, time.Now().UTC().Add(-2*time.Hour))
+	require.NoError(t, err)
+
+	n, err := env.revisions.Prune(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{"4"}, env.contents(t, models.RevisionStylesheet))
+	require.Equal(t, []string{"[Home](/)"}, env.contents(t, models.RevisionNavbar))
+}
+
+func TestScheduledHomeIsRevision(t *testing.T) {
+	t.Parallel()
+	env := newRevisionEnv(t, config.Core{RevisionLimit: 2})
+	ctx := context.Background()
+
+	schedule := func(content string) {
+		input, at := localInput(t, time.Hour)
+		require.NoError(t, env.blogs.SetHome(ctx, env.site.Id, env.user.Id, services.BlogHomeRequest{Content: content, Status: models.PostScheduled, PublishAt: input}))
+		require.NoError(t, env.schedule.PublishDue(ctx, at.Add(time.Second).UTC()))
+	}
+
+	home := env.contents(t, models.RevisionHome)
+	schedule("Later.")
+
+	h, err := env.revisions.History(ctx, env.site.Id, services.RevisionHistoryRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "Later.", h.To.Content)
+	require.Equal(t, env.user.Id, *h.To.AuthorId)
+	require.Equal(t, append([]string{"Later."}, home...), env.contents(t, models.RevisionHome))
+
+	// Publishing what's already live keeps one revision, like saving it does.
+	schedule("Later.")
+	require.Equal(t, append([]string{"Later."}, home...), env.contents(t, models.RevisionHome))
+
+	// And the retention policy holds.
+	schedule("Latest.")
+	require.Equal(t, []string{"Latest.", "Later."}, env.contents(t, models.RevisionHome))
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/i18n"
+	"uwece.ca/app/models"
+)
+
+// Longest the scheduler sleeps, so something scheduled while it waits goes
+// live at most this late.
+const schedulePollInterval = time.Minute
+
+// How datetime-local inputs send a date and time.
+const scheduleLayout = "2006-01-02T15:04"
+
+// Publishes scheduled posts and home pages when their time comes.
+type ScheduleService struct {
+	db     *db.DB
+	config *config.Config
+}
+
+func NewScheduleService(db *db.DB, config *config.Config) *ScheduleService {
+	return &ScheduleService{db: db, config: config}
+}
+
+// Publish whatever comes due until ctx is done.
+func (s *ScheduleService) Run(ctx context.Context) {
+	for {
+		if err := s.PublishDue(ctx, time.Now().UTC()); err != nil && ctx.Err() == nil {
+			slog.Error("error publishing scheduled content", "error", err)
+		}
+
+		wait := schedulePollInterval
+		if next, err := s.next(ctx); err != nil {
+			if ctx.Err() == nil {
+				slog.Error("error fetching next scheduled content", "error", err)
+			}
+		} else if next != nil {
+			wait = min(wait, max(time.Until(*next), time.Second))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Put everything scheduled for now or earlier live.
+func (s *ScheduleService) PublishDue(ctx context.Context, now time.Time) error {
+	posts, err := models.PublishScheduledPosts(ctx, s.db, now)
+	if err != nil {
+		return fmt.Errorf("error publishing scheduled posts: %w", err)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	homes, err := models.PublishScheduledHomes(ctx, tx, now)
+	if err != nil {
+		return fmt.Errorf("error publishing scheduled home pages: %w", err)
+	}
+
+	if len(homes) > 0 {
+		keep, cutoff := revisionRetention(s.config)
+		_, err := models.PruneRevisions(ctx, tx, keep, cutoff, db.FilterIn("site_id", homes), db.FilterEq("field", models.RevisionHome))
+		if err != nil {
+			return fmt.Errorf("error pruning revisions: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if posts > 0 || len(homes) > 0 {
+		slog.Info("published scheduled content", "posts", posts, "home_pages", len(homes))
+	}
+
+	return nil
+}
+
+// The soonest anything is due, nil when nothing is scheduled.
+func (s *ScheduleService) next(ctx context.Context) (*time.Time, error) {
+	post, err := models.NextScheduledPost(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	home, err := models.NextScheduledHome(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	if post == nil || (home != nil && home.Before(*post)) {
+		return home, nil
+	}
+
+	return post, nil
+}
+
+// A time typed into a datetime-local input, read in the configured zone. It
+// has to be in the future, and is returned in UTC like every time in the db.
+func parseScheduleTime(cfg *config.Config, value string, now time.Time) (time.Time, error) {
+	at, err := time.ParseInLocation(scheduleLayout, value, cfg.Core.Location())
+	if err != nil || !at.After(now) {
+		return time.Time{}, fmt.Errorf("%w: %w", ErrValidationFailed, i18n.NewError("validation.publish_at"))
+	}
+
+	return at.UTC(), nil
+}
@@ -0,0 +1,177 @@
+package services_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/config"
+	"uwece.ca/app/models"
+	"uwece.ca/app/services"
+)
+
+type scheduleEnv struct {
+	testSite
+	posts    *services.PostService
+	blogs    *services.BlogService
+	schedule *services.ScheduleService
+}
+
+// goose.28 with "Live." as its published home page.
+func newScheduleEnv(t *testing.T) scheduleEnv {
+	t.Helper()
+
+	ts := newTestSite(t, config.Core{})
+	env := scheduleEnv{
+		testSite: ts,
+		posts:    services.NewPostService(ts.db, ts.cfg),
+		blogs:    services.NewBlogService(ts.db, ts.cfg, nil),
+		schedule: services.NewScheduleService(ts.db, ts.cfg),
+	}
+
+	home := services.BlogHomeRequest{Content: "Live.", Status: models.PostPublished}
+	require.NoError(t, env.blogs.SetHome(context.Background(), ts.site.Id, ts.user.Id, home))
+	env.site = env.reload(t)
+
+	return env
+}
+
+// A datetime-local value d from now, and the time it means.
+func localInput(t *testing.T, d time.Duration) (string, time.Time) {
+	t.Helper()
+
+	toronto, err := time.LoadLocation("America/Toronto")
+	require.NoError(t, err)
+
+	at := time.Now().Add(d).In(toronto).Truncate(time.Minute)
+	return at.Format("2006-01-02T15:04"), at
+}
+
+func TestPostStatuses(t *testing.T) {
+	t.Parallel()
+	env := newScheduleEnv(t)
+	ctx := context.Background()
+
+	draft, err := env.posts.Create(ctx, env.site.Id, services.PostNewRequest{Title: "Draft", Status: models.PostDraft})
+	require.NoError(t, err)
+	require.Nil(t, draft.PublishedAt)
+
+	input, at := localInput(t, 2*time.Hour)
+	scheduled, err := env.posts.Create(ctx, env.site.Id, services.PostNewRequest{Title: "Later", Status: models.PostScheduled, PublishAt: input})
+	require.NoError(t, err)
+	require.Equal(t, models.PostScheduled, scheduled.Status)
+	require.True(t, at.Equal(*scheduled.PublishedAt))
+
+	// Empty, as by email, publishes right away.
+	live, err := env.posts.Create(ctx, env.site.Id, services.PostNewRequest{Title: "Now"})
+	require.NoError(t, err)
+	require.Equal(t, models.PostPublished, live.Status)
+
+	public, err := env.posts.List(ctx, env.site.Id)
+	require.NoError(t, err)
+	require.Len(t, public, 1)
+	require.Equal(t, live.Id, public[0].Id)
+
+	_, err = env.posts.Get(ctx, env.site.Id, draft.Slug)
+	require.ErrorIs(t, err, services.ErrPostDoesNotExist)
+	_, err = env.posts.GetAny(ctx, env.site.Id, draft.Slug)
+	require.NoError(t, err)
+
+	all, err := env.posts.ListAll(ctx, env.site.Id)
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+
+	// Only times still to come can be scheduled.
+	past, _ := localInput(t, -time.Hour)
+	_, err = env.posts.Update(ctx, env.site.Id, draft.Id, services.PostNewRequest{Title: "Draft", Status: models.PostScheduled, PublishAt: past})
+	require.ErrorIs(t, err, services.ErrValidationFailed)
+	_, err = env.posts.Update(ctx, env.site.Id, draft.Id, services.PostNewRequest{Title: "Draft", Status: "live"})
+	require.ErrorIs(t, err, services.ErrValidationFailed)
+
+	// Edits to a live post keep the time it went up.
+	edited, err := env.posts.Update(ctx, env.site.Id, live.Id, services.PostNewRequest{Title: "Now, edited", Status: models.PostPublished})
+	require.NoError(t, err)
+	require.Equal(t, "Now, edited", edited.Title)
+	require.Equal(t, live.Slug, edited.Slug)
+	require.True(t, live.PublishedAt.Equal(*edited.PublishedAt))
+
+	unpublished, err := env.posts.Update(ctx, env.site.Id, live.Id, services.PostNewRequest{Title: "Now", Status: models.PostDraft})
+	require.NoError(t, err)
+	require.Equal(t, models.PostDraft, unpublished.Status)
+	require.Nil(t, unpublished.PublishedAt)
+}
+
+func TestScheduledContentPublishes(t *testing.T) {
+	t.Parallel()
+	env := newScheduleEnv(t)
+	ctx := context.Background()
+
+	input, at := localInput(t, time.Hour)
+	post, err := env.posts.Create(ctx, env.site.Id, services.PostNewRequest{Title: "Later", Status: models.PostScheduled, PublishAt: input})
+	require.NoError(t, err)
+	require.NoError(t, env.blogs.SetHome(ctx, env.site.Id, env.site.UserId, services.BlogHomeRequest{Content: "New.", Status: models.PostScheduled, PublishAt: input}))
+
+	site := env.reload(t)
+	require.Equal(t, "Live.", site.HomeContent)
+	require.Equal(t, "New.", *site.HomeDraft)
+
+	// Not yet.
+	require.NoError(t, env.schedule.PublishDue(ctx, time.Now().UTC()))
+	_, err = env.posts.Get(ctx, env.site.Id, post.Slug)
+	require.ErrorIs(t, err, services.ErrPostDoesNotExist)
+	require.Equal(t, "Live.", env.reload(t).HomeContent)
+
+	require.NoError(t, env.schedule.PublishDue(ctx, at.Add(time.Second).UTC()))
+	published, err := env.posts.Get(ctx, env.site.Id, post.Slug)
+	require.NoError(t, err)
+	require.True(t, at.Equal(*published.PublishedAt))
+
+	site = env.reload(t)
+	require.Equal(t, "New.", site.HomeContent)
+	require.Nil(t, site.HomeDraft)
+	require.Nil(t, site.HomePublishAt)
+}
+
+func TestHomeDrafts(t *testing.T) {
+	t.Parallel()
+	env := newScheduleEnv(t)
+	ctx := context.Background()
+
+	require.NoError(t, env.blogs.SetHome(ctx, env.site.Id, env.site.UserId, services.BlogHomeRequest{Content: "Work in progress.", Status: models.PostDraft}))
+	site := env.reload(t)
+	require.Equal(t, "Live.", site.HomeContent)
+	require.Equal(t, "Work in progress.", *site.HomeDraft)
+	require.Nil(t, site.HomePublishAt)
+
+	require.NoError(t, env.blogs.DiscardHomeDraft(ctx, env.site.Id))
+	require.Nil(t, env.reload(t).HomeDraft)
+
+	require.NoError(t, env.blogs.SetHome(ctx, env.site.Id, env.site.UserId, services.BlogHomeRequest{Content: "Done.", Status: models.PostPublished}))
+	site = env.reload(t)
+	require.Equal(t, "Done.", site.HomeContent)
+	require.Nil(t, site.HomeDraft)
+}
+
+func TestPreviewLinks(t *testing.T) {
+	t.Parallel()
+	env := newScheduleEnv(t)
+
+	link := env.blogs.PreviewLink(env.site)
+	token, ok := strings.CutPrefix(link, "https://goose.28.uwece.ca/preview/")
+	require.True(t, ok)
+
+	expires, ok := env.blogs.CheckPreview(env.site, token)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute)
+
+	// Only for the site it was made for.
+	other := env.site
+	other.Id++
+	_, ok = env.blogs.CheckPreview(other, token)
+	require.False(t, ok)
+
+	_, ok = env.blogs.CheckPreview(env.site, token+"x")
+	require.False(t, ok)
+}
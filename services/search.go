@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+	"unicode"
+
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/models"
+)
+
+const searchPageSize = 20
+
+// How many matching blogs a platform search shows above its posts.
+const searchSiteCount = 5
+
+// Words past this are left out of the query.
+const maxSearchTerms = 10
+
+type SearchService struct {
+	db     *db.DB
+	config *config.Config
+}
+
+func NewSearchService(db *db.DB, config *config.Config) *SearchService {
+	return &SearchService{db: db, config: config}
+}
+
+type SearchRequest struct {
+	Query string
+	Page  int
+}
+
+// Title and Snippet are escaped, with matches in <mark>.
+type PostResult struct {
+	Title     template.HTML
+	Snippet   template.HTML
+	URL       string
+	Site      string
+	Published time.Time
+}
+
+type SiteResult struct {
+	Name    string
+	Year    int
+	URL     string
+	Snippet template.HTML
+}
+
+type SearchResults struct {
+	// Only on the first page of a platform search.
+	Sites []SiteResult
+	Posts []PostResult
+	// Matching posts, over every page.
+	Total int
+	Page  int
+	Pages int
+}
+
+// Posts on one site, best match first.
+func (s *SearchService) Blog(ctx context.Context, site models.Site, req SearchRequest) (SearchResults, error) {
+	return s.search(ctx, req, false, db.FilterEq("posts.site_id", site.Id))
+}
+
+// Posts and blogs across every site in the directory, best match first.
+func (s *SearchService) Platform(ctx context.Context, req SearchRequest) (SearchResults, error) {
+	return s.search(ctx, req, true, publicSitesIn("sites", db.FilterIs("sites.unlisted_at", nil))...)
+}
+
+func (s *SearchService) search(ctx context.Context, req SearchRequest, withSites bool, filters ...db.Filter) (SearchResults, error) {
+	results := SearchResults{Page: max(req.Page, 1), Pages: 1}
+
+	match := matchQuery(req.Query)
+	if match == "" {
+		return results, nil
+	}
+
+	postFilters := append([]db.Filter{
+		db.FilterMatch("posts_fts", match),
+		db.FilterEq("posts.status", models.PostPublished),
+	}, filters...)
+
+	total, err := models.CountPostMatches(ctx, s.db, postFilters...)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("error counting post matches: %w", err)
+	}
+	results.Total = total
+	results.Pages = max((results.Total+searchPageSize-1)/searchPageSize, 1)
+
+	posts, err := models.SearchPosts(ctx, s.db, db.PageNumber(results.Page, searchPageSize), postFilters...)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("error searching posts: %w", err)
+	}
+
+	for _, p := range posts {
+		published := p.CreatedAt
+		if p.PublishedAt != nil {
+			published = *p.PublishedAt
+		}
+
+		results.Posts = append(results.Posts, PostResult{
+			Title:     markMatches(p.TitleHighlight),
+			Snippet:   markMatches(p.Snippet),
+			URL:       s.config.Core.SiteURL(p.Subdomain) + "/posts/" + p.Slug,
+			Site:      p.Subdomain + "." + s.config.Core.BaseDomain,
+			Published: published,
+		})
+	}
+
+	if !withSites || results.Page != 1 {
+		return results, nil
+	}
+
+	siteFilters := append([]db.Filter{db.FilterMatch("sites_fts", match)}, filters...)
+	sites, err := models.SearchSites(ctx, s.db, db.PageNumber(1, searchSiteCount), siteFilters...)
+	if err != nil {
+		return SearchResults{}, fmt.Errorf("error searching sites: %w", err)
+	}
+
+	for _, site := range sites {
+		name, year := splitSubdomain(site.Subdomain)
+		results.Sites = append(results.Sites, SiteResult{
+			Name:    name,
+			Year:    year,
+			URL:     s.config.Core.SiteURL(site.Subdomain),
+			Snippet: markMatches(site.Snippet),
+		})
+	}
+
+	return results, nil
+}
+
+// Turns what a reader typed into an FTS5 query matching text with every word,
+// the last one as a prefix so results show up while it's still being typed.
+// Words are only letters and digits, quoted, so nothing typed is read as
+// query syntax.
+func matchQuery(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) > maxSearchTerms {
+		words = words[:maxSearchTerms]
+	}
+
+	terms := make([]string, len(words))
+	for i, w := range words {
+		terms[i] = `"` + w + `"`
+	}
+	if len(terms) > 0 {
+		terms[len(terms)-1] += "*"
+	}
+
+	return strings.Join(terms, " ")
+}
+
+// Escape text from the index and wrap its matches in <mark>.
+func markMatches(s string) template.HTML {
+	s = template.HTMLEscapeString(s)
+	s = strings.ReplaceAll(s, models.MatchStart, "<mark>")
+	s = strings.ReplaceAll(s, models.MatchEnd, "</mark>")
+
+	return template.HTML(s) //nolint:gosec // escaped above
+}
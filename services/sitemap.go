@@ -0,0 +1,40 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"uwece.ca/app/db"
+	"uwece.ca/app/models"
+	"uwece.ca/app/sitemap"
+)
+
+// Every public site's sitemap, for the index on the main site.
+func (s *BlogService) SitemapIndex(ctx context.Context) ([]sitemap.URL, error) {
+	sites, err := models.GetSites(ctx, s.db, publicSites()...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching sites for sitemap index: %w", err)
+	}
+
+	urls := make([]sitemap.URL, len(sites))
+	for i, site := range sites {
+		urls[i] = sitemap.URL{Loc: s.config.Core.SiteURL(site.Subdomain) + "/sitemap.xml"}
+	}
+
+	return urls, nil
+}
+
+// The pages of a site: its home page and every post.
+func (s *PostService) Sitemap(ctx context.Context, site models.Site) ([]sitemap.URL, error) {
+	posts, err := models.GetPosts(ctx, s.db, publishedPosts(db.FilterEq("site_id", site.Id))...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching posts for sitemap: %w", err)
+	}
+
+	urls := []sitemap.URL{{Loc: s.config.Core.SiteURL(site.Subdomain) + "/", LastMod: site.UpdatedAt}}
+	for _, p := range posts {
+		urls = append(urls, sitemap.URL{Loc: s.URL(site, p), LastMod: p.UpdatedAt})
+	}
+
+	return urls, nil
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
 	"uwece.ca/app/config"
 	"uwece.ca/app/db"
+	"uwece.ca/app/i18n"
 	"uwece.ca/app/mailer"
 	"uwece.ca/app/models"
 	"uwece.ca/app/utils"
@@ -25,26 +27,62 @@ var (
 	ErrTokenExpired        = errors.New("verification token expired")
 	ErrSessionExpired      = errors.New("user session expired")
 	ErrSessionDoesNotExist = errors.New("user session does not exist")
+	ErrNetIDMismatch       = errors.New("netid does not match user")
+
+	errCommonPassword = i18n.NewError("validation.password_common")
 )
 
+const minPasswordLength = 12
+
 type UserService struct {
-	db     *db.DB
-	mailer mailer.Mailer
-	config *config.Config
+	db        *db.DB
+	mailer    mailer.Mailer
+	config    *config.Config
+	passwords *utils.PasswordHasher
+	denylist  *utils.DenyList
 }
 
-func NewUserService(db *db.DB, mailer mailer.Mailer, config *config.Config) *UserService {
-	return &UserService{
-		db:     db,
-		mailer: mailer,
-		config: config,
+func NewUserService(db *db.DB, mailer mailer.Mailer, config *config.Config) (*UserService, error) {
+	denylist, err := utils.LoadDenyList(config.Password.DenyListFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading password deny list: %w", err)
 	}
+
+	params := utils.PasswordParams{
+		MemoryKiB:   config.Password.MemoryKiB,
+		Iterations:  config.Password.Iterations,
+		Parallelism: config.Password.Parallelism,
+	}
+
+	return &UserService{
+		db:        db,
+		mailer:    mailer,
+		config:    config,
+		passwords: utils.NewPasswordHasher(params, config.Password.Pepper),
+		denylist:  denylist,
+	}, nil
+}
+
+// Accounts made through an identity provider have no password until their
+// owner sets one, and can't be logged into with one.
+func HasPassword(usr models.User) bool {
+	return usr.Password != ""
 }
 
 func (s UserService) GetEmail(netID string) string {
 	return fmt.Sprintf("%s@%s", netID, s.config.Core.EmailDomain)
 }
 
+// Send msg to usr, unless mail to them has been bouncing.
+func (s *UserService) mail(usr models.User, msg mailer.Message) error {
+	if usr.UndeliverableAt != nil {
+		slog.Info("not mailing undeliverable user", "user_id", usr.Id, "template", msg.Template())
+		return nil
+	}
+
+	return s.mailer.Send(s.GetEmail(usr.NetID), usr.Name, msg)
+}
+
 type UserSignupRequest struct {
 	NetID           string
 	Password        string
@@ -52,9 +90,9 @@ type UserSignupRequest struct {
 	Name            string
 }
 
-func (s UserSignupRequest) Validate() error {
-	if len(s.NetID) == 0 || len(s.NetID) > 35 {
-		return errors.New("Please provide a valid netID (1 - 35 characters in length).")
+func validateNetID(netID string) error {
+	if len(netID) == 0 || len(netID) > 35 {
+		return i18n.NewError("validation.netid_length", "min", 1, "max", 35)
 	}
 
 	filteredNetID := strings.Map(func(r rune) rune {
@@ -63,22 +101,42 @@ func (s UserSignupRequest) Validate() error {
 		}
 
 		return r
-	}, s.NetID)
+	}, netID)
 
-	if filteredNetID != s.NetID {
-		return errors.New("Please provide a valid netID (1-9,a-z).")
+	if filteredNetID != netID {
+		return i18n.NewError("validation.netid_chars")
 	}
 
-	if s.Name == "" {
-		return errors.New("Please provide a name :).")
+	return nil
+}
+
+func (s UserSignupRequest) Validate() error {
+	if err := validateNetID(s.NetID); err != nil {
+		return err
 	}
 
-	if len(s.Password) < 12 {
-		return errors.New("Please provide a password of length 12 or greater.")
+	if err := validateName(s.Name); err != nil {
+		return err
+	}
+
+	return validatePassword(s.Password, s.PasswordConfirm)
+}
+
+func validateName(name string) error {
+	if name == "" {
+		return i18n.NewError("validation.name_required")
+	}
+
+	return nil
+}
+
+func validatePassword(password, confirm string) error {
+	if len(password) < minPasswordLength {
+		return i18n.NewError("validation.password_length", "min", minPasswordLength)
 	}
 
-	if s.Password != s.PasswordConfirm {
-		return errors.New("Password and password confirmation must match.")
+	if password != confirm {
+		return i18n.NewError("validation.password_mismatch")
 	}
 
 	return nil
@@ -91,10 +149,14 @@ type UserSignupResponse struct {
 
 func (s *UserService) Signup(ctx context.Context, req UserSignupRequest) (UserSignupResponse, error) {
 	if err := req.Validate(); err != nil {
-		return UserSignupResponse{}, fmt.Errorf("%w: %v", ErrValidationFailed, err)
+		return UserSignupResponse{}, fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	if s.denylist.Contains(req.Password) {
+		return UserSignupResponse{}, fmt.Errorf("%w: %w", ErrValidationFailed, errCommonPassword)
 	}
 
-	hashedPassword := utils.HashPassword(req.Password)
+	hashedPassword := s.passwords.Hash(req.Password)
 
 	usr, err := models.InsertUser(ctx, s.db, models.NewUser{
 		NetID:    req.NetID,
@@ -109,23 +171,36 @@ func (s *UserService) Signup(ctx context.Context, req UserSignupRequest) (UserSi
 		return UserSignupResponse{}, fmt.Errorf("failed to create user: %s", err)
 	}
 
-	e, err := models.InsertEmail(ctx, s.db, models.NewEmail{
+	if err := s.sendVerification(ctx, s.db, usr); err != nil {
+		return UserSignupResponse{}, err
+	}
+
+	return UserSignupResponse{
+		Email: s.GetEmail(usr.NetID),
+		Name:  usr.Name,
+	}, nil
+}
+
+// Mail usr a new link to verify their account with.
+func (s *UserService) sendVerification(ctx context.Context, d db.Ex, usr models.User) error {
+	e, err := models.InsertEmail(ctx, d, models.NewEmail{
 		Token:   utils.NewToken(),
 		UserId:  usr.Id,
 		Expires: time.Now().Add(48 * time.Hour),
 	})
 	if err != nil {
-		return UserSignupResponse{}, fmt.Errorf("error creating verification email in database: %w", err)
+		return fmt.Errorf("error creating verification email in database: %w", err)
 	}
 
-	if err := s.mailer.SendVerificationEmail(s.GetEmail(usr.NetID), usr.Name, e.Token); err != nil {
-		return UserSignupResponse{}, fmt.Errorf("error sending verification email: %w", err)
+	msg := mailer.VerificationMessage{
+		Name: usr.Name,
+		Link: fmt.Sprintf("%s/signup/verify/%s", s.config.Core.BaseURL(), e.Token),
+	}
+	if err := s.mail(usr, msg); err != nil {
+		return fmt.Errorf("error sending verification email: %w", err)
 	}
 
-	return UserSignupResponse{
-		Email: s.GetEmail(usr.NetID),
-		Name:  usr.Name,
-	}, nil
+	return nil
 }
 
 type UserLoginRequest struct {
@@ -135,11 +210,11 @@ type UserLoginRequest struct {
 
 func (r UserLoginRequest) Validate() error {
 	if r.NetID == "" {
-		return errors.New("Please provide a non-zero NetID.")
+		return i18n.NewError("validation.netid_required")
 	}
 
 	if r.Password == "" {
-		return errors.New("Please provide a valid password.")
+		return i18n.NewError("validation.password_required")
 	}
 
 	return nil
@@ -151,7 +226,7 @@ type UserLoginResponse struct {
 
 func (s *UserService) Login(ctx context.Context, req UserLoginRequest) (UserLoginResponse, error) {
 	if err := req.Validate(); err != nil {
-		return UserLoginResponse{}, fmt.Errorf("%w, %v", ErrValidationFailed, err)
+		return UserLoginResponse{}, fmt.Errorf("%w: %w", ErrValidationFailed, err)
 	}
 
 	usr, err := models.GetUser(ctx, s.db, db.FilterEq("net_id", req.NetID))
@@ -167,7 +242,11 @@ func (s *UserService) Login(ctx context.Context, req UserLoginRequest) (UserLogi
 		return UserLoginResponse{}, ErrUserNotVerified
 	}
 
-	ok, err := utils.VerifyPassword(req.Password, usr.Password)
+	if !HasPassword(usr) {
+		return UserLoginResponse{}, ErrUserWrongPassword
+	}
+
+	ok, rehash, err := s.passwords.Verify(req.Password, usr.Password)
 	if err != nil {
 		return UserLoginResponse{}, fmt.Errorf("error verifiying password: %w", err)
 	}
@@ -176,11 +255,22 @@ func (s *UserService) Login(ctx context.Context, req UserLoginRequest) (UserLogi
 		return UserLoginResponse{}, ErrUserWrongPassword
 	}
 
+	if rehash {
+		updates := db.Updates(db.Update("password", s.passwords.Hash(req.Password)))
+		if err := models.UpdateUser(ctx, s.db, updates, db.FilterEq("id", usr.Id)); err != nil {
+			slog.Warn("failed to rehash outdated password", "user_id", usr.Id, "error", err)
+		}
+	}
+
+	return s.newSession(ctx, usr.Id)
+}
+
+func (s *UserService) newSession(ctx context.Context, usrID int) (UserLoginResponse, error) {
 	session := web.NewSession()
-	_, err = models.InsertSession(ctx, s.db, models.NewSession{
+	_, err := models.InsertSession(ctx, s.db, models.NewSession{
 		Token:   session.Token,
 		Expires: session.Expiry,
-		UserId:  usr.Id,
+		UserId:  usrID,
 	})
 	if err != nil {
 		return UserLoginResponse{}, fmt.Errorf("error inserting session token: %w", err)
@@ -189,6 +279,180 @@ func (s *UserService) Login(ctx context.Context, req UserLoginRequest) (UserLogi
 	return UserLoginResponse{Session: session}, nil
 }
 
+// An account at an external identity provider, already authenticated by the caller.
+type ExternalIdentity struct {
+	Issuer  string
+	Subject string
+	NetID   string
+	Name    string
+}
+
+// Log in through an external identity provider, linking the identity to the
+// user with a matching NetID and creating that user if needed. Unless the
+// provider's users are trusted to be verified, an unverified user is mailed a
+// link to verify with first.
+func (s *UserService) LoginExternal(ctx context.Context, id ExternalIdentity) (UserLoginResponse, error) {
+	usr, err := s.resolveIdentity(ctx, id)
+	if err != nil {
+		return UserLoginResponse{}, err
+	}
+
+	if usr.VerifiedAt == nil {
+		if !s.config.OIDC.AutoVerifyUsers {
+			return UserLoginResponse{}, ErrUserNotVerified
+		}
+
+		updates := db.Updates(
+			db.Update("updated_at", time.Now()),
+			db.Update("verified_at", time.Now()),
+		)
+		if err := models.UpdateUser(ctx, s.db, updates, db.FilterEq("id", usr.Id)); err != nil {
+			return UserLoginResponse{}, fmt.Errorf("error setting user as verified: %w", err)
+		}
+	}
+
+	if s.config.OIDC.AutoVerifySites {
+		updates := db.Updates(
+			db.Update("updated_at", time.Now()),
+			db.Update("verified_at", time.Now()),
+		)
+		err := models.UpdateSites(ctx, s.db, updates, db.FilterEq("user_id", usr.Id), db.FilterIs("verified_at", nil))
+		if err != nil {
+			return UserLoginResponse{}, fmt.Errorf("error setting site as verified: %w", err)
+		}
+	}
+
+	return s.newSession(ctx, usr.Id)
+}
+
+func (s *UserService) resolveIdentity(ctx context.Context, id ExternalIdentity) (models.User, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	usr, err := s.identityUser(ctx, tx, id)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// The verification link goes out with the changes above, or neither does,
+	// so the NetID is never left with an account nobody can get into.
+	if usr.VerifiedAt == nil && !s.config.OIDC.AutoVerifyUsers {
+		pending, err := s.hasPendingVerification(ctx, tx, usr.Id)
+		if err != nil {
+			return models.User{}, err
+		}
+
+		if !pending {
+			if err := s.sendVerification(ctx, tx, usr); err != nil {
+				return models.User{}, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.User{}, err
+	}
+
+	return usr, nil
+}
+
+// The user an identity is linked to, linking it first if it's new.
+func (s *UserService) identityUser(ctx context.Context, tx db.Ex, id ExternalIdentity) (models.User, error) {
+	identity, err := models.GetIdentity(ctx, tx, db.FilterEq("issuer", id.Issuer), db.FilterEq("subject", id.Subject))
+	if err == nil {
+		usr, err := models.GetUser(ctx, tx, db.FilterEq("id", identity.UserId))
+		if err != nil {
+			return models.User{}, fmt.Errorf("error fetching user for identity: %w", err)
+		}
+
+		return usr, nil
+	}
+	if !errors.Is(err, db.ErrNoRows) {
+		return models.User{}, fmt.Errorf("error fetching identity from database: %w", err)
+	}
+
+	if err := validateNetID(id.NetID); err != nil {
+		return models.User{}, fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	name := id.Name
+	if name == "" {
+		name = id.NetID
+	}
+
+	usr, err := models.GetUser(ctx, tx, db.FilterEq("net_id", id.NetID))
+	switch {
+	case errors.Is(err, db.ErrNoRows):
+		// No password, so the account can only be reached through the provider until its
+		// owner sets one.
+		usr, err = models.InsertUser(ctx, tx, models.NewUser{
+			NetID: id.NetID,
+			Name:  name,
+		})
+	case err == nil && usr.VerifiedAt == nil:
+		err = s.reclaimUnverified(ctx, tx, usr.Id, name)
+		usr.Name, usr.Password = name, ""
+	}
+	if err != nil {
+		return models.User{}, fmt.Errorf("error fetching or creating user for identity: %w", err)
+	}
+
+	_, err = models.InsertIdentity(ctx, tx, models.NewIdentity{
+		UserId:  usr.Id,
+		Issuer:  id.Issuer,
+		Subject: id.Subject,
+	})
+	if err != nil {
+		return models.User{}, fmt.Errorf("error linking identity to user: %w", err)
+	}
+
+	return usr, nil
+}
+
+// Anyone can sign up with any NetID, but only the provider proves whose it is.
+// An unverified account being linked may have been made by someone else, so
+// the name and password they chose and their sessions and verification links
+// go before the NetID's owner gets it.
+func (s *UserService) reclaimUnverified(ctx context.Context, tx db.Ex, usrID int, name string) error {
+	updates := db.Updates(
+		db.Update("updated_at", time.Now()),
+		db.Update("name", name),
+		db.Update("password", ""),
+	)
+	if err := models.UpdateUser(ctx, tx, updates, db.FilterEq("id", usrID)); err != nil {
+		return fmt.Errorf("error resetting user: %w", err)
+	}
+
+	if err := models.DeleteSessions(ctx, tx, db.FilterEq("user_id", usrID)); err != nil {
+		return fmt.Errorf("error deleting sessions: %w", err)
+	}
+
+	if err := models.DeleteEmails(ctx, tx, db.FilterEq("user_id", usrID)); err != nil {
+		return fmt.Errorf("error deleting verification emails: %w", err)
+	}
+
+	return nil
+}
+
+// Whether the user has a verification link that still works.
+func (s *UserService) hasPendingVerification(ctx context.Context, d db.Ex, usrID int) (bool, error) {
+	emails, err := models.GetEmails(ctx, d, db.FilterEq("user_id", usrID))
+	if err != nil {
+		return false, fmt.Errorf("error fetching verification emails: %w", err)
+	}
+
+	for _, e := range emails {
+		if time.Now().Before(e.Expires) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 func (s *UserService) Verify(ctx context.Context, token utils.Token) error {
 	e, err := models.GetEmail(ctx, s.db, db.FilterEq("token", token))
 	if err != nil {
@@ -202,6 +466,16 @@ func (s *UserService) Verify(ctx context.Context, token utils.Token) error {
 		return ErrTokenExpired
 	}
 
+	usr, err := models.GetUser(ctx, s.db, db.FilterEq("id", e.UserId))
+	if err != nil {
+		return fmt.Errorf("error fetching user to verify: %w", err)
+	}
+
+	// Following the link twice is harmless.
+	if usr.VerifiedAt != nil {
+		return nil
+	}
+
 	updates := db.Updates(
 		db.Update("updated_at", time.Now()),
 		db.Update("verified_at", time.Now()),
@@ -211,6 +485,14 @@ func (s *UserService) Verify(ctx context.Context, token utils.Token) error {
 		return fmt.Errorf("error setting user as verified: %w", err)
 	}
 
+	msg := mailer.WelcomeMessage{
+		Name:        usr.Name,
+		NewBlogLink: s.config.Core.BaseURL() + "/new-blog",
+	}
+	if err := s.mail(usr, msg); err != nil {
+		slog.Warn("error sending welcome email", "user_id", usr.Id, "error", err)
+	}
+
 	return nil
 }
 
@@ -238,3 +520,146 @@ func (s *UserService) LoadSession(ctx context.Context, token utils.Token) (model
 
 	return usr, nil
 }
+
+func (s *UserService) Logout(ctx context.Context, token utils.Token) error {
+	if err := models.DeleteSessions(ctx, s.db, db.FilterEq("token", token)); err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+
+	return nil
+}
+
+type UserUpdateNameRequest struct {
+	Name string
+}
+
+func (r UserUpdateNameRequest) Validate() error {
+	return validateName(r.Name)
+}
+
+func (s *UserService) UpdateName(ctx context.Context, usrID int, req UserUpdateNameRequest) error {
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	updates := db.Updates(
+		db.Update("updated_at", time.Now()),
+		db.Update("name", req.Name),
+	)
+	if err := models.UpdateUser(ctx, s.db, updates, db.FilterEq("id", usrID)); err != nil {
+		return fmt.Errorf("error updating user name: %w", err)
+	}
+
+	return nil
+}
+
+type UserLocaleRequest struct {
+	// Empty to follow the browser.
+	Locale string
+}
+
+func (r UserLocaleRequest) Validate() error {
+	if r.Locale != "" && !i18n.Supports(r.Locale) {
+		return i18n.NewError("validation.locale_unknown")
+	}
+
+	return nil
+}
+
+func (s *UserService) SetLocale(ctx context.Context, usrID int, req UserLocaleRequest) error {
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	updates := db.Updates(
+		db.Update("updated_at", time.Now()),
+		db.Update("locale", req.Locale),
+	)
+	if err := models.UpdateUser(ctx, s.db, updates, db.FilterEq("id", usrID)); err != nil {
+		return fmt.Errorf("error updating user locale: %w", err)
+	}
+
+	return nil
+}
+
+type UserChangePasswordRequest struct {
+	CurrentPassword string
+	Password        string
+	PasswordConfirm string
+}
+
+func (r UserChangePasswordRequest) Validate() error {
+	return validatePassword(r.Password, r.PasswordConfirm)
+}
+
+// Change a user's password, signing out every session except current. Users
+// without one yet, who came through an identity provider, set their first
+// without a current password.
+func (s *UserService) ChangePassword(ctx context.Context, usrID int, current utils.Token, req UserChangePasswordRequest) error {
+	if err := req.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, err)
+	}
+
+	if s.denylist.Contains(req.Password) {
+		return fmt.Errorf("%w: %w", ErrValidationFailed, errCommonPassword)
+	}
+
+	usr, err := models.GetUser(ctx, s.db, db.FilterEq("id", usrID))
+	if err != nil {
+		if errors.Is(err, db.ErrNoRows) {
+			return ErrUserDoesNotExist
+		}
+
+		return fmt.Errorf("error fetching user from database: %w", err)
+	}
+
+	if HasPassword(usr) {
+		if req.CurrentPassword == "" {
+			return fmt.Errorf("%w: %w", ErrValidationFailed, i18n.NewError("validation.current_password_required"))
+		}
+
+		ok, _, err := s.passwords.Verify(req.CurrentPassword, usr.Password)
+		if err != nil {
+			return fmt.Errorf("error verifiying password: %w", err)
+		}
+
+		if !ok {
+			return ErrUserWrongPassword
+		}
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	updates := db.Updates(
+		db.Update("updated_at", time.Now()),
+		db.Update("password", s.passwords.Hash(req.Password)),
+	)
+	if err := models.UpdateUser(ctx, tx, updates, db.FilterEq("id", usrID)); err != nil {
+		return fmt.Errorf("error updating password: %w", err)
+	}
+
+	err = models.DeleteSessions(ctx, tx, db.FilterEq("user_id", usrID), db.FilterNotEq("token", current))
+	if err != nil {
+		return fmt.Errorf("error revoking other sessions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// The change already happened, a missing notification isn't worth failing over.
+	msg := mailer.PasswordChangedMessage{
+		Name:      usr.Name,
+		ChangedAt: time.Now(),
+		LoginLink: s.config.Core.BaseURL() + "/login",
+	}
+	if err := s.mail(usr, msg); err != nil {
+		slog.Warn("error sending password changed email", "user_id", usrID, "error", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,335 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/config"
+	"uwece.ca/app/db"
+	"uwece.ca/app/db/dbtest"
+	"uwece.ca/app/mailer"
+	"uwece.ca/app/models"
+	"uwece.ca/app/services"
+)
+
+const testPassword = "correct horse battery"
+
+type userEnv struct {
+	db    *db.DB
+	cfg   *config.Config
+	mail  *mailer.MemoryTransport
+	users *services.UserService
+}
+
+func newUserEnv(t *testing.T) userEnv {
+	t.Helper()
+
+	d := dbtest.GetTestDB(t)
+	require.NoError(t, d.RunMigrations(models.Migrations))
+
+	cfg := &config.Config{
+		Core: config.Core{
+			BaseDomain:    "uwece.ca",
+			EmailDomain:   "connect.uwaterloo.ca",
+			SecretKey:     "test secret",
+			MediaDir:      t.TempDir(),
+			MediaQuota:    10 << 20,
+			MaxUploadSize: 1 << 20,
+		},
+		Mailer: config.Mailer{FromAddress: "noreply@uwece.ca"},
+		OIDC:   config.OIDC{AutoVerifyUsers: true},
+		// Cheap hashing, these tests aren't about its cost.
+		Password: config.Password{MemoryKiB: 64, Iterations: 1, Parallelism: 1},
+	}
+	tr := mailer.NewMemoryTransport()
+	users, err := services.NewUserService(d, mailer.NewWithTransport(cfg, tr), cfg)
+	require.NoError(t, err)
+
+	return userEnv{db: d, cfg: cfg, mail: tr, users: users}
+}
+
+func (e userEnv) user(t *testing.T, netID string) models.User {
+	t.Helper()
+
+	usr, err := models.GetUser(context.Background(), e.db, db.FilterEq("net_id", netID))
+	require.NoError(t, err)
+
+	return usr
+}
+
+func (e userEnv) sessions(t *testing.T, usrID int) []models.Session {
+	t.Helper()
+
+	sessions, err := models.GetSessions(context.Background(), e.db, db.FilterEq("user_id", usrID))
+	require.NoError(t, err)
+
+	return sessions
+}
+
+// Sign goose up with a password and verify them, as the signup form would.
+func (e userEnv) signup(t *testing.T) models.User {
+	t.Helper()
+	ctx := context.Background()
+
+	_, err := e.users.Signup(ctx, services.UserSignupRequest{
+		NetID:           "goose",
+		Name:            "Goose",
+		Password:        testPassword,
+		PasswordConfirm: testPassword,
+	})
+	require.NoError(t, err)
+
+	usr := e.user(t, "goose")
+	emails, err := models.GetEmails(ctx, e.db, db.FilterEq("user_id", usr.Id))
+	require.NoError(t, err)
+	require.NoError(t, e.users.Verify(ctx, emails[0].Token))
+
+	return e.user(t, "goose")
+}
+
+func TestLoginExternal(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+
+	id := services.ExternalIdentity{Issuer: "https://idp.uwaterloo.ca", Subject: "1234", NetID: "goose", Name: "Goose"}
+
+	// A NetID nobody has used gets a new, verified account.
+	first, err := env.users.LoginExternal(ctx, id)
+	require.NoError(t, err)
+	usr := env.user(t, "goose")
+	require.NotNil(t, usr.VerifiedAt)
+	require.Equal(t, "Goose", usr.Name)
+
+	// The identity finds the same account again, whatever NetID it claims now.
+	id.NetID = "renamed"
+	second, err := env.users.LoginExternal(ctx, id)
+	require.NoError(t, err)
+	require.NotEqual(t, first.Session.Token, second.Session.Token)
+	require.Len(t, env.sessions(t, usr.Id), 2)
+
+	users, err := models.GetUsers(ctx, env.db)
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+}
+
+func TestLoginExternalReclaimsUnverifiedNetID(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+
+	// Someone else signs up with goose's NetID, and never verifies it.
+	_, err := env.users.Signup(ctx, services.UserSignupRequest{
+		NetID:           "goose",
+		Name:            "Not Goose",
+		Password:        testPassword,
+		PasswordConfirm: testPassword,
+	})
+	require.NoError(t, err)
+	squatter := env.user(t, "goose")
+	_, err = models.InsertSession(ctx, env.db, models.NewSession{Token: "squatter", Expires: squatter.CreatedAt.AddDate(1, 0, 0), UserId: squatter.Id})
+	require.NoError(t, err)
+
+	_, err = env.users.LoginExternal(ctx, services.ExternalIdentity{Issuer: "https://idp.uwaterloo.ca", Subject: "1234", NetID: "goose"})
+	require.NoError(t, err)
+
+	usr := env.user(t, "goose")
+	require.Equal(t, squatter.Id, usr.Id)
+	require.NotNil(t, usr.VerifiedAt)
+	require.Equal(t, "goose", usr.Name)
+
+	// Nothing the first signup set up still gets in.
+	_, err = env.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: testPassword})
+	require.ErrorIs(t, err, services.ErrUserWrongPassword)
+
+	sessions := env.sessions(t, usr.Id)
+	require.Len(t, sessions, 1)
+	require.NotEqual(t, "squatter", string(sessions[0].Token))
+
+	emails, err := models.GetEmails(ctx, env.db, db.FilterEq("user_id", usr.Id))
+	require.NoError(t, err)
+	require.Empty(t, emails)
+}
+
+func TestLoginExternalLinksVerifiedNetID(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+
+	usr := env.signup(t)
+
+	_, err := env.users.LoginExternal(ctx, services.ExternalIdentity{Issuer: "https://idp.uwaterloo.ca", Subject: "1234", NetID: "goose"})
+	require.NoError(t, err)
+
+	// The owner's own password and sessions are theirs to keep.
+	_, err = env.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: testPassword})
+	require.NoError(t, err)
+	require.Len(t, env.sessions(t, usr.Id), 2)
+}
+
+func TestLoginExternalWithoutAutoVerify(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	env.cfg.OIDC.AutoVerifyUsers = false
+	ctx := context.Background()
+
+	// Someone else signs up with goose's NetID first.
+	_, err := env.users.Signup(ctx, services.UserSignupRequest{
+		NetID:           "goose",
+		Name:            "Not Goose",
+		Password:        testPassword,
+		PasswordConfirm: testPassword,
+	})
+	require.NoError(t, err)
+	squatter := env.user(t, "goose")
+	env.mail.Reset()
+
+	id := services.ExternalIdentity{Issuer: "https://idp.uwaterloo.ca", Subject: "1234", NetID: "goose", Name: "Goose"}
+	_, err = env.users.LoginExternal(ctx, id)
+	require.ErrorIs(t, err, services.ErrUserNotVerified)
+
+	// The account is goose's now, and they get a link of their own to verify it.
+	usr := env.user(t, "goose")
+	require.Equal(t, squatter.Id, usr.Id)
+	require.Nil(t, usr.VerifiedAt)
+	require.Equal(t, "Goose", usr.Name)
+	require.False(t, services.HasPassword(usr))
+
+	sent := env.mail.Messages()
+	require.Len(t, sent, 1)
+	require.Equal(t, []string{"goose@connect.uwaterloo.ca"}, sent[0].Envelope.To)
+
+	emails, err := models.GetEmails(ctx, env.db, db.FilterEq("user_id", usr.Id))
+	require.NoError(t, err)
+	require.Len(t, emails, 1)
+
+	// Trying again before following it doesn't send another.
+	_, err = env.users.LoginExternal(ctx, id)
+	require.ErrorIs(t, err, services.ErrUserNotVerified)
+	require.Len(t, env.mail.Messages(), 1)
+
+	require.NoError(t, env.users.Verify(ctx, emails[0].Token))
+	_, err = env.users.LoginExternal(ctx, id)
+	require.NoError(t, err)
+}
+
+func TestNewUserServiceDenyListError(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{Password: config.Password{DenyListFile: "testdata/missing.txt"}}
+	_, err := services.NewUserService(nil, nil, cfg)
+	require.Error(t, err)
+}
+
+func TestChangePassword(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+
+	usr := env.signup(t)
+	current, err := env.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: testPassword})
+	require.NoError(t, err)
+	_, err = env.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: testPassword})
+	require.NoError(t, err)
+	env.mail.Reset()
+
+	const newPassword = "battery staple horse"
+	change := func(currentPassword, password string) error {
+		return env.users.ChangePassword(ctx, usr.Id, current.Session.Token, services.UserChangePasswordRequest{
+			CurrentPassword: currentPassword,
+			Password:        password,
+			PasswordConfirm: password,
+		})
+	}
+
+	require.ErrorIs(t, change("", newPassword), services.ErrValidationFailed)
+	require.ErrorIs(t, change("wrong password", newPassword), services.ErrUserWrongPassword)
+	require.ErrorIs(t, change(testPassword, "short"), services.ErrValidationFailed)
+	require.ErrorIs(t, change(testPassword, "password1234"), services.ErrValidationFailed)
+
+	// None of those changed anything.
+	require.Len(t, env.sessions(t, usr.Id), 2)
+	require.Empty(t, env.mail.Messages())
+
+	require.NoError(t, change(testPassword, newPassword))
+
+	// Only the session that made the change is left.
+	sessions := env.sessions(t, usr.Id)
+	require.Len(t, sessions, 1)
+	require.Equal(t, current.Session.Token, sessions[0].Token)
+
+	sent := env.mail.Messages()
+	require.Len(t, sent, 1)
+	require.Equal(t, []string{"goose@connect.uwaterloo.ca"}, sent[0].Envelope.To)
+	require.Contains(t, string(sent[0].Data), "Password Changed")
+
+	_, err = env.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: testPassword})
+	require.ErrorIs(t, err, services.ErrUserWrongPassword)
+	_, err = env.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: newPassword})
+	require.NoError(t, err)
+}
+
+func TestChangePasswordSetsFirstPassword(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+
+	res, err := env.users.LoginExternal(ctx, services.ExternalIdentity{Issuer: "https://idp.uwaterloo.ca", Subject: "1234", NetID: "goose"})
+	require.NoError(t, err)
+	usr := env.user(t, "goose")
+
+	// There's no current password to give.
+	err = env.users.ChangePassword(ctx, usr.Id, res.Session.Token, services.UserChangePasswordRequest{
+		Password:        testPassword,
+		PasswordConfirm: testPassword,
+	})
+	require.NoError(t, err)
+	require.True(t, services.HasPassword(env.user(t, "goose")))
+
+	_, err = env.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: testPassword})
+	require.NoError(t, err)
+
+	// From then on it's needed like anyone else's.
+	err = env.users.ChangePassword(ctx, usr.Id, res.Session.Token, services.UserChangePasswordRequest{
+		Password:        "battery staple horse",
+		PasswordConfirm: "battery staple horse",
+	})
+	require.ErrorIs(t, err, services.ErrValidationFailed)
+}
+
+func TestUpdateName(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+
+	usr := env.signup(t)
+
+	require.NoError(t, env.users.UpdateName(ctx, usr.Id, services.UserUpdateNameRequest{Name: "Canada Goose"}))
+	require.Equal(t, "Canada Goose", env.user(t, "goose").Name)
+
+	err := env.users.UpdateName(ctx, usr.Id, services.UserUpdateNameRequest{Name: ""})
+	require.ErrorIs(t, err, services.ErrValidationFailed)
+	require.Equal(t, "Canada Goose", env.user(t, "goose").Name)
+}
+
+func TestLogout(t *testing.T) {
+	t.Parallel()
+	env := newUserEnv(t)
+	ctx := context.Background()
+
+	usr := env.signup(t)
+	first, err := env.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: testPassword})
+	require.NoError(t, err)
+	second, err := env.users.Login(ctx, services.UserLoginRequest{NetID: "goose", Password: testPassword})
+	require.NoError(t, err)
+
+	require.NoError(t, env.users.Logout(ctx, first.Session.Token))
+
+	// Only that session ends.
+	_, err = env.users.LoadSession(ctx, first.Session.Token)
+	require.ErrorIs(t, err, services.ErrSessionDoesNotExist)
+	loaded, err := env.users.LoadSession(ctx, second.Session.Token)
+	require.NoError(t, err)
+	require.Equal(t, usr.Id, loaded.Id)
+}
@@ -0,0 +1,237 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"uwece.ca/app/i18n"
+	"uwece.ca/app/services"
+	"uwece.ca/app/web"
+)
+
+func (s *Site) AccountPage(w http.ResponseWriter, r *http.Request) error {
+	ctx := s.BaseContext(r)
+	ctx.Add("locales", i18n.Locales())
+	ctx.Add("has_password", services.HasPassword(*ExtractUser(r)))
+
+	site, err := s.blogs.LoadBlogFromUser(r.Context(), ExtractUser(r).Id)
+	switch {
+	case err == nil:
+		ctx.Add("site", site)
+		ctx.Add("post_address", s.postmail.Address(site))
+		ctx.Add("email", s.users.GetEmail(ExtractUser(r).NetID))
+	case !errors.Is(err, services.ErrBlogDoesNotExist):
+		return err
+	}
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/account", ctx)
+}
+
+func (s *Site) AccountNameHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.UserUpdateNameRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	usr := ExtractUser(r)
+
+	if err := s.users.UpdateName(r.Context(), usr.Id, req); err != nil {
+		if errors.Is(err, services.ErrValidationFailed) {
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		}
+
+		return err
+	}
+
+	return s.SuccessAlert(w, Translate(r, "account.name_updated"))
+}
+
+// Pages are rendered in the new language, so reload rather than alert.
+func (s *Site) AccountLocaleHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.UserLocaleRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	if err := s.users.SetLocale(r.Context(), ExtractUser(r).Id, req); err != nil {
+		if errors.Is(err, services.ErrValidationFailed) {
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		}
+
+		return err
+	}
+
+	return web.HxRefresh(w)
+}
+
+func (s *Site) AccountPasswordHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.UserChangePasswordRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	usr := ExtractUser(r)
+	se, _ := web.GetSession(r)
+
+	if err := s.users.ChangePassword(r.Context(), usr.Id, se.Token, req); err != nil {
+		switch {
+		case errors.Is(err, services.ErrValidationFailed):
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		case errors.Is(err, services.ErrUserWrongPassword):
+			return s.DangerAlert(w, Translate(r, "account.current_password_incorrect"))
+		}
+
+		return err
+	}
+
+	// The form needs the current password from now on.
+	if !services.HasPassword(*usr) {
+		return web.HxRefresh(w)
+	}
+
+	return s.SuccessAlert(w, Translate(r, "account.password_changed"))
+}
+
+// The zip is streamed as it's made, media and all, so it can take a while.
+func (s *Site) AccountExportHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := extendDeadlines(w, false); err != nil {
+		return err
+	}
+
+	usr := ExtractUser(r)
+
+	ew := &exportWriter{w: w, netID: usr.NetID}
+	if err := s.users.Export(r.Context(), usr.Id, ew); err != nil {
+		if !ew.started {
+			return err
+		}
+
+		// Too late for an error page, the zip is cut short and won't open.
+		slog.Error("error exporting account", "user_id", usr.Id, "error", err)
+	}
+
+	return nil
+}
+
+// Sends the export's headers with the first of the zip, so an error before
+// then still gets an error page.
+type exportWriter struct {
+	w       http.ResponseWriter
+	netID   string
+	started bool
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		e.started = true
+		e.w.Header().Set("Content-Type", "application/zip")
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="uwececa-%s.zip"`, e.netID))
+		e.w.WriteHeader(http.StatusOK)
+	}
+
+	return e.w.Write(p)
+}
+
+func (s *Site) AccountDeleteHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.AccountDeleteRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	usr := ExtractUser(r)
+
+	if err := s.users.DeleteAccount(r.Context(), usr.Id, req); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserWrongPassword):
+			return s.DangerAlert(w, Translate(r, "account.password_incorrect"))
+		case errors.Is(err, services.ErrNetIDMismatch):
+			return s.DangerAlert(w, Translate(r, "account.netid_incorrect"))
+		}
+
+		return err
+	}
+
+	slog.Info("user deleted their account", "user_id", usr.Id)
+
+	web.DeleteSession(w)
+	return web.HxRedirect(w, "/")
+}
+
+func (s *Site) AccountAnnouncementsHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.AccountAnnouncementsRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	usr := ExtractUser(r)
+
+	if err := s.broadcasts.SetSubscribed(r.Context(), usr.Id, req.Subscribed); err != nil {
+		return err
+	}
+
+	if req.Subscribed {
+		return s.SuccessAlert(w, Translate(r, "account.announcements_on"))
+	}
+
+	return s.SuccessAlert(w, Translate(r, "account.announcements_off"))
+}
+
+// Turn posting by email on with a fresh address, or off.
+func (s *Site) AccountPostByEmailHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.PostByEmailRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	site, err := s.blogs.LoadBlogFromUser(r.Context(), ExtractUser(r).Id)
+	if err != nil {
+		if errors.Is(err, services.ErrBlogDoesNotExist) {
+			return s.DangerAlert(w, Translate(r, "account.post_by_email_no_site"))
+		}
+
+		return err
+	}
+
+	if req.Enabled {
+		err = s.postmail.NewAddress(r.Context(), site.Id)
+	} else {
+		err = s.postmail.Disable(r.Context(), site.Id)
+	}
+	if err != nil {
+		return err
+	}
+
+	return web.HxRedirect(w, "/account")
+}
@@ -0,0 +1,128 @@
+package site
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"uwece.ca/app/models"
+	"uwece.ca/app/services"
+	"uwece.ca/app/web"
+)
+
+func (s *Site) AdminPage(w http.ResponseWriter, r *http.Request) error {
+	broadcasts, err := s.broadcasts.Broadcasts(r.Context())
+	if err != nil {
+		return err
+	}
+
+	undeliverable, err := s.bounces.Undeliverable(r.Context())
+	if err != nil {
+		return err
+	}
+
+	ctx := s.BaseContext(r)
+	ctx.Add("broadcasts", broadcasts)
+	ctx.Add("undeliverable", undeliverable)
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/admin", ctx)
+}
+
+func (s *Site) NewBroadcastPage(w http.ResponseWriter, r *http.Request) error {
+	ctx := s.BaseContext(r)
+	ctx.Add("audiences", []string{models.AudienceAll, models.AudienceVerifiedSites, models.AudienceCohort})
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/admin-broadcast", ctx)
+}
+
+func (s *Site) decodeBroadcast(w http.ResponseWriter, r *http.Request) (services.BroadcastRequest, bool, error) {
+	if err := r.ParseForm(); err != nil {
+		return services.BroadcastRequest{}, false, err
+	}
+
+	var req services.BroadcastRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return req, false, s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	return req, true, nil
+}
+
+func (s *Site) BroadcastPreviewHandler(w http.ResponseWriter, r *http.Request) error {
+	req, ok, err := s.decodeBroadcast(w, r)
+	if !ok {
+		return err
+	}
+
+	preview, err := s.broadcasts.Preview(r.Context(), *ExtractUser(r), req)
+	if err != nil {
+		if errors.Is(err, services.ErrValidationFailed) {
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		}
+
+		return err
+	}
+
+	ctx := s.BaseContext(r)
+	ctx.Add("preview", preview)
+
+	return s.RenderPlain(w, http.StatusOK, "public/admin-broadcast-preview", ctx)
+}
+
+func (s *Site) BroadcastSendHandler(w http.ResponseWriter, r *http.Request) error {
+	req, ok, err := s.decodeBroadcast(w, r)
+	if !ok {
+		return err
+	}
+
+	usr := ExtractUser(r)
+
+	if _, err := s.broadcasts.Create(r.Context(), usr.Id, req); err != nil {
+		if errors.Is(err, services.ErrValidationFailed) {
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		}
+
+		return err
+	}
+
+	return web.HxRedirect(w, "/admin")
+}
+
+// Start mailing a user again after their address bounced.
+func (s *Site) MarkDeliverableHandler(w http.ResponseWriter, r *http.Request) error {
+	usrID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return s.DangerAlert(w, Translate(r, "admin.unknown_user"))
+	}
+
+	if err := s.bounces.MarkDeliverable(r.Context(), usrID); err != nil {
+		return err
+	}
+
+	return web.HxRedirect(w, "/admin")
+}
+
+func (s *Site) UnsubscribePage(w http.ResponseWriter, r *http.Request) error {
+	ctx := s.BaseContext(r)
+	ctx.Add("token", r.PathValue("token"))
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/unsubscribe", ctx)
+}
+
+// Handles both the confirmation form and one click unsubscribes from mail clients.
+func (s *Site) UnsubscribeHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := s.broadcasts.Unsubscribe(r.Context(), r.PathValue("token")); err != nil {
+		if errors.Is(err, services.ErrBadUnsubscribeLink) {
+			return s.FullpageError(w, r, http.StatusBadRequest, Translate(r, "unsubscribe.invalid"))
+		}
+
+		return err
+	}
+
+	ctx := s.BaseContext(r)
+	ctx.Add("done", true)
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/unsubscribe", ctx)
+}
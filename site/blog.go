@@ -0,0 +1,327 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"uwece.ca/app/feeds"
+	"uwece.ca/app/models"
+	"uwece.ca/app/services"
+	"uwece.ca/app/templates"
+	"uwece.ca/app/web"
+)
+
+var (
+	publicBlogContextKey = struct{ K int }{2}
+	previewContextKey    = struct{ K int }{3}
+)
+
+// Holds the token from a preview link, on the blog's own host.
+const previewCookieName = "uwececa_preview_v1"
+
+// Where a preview link can send its owner after starting the preview.
+var previewNext = regexp.MustCompile(`^/posts/[a-z0-9-]+$`)
+
+// Send requests for a student's subdomain to the blog routes, everything
+// else carries on to the main site.
+func (s *Site) ServeBlogs(blogs http.Handler) web.Middleware {
+	suffix := "." + strings.ToLower(s.config.Core.BaseDomain)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			subdomain, ok := strings.CutSuffix(strings.ToLower(r.Host), suffix)
+			if !ok || subdomain == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			blogs.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), publicBlogContextKey, subdomain)))
+		})
+	}
+}
+
+// Routes served on every student's subdomain.
+func (s *Site) BlogRoutes() http.Handler {
+	w := web.NewHandlerWrapper(s)
+	r := chi.NewMux()
+
+	r.Use(s.LoadPublicBlog)
+	r.Use(s.LoadPreview)
+	r.Get("/", w.Wrap(s.BlogHomePage))
+	r.Get("/posts/{slug}", w.Wrap(s.BlogPostPage))
+	r.Get("/search", w.Wrap(s.BlogSearchPage))
+	r.Get("/preview/exit", w.Wrap(s.BlogPreviewExitHandler))
+	r.Get("/preview/{token}", w.Wrap(s.BlogPreviewHandler))
+	r.Get("/media/{hash}/{filename}", w.Wrap(s.BlogMediaHandler))
+	r.Get("/style.css", w.Wrap(s.BlogStylesheetHandler))
+	r.Get("/feed.xml", w.Wrap(s.BlogFeedHandler("/feed.xml", feeds.RSSContentType, feeds.RSS)))
+	r.Get("/atom.xml", w.Wrap(s.BlogFeedHandler("/atom.xml", feeds.AtomContentType, feeds.Atom)))
+	r.Get("/sitemap.xml", w.Wrap(s.BlogSitemapHandler))
+	r.Get("/robots.txt", w.Wrap(s.BlogRobotsHandler))
+	r.NotFound(w.Wrap(s.BlogNotFound))
+
+	return r
+}
+
+// Swap the subdomain ServeBlogs found for its site, or 404 when there's no
+// such (verified) site. Those 404s, robots.txt included, keep search engines
+// away from the subdomain.
+func (s *Site) LoadPublicBlog(next http.Handler) http.Handler {
+	return web.NewHandlerWrapper(s).Wrap(func(w http.ResponseWriter, r *http.Request) error {
+		subdomain, _ := r.Context().Value(publicBlogContextKey).(string)
+
+		blog, err := s.blogs.LoadBlogBySubdomain(r.Context(), subdomain)
+		if err != nil {
+			if errors.Is(err, services.ErrBlogDoesNotExist) {
+				w.Header().Set("X-Robots-Tag", "noindex")
+				if r.URL.Path == "/robots.txt" {
+					return noindexRobots(w)
+				}
+
+				return s.NotFound(w, r)
+			}
+
+			return err
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), publicBlogContextKey, blog)))
+		return nil
+	})
+}
+
+// The blog being served, only set on subdomain routes.
+func ExtractPublicBlog(r *http.Request) *models.Site {
+	blog, ok := r.Context().Value(publicBlogContextKey).(models.Site)
+	if !ok {
+		return nil
+	}
+
+	return &blog
+}
+
+// Mark requests from an owner previewing their site, so drafts and scheduled
+// posts show up. Previews are never cached or indexed.
+func (s *Site) LoadPreview(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(previewCookieName)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if _, ok := s.blogs.CheckPreview(*ExtractPublicBlog(r), cookie.Value); !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Robots-Tag", "noindex")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), previewContextKey, true)))
+	})
+}
+
+// Whether the owner is previewing the blog being served.
+func IsPreview(r *http.Request) bool {
+	preview, _ := r.Context().Value(previewContextKey).(bool)
+	return preview
+}
+
+// What every theme layout can use.
+func (s *Site) BlogContext(r *http.Request) (templates.Context, error) {
+	blog := ExtractPublicBlog(r)
+
+	author, err := s.blogs.Author(r.Context(), *blog)
+	if err != nil {
+		return nil, err
+	}
+
+	return templates.Context{
+		"blog":   blog,
+		"author": author,
+		// Changes whenever the stylesheet does, so browsers can cache it.
+		"stylesheet": "/style.css?v=" + stylesheetVersion(s.blogs.Stylesheet(*blog)),
+		"main_url":   s.config.Core.BaseURL(),
+		"locale":     Locale(r),
+		"preview":    IsPreview(r),
+	}, nil
+}
+
+func (s *Site) RenderBlog(w http.ResponseWriter, r *http.Request, statusCode int, name string, ctx templates.Context) error {
+	theme, _ := s.blogs.Theme(*ExtractPublicBlog(r))
+
+	return s.Render(w, statusCode, theme.Layout(), name, ctx)
+}
+
+func (s *Site) BlogHomePage(w http.ResponseWriter, r *http.Request) error {
+	ctx, err := s.BlogContext(r)
+	if err != nil {
+		return err
+	}
+
+	blog := ExtractPublicBlog(r)
+	list := s.posts.List
+	if IsPreview(r) {
+		list = s.posts.ListAll
+		if blog.HomeDraft != nil {
+			blog.HomeContent = *blog.HomeDraft
+			ctx.Add("blog", blog)
+		}
+	}
+
+	posts, err := list(r.Context(), blog.Id)
+	if err != nil {
+		return err
+	}
+	ctx.Add("posts", posts)
+
+	return s.RenderBlog(w, r, http.StatusOK, "blog/home", ctx)
+}
+
+func (s *Site) BlogPostPage(w http.ResponseWriter, r *http.Request) error {
+	get := s.posts.Get
+	if IsPreview(r) {
+		get = s.posts.GetAny
+	}
+
+	post, err := get(r.Context(), ExtractPublicBlog(r).Id, chi.URLParam(r, "slug"))
+	if err != nil {
+		if errors.Is(err, services.ErrPostDoesNotExist) {
+			return s.BlogNotFound(w, r)
+		}
+
+		return err
+	}
+
+	ctx, err := s.BlogContext(r)
+	if err != nil {
+		return err
+	}
+	ctx.Add("post", post)
+
+	return s.RenderBlog(w, r, http.StatusOK, "blog/post", ctx)
+}
+
+// Start previewing with a link from the dashboard. The link's token goes in
+// a cookie that lasts as long as the link would have.
+func (s *Site) BlogPreviewHandler(w http.ResponseWriter, r *http.Request) error {
+	token := chi.URLParam(r, "token")
+
+	expires, ok := s.blogs.CheckPreview(*ExtractPublicBlog(r), token)
+	if !ok {
+		ctx, err := s.BlogContext(r)
+		if err != nil {
+			return err
+		}
+		ctx.Add("Code", http.StatusForbidden)
+		ctx.Add("Message", Translate(r, "blog.preview_expired"))
+
+		w.Header().Set("X-Robots-Tag", "noindex")
+		return s.RenderBlog(w, r, http.StatusForbidden, "blog/error", ctx)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     previewCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Straight to a draft post, when the link was for one.
+	if next := r.URL.Query().Get("next"); previewNext.MatchString(next) {
+		return web.Redirect(w, next)
+	}
+
+	return web.Redirect(w, "/")
+}
+
+func (s *Site) BlogPreviewExitHandler(w http.ResponseWriter, r *http.Request) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     previewCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return web.Redirect(w, "/")
+}
+
+func (s *Site) BlogNotFound(w http.ResponseWriter, r *http.Request) error {
+	ctx, err := s.BlogContext(r)
+	if err != nil {
+		return err
+	}
+	ctx.Add("Code", http.StatusNotFound)
+	ctx.Add("Message", Translate(r, "blog.not_found"))
+
+	return s.RenderBlog(w, r, http.StatusNotFound, "blog/error", ctx)
+}
+
+// The theme's stylesheet with the owner's custom CSS on top.
+func (s *Site) BlogStylesheetHandler(w http.ResponseWriter, r *http.Request) error {
+	css := s.blogs.Stylesheet(*ExtractPublicBlog(r))
+
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	if r.URL.Query().Get("v") != "" {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", `"`+stylesheetVersion(css)+`"`)
+
+	// No modification time, the site's isn't the theme's, the ETag decides.
+	http.ServeContent(w, r, "style.css", time.Time{}, strings.NewReader(css))
+	return nil
+}
+
+// A hash of the generated stylesheet, so a new version of the theme's own
+// stylesheet counts as a change as much as the site's edits do.
+func stylesheetVersion(css string) string {
+	sum := sha256.Sum256([]byte(css))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Serve the blog's latest posts at path in the format encode writes. Feed
+// readers poll, so answer conditional requests with a 304 when nothing
+// changed.
+func (s *Site) BlogFeedHandler(path, contentType string, encode func(feeds.Feed) ([]byte, error)) func(http.ResponseWriter, *http.Request) error {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		blog := ExtractPublicBlog(r)
+
+		author, err := s.blogs.Author(r.Context(), *blog)
+		if err != nil {
+			return err
+		}
+
+		feed, err := s.posts.Feed(r.Context(), *blog, author, path)
+		if err != nil {
+			return err
+		}
+
+		body, err := encode(feed)
+		if err != nil {
+			return fmt.Errorf("error encoding feed: %w", err)
+		}
+
+		sum := sha256.Sum256(body)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:8])+`"`)
+
+		http.ServeContent(w, r, path, feed.Updated, bytes.NewReader(body))
+		return nil
+	}
+}
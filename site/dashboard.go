@@ -0,0 +1,176 @@
+package site
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"uwece.ca/app/services"
+	"uwece.ca/app/themes"
+	"uwece.ca/app/web"
+)
+
+// A theme on the picker, with the values its option inputs start with.
+type themeChoice struct {
+	themes.Theme
+	Values  map[string]string
+	Current bool
+}
+
+func (s *Site) DashboardPage(w http.ResponseWriter, r *http.Request) error {
+	blog := ExtractBlog(r)
+	current, values := s.blogs.Theme(*blog)
+
+	var choices []themeChoice
+	for _, t := range s.blogs.Themes() {
+		c := themeChoice{Theme: t, Current: t.ID == current.ID}
+		if c.Current {
+			c.Values = values
+		} else {
+			c.Values, _ = t.Resolve(nil)
+		}
+		choices = append(choices, c)
+	}
+
+	media, err := s.media.Library(r.Context(), *blog)
+	if err != nil {
+		return err
+	}
+
+	posts, err := s.posts.ListAll(r.Context(), blog.Id)
+	if err != nil {
+		return err
+	}
+
+	// What the home page editor starts with: the draft if there is one.
+	home := blog.HomeContent
+	if blog.HomeDraft != nil {
+		home = *blog.HomeDraft
+	}
+
+	ctx := s.BaseContext(r)
+	ctx.Add("site", blog)
+	ctx.Add("media", media)
+	ctx.Add("posts", posts)
+	ctx.Add("home", home)
+	ctx.Add("preview_link", s.blogs.PreviewLink(*blog))
+	ctx.Add("site_url", s.config.Core.SiteURL(blog.Subdomain))
+	ctx.Add("themes", choices)
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/dashboard", ctx)
+}
+
+func (s *Site) DashboardThemeHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	// Option inputs are named Options.<id>, which differ between themes.
+	req := services.BlogThemeRequest{
+		Theme:   r.Form.Get("Theme"),
+		Options: make(map[string]string),
+	}
+	for key := range r.Form {
+		if id, ok := strings.CutPrefix(key, "Options."); ok {
+			req.Options[id] = r.Form.Get(key)
+		}
+	}
+
+	if err := s.blogs.SetTheme(r.Context(), ExtractBlog(r).Id, req); err != nil {
+		if errors.Is(err, services.ErrValidationFailed) {
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		}
+
+		return err
+	}
+
+	return web.HxRefresh(w)
+}
+
+func (s *Site) DashboardHomeHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.BlogHomeRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	if err := s.blogs.SetHome(r.Context(), ExtractBlog(r).Id, ExtractUser(r).Id, req); err != nil {
+		if errors.Is(err, services.ErrValidationFailed) {
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		}
+
+		return err
+	}
+
+	return web.HxRefresh(w)
+}
+
+func (s *Site) DashboardHomeDiscardHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := s.blogs.DiscardHomeDraft(r.Context(), ExtractBlog(r).Id); err != nil {
+		return err
+	}
+
+	return web.HxRefresh(w)
+}
+
+func (s *Site) DashboardNavbarHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.BlogNavbarRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	if err := s.blogs.SetNavbar(r.Context(), ExtractBlog(r).Id, ExtractUser(r).Id, req); err != nil {
+		if errors.Is(err, services.ErrValidationFailed) {
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		}
+
+		return err
+	}
+
+	return s.SuccessAlert(w, Translate(r, "dashboard.navbar_saved"))
+}
+
+func (s *Site) DashboardStylesheetHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.BlogStylesheetRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	if err := s.blogs.SetStylesheet(r.Context(), ExtractBlog(r).Id, ExtractUser(r).Id, req); err != nil {
+		if errors.Is(err, services.ErrValidationFailed) {
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		}
+
+		return err
+	}
+
+	return s.SuccessAlert(w, Translate(r, "dashboard.stylesheet_saved"))
+}
+
+func (s *Site) ThemePreviewHandler(w http.ResponseWriter, r *http.Request) error {
+	theme, ok := s.blogs.GetTheme(chi.URLParam(r, "id"))
+	if !ok || theme.PreviewImage == nil {
+		return s.NotFound(w, r)
+	}
+
+	w.Header().Set("Content-Type", theme.PreviewType)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	_, err := w.Write(theme.PreviewImage)
+	return err
+}
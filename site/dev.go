@@ -0,0 +1,124 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"uwece.ca/app/services"
+)
+
+// Routes in this file are only mounted in development mode.
+
+func (s *Site) DevEmailsPage(w http.ResponseWriter, r *http.Request) error {
+	ctx := s.BaseContext(r)
+	ctx.Add("names", s.emails.Names())
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/dev-emails", ctx)
+}
+
+func (s *Site) DevEmailPage(w http.ResponseWriter, r *http.Request) error {
+	name := r.PathValue("name")
+
+	rendered, err := s.emails.Preview(name)
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownEmail) {
+			return s.NotFound(w, r)
+		}
+
+		// Template mistakes are what this page is for, show them.
+		return s.FullpageError(w, r, http.StatusInternalServerError, err.Error())
+	}
+
+	ctx := s.BaseContext(r)
+	ctx.Add("names", s.emails.Names())
+	ctx.Add("name", name)
+	ctx.Add("rendered", rendered)
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/dev-email", ctx)
+}
+
+func (s *Site) DevEmailSendHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.EmailTestRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	if err := s.emails.SendTest(r.PathValue("name"), req); err != nil {
+		switch {
+		case errors.Is(err, services.ErrValidationFailed):
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		case errors.Is(err, services.ErrUnknownEmail):
+			return s.DangerAlert(w, Translate(r, "dev.unknown_email"))
+		}
+
+		// Most likely the transport is down, which is worth seeing here.
+		return s.DangerAlert(w, ErrorMessage(r, err))
+	}
+
+	return s.SuccessAlert(w, Translate(r, "dev.test_sent", "address", req.Address))
+}
+
+// How often the reload stream sends a comment, so proxies and browsers don't
+// give up on a quiet connection.
+const devReloadHeartbeat = 15 * time.Second
+
+// A server-sent event stream the base layout listens to. It says "reload"
+// whenever a template changes, and starts with "hello" and an id for this
+// process so pages open across a restart refresh once it's back up.
+func (s *Site) DevReloadHandler(w http.ResponseWriter, r *http.Request) error {
+	rc := http.NewResponseController(w)
+	// The server's write timeout would cut the stream off.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		return fmt.Errorf("error clearing write deadline: %w", err)
+	}
+
+	changed, stop := s.templates.Subscribe()
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(format string, args ...any) error {
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	if err := send("event: hello\ndata: %s\n\n", s.bootID); err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(devReloadHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return nil
+		case _, ok := <-changed:
+			if !ok {
+				return nil
+			}
+			err = send("event: reload\ndata: templates\n\n")
+		case <-heartbeat.C:
+			err = send(": heartbeat\n\n")
+		}
+
+		// The browser went away.
+		if err != nil {
+			return nil
+		}
+	}
+}
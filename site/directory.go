@@ -0,0 +1,72 @@
+package site
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"uwece.ca/app/services"
+)
+
+func (s *Site) DirectoryPage(w http.ResponseWriter, r *http.Request) error {
+	// A mangled query string still gets a directory, with whatever did decode.
+	var req services.DirectoryRequest
+	if err := s.decoder.Decode(&req, r.URL.Query()); err != nil {
+		slog.Debug("directory query decode error", "error", err)
+	}
+
+	dir, err := s.blogs.Directory(r.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	ctx := s.BaseContext(r)
+	ctx.Add("directory", dir)
+	ctx.Add("query", req.Query)
+	ctx.Add("year", req.Year)
+	ctx.Add("years", services.GradYears())
+	if dir.Page > 1 {
+		ctx.Add("prev_url", directoryURL(req, dir.Page-1))
+	}
+	if dir.Page < dir.Pages {
+		ctx.Add("next_url", directoryURL(req, dir.Page+1))
+	}
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/directory", ctx)
+}
+
+func directoryURL(req services.DirectoryRequest, page int) string {
+	q := url.Values{}
+	if req.Query != "" {
+		q.Set("Query", req.Query)
+	}
+	if req.Year != 0 {
+		q.Set("Year", strconv.Itoa(req.Year))
+	}
+	q.Set("Page", strconv.Itoa(page))
+
+	return "/directory?" + q.Encode()
+}
+
+func (s *Site) DashboardDirectoryHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.BlogDirectoryRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	if err := s.blogs.SetListed(r.Context(), ExtractBlog(r).Id, req); err != nil {
+		return err
+	}
+
+	if req.Listed {
+		return s.SuccessAlert(w, Translate(r, "dashboard.directory_listed"))
+	}
+
+	return s.SuccessAlert(w, Translate(r, "dashboard.directory_unlisted"))
+}
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"net/http"
 
+	"uwece.ca/app/i18n"
 	"uwece.ca/app/templates"
 )
 
@@ -14,8 +15,22 @@ func (s *Site) UnhandledError(w http.ResponseWriter, err error) {
 	if s.config.Core.Development {
 		response = fmt.Sprintf("Internal Server Error: %v", err)
 	} else {
-		response = "Internal Server Error! Please Contact The Maintainers."
+		response = i18n.T(i18n.Default, "error.internal")
 	}
+
+	// There's no request here, so the page is rendered as if logged out.
+	rerr := s.Render(w, http.StatusInternalServerError, "layouts/public-base", "public/error", templates.Context{
+		"Code":    http.StatusInternalServerError,
+		"Message": response,
+		"locale":  i18n.Default,
+	})
+	if rerr == nil {
+		return
+	}
+
+	// The error page itself is broken, say so in plain text.
+	slog.Error("error rendering error page", "error", rerr)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusInternalServerError)
 	_, err = w.Write([]byte(response))
 	if err != nil {
@@ -32,7 +47,18 @@ func (s *Site) FullpageError(w http.ResponseWriter, r *http.Request, code int, m
 }
 
 func (s *Site) NotFound(w http.ResponseWriter, r *http.Request) error {
-	return s.FullpageError(w, r, http.StatusNotFound, "No resources found.")
+	return s.FullpageError(w, r, http.StatusNotFound, Translate(r, "error.not_found"))
+}
+
+// Message id translated for r's locale.
+func Translate(r *http.Request, id string, params ...any) string {
+	return i18n.T(Locale(r), id, params...)
+}
+
+// What to tell the user about err, translated when it carries a message id
+// (like a validation error from a service).
+func ErrorMessage(r *http.Request, err error) string {
+	return i18n.Message(Locale(r), err)
 }
 
 func (s *Site) DangerAlert(w http.ResponseWriter, message string) error {
@@ -48,3 +74,10 @@ func (s *Site) WarnAlert(w http.ResponseWriter, message string) error {
 		"variant": "warning",
 	})
 }
+
+func (s *Site) SuccessAlert(w http.ResponseWriter, message string) error {
+	return s.RenderPlain(w, http.StatusOK, "public/alert", templates.Context{
+		"message": message,
+		"variant": "success",
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"net/http"
 
+	"uwece.ca/app/i18n"
 	"uwece.ca/app/models"
 	"uwece.ca/app/services"
 	"uwece.ca/app/web"
@@ -59,6 +60,16 @@ func ExtractUser(r *http.Request) *models.User {
 	return &usr
 }
 
+// The locale to respond to r in: the user's choice if they've made one,
+// otherwise the best match for their browser.
+func Locale(r *http.Request) string {
+	if usr := ExtractUser(r); usr != nil && i18n.Supports(usr.Locale) {
+		return usr.Locale
+	}
+
+	return i18n.Match(r.Header.Get("Accept-Language"))
+}
+
 func RequireLogin(t bool) web.Middleware {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -162,3 +173,18 @@ func RequireBlog(t, verified bool) web.Middleware {
 		})
 	}
 }
+
+// Hide admin pages from everyone else, as if they did not exist.
+func (s *Site) RequireAdmin(next http.Handler) http.Handler {
+	notFound := web.NewHandlerWrapper(s).Wrap(s.NotFound)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usr := ExtractUser(r)
+		if usr == nil || !s.config.Core.IsAdmin(usr.NetID) {
+			notFound(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,51 @@
+package site
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"uwece.ca/app/services"
+	"uwece.ca/app/web"
+)
+
+// A field's revisions, and the diff between the two picked.
+func (s *Site) DashboardHistoryPage(w http.ResponseWriter, r *http.Request) error {
+	var req services.RevisionHistoryRequest
+	if err := s.decoder.Decode(&req, r.URL.Query()); err != nil {
+		slog.Debug("history query decode error", "error", err)
+	}
+
+	blog := ExtractBlog(r)
+	history, err := s.revisions.History(r.Context(), blog.Id, req)
+	if err != nil {
+		return err
+	}
+
+	ctx := s.BaseContext(r)
+	ctx.Add("site", blog)
+	ctx.Add("history", history)
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/history", ctx)
+}
+
+func (s *Site) DashboardRestoreHandler(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return s.NotFound(w, r)
+	}
+
+	rev, err := s.revisions.Restore(r.Context(), ExtractBlog(r).Id, ExtractUser(r).Id, id)
+	if err != nil {
+		if errors.Is(err, services.ErrRevisionDoesNotExist) {
+			return s.NotFound(w, r)
+		}
+
+		return err
+	}
+
+	// Back to the newest two, which shows what the restore changed.
+	return web.HxRedirect(w, "/site/history?Field="+rev.Field)
+}
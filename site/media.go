@@ -0,0 +1,146 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"uwece.ca/app/services"
+	"uwece.ca/app/web"
+)
+
+// Room for the rest of the multipart body around the file.
+const uploadOverhead = 64 << 10
+
+// How long a request moving a whole file gets, in place of the server's
+// timeouts which are only long enough for pages.
+const fileTransferTimeout = 5 * time.Minute
+
+// Give the request fileTransferTimeout to read its body and write its
+// response in.
+func extendDeadlines(w http.ResponseWriter, read bool) error {
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(fileTransferTimeout)
+
+	if read {
+		if err := rc.SetReadDeadline(deadline); err != nil {
+			return fmt.Errorf("error extending read deadline: %w", err)
+		}
+	}
+
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		return fmt.Errorf("error extending write deadline: %w", err)
+	}
+
+	return nil
+}
+
+// Uploads are read, resized and stored all in the one request.
+func (s *Site) DashboardMediaHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := extendDeadlines(w, true); err != nil {
+		return err
+	}
+
+	maxSize := s.config.Core.MaxUploadSize
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+uploadOverhead)
+
+	if err := r.ParseMultipartForm(maxSize); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			return s.DangerAlert(w, Translate(r, "validation.media_size", "max", maxSize>>20))
+		}
+
+		slog.Warn("upload decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("File")
+	if err != nil {
+		return s.DangerAlert(w, Translate(r, "dashboard.media_missing"))
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.media.Store(r.Context(), ExtractBlog(r).Id, header.Filename, data); err != nil {
+		switch {
+		case errors.Is(err, services.ErrValidationFailed):
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		case errors.Is(err, services.ErrMediaTypeNotAllowed):
+			return s.DangerAlert(w, Translate(r, "dashboard.media_type"))
+		case errors.Is(err, services.ErrMediaQuotaExceeded):
+			return s.DangerAlert(w, Translate(r, "dashboard.media_quota"))
+		}
+
+		return err
+	}
+
+	return web.HxRefresh(w)
+}
+
+func (s *Site) DashboardMediaDeleteHandler(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return s.NotFound(w, r)
+	}
+
+	if err := s.media.Delete(r.Context(), ExtractBlog(r).Id, id); err != nil {
+		if errors.Is(err, services.ErrMediaDoesNotExist) {
+			return s.NotFound(w, r)
+		}
+
+		return err
+	}
+
+	return web.HxRefresh(w)
+}
+
+// An upload, or a resized copy of it with ?w=<width>. Files are named by
+// their contents, so they can be cached forever.
+func (s *Site) BlogMediaHandler(w http.ResponseWriter, r *http.Request) error {
+	width, _ := strconv.Atoi(r.URL.Query().Get("w"))
+
+	content, err := s.media.Open(r.Context(), ExtractPublicBlog(r).Id, chi.URLParam(r, "hash"), width)
+	if err != nil {
+		if errors.Is(err, services.ErrMediaDoesNotExist) {
+			return s.BlogNotFound(w, r)
+		}
+
+		return err
+	}
+
+	f, err := os.Open(content.Path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			slog.Error("media file missing", "path", content.Path)
+			return s.BlogNotFound(w, r)
+		}
+
+		return err
+	}
+	defer f.Close()
+
+	if err := extendDeadlines(w, false); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", content.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": content.Filename}))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+content.Hash+`"`)
+
+	http.ServeContent(w, r, content.Filename, content.CreatedAt, f)
+	return nil
+}
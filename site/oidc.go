@@ -0,0 +1,73 @@
+package site
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"uwece.ca/app/oidc"
+	"uwece.ca/app/services"
+	"uwece.ca/app/web"
+)
+
+func (s *Site) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) error {
+	req := oidc.NewAuthRequest()
+	web.AddAuthFlow(w, req.Encode())
+
+	return web.Redirect(w, s.idp.AuthCodeURL(req))
+}
+
+func (s *Site) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) error {
+	raw, _ := web.GetAuthFlow(r)
+	web.DeleteAuthFlow(w)
+
+	req, ok := oidc.DecodeAuthRequest(raw)
+	if !ok {
+		return s.FullpageError(w, r, http.StatusBadRequest, Translate(r, "oidc.expired"))
+	}
+
+	tok, err := s.idp.HandleCallback(r.Context(), r.URL.Query(), req)
+	if err != nil {
+		slog.Warn("oidc callback failed", "error", err)
+		return s.FullpageError(w, r, http.StatusBadRequest, Translate(r, "oidc.failed"))
+	}
+
+	name := tok.StringClaim("given_name")
+	if name == "" {
+		name = tok.StringClaim("name")
+	}
+
+	res, err := s.users.LoginExternal(r.Context(), services.ExternalIdentity{
+		Issuer:  tok.Issuer,
+		Subject: tok.Subject,
+		NetID:   netIDFromClaim(tok.StringClaim(s.config.OIDC.NetIDClaim)),
+		Name:    name,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrValidationFailed):
+			slog.Warn("identity provider returned unusable netid", "error", err, "subject", tok.Subject)
+			return s.FullpageError(w, r, http.StatusBadRequest, Translate(r, "oidc.invalid_netid"))
+		case errors.Is(err, services.ErrUserNotVerified):
+			return s.FullpageError(w, r, http.StatusForbidden, Translate(r, "login.not_verified"))
+		}
+
+		return err
+	}
+
+	web.AddSession(w, res.Session)
+
+	// The session cookie is strict, so it would not be sent on a redirect that started
+	// at the provider. Bounce through a page on our own origin instead.
+	ctx := s.BaseContext(r)
+	ctx.Add("target", "/site")
+
+	return s.RenderPlain(w, http.StatusOK, "public/redirect", ctx)
+}
+
+// Providers commonly hand out "netid@uwaterloo.ca" rather than a bare NetID.
+func netIDFromClaim(claim string) string {
+	netID, _, _ := strings.Cut(claim, "@")
+	return strings.ToLower(netID)
+}
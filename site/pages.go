@@ -4,7 +4,6 @@ import (
 	"errors"
 	"log/slog"
 	"net/http"
-	"strings"
 
 	"uwece.ca/app/services"
 	"uwece.ca/app/utils"
@@ -20,6 +19,7 @@ func (s *Site) Index(w http.ResponseWriter, r *http.Request) error {
 func (s *Site) LoginPage(w http.ResponseWriter, r *http.Request) error {
 	ctx := s.BaseContext(r)
 	ctx.Add("variant", "Login")
+	ctx.Add("oidc", s.idp != nil)
 
 	return s.Render(w, http.StatusOK, "layouts/public-base", "public/login-signup", ctx)
 }
@@ -32,20 +32,20 @@ func (s *Site) LoginHandler(w http.ResponseWriter, r *http.Request) error {
 	var req services.UserLoginRequest
 	if err := s.decoder.Decode(&req, r.Form); err != nil {
 		slog.Warn("form decode error", "error", err)
-		return s.DangerAlert(w, "Error decoding form, please try again.")
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
 	}
 
 	res, err := s.users.Login(r.Context(), req)
 	if err != nil {
 		switch {
 		case errors.Is(err, services.ErrValidationFailed):
-			return s.DangerAlert(w, err.Error())
+			return s.DangerAlert(w, ErrorMessage(r, err))
 		case errors.Is(err, services.ErrUserDoesNotExist):
 			fallthrough
 		case errors.Is(err, services.ErrUserWrongPassword):
-			return s.DangerAlert(w, "No user account found with that netid and password.")
+			return s.DangerAlert(w, Translate(r, "login.failed"))
 		case errors.Is(err, services.ErrUserNotVerified):
-			return s.WarnAlert(w, "User account not verified, please check your email for a verification link.")
+			return s.WarnAlert(w, Translate(r, "login.not_verified"))
 		}
 
 		return err
@@ -55,9 +55,21 @@ func (s *Site) LoginHandler(w http.ResponseWriter, r *http.Request) error {
 	return web.HxRedirect(w, "/site")
 }
 
+func (s *Site) LogoutHandler(w http.ResponseWriter, r *http.Request) error {
+	if se, ok := web.GetSession(r); ok {
+		if err := s.users.Logout(r.Context(), se.Token); err != nil {
+			return err
+		}
+	}
+
+	web.DeleteSession(w)
+	return web.Redirect(w, "/")
+}
+
 func (s *Site) SignupPage(w http.ResponseWriter, r *http.Request) error {
 	ctx := s.BaseContext(r)
 	ctx.Add("variant", "Signup")
+	ctx.Add("oidc", s.idp != nil)
 
 	return s.Render(w, http.StatusOK, "layouts/public-base", "public/login-signup", ctx)
 }
@@ -70,16 +82,16 @@ func (s *Site) SignupHandler(w http.ResponseWriter, r *http.Request) error {
 	var req services.UserSignupRequest
 	if err := s.decoder.Decode(&req, r.Form); err != nil {
 		slog.Warn("form decode error", "error", err)
-		return s.DangerAlert(w, "Error decoding form, please try again.")
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
 	}
 
 	res, err := s.users.Signup(r.Context(), req)
 	if err != nil {
 		switch {
 		case errors.Is(err, services.ErrValidationFailed):
-			return s.DangerAlert(w, err.Error())
+			return s.DangerAlert(w, ErrorMessage(r, err))
 		case errors.Is(err, services.ErrUserExists):
-			return s.DangerAlert(w, "An account with that netid already exists.")
+			return s.DangerAlert(w, Translate(r, "signup.exists"))
 		}
 
 		return err
@@ -97,9 +109,9 @@ func (s *Site) VerificationHandler(w http.ResponseWriter, r *http.Request) error
 	if err := s.users.Verify(r.Context(), token); err != nil {
 		switch {
 		case errors.Is(err, services.ErrTokenExpired):
-			return s.FullpageError(w, r, http.StatusBadRequest, "Token Expired.")
+			return s.FullpageError(w, r, http.StatusBadRequest, Translate(r, "verify.token_expired"))
 		case errors.Is(err, services.ErrTokenNotFound):
-			return s.FullpageError(w, r, http.StatusNotFound, "Token Does Not Exist.")
+			return s.FullpageError(w, r, http.StatusNotFound, Translate(r, "verify.token_missing"))
 		}
 		return err
 	}
@@ -110,7 +122,7 @@ func (s *Site) VerificationHandler(w http.ResponseWriter, r *http.Request) error
 func (s *Site) NewBlogPage(w http.ResponseWriter, r *http.Request) error {
 	usr := ExtractUser(r)
 	ctx := s.BaseContext(r)
-	ctx.Add("name", strings.ToLower(usr.Name))
+	ctx.Add("name", usr.Name)
 
 	return s.Render(w, http.StatusOK, "layouts/public-base", "public/new-blog", ctx)
 }
@@ -123,7 +135,7 @@ func (s *Site) NewBlogHandler(w http.ResponseWriter, r *http.Request) error {
 	var req services.BlogNewRequest
 	if err := s.decoder.Decode(&req, r.Form); err != nil {
 		slog.Warn("form decode error", "error", err)
-		return s.DangerAlert(w, "Error decoding form, please try again.")
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
 	}
 
 	usr := ExtractUser(r)
@@ -131,9 +143,9 @@ func (s *Site) NewBlogHandler(w http.ResponseWriter, r *http.Request) error {
 	if err := s.blogs.New(r.Context(), req, usr.Id); err != nil {
 		switch {
 		case errors.Is(err, services.ErrValidationFailed):
-			return s.DangerAlert(w, err.Error())
+			return s.DangerAlert(w, ErrorMessage(r, err))
 		case errors.Is(err, services.ErrSubdomainNotAvailable):
-			return s.DangerAlert(w, "The subdomain you selected was not available, maybe try using your last name.")
+			return s.DangerAlert(w, Translate(r, "new_blog.unavailable"))
 		}
 
 		return err
@@ -0,0 +1,99 @@
+package site
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"uwece.ca/app/models"
+	"uwece.ca/app/services"
+	"uwece.ca/app/web"
+)
+
+// The post editor, empty for a new post.
+func (s *Site) DashboardPostPage(w http.ResponseWriter, r *http.Request) error {
+	blog := ExtractBlog(r)
+
+	ctx := s.BaseContext(r)
+	ctx.Add("site", blog)
+	ctx.Add("preview_link", s.blogs.PreviewLink(*blog))
+
+	if idParam := chi.URLParam(r, "id"); idParam != "" {
+		id, err := strconv.Atoi(idParam)
+		if err != nil {
+			return s.NotFound(w, r)
+		}
+
+		post, err := s.posts.GetByID(r.Context(), blog.Id, id)
+		if err != nil {
+			if errors.Is(err, services.ErrPostDoesNotExist) {
+				return s.NotFound(w, r)
+			}
+
+			return err
+		}
+
+		ctx.Add("post", post)
+		if post.Status == models.PostPublished {
+			ctx.Add("post_url", s.posts.URL(*blog, post))
+		}
+	}
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/post-editor", ctx)
+}
+
+func (s *Site) DashboardPostCreateHandler(w http.ResponseWriter, r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.PostNewRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	post, err := s.posts.Create(r.Context(), ExtractBlog(r).Id, req)
+	if err != nil {
+		if errors.Is(err, services.ErrValidationFailed) {
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		}
+
+		return err
+	}
+
+	return web.HxRedirect(w, fmt.Sprintf("/site/posts/%d", post.Id))
+}
+
+func (s *Site) DashboardPostUpdateHandler(w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return s.NotFound(w, r)
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+
+	var req services.PostNewRequest
+	if err := s.decoder.Decode(&req, r.Form); err != nil {
+		slog.Warn("form decode error", "error", err)
+		return s.DangerAlert(w, Translate(r, "form.decode_error"))
+	}
+
+	if _, err := s.posts.Update(r.Context(), ExtractBlog(r).Id, id, req); err != nil {
+		switch {
+		case errors.Is(err, services.ErrValidationFailed):
+			return s.DangerAlert(w, ErrorMessage(r, err))
+		case errors.Is(err, services.ErrPostDoesNotExist):
+			return s.NotFound(w, r)
+		}
+
+		return err
+	}
+
+	return web.HxRefresh(w)
+}
@@ -0,0 +1,76 @@
+package site
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"uwece.ca/app/services"
+	"uwece.ca/app/templates"
+)
+
+// Posts and blogs across the platform.
+func (s *Site) SearchPage(w http.ResponseWriter, r *http.Request) error {
+	req := s.searchRequest(r)
+
+	results, err := s.search.Platform(r.Context(), req)
+	if err != nil {
+		return err
+	}
+
+	ctx := s.BaseContext(r)
+	addSearchResults(ctx, req, results)
+
+	return s.Render(w, http.StatusOK, "layouts/public-base", "public/search", ctx)
+}
+
+// Posts on the blog being served.
+func (s *Site) BlogSearchPage(w http.ResponseWriter, r *http.Request) error {
+	req := s.searchRequest(r)
+
+	results, err := s.search.Blog(r.Context(), *ExtractPublicBlog(r), req)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := s.BlogContext(r)
+	if err != nil {
+		return err
+	}
+	addSearchResults(ctx, req, results)
+
+	// Results change with every post, there's no sense in indexing them.
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	return s.RenderBlog(w, r, http.StatusOK, "blog/search", ctx)
+}
+
+// A mangled query string still gets a search, with whatever did decode.
+func (s *Site) searchRequest(r *http.Request) services.SearchRequest {
+	var req services.SearchRequest
+	if err := s.decoder.Decode(&req, r.URL.Query()); err != nil {
+		slog.Debug("search query decode error", "error", err)
+	}
+
+	return req
+}
+
+func addSearchResults(ctx templates.Context, req services.SearchRequest, results services.SearchResults) {
+	ctx.Add("query", req.Query)
+	ctx.Add("results", results)
+	if results.Page > 1 {
+		ctx.Add("prev_url", searchURL(req, results.Page-1))
+	}
+	if results.Page < results.Pages {
+		ctx.Add("next_url", searchURL(req, results.Page+1))
+	}
+}
+
+func searchURL(req services.SearchRequest, page int) string {
+	q := url.Values{}
+	q.Set("Query", req.Query)
+	q.Set("Page", strconv.Itoa(page))
+
+	return "/search?" + q.Encode()
+}
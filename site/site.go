@@ -1,20 +1,28 @@
 package site
 
 import (
+	"context"
 	"embed"
+	"fmt"
 	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
 	chimd "github.com/go-chi/chi/v5/middleware"
 	"github.com/gorilla/schema"
+	"uwece.ca/app/assets"
 	"uwece.ca/app/config"
 	"uwece.ca/app/db"
 	"uwece.ca/app/mailer"
+	"uwece.ca/app/oidc"
 	"uwece.ca/app/services"
 	"uwece.ca/app/templates"
+	"uwece.ca/app/themes"
+	"uwece.ca/app/utils"
 	"uwece.ca/app/web"
 )
 
@@ -22,28 +30,152 @@ import (
 var embedFS embed.FS
 
 type Site struct {
-	blogs     *services.BlogService
-	users     *services.UserService
-	templates *templates.Templates
-	config    *config.Config
-	decoder   *schema.Decoder
+	blogs      *services.BlogService
+	posts      *services.PostService
+	users      *services.UserService
+	broadcasts *services.BroadcastService
+	bounces    *services.BounceService
+	postmail   *services.PostMailService
+	emails     *services.EmailPreviewService
+	search     *services.SearchService
+	media      *services.MediaService
+	schedule   *services.ScheduleService
+	revisions  *services.RevisionService
+	templates  *templates.Templates
+	assets     *assets.Assets
+	config     *config.Config
+	decoder    *schema.Decoder
+
+	// Nil when external login is disabled.
+	idp *oidc.Provider
+
+	// Changes every start so dev pages know to reload after a restart.
+	bootID string
+
+	stopWorkers context.CancelFunc
+	workers     sync.WaitGroup
 }
 
-func New(cfg *config.Config, db *db.DB, mailer mailer.Mailer) *Site {
+func New(cfg *config.Config, db *db.DB, mailer mailer.Mailer, idp *oidc.Provider) (*Site, error) {
+	var static *assets.Assets
+	if cfg.Core.Development {
+		static = assets.NewDev("./site/static", "/static")
+	} else {
+		sub, err := fs.Sub(embedFS, "static")
+		if err != nil {
+			return nil, fmt.Errorf("no static dir in mainsite: %w", err)
+		}
+
+		static, err = assets.New(sub, "/static")
+		if err != nil {
+			return nil, fmt.Errorf("error setting up static assets: %w", err)
+		}
+	}
+
+	funcs := templates.Library(cfg.Core)
+	funcs["asset"] = static.URL
+
 	var tmpl *templates.Templates
+	var themeFS fs.FS
 	if cfg.Core.Development {
-		tmpl = templates.NewDevTemplates(embedFS, "./site/templates")
+		tmpl = templates.NewDevTemplates("./site/templates", funcs)
+		themeFS = os.DirFS("./site/templates/themes")
 	} else {
-		tmpl = templates.NewTemplates(embedFS)
+		tmpl = templates.NewTemplates(embedFS, funcs)
+		themeFS, _ = fs.Sub(embedFS, "templates/themes")
+	}
+
+	registry, err := themes.Load(themeFS)
+	if err != nil {
+		return nil, fmt.Errorf("error loading blog themes: %w", err)
+	}
+	for _, t := range registry.List() {
+		if !tmpl.Defines("blog/home", t.Layout()) {
+			return nil, fmt.Errorf("blog theme %s: layout.html must define %s", t.ID, t.Layout())
+		}
+	}
+
+	users, err := services.NewUserService(db, mailer, cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	return &Site{
-		users:     services.NewUserService(db, mailer, cfg),
-		blogs:     services.NewBlogService(db),
-		config:    cfg,
-		templates: tmpl,
-		decoder:   schema.NewDecoder(),
+		users:      users,
+		blogs:      services.NewBlogService(db, cfg, registry),
+		posts:      services.NewPostService(db, cfg),
+		broadcasts: services.NewBroadcastService(db, mailer, cfg, users),
+		bounces:    services.NewBounceService(db, cfg),
+		postmail:   services.NewPostMailService(db, mailer, cfg, users),
+		emails:     services.NewEmailPreviewService(mailer),
+		search:     services.NewSearchService(db, cfg),
+		media:      services.NewMediaService(db, cfg),
+		schedule:   services.NewScheduleService(db, cfg),
+		revisions:  services.NewRevisionService(db, cfg),
+		config:     cfg,
+		templates:  tmpl,
+		assets:     static,
+		decoder:    schema.NewDecoder(),
+		idp:        idp,
+		bootID:     string(utils.NewToken())[:8],
+	}, nil
+}
+
+// Start background jobs, such as sending queued broadcasts, reading bounces,
+// listening for posts by email, publishing scheduled posts and pruning old
+// revisions.
+func (s *Site) StartWorkers(ctx context.Context) {
+	ctx, s.stopWorkers = context.WithCancel(ctx)
+
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		s.schedule.Run(ctx)
+	}()
+
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		s.revisions.Run(ctx)
+	}()
+
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		s.broadcasts.Run(ctx)
+	}()
+
+	if s.bounces.Enabled() {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			s.bounces.Run(ctx)
+		}()
+	}
+
+	if s.postmail.Enabled() {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			s.postmail.Run(ctx)
+		}()
+	}
+}
+
+// Stop background jobs and wait for them to finish what they are doing.
+func (s *Site) StopWorkers() {
+	if s.stopWorkers == nil {
+		return
 	}
+
+	s.stopWorkers()
+	s.workers.Wait()
+}
+
+// Stop watching templates, which also ends any open dev reload streams so the
+// http server can shut down.
+func (s *Site) Close() error {
+	return s.templates.Close()
 }
 
 func (s *Site) Routes() http.Handler {
@@ -51,12 +183,20 @@ func (s *Site) Routes() http.Handler {
 	r := chi.NewMux()
 	r.Use(web.MidLogRecover)
 	r.Use(chimd.Compress(5))
+	r.Use(s.ServeBlogs(s.BlogRoutes()))
 
 	r.Handle("/static/*", s.Static())
+	r.Get("/themes/{id}/preview", w.Wrap(s.ThemePreviewHandler))
+	r.Get("/robots.txt", w.Wrap(s.RobotsHandler))
+	r.Get("/sitemap.xml", w.Wrap(s.SitemapIndexHandler))
 
 	r.Group(func(r chi.Router) {
 		r.Use(s.LoadUser)
 		r.Handle("/", w.Wrap(s.Index))
+		r.Get("/directory", w.Wrap(s.DirectoryPage))
+		r.Get("/search", w.Wrap(s.SearchPage))
+		r.Get("/unsubscribe/{token}", w.Wrap(s.UnsubscribePage))
+		r.Post("/unsubscribe/{token}", w.Wrap(s.UnsubscribeHandler))
 
 		r.Group(func(r chi.Router) {
 			r.Use(RequireLogin(false))
@@ -65,15 +205,71 @@ func (s *Site) Routes() http.Handler {
 			r.Get("/signup", w.Wrap(s.SignupPage))
 			r.Post("/signup", w.Wrap(s.SignupHandler))
 			r.Get("/signup/verify/{token}", w.Wrap(s.VerificationHandler))
+
+			if s.idp != nil {
+				r.Get("/login/oidc", w.Wrap(s.OIDCLoginHandler))
+				r.Get("/login/oidc/callback", w.Wrap(s.OIDCCallbackHandler))
+			}
 		})
 
 		r.Group(func(r chi.Router) {
 			r.Use(RequireLogin(true))
+			r.Use(s.LoadBlog)
 			r.Use(RequireBlog(false, false))
 			r.Get("/new-blog", w.Wrap(s.NewBlogPage))
 			r.Post("/new-blog", w.Wrap(s.NewBlogHandler))
 		})
 
+		r.Group(func(r chi.Router) {
+			r.Use(RequireLogin(true))
+			r.Use(s.LoadBlog)
+			r.Use(RequireBlog(true, false))
+			r.Get("/site", w.Wrap(s.DashboardPage))
+			r.Post("/site/home", w.Wrap(s.DashboardHomeHandler))
+			r.Post("/site/home/discard", w.Wrap(s.DashboardHomeDiscardHandler))
+			r.Get("/site/posts/new", w.Wrap(s.DashboardPostPage))
+			r.Post("/site/posts", w.Wrap(s.DashboardPostCreateHandler))
+			r.Get("/site/posts/{id}", w.Wrap(s.DashboardPostPage))
+			r.Post("/site/posts/{id}", w.Wrap(s.DashboardPostUpdateHandler))
+			r.Post("/site/theme", w.Wrap(s.DashboardThemeHandler))
+			r.Post("/site/navbar", w.Wrap(s.DashboardNavbarHandler))
+			r.Post("/site/stylesheet", w.Wrap(s.DashboardStylesheetHandler))
+			r.Get("/site/history", w.Wrap(s.DashboardHistoryPage))
+			r.Post("/site/history/{id}/restore", w.Wrap(s.DashboardRestoreHandler))
+			r.Post("/site/directory", w.Wrap(s.DashboardDirectoryHandler))
+			r.Post("/site/media", w.Wrap(s.DashboardMediaHandler))
+			r.Post("/site/media/{id}/delete", w.Wrap(s.DashboardMediaDeleteHandler))
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequireLogin(true))
+			r.Get("/logout", w.Wrap(s.LogoutHandler))
+			r.Get("/account", w.Wrap(s.AccountPage))
+			r.Post("/account/name", w.Wrap(s.AccountNameHandler))
+			r.Post("/account/password", w.Wrap(s.AccountPasswordHandler))
+			r.Post("/account/locale", w.Wrap(s.AccountLocaleHandler))
+			r.Get("/account/export", w.Wrap(s.AccountExportHandler))
+			r.Post("/account/delete", w.Wrap(s.AccountDeleteHandler))
+			r.Post("/account/announcements", w.Wrap(s.AccountAnnouncementsHandler))
+			r.Post("/account/post-by-email", w.Wrap(s.AccountPostByEmailHandler))
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(s.RequireAdmin)
+			r.Get("/admin", w.Wrap(s.AdminPage))
+			r.Get("/admin/broadcasts/new", w.Wrap(s.NewBroadcastPage))
+			r.Post("/admin/broadcasts/preview", w.Wrap(s.BroadcastPreviewHandler))
+			r.Post("/admin/broadcasts", w.Wrap(s.BroadcastSendHandler))
+			r.Post("/admin/users/{id}/deliverable", w.Wrap(s.MarkDeliverableHandler))
+		})
+
+		if s.config.Core.Development {
+			r.Get("/dev/reload", w.Wrap(s.DevReloadHandler))
+			r.Get("/dev/emails", w.Wrap(s.DevEmailsPage))
+			r.Get("/dev/emails/{name}", w.Wrap(s.DevEmailPage))
+			r.Post("/dev/emails/{name}/send", w.Wrap(s.DevEmailSendHandler))
+		}
+
 		r.NotFound(w.Wrap(s.NotFound))
 	})
 
@@ -81,20 +277,37 @@ func (s *Site) Routes() http.Handler {
 }
 
 func (s *Site) BaseContext(r *http.Request) templates.Context {
+	usr := ExtractUser(r)
+
 	return templates.Context{
-		"current_user": nil,
+		"current_user": usr,
+		"is_admin":     usr != nil && s.config.Core.IsAdmin(usr.NetID),
+		"dev_reload":   s.config.Core.Development,
+		"locale":       Locale(r),
 	}
 }
 
+// Render into a buffer first, so a template that fails halfway sends nothing
+// and the caller can still respond with an error page.
 func (s *Site) Render(w http.ResponseWriter, statusCode int, base, name string, params templates.Context) error {
-	err := s.templates.Execute(name, w, base, params)
-	if err != nil {
-		return err
+	buf := templates.GetBuffer()
+	defer templates.PutBuffer(buf)
+
+	if err := s.templates.Execute(name, buf, base, params); err != nil {
+		return fmt.Errorf("error rendering %s: %w", name, err)
 	}
 
-	w.Header().Set("Content-Type", "text/html")
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
 	w.WriteHeader(statusCode)
 
+	// Headers are gone, all that's left to go wrong is the client leaving.
+	if _, err := buf.WriteTo(w); err != nil {
+		slog.Debug("error writing response", "template", name, "error", err)
+	}
+
 	return nil
 }
 
@@ -103,15 +316,5 @@ func (s *Site) RenderPlain(w http.ResponseWriter, statusCode int, name string, p
 }
 
 func (s *Site) Static() http.Handler {
-	if s.config.Core.Development {
-		return http.StripPrefix("/static", http.FileServer(http.Dir("./site/static")))
-	}
-
-	sub, err := fs.Sub(embedFS, "static")
-	if err != nil {
-		slog.Error("no static dir in mainsite", "error", err)
-		os.Exit(1)
-	}
-
-	return http.StripPrefix("/static", http.FileServer(http.FS(sub)))
+	return s.assets
 }
@@ -0,0 +1,81 @@
+package site
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"uwece.ca/app/sitemap"
+)
+
+// Pages on the main site that are only useful signed in.
+var privatePaths = []string{"/account", "/admin", "/dev/", "/logout", "/new-blog", "/site", "/unsubscribe/"}
+
+func (s *Site) RobotsHandler(w http.ResponseWriter, r *http.Request) error {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, p := range privatePaths {
+		fmt.Fprintf(&b, "Disallow: %s\n", p)
+	}
+	fmt.Fprintf(&b, "\nSitemap: %s/sitemap.xml\n", s.config.Core.BaseURL())
+
+	return writeText(w, b.String())
+}
+
+// Lists the sitemap of every public blog.
+func (s *Site) SitemapIndexHandler(w http.ResponseWriter, r *http.Request) error {
+	urls, err := s.blogs.SitemapIndex(r.Context())
+	if err != nil {
+		return err
+	}
+
+	body, err := sitemap.Index(urls)
+	if err != nil {
+		return fmt.Errorf("error encoding sitemap index: %w", err)
+	}
+
+	return serveSitemap(w, r, urls, body)
+}
+
+func (s *Site) BlogRobotsHandler(w http.ResponseWriter, r *http.Request) error {
+	blog := ExtractPublicBlog(r)
+
+	return writeText(w, fmt.Sprintf("User-agent: *\nAllow: /\n\nSitemap: %s/sitemap.xml\n", s.config.Core.SiteURL(blog.Subdomain)))
+}
+
+func (s *Site) BlogSitemapHandler(w http.ResponseWriter, r *http.Request) error {
+	urls, err := s.posts.Sitemap(r.Context(), *ExtractPublicBlog(r))
+	if err != nil {
+		return err
+	}
+
+	body, err := sitemap.URLSet(urls)
+	if err != nil {
+		return fmt.Errorf("error encoding sitemap: %w", err)
+	}
+
+	return serveSitemap(w, r, urls, body)
+}
+
+// Hosts without a public blog (unverified, or never created) ask not to be
+// indexed at all.
+func noindexRobots(w http.ResponseWriter) error {
+	return writeText(w, "User-agent: *\nDisallow: /\n")
+}
+
+func serveSitemap(w http.ResponseWriter, r *http.Request, urls []sitemap.URL, body []byte) error {
+	w.Header().Set("Content-Type", sitemap.ContentType)
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	http.ServeContent(w, r, "sitemap.xml", sitemap.Latest(urls), bytes.NewReader(body))
+	return nil
+}
+
+func writeText(w http.ResponseWriter, text string) error {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+
+	_, err := w.Write([]byte(text))
+	return err
+}
@@ -0,0 +1,97 @@
+// Package sitemap writes sitemaps and sitemap indexes, per the protocol at
+// https://www.sitemaps.org/protocol.html.
+package sitemap
+
+import (
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+const ContentType = "application/xml; charset=utf-8"
+
+// The most a single sitemap or index may list.
+const MaxURLs = 50_000
+
+const namespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// A page in a sitemap, or a sitemap in an index. Loc must be absolute.
+type URL struct {
+	Loc string
+	// Left out when zero.
+	LastMod time.Time
+}
+
+type entry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type urlSet struct {
+	XMLName xml.Name `xml:"urlset"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	URLs    []entry  `xml:"url"`
+}
+
+type index struct {
+	XMLName  xml.Name `xml:"sitemapindex"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	Sitemaps []entry  `xml:"sitemap"`
+}
+
+// A sitemap listing pages.
+func URLSet(urls []URL) ([]byte, error) {
+	entries, err := toEntries(urls)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshal(urlSet{XMLNS: namespace, URLs: entries})
+}
+
+// A sitemap index listing other sitemaps.
+func Index(sitemaps []URL) ([]byte, error) {
+	entries, err := toEntries(sitemaps)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshal(index{XMLNS: namespace, Sitemaps: entries})
+}
+
+func toEntries(urls []URL) ([]entry, error) {
+	if len(urls) > MaxURLs {
+		return nil, fmt.Errorf("sitemap has %d urls, the limit is %d", len(urls), MaxURLs)
+	}
+
+	entries := make([]entry, len(urls))
+	for i, u := range urls {
+		entries[i].Loc = u.Loc
+		if !u.LastMod.IsZero() {
+			entries[i].LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+	}
+
+	return entries, nil
+}
+
+// The newest LastMod, for the Last-Modified header.
+func Latest(urls []URL) time.Time {
+	var latest time.Time
+	for _, u := range urls {
+		if u.LastMod.After(latest) {
+			latest = u.LastMod
+		}
+	}
+
+	return latest
+}
+
+func marshal(doc any) ([]byte, error) {
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
@@ -0,0 +1,80 @@
+package sitemap_test
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/sitemap"
+)
+
+const namespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type entry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+func TestURLSet(t *testing.T) {
+	t.Parallel()
+
+	mod := time.Date(2025, 3, 1, 12, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	out, err := sitemap.URLSet([]sitemap.URL{
+		{Loc: "https://goose.28.uwece.ca/", LastMod: mod},
+		{Loc: "https://goose.28.uwece.ca/posts/fish?a=1&b=2"},
+	})
+	require.NoError(t, err)
+
+	var doc struct {
+		XMLName xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+		URLs    []entry  `xml:"url"`
+	}
+	require.NoError(t, xml.Unmarshal(out, &doc))
+	require.Equal(t, namespace, doc.XMLName.Space)
+
+	require.Len(t, doc.URLs, 2)
+	require.Equal(t, "https://goose.28.uwece.ca/", doc.URLs[0].Loc)
+	// W3C datetime, in UTC.
+	require.Equal(t, "2025-03-01T17:00:00Z", doc.URLs[0].LastMod)
+
+	// Entities are escaped, and a zero time leaves lastmod out.
+	require.Equal(t, "https://goose.28.uwece.ca/posts/fish?a=1&b=2", doc.URLs[1].Loc)
+	require.Empty(t, doc.URLs[1].LastMod)
+	require.NotContains(t, string(out), "<lastmod></lastmod>")
+}
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+
+	out, err := sitemap.Index([]sitemap.URL{
+		{Loc: "https://goose.28.uwece.ca/sitemap.xml"},
+		{Loc: "https://duck.27.uwece.ca/sitemap.xml"},
+	})
+	require.NoError(t, err)
+
+	var doc struct {
+		XMLName  xml.Name `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+		Sitemaps []entry  `xml:"sitemap"`
+	}
+	require.NoError(t, xml.Unmarshal(out, &doc))
+	require.Len(t, doc.Sitemaps, 2)
+	require.Equal(t, "https://duck.27.uwece.ca/sitemap.xml", doc.Sitemaps[1].Loc)
+}
+
+func TestTooManyURLs(t *testing.T) {
+	t.Parallel()
+
+	_, err := sitemap.URLSet(make([]sitemap.URL, sitemap.MaxURLs+1))
+	require.Error(t, err)
+}
+
+func TestLatest(t *testing.T) {
+	t.Parallel()
+
+	older := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	require.True(t, sitemap.Latest(nil).IsZero())
+	require.Equal(t, newer, sitemap.Latest([]sitemap.URL{{LastMod: older}, {LastMod: newer}, {}}))
+}
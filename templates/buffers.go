@@ -0,0 +1,30 @@
+package templates
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Buffers bigger than this are left for the garbage collector, so one huge
+// page doesn't pin its memory in the pool forever.
+const maxPooledBuffer = 64 << 10
+
+var buffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// An empty buffer to render into. Hand it back with PutBuffer once its
+// contents have been written out.
+func GetBuffer() *bytes.Buffer {
+	buf, _ := buffers.Get().(*bytes.Buffer)
+	return buf
+}
+
+func PutBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+
+	buf.Reset()
+	buffers.Put(buf)
+}
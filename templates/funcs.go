@@ -0,0 +1,195 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"uwece.ca/app/config"
+	"uwece.ca/app/i18n"
+	"uwece.ca/app/utils"
+)
+
+// The default layout for the date function.
+const dateLayout = "January 2, 2006"
+
+// The functions every template can use. Site and mailer templates are both
+// built with this, so a template behaves the same wherever it's rendered. Add
+// to the returned map to register more before creating the templates.
+func Library(cfg config.Core) template.FuncMap {
+	baseURL := cfg.BaseURL()
+	loc := cfg.Location()
+
+	return template.FuncMap{
+		"date":     formatDate,
+		"markdown": utils.RenderMarkdown,
+		"asset":    asset,
+		"route":    route,
+		"url": func(pattern string, args ...any) (string, error) {
+			path, err := route(pattern, args...)
+			if err != nil {
+				return "", err
+			}
+
+			return baseURL + path, nil
+		},
+		"local": func(t any) (*time.Time, error) {
+			return localTime(t, loc)
+		},
+		"truncate": truncate,
+		"bytes":    formatBytes,
+		"json":     toJSON,
+		"lower":    strings.ToLower,
+		"upper":    strings.ToUpper,
+		"t":        i18n.T,
+	}
+}
+
+var (
+	errBadFuncReturn = errors.New("must return one value, or a value and an error")
+	funcName         = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	errorType        = reflect.TypeFor[error]()
+)
+
+// Check every entry is something html/template can call, so a bad
+// registration fails at startup with an error instead of a panic.
+func ValidateFuncs(funcs template.FuncMap) error {
+	for name, fn := range funcs {
+		if !funcName.MatchString(name) {
+			return fmt.Errorf("template func %q: invalid name", name)
+		}
+
+		v := reflect.ValueOf(fn)
+		if v.Kind() != reflect.Func || v.IsNil() {
+			return fmt.Errorf("template func %q: not a function", name)
+		}
+
+		switch t := v.Type(); {
+		case t.NumOut() == 1:
+		case t.NumOut() == 2 && t.Out(1) == errorType:
+		default:
+			return fmt.Errorf("template func %q: %w", name, errBadFuncReturn)
+		}
+	}
+
+	return nil
+}
+
+// Accepts a time.Time or *time.Time, nil gives "". Optionally takes a Go
+// time layout: {{ date .CreatedAt "2006-01-02" }}.
+func formatDate(t any, layout ...string) (string, error) {
+	tm, ok, err := toTime(t)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	l := dateLayout
+	if len(layout) > 0 {
+		l = layout[0]
+	}
+
+	return tm.Format(l), nil
+}
+
+// The time in loc, so it reads the way users type times in. Accepts the same
+// as date, nil gives nil.
+func localTime(t any, loc *time.Location) (*time.Time, error) {
+	tm, ok, err := toTime(t)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	tm = tm.In(loc)
+	return &tm, nil
+}
+
+func toTime(t any) (time.Time, bool, error) {
+	switch t := t.(type) {
+	case time.Time:
+		return t, true, nil
+	case *time.Time:
+		if t == nil {
+			return time.Time{}, false, nil
+		}
+		return *t, true, nil
+	case nil:
+		return time.Time{}, false, nil
+	}
+
+	return time.Time{}, false, fmt.Errorf("expected a time, got %T", t)
+}
+
+// A file size like 1.5 MB, in powers of 1024.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// The url of a file in site/static.
+func asset(name string) string {
+	return "/static/" + strings.TrimPrefix(name, "/")
+}
+
+var routeParam = regexp.MustCompile(`\{[^}]+\}`)
+
+// Fill in a chi style pattern in order: {{ route "/dev/emails/{name}" .name }}.
+// Values are path escaped.
+func route(pattern string, args ...any) (string, error) {
+	params := routeParam.FindAllStringIndex(pattern, -1)
+	if len(params) != len(args) {
+		return "", fmt.Errorf("route %s takes %d values, got %d", pattern, len(params), len(args))
+	}
+
+	var b strings.Builder
+	last := 0
+	for i, loc := range params {
+		b.WriteString(pattern[last:loc[0]])
+		b.WriteString(url.PathEscape(fmt.Sprint(args[i])))
+		last = loc[1]
+	}
+	b.WriteString(pattern[last:])
+
+	return b.String(), nil
+}
+
+// Shorten s to at most n characters, ending in an ellipsis when cut. Takes
+// the string last so it works in a pipeline: {{ .Body | truncate 80 }}.
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
+
+// Embed a value as JSON, in a script or an attribute like hx-vals.
+func toJSON(v any) (template.JS, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	// json.Marshal escapes <, > and &, so this can't close a script tag.
+	return template.JS(b), nil //nolint:gosec // see above
+}
@@ -0,0 +1,98 @@
+package templates_test
+
+import (
+	"bytes"
+	"html/template"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/config"
+	"uwece.ca/app/templates"
+)
+
+func render(t *testing.T, src string, data any) string {
+	t.Helper()
+
+	tmpl, err := template.New("test").Funcs(funcs).Parse(src)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, tmpl.Execute(&buf, data))
+
+	return buf.String()
+}
+
+func TestLibraryDates(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2025, time.March, 4, 15, 30, 0, 0, time.UTC)
+	var missing *time.Time
+
+	require.Equal(t, "March 4, 2025", render(t, `{{ date . }}`, created))
+	require.Equal(t, "2025-03-04 15:30", render(t, `{{ date . "2006-01-02 15:04" }}`, &created))
+	require.Equal(t, "", render(t, `{{ date . }}`, missing))
+	require.Equal(t, "2025-03-04 15:30", render(t, `{{ date (local .) "2006-01-02 15:04" }}`, created))
+	require.Equal(t, "", render(t, `{{ date (local .) }}`, missing))
+}
+
+func TestLibraryLocalTime(t *testing.T) {
+	t.Parallel()
+
+	lib := templates.Library(config.Core{BaseDomain: "uwece.ca", TimeZone: "America/Toronto"})
+	tmpl, err := template.New("test").Funcs(lib).Parse(`{{ date (local .) "2006-01-02 15:04" }}`)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, tmpl.Execute(&buf, time.Date(2025, time.March, 4, 15, 30, 0, 0, time.UTC)))
+	require.Equal(t, "2025-03-04 10:30", buf.String())
+}
+
+func TestLibraryText(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "Hello,…", render(t, `{{ . | truncate 7 }}`, "Hello, world"))
+	require.Equal(t, "Hello", render(t, `{{ . | truncate 7 }}`, "Hello"))
+	require.Equal(t, "café…", render(t, `{{ . | truncate 5 }}`, "cafés and more"))
+	require.Equal(t, "goose GOOSE", render(t, `{{ lower . }} {{ upper . }}`, "Goose"))
+	require.Equal(t, "512 B, 1.5 KB, 100.0 MB", render(t, `{{ bytes 512 }}, {{ bytes 1536 }}, {{ bytes . }}`, int64(100<<20)))
+}
+
+func TestLibraryMarkdown(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "<p><strong>hi</strong> <!-- raw HTML omitted --></p>\n", render(t, `{{ markdown . }}`, "**hi** <b>"))
+}
+
+func TestLibraryURLs(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "/static/htmx.min.js", render(t, `{{ asset "htmx.min.js" }}`, nil))
+	require.Equal(t, "/dev/emails/a%2Fb", render(t, `{{ route "/dev/emails/{name}" . }}`, "a/b"))
+	require.Equal(t, "https://uwece.ca/admin/users/7/deliverable",
+		render(t, `{{ url "/admin/users/{id}/deliverable" . }}`, 7))
+
+	tmpl := template.Must(template.New("test").Funcs(funcs).Parse(`{{ route "/users/{id}" }}`))
+	require.Error(t, tmpl.Execute(&bytes.Buffer{}, nil))
+}
+
+func TestLibraryJSON(t *testing.T) {
+	t.Parallel()
+
+	data := map[string]string{"Name": "</script>"}
+
+	require.Equal(t, `<script>const x = {"Name":"\u003c/script\u003e"};</script>`,
+		render(t, `<script>const x = {{ json . }};</script>`, data))
+	require.Equal(t, `<div hx-vals='{&#34;Name&#34;:&#34;\u003c/script\u003e&#34;}'></div>`,
+		render(t, `<div hx-vals='{{ json . }}'></div>`, data))
+}
+
+func TestValidateFuncs(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, templates.ValidateFuncs(funcs))
+	require.Error(t, templates.ValidateFuncs(template.FuncMap{"bad-name": func() string { return "" }}))
+	require.Error(t, templates.ValidateFuncs(template.FuncMap{"notFunc": "x"}))
+	require.Error(t, templates.ValidateFuncs(template.FuncMap{"twoValues": func() (string, string) { return "", "" }}))
+	require.Error(t, templates.ValidateFuncs(template.FuncMap{"noValues": func() {}}))
+}
@@ -1,8 +1,8 @@
 package templates
 
 import (
-	"bytes"
 	"embed"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
@@ -12,202 +12,413 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
 )
 
+// How long to wait for a burst of file events (an editor saving, a git
+// checkout) to settle before rebuilding.
+const watchDebounce = 50 * time.Millisecond
+
 type Templates struct {
-	mu      sync.RWMutex
-	t       map[string]*template.Template
-	embedFS embed.FS
-	dev     bool
-	path    string
+	mu    sync.RWMutex
+	t     map[string]*template.Template
+	fsys  fs.FS
+	funcs template.FuncMap
+
+	// Only set for dev templates.
+	watcher *fsnotify.Watcher
+
+	subsMu sync.Mutex
+	subs   map[chan struct{}]struct{}
+}
+
+// Parse the templates in embedFS, which can call any of funcs (usually
+// Library).
+func NewTemplates(embedFS embed.FS, funcs template.FuncMap) *Templates {
+	sub, err := fs.Sub(embedFS, "templates")
+	if err != nil {
+		slog.Error("no templates dir in embedded fs", "error", err)
+		os.Exit(1)
+	}
+
+	return newTemplates(sub, funcs)
 }
 
-func NewTemplates(fs embed.FS) *Templates {
+// Templates read from path on disk instead of the embedded copy. Whenever a
+// file under path changes the templates it affects are rebuilt and
+// subscribers are told about it.
+func NewDevTemplates(path string, funcs template.FuncMap) *Templates {
+	p := newTemplates(os.DirFS(path), funcs)
+
+	if err := p.watch(path); err != nil {
+		slog.Warn("not watching templates for changes", "path", path, "error", err)
+	}
+
+	return p
+}
+
+func newTemplates(fsys fs.FS, funcs template.FuncMap) *Templates {
 	p := &Templates{
-		mu:      sync.RWMutex{},
-		t:       make(map[string]*template.Template),
-		embedFS: fs,
-		dev:     false,
-		path:    "",
+		mu:    sync.RWMutex{},
+		t:     make(map[string]*template.Template),
+		fsys:  fsys,
+		funcs: funcs,
+		subs:  make(map[chan struct{}]struct{}),
 	}
 
-	p.loadAllTemplates()
+	if err := ValidateFuncs(funcs); err != nil {
+		slog.Error("invalid template functions", "error", err)
+		os.Exit(1)
+	}
+
+	if err := p.loadAllTemplates(); err != nil {
+		slog.Error("failed setting up templates", "error", err)
+		os.Exit(1)
+	}
 
 	return p
 }
 
-func NewDevTemplates(fs embed.FS, path string) *Templates {
-	tmpl := NewTemplates(fs)
+// The .html files in the templates fs, by what they are used for.
+type templateFiles struct {
+	layouts   []string
+	fragments []string
+	pages     []string
+}
 
-	tmpl.dev = true
-	tmpl.path = path
+func isFragment(path string) bool {
+	return strings.Contains(path, "fragments/")
+}
 
-	return tmpl
+// Anything under layouts/, or a layout.html anywhere (like a blog theme's).
+func isLayout(path string) bool {
+	return strings.HasPrefix(path, "layouts/") || path == "layout.html" || strings.HasSuffix(path, "/layout.html")
 }
 
-func (p *Templates) loadAllTemplates() {
-	templates := make(map[string]*template.Template)
-	var fragmentPaths []string
+func templateName(path string) string {
+	return strings.TrimSuffix(path, ".html")
+}
 
-	err := fs.WalkDir(p.embedFS, "templates", func(path string, d fs.DirEntry, err error) error {
+func (p *Templates) listFiles() (templateFiles, error) {
+	var files templateFiles
+
+	err := fs.WalkDir(p.fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if d.IsDir() {
+		if d.IsDir() || !strings.HasSuffix(path, ".html") {
 			return nil
 		}
-		if !strings.HasSuffix(path, ".html") {
-			return nil
-		}
-		if !strings.Contains(path, "fragments/") {
-			return nil
-		}
-		name := strings.TrimPrefix(path, "templates/")
-		name = strings.TrimSuffix(name, ".html")
-		tmpl, err := template.New(name).ParseFS(p.embedFS, path)
-		if err != nil {
-			slog.Error("failed setting up template fragment", "error", err)
-			os.Exit(1)
+
+		switch {
+		case isFragment(path):
+			files.fragments = append(files.fragments, path)
+		case isLayout(path):
+			files.layouts = append(files.layouts, path)
+		default:
+			files.pages = append(files.pages, path)
 		}
-		templates[name] = tmpl
-		fragmentPaths = append(fragmentPaths, path)
-		slog.Debug("loaded fragment", "name", name)
+
 		return nil
 	})
 	if err != nil {
-		slog.Error("failed walking template dir for fragments", "error", err)
-		os.Exit(1)
+		return templateFiles{}, fmt.Errorf("error walking template dir: %w", err)
 	}
 
-	err = fs.WalkDir(p.embedFS, "templates", func(path string, d fs.DirEntry, err error) error {
+	return files, nil
+}
+
+// Fragments are parsed on their own, pages with every layout and fragment.
+func (p *Templates) parse(path string, files templateFiles) (*template.Template, error) {
+	var paths []string
+	if !isFragment(path) {
+		paths = append(paths, files.layouts...)
+		paths = append(paths, files.fragments...)
+	}
+	paths = append(paths, path)
+
+	tmpl, err := template.New(templateName(path)).Funcs(p.funcs).ParseFS(p.fsys, paths...)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing template %s: %w", path, err)
+	}
+
+	return tmpl, nil
+}
+
+func (p *Templates) loadAllTemplates() error {
+	files, err := p.listFiles()
+	if err != nil {
+		return err
+	}
+
+	templates := make(map[string]*template.Template)
+	for _, path := range append(files.fragments, files.pages...) {
+		tmpl, err := p.parse(path, files)
 		if err != nil {
 			return err
 		}
-		if d.IsDir() {
-			return nil
-		}
-		if !strings.HasSuffix(path, ".html") {
-			return nil
-		}
-		if strings.Contains(path, "fragments/") {
-			return nil
+
+		templates[templateName(path)] = tmpl
+		slog.Debug("loaded template", "name", templateName(path))
+	}
+
+	slog.Debug("templates loaded", "count", len(templates))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.t = templates
+
+	return nil
+}
+
+// Rebuild the templates affected by the changed paths: a page only needs
+// itself, while every page includes the layouts and fragments. A nil changed
+// rebuilds everything. Templates that fail to parse keep their last good
+// version so a half-written file doesn't take the site down.
+func (p *Templates) reload(changed []string) error {
+	files, err := p.listFiles()
+	if err != nil {
+		return err
+	}
+
+	present := make(map[string]bool)
+	for _, path := range append(files.fragments, files.pages...) {
+		present[path] = true
+	}
+
+	affected := make(map[string]bool)
+	for _, path := range changed {
+		if isLayout(path) || isFragment(path) {
+			for _, page := range files.pages {
+				affected[page] = true
+			}
 		}
-		if strings.Contains(path, "layouts/") {
-			return nil
+		if present[path] {
+			affected[path] = true
 		}
-		name := strings.TrimPrefix(path, "templates/")
-		name = strings.TrimSuffix(name, ".html")
-
-		templatePaths := []string{}
-		templatePaths = append(templatePaths, "templates/layouts/*.html")
-		templatePaths = append(templatePaths, fragmentPaths...)
-		templatePaths = append(templatePaths, path)
+	}
+	if changed == nil {
+		affected = present
+	}
 
-		tmpl, err := template.New(name).ParseFS(p.embedFS, templatePaths...)
+	rebuilt := make(map[string]*template.Template)
+	var errs []error
+	for path := range affected {
+		tmpl, err := p.parse(path, files)
 		if err != nil {
-			slog.Error("failed setting up template", "error", err)
-			os.Exit(1)
+			errs = append(errs, err)
+			continue
 		}
-		templates[name] = tmpl
-		slog.Debug("loaded template", "name", name)
-		return nil
-	})
-	if err != nil {
-		slog.Error("failed walking template dir", "error", err)
-		os.Exit(1)
+
+		rebuilt[templateName(path)] = tmpl
 	}
 
-	slog.Debug("templates loaded", "count", len(templates))
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.t = templates
+	for name := range p.t {
+		if !present[name+".html"] {
+			delete(p.t, name)
+		}
+	}
+	for name, tmpl := range rebuilt {
+		p.t[name] = tmpl
+	}
+	p.mu.Unlock()
+
+	slog.Debug("templates reloaded", "count", len(rebuilt))
+
+	return errors.Join(errs...)
 }
 
-func (p *Templates) loadOneFromDisk(path string) error {
-	tmplPath := filepath.Join(p.path, path+".html")
-	if _, err := os.Stat(tmplPath); os.IsNotExist(err) {
-		return fmt.Errorf("template file not found on disk: %w", err)
+func (p *Templates) watch(root string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating watcher: %w", err)
 	}
 
-	var fragmentPaths []string
-	err := fs.WalkDir(p.embedFS, "templates", func(path string, d fs.DirEntry, err error) error {
+	// fsnotify isn't recursive, every directory needs adding.
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 		if d.IsDir() {
-			return nil
+			return watcher.Add(path)
 		}
-		if !strings.HasSuffix(path, ".html") {
-			return nil
-		}
-		if !strings.Contains(path, "fragments/") {
-			return nil
-		}
-		name := strings.TrimPrefix(path, "templates/")
-		name = strings.TrimSuffix(name, ".html")
-		fragmentPaths = append(fragmentPaths, path)
+
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("error loading fragments from disk: %w", err)
+		watcher.Close()
+		return fmt.Errorf("error watching template dir: %w", err)
 	}
 
-	tmpl := template.New(path)
+	p.watcher = watcher
+	go p.watchLoop(root, watcher)
 
-	glob := filepath.Join(p.path, "layouts", "*.html")
-	layouts, err := filepath.Glob(glob)
-	if err != nil {
-		return fmt.Errorf("error finding layout templates: %w", err)
+	return nil
+}
+
+func (p *Templates) watchLoop(root string, watcher *fsnotify.Watcher) {
+	var (
+		pending = make(map[string]bool)
+		all     bool
+		timer   = time.NewTimer(watchDebounce)
+	)
+	timer.Stop()
+
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				timer.Stop()
+				return
+			}
+
+			rel, err := filepath.Rel(root, ev.Name)
+			if err != nil {
+				continue
+			}
+			rel = filepath.ToSlash(rel)
+
+			if ev.Has(fsnotify.Create) {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					// Files moved in with the directory won't get their own events.
+					if err := watcher.Add(ev.Name); err != nil {
+						slog.Warn("error watching template dir", "path", ev.Name, "error", err)
+					}
+					all = true
+				}
+			}
+
+			if strings.HasSuffix(rel, ".html") {
+				pending[rel] = true
+			} else if filepath.Ext(rel) == "" && (ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename)) {
+				// Probably a directory, which might have held templates.
+				all = true
+			} else if !all {
+				continue
+			}
+
+			timer.Reset(watchDebounce)
+
+		case <-timer.C:
+			var changed []string
+			if !all {
+				for path := range pending {
+					changed = append(changed, path)
+				}
+			}
+			pending = make(map[string]bool)
+			all = false
+
+			if err := p.reload(changed); err != nil {
+				slog.Warn("error reloading templates", "error", err)
+			}
+			p.notify()
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+
+			slog.Warn("template watcher error", "error", err)
+		}
 	}
+}
 
-	files := append(layouts, fragmentPaths...)
-	files = append(files, tmplPath)
+// Get a value on the returned channel after templates are rebuilt. Call the
+// returned func to stop listening. The channel is closed when the templates
+// are closed.
+func (p *Templates) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
 
-	tmpl, err = tmpl.ParseFiles(files...)
-	if err != nil {
-		return fmt.Errorf("error parsing template files: %w", err)
+	p.subsMu.Lock()
+	p.subs[ch] = struct{}{}
+	p.subsMu.Unlock()
+
+	return ch, func() {
+		p.subsMu.Lock()
+		defer p.subsMu.Unlock()
+
+		if _, ok := p.subs[ch]; ok {
+			delete(p.subs, ch)
+			close(ch)
+		}
 	}
+}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.t[path] = tmpl
-	slog.Debug("template loaded from disk", "name", path)
+func (p *Templates) notify() {
+	p.subsMu.Lock()
+	defer p.subsMu.Unlock()
 
-	return nil
+	for ch := range p.subs {
+		// A subscriber that hasn't caught up already has a reload waiting.
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Stop watching for changes and close every subscription.
+func (p *Templates) Close() error {
+	var err error
+	if p.watcher != nil {
+		err = p.watcher.Close()
+	}
+
+	p.subsMu.Lock()
+	defer p.subsMu.Unlock()
+
+	for ch := range p.subs {
+		delete(p.subs, ch)
+		close(ch)
+	}
+
+	return err
 }
 
 func (p *Templates) ExecutePlain(name string, w io.Writer, params any) error {
-	return p.executeReload(name, w, "", params)
+	return p.execute(name, w, "", params)
 }
 
 func (p *Templates) Execute(name string, w io.Writer, base string, params any) error {
-	return p.executeReload(name, w, base, params)
+	return p.execute(name, w, base, params)
 }
 
 func (p *Templates) ExecuteString(name string, base string, params any) (string, error) {
-	var buffer bytes.Buffer
-	if err := p.Execute(name, &buffer, base, params); err != nil {
-		return "", nil
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+
+	if err := p.Execute(name, buf, base, params); err != nil {
+		return "", err
 	}
 
-	return buffer.String(), nil
+	return buf.String(), nil
 }
 
 func (p *Templates) ExecutePlainString(name string, params any) (string, error) {
 	return p.ExecuteString(name, "", params)
 }
 
-func (p *Templates) executeReload(name string, w io.Writer, base string, params any) error {
-	if p.dev {
-		if err := p.loadOneFromDisk(name); err != nil {
-			slog.Warn("error loading template from disk", "error", err)
-		}
-	}
-
+// Reports whether the template name has a nested definition called def.
+func (p *Templates) Defines(name, def string) bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
 	tmpl, ok := p.t[name]
+	if !ok {
+		return false
+	}
+
+	return tmpl.Lookup(def) != nil
+}
+
+func (p *Templates) execute(name string, w io.Writer, base string, params any) error {
+	p.mu.RLock()
+	tmpl, ok := p.t[name]
+	p.mu.RUnlock()
+
 	if !ok {
 		return fmt.Errorf("template not found: %s", name)
 	}
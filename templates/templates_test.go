@@ -3,18 +3,25 @@ package templates_test
 import (
 	"bytes"
 	"embed"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"uwece.ca/app/config"
 	"uwece.ca/app/templates"
 )
 
 //go:embed templates/*
 var embedFS embed.FS
 
+var funcs = templates.Library(config.Core{BaseDomain: "uwece.ca"})
+
 func TestRenderFragment(t *testing.T) {
 	t.Parallel()
-	templ := templates.NewTemplates(embedFS)
+	templ := templates.NewTemplates(embedFS, funcs)
 	var data bytes.Buffer
 
 	err := templ.ExecutePlain("fragments/fragment", &data, "Hallo")
@@ -25,7 +32,7 @@ func TestRenderFragment(t *testing.T) {
 
 func TestRenderPage(t *testing.T) {
 	t.Parallel()
-	templ := templates.NewTemplates(embedFS)
+	templ := templates.NewTemplates(embedFS, funcs)
 	var data bytes.Buffer
 
 	err := templ.Execute("home", &data, "layouts/base", nil)
@@ -33,3 +40,114 @@ func TestRenderPage(t *testing.T) {
 
 	require.Equal(t, "\n<h1>Base Layout</h1>\n\n<h1>Hallo</h1>\n\n", data.String())
 }
+
+func TestDefines(t *testing.T) {
+	t.Parallel()
+	templ := templates.NewTemplates(embedFS, funcs)
+
+	require.True(t, templ.Defines("home", "content"))
+	require.True(t, templ.Defines("home", "layouts/base"))
+	require.False(t, templ.Defines("home", "title"))
+	require.False(t, templ.Defines("missing", "content"))
+}
+
+func devTemplates(t *testing.T) (*templates.Templates, string) {
+	t.Helper()
+
+	sub, err := fs.Sub(embedFS, "templates")
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	require.NoError(t, os.CopyFS(dir, sub))
+
+	templ := templates.NewDevTemplates(dir, funcs)
+	t.Cleanup(func() { templ.Close() })
+
+	return templ, dir
+}
+
+func writeAndWait(t *testing.T, templ *templates.Templates, path, content string) {
+	t.Helper()
+
+	changed, stop := templ.Subscribe()
+	defer stop()
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("templates were not reloaded")
+	}
+}
+
+func TestDevTemplatesReloadFragments(t *testing.T) {
+	t.Parallel()
+	templ, dir := devTemplates(t)
+
+	writeAndWait(t, templ, filepath.Join(dir, "fragments", "fragment.html"),
+		`{{ define "fragments/fragment" }}<p>Changed: {{ . }}</p>{{ end }}`)
+
+	var data bytes.Buffer
+	require.NoError(t, templ.ExecutePlain("fragments/fragment", &data, "Hallo"))
+	require.Equal(t, "<p>Changed: Hallo</p>", data.String())
+}
+
+func TestDevTemplatesReloadPagesWhenLayoutChanges(t *testing.T) {
+	t.Parallel()
+	templ, dir := devTemplates(t)
+
+	writeAndWait(t, templ, filepath.Join(dir, "layouts", "base.html"),
+		`{{ define "layouts/base" }}<main>{{ block "content" . }}{{ end }}</main>{{ end }}`)
+
+	var data bytes.Buffer
+	require.NoError(t, templ.Execute("home", &data, "layouts/base", nil))
+	require.Equal(t, "<main>\n<h1>Hallo</h1>\n</main>", data.String())
+}
+
+func TestDevTemplatesAddAndRemovePages(t *testing.T) {
+	t.Parallel()
+	templ, dir := devTemplates(t)
+
+	path := filepath.Join(dir, "public", "about.html")
+	writeAndWait(t, templ, path, `{{ define "content" }}About{{ end }}`)
+	require.True(t, templ.Defines("public/about", "content"))
+
+	changed, stop := templ.Subscribe()
+	defer stop()
+	require.NoError(t, os.Remove(path))
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("templates were not reloaded")
+	}
+	require.False(t, templ.Defines("public/about", "content"))
+}
+
+func TestDevTemplatesKeepLastGoodVersion(t *testing.T) {
+	t.Parallel()
+	templ, dir := devTemplates(t)
+
+	writeAndWait(t, templ, filepath.Join(dir, "home.html"), `{{ define "content" }}{{ if }}{{ end }}`)
+
+	var data bytes.Buffer
+	require.NoError(t, templ.Execute("home", &data, "layouts/base", nil))
+	require.Contains(t, data.String(), "<h1>Hallo</h1>")
+}
+
+func TestExecuteStringReturnsErrors(t *testing.T) {
+	t.Parallel()
+	templ := templates.NewTemplates(embedFS, funcs)
+
+	out, err := templ.ExecuteString("home", "layouts/base", nil)
+	require.NoError(t, err)
+	require.Contains(t, out, "<h1>Hallo</h1>")
+
+	_, err = templ.ExecuteString("missing", "layouts/base", nil)
+	require.Error(t, err)
+
+	_, err = templ.ExecuteString("home", "layouts/missing", nil)
+	require.Error(t, err)
+}
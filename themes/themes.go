@@ -0,0 +1,243 @@
+// Package themes reads the blog themes shipped with the site. A theme is a
+// directory holding a theme.json manifest, a layout.html that defines
+// "themes/<directory name>", a style.css and a preview image, so adding one
+// needs no Go changes. Layouts should include "fragments/blog-head" in their
+// head for the stylesheet and feed links.
+package themes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"uwece.ca/app/i18n"
+)
+
+var ErrInvalidOption = errors.New("invalid theme option")
+
+const (
+	OptionColor  = "color"
+	OptionSelect = "select"
+)
+
+var (
+	themeID   = regexp.MustCompile(`^[a-z0-9-]+$`)
+	hexColour = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// Something the owner can change about a theme, exposed to its stylesheet as
+// the custom property --<id>.
+type Option struct {
+	ID      string `json:"id"`
+	Label   string `json:"label"`
+	Type    string `json:"type"`
+	Default string `json:"default"`
+	// The values allowed for a select.
+	Choices []string `json:"choices"`
+}
+
+func (o Option) Validate(value string) error {
+	switch o.Type {
+	case OptionColor:
+		if !hexColour.MatchString(value) {
+			return fmt.Errorf("%w: %w", ErrInvalidOption, i18n.NewError("validation.theme_colour", "option", o.Label))
+		}
+	case OptionSelect:
+		if !slices.Contains(o.Choices, value) {
+			return fmt.Errorf("%w: %w", ErrInvalidOption, i18n.NewError("validation.theme_choice", "option", o.Label, "choices", strings.Join(o.Choices, ", ")))
+		}
+	}
+
+	return nil
+}
+
+type Theme struct {
+	// The directory name.
+	ID          string   `json:"-"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Preview     string   `json:"preview"`
+	Options     []Option `json:"options"`
+	// Used for sites that haven't picked one.
+	Default bool `json:"default"`
+
+	Stylesheet   string `json:"-"`
+	PreviewImage []byte `json:"-"`
+	PreviewType  string `json:"-"`
+}
+
+// The layout template every blog page of this theme renders with.
+func (t Theme) Layout() string {
+	return "themes/" + t.ID
+}
+
+// Fill in defaults for missing options and check the rest. Options the theme
+// doesn't have are dropped, so switching themes doesn't fail on leftovers.
+func (t Theme) Resolve(values map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(t.Options))
+	for _, o := range t.Options {
+		v, ok := values[o.ID]
+		if !ok || v == "" {
+			resolved[o.ID] = o.Default
+			continue
+		}
+
+		if err := o.Validate(v); err != nil {
+			return nil, err
+		}
+		resolved[o.ID] = v
+	}
+
+	return resolved, nil
+}
+
+// The theme's stylesheet with its options set as custom properties on :root.
+// Values must have come through Resolve.
+func (t Theme) CSS(values map[string]string) string {
+	var b strings.Builder
+	b.WriteString(":root {\n")
+	for _, o := range t.Options {
+		fmt.Fprintf(&b, "\t--%s: %s;\n", o.ID, values[o.ID])
+	}
+	b.WriteString("}\n\n")
+	b.WriteString(t.Stylesheet)
+
+	return b.String()
+}
+
+type Registry struct {
+	themes []Theme
+	def    Theme
+}
+
+// Read every theme directory at the top of fsys. Any mistake in a manifest
+// is an error, so a broken theme fails at startup instead of on a blog.
+func Load(fsys fs.FS) (*Registry, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("error reading themes dir: %w", err)
+	}
+
+	r := &Registry{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		t, err := load(fsys, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error loading theme %s: %w", e.Name(), err)
+		}
+		r.themes = append(r.themes, t)
+	}
+
+	if len(r.themes) == 0 {
+		return nil, errors.New("no themes found")
+	}
+
+	r.def = r.themes[0]
+	defaults := 0
+	for _, t := range r.themes {
+		if t.Default {
+			r.def = t
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return nil, errors.New("more than one default theme")
+	}
+
+	return r, nil
+}
+
+func load(fsys fs.FS, id string) (Theme, error) {
+	if !themeID.MatchString(id) {
+		return Theme{}, errors.New("directory name must be lowercase letters, digits and dashes")
+	}
+
+	manifest, err := fs.ReadFile(fsys, path.Join(id, "theme.json"))
+	if err != nil {
+		return Theme{}, err
+	}
+
+	var t Theme
+	if err := json.Unmarshal(manifest, &t); err != nil {
+		return Theme{}, fmt.Errorf("error parsing theme.json: %w", err)
+	}
+	t.ID = id
+
+	if t.Name == "" {
+		return Theme{}, errors.New("theme.json needs a name")
+	}
+
+	if _, err := fs.Stat(fsys, path.Join(id, "layout.html")); err != nil {
+		return Theme{}, err
+	}
+
+	stylesheet, err := fs.ReadFile(fsys, path.Join(id, "style.css"))
+	if err != nil {
+		return Theme{}, err
+	}
+	t.Stylesheet = string(stylesheet)
+
+	if t.Preview != "" {
+		if t.PreviewImage, err = fs.ReadFile(fsys, path.Join(id, t.Preview)); err != nil {
+			return Theme{}, err
+		}
+		t.PreviewType = mime.TypeByExtension(path.Ext(t.Preview))
+	}
+
+	seen := make(map[string]bool)
+	for _, o := range t.Options {
+		if !themeID.MatchString(o.ID) || seen[o.ID] {
+			return Theme{}, fmt.Errorf("option id %q must be unique lowercase letters, digits and dashes", o.ID)
+		}
+		seen[o.ID] = true
+
+		if o.Type != OptionColor && o.Type != OptionSelect {
+			return Theme{}, fmt.Errorf("option %s has unknown type %q", o.ID, o.Type)
+		}
+		if o.Type == OptionSelect && len(o.Choices) == 0 {
+			return Theme{}, fmt.Errorf("option %s needs choices", o.ID)
+		}
+		if err := o.Validate(o.Default); err != nil {
+			return Theme{}, fmt.Errorf("option %s default: %w", o.ID, err)
+		}
+	}
+
+	return t, nil
+}
+
+// Every theme, ordered by directory name.
+func (r *Registry) List() []Theme {
+	return r.themes
+}
+
+func (r *Registry) Get(id string) (Theme, bool) {
+	for _, t := range r.themes {
+		if t.ID == id {
+			return t, true
+		}
+	}
+
+	return Theme{}, false
+}
+
+func (r *Registry) Default() Theme {
+	return r.def
+}
+
+// The theme with id, or the default when it's empty or has been removed.
+func (r *Registry) Lookup(id string) Theme {
+	if t, ok := r.Get(id); ok {
+		return t
+	}
+
+	return r.def
+}
@@ -0,0 +1,101 @@
+package themes_test
+
+import (
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/themes"
+)
+
+func theme(manifest string) fstest.MapFS {
+	return fstest.MapFS{
+		"plain/theme.json":  {Data: []byte(manifest)},
+		"plain/layout.html": {Data: []byte(`{{ define "themes/plain" }}{{ end }}`)},
+		"plain/style.css":   {Data: []byte("body { color: var(--accent); }\n")},
+		"plain/preview.svg": {Data: []byte("<svg></svg>")},
+	}
+}
+
+const manifest = `{
+	"name": "Plain",
+	"preview": "preview.svg",
+	"options": [
+		{ "id": "accent", "label": "Accent", "type": "color", "default": "#112233" },
+		{ "id": "font", "label": "Font", "type": "select", "default": "serif", "choices": ["serif", "sans-serif"] }
+	]
+}`
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	r, err := themes.Load(theme(manifest))
+	require.NoError(t, err)
+
+	plain, ok := r.Get("plain")
+	require.True(t, ok)
+	require.Equal(t, "Plain", plain.Name)
+	require.Equal(t, "themes/plain", plain.Layout())
+	require.Equal(t, "image/svg+xml", plain.PreviewType)
+	require.Len(t, plain.Options, 2)
+
+	// The only theme is the default, and unknown ids fall back to it.
+	require.Equal(t, "plain", r.Default().ID)
+	require.Equal(t, "plain", r.Lookup("removed").ID)
+}
+
+func TestLoadRejectsBadManifests(t *testing.T) {
+	t.Parallel()
+
+	for name, m := range map[string]string{
+		"no name":        `{}`,
+		"bad json":       `{`,
+		"bad type":       `{"name": "x", "options": [{"id": "a", "type": "number", "default": "1"}]}`,
+		"bad default":    `{"name": "x", "options": [{"id": "a", "type": "color", "default": "red"}]}`,
+		"no choices":     `{"name": "x", "options": [{"id": "a", "type": "select", "default": "a"}]}`,
+		"bad option id":  `{"name": "x", "options": [{"id": "A B", "type": "color", "default": "#000000"}]}`,
+		"missing images": `{"name": "x", "preview": "missing.png"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := themes.Load(theme(m))
+			require.Error(t, err)
+		})
+	}
+
+	fsys := theme(manifest)
+	delete(fsys, "plain/layout.html")
+	_, err := themes.Load(fsys)
+	require.Error(t, err)
+}
+
+func TestResolveAndCSS(t *testing.T) {
+	t.Parallel()
+
+	r, err := themes.Load(theme(manifest))
+	require.NoError(t, err)
+	plain := r.Default()
+
+	values, err := plain.Resolve(map[string]string{"font": "sans-serif", "gone": "x"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"accent": "#112233", "font": "sans-serif"}, values)
+
+	_, err = plain.Resolve(map[string]string{"accent": "red; } body { display: none"})
+	require.ErrorIs(t, err, themes.ErrInvalidOption)
+
+	_, err = plain.Resolve(map[string]string{"font": "comic-sans"})
+	require.ErrorIs(t, err, themes.ErrInvalidOption)
+
+	require.Equal(t, ":root {\n\t--accent: #112233;\n\t--font: sans-serif;\n}\n\nbody { color: var(--accent); }\n",
+		plain.CSS(values))
+}
+
+func TestBuiltInThemesLoad(t *testing.T) {
+	t.Parallel()
+
+	r, err := themes.Load(os.DirFS("../site/templates/themes"))
+	require.NoError(t, err)
+	require.True(t, r.Default().Default)
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed common-passwords.txt
+var commonPasswords string
+
+// A set of passwords nobody should be allowed to pick, stored as SHA-1 so
+// breach corpora published as hashes can be loaded alongside plaintext lists.
+type DenyList struct {
+	hashes map[string]struct{}
+}
+
+// Build a deny list from the built in common passwords and, if path is set, the file at path.
+func LoadDenyList(path string) (*DenyList, error) {
+	d := &DenyList{hashes: make(map[string]struct{})}
+
+	if err := d.read(strings.NewReader(commonPasswords)); err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		return d, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening password deny list: %w", err)
+	}
+	defer f.Close()
+
+	if err := d.read(f); err != nil {
+		return nil, fmt.Errorf("error reading password deny list: %w", err)
+	}
+
+	return d, nil
+}
+
+// One entry per line, either a plaintext password or a SHA-1 hex digest,
+// optionally followed by ":count" as in the pwned passwords dumps.
+func (d *DenyList) read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			d.hashes[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+
+		d.hashes[sha1Hex(strings.ToLower(line))] = struct{}{}
+	}
+
+	return sc.Err()
+}
+
+func (d *DenyList) Contains(password string) bool {
+	if _, ok := d.hashes[sha1Hex(password)]; ok {
+		return true
+	}
+
+	_, ok := d.hashes[sha1Hex(strings.ToLower(password))]
+	return ok
+}
+
+func (d *DenyList) Len() int {
+	return len(d.hashes)
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != sha1.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
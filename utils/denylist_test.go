@@ -0,0 +1,45 @@
+package utils_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/utils"
+)
+
+func TestDenyListHasCommonPasswords(t *testing.T) {
+	t.Parallel()
+
+	d, err := utils.LoadDenyList("")
+	require.NoError(t, err)
+
+	require.True(t, d.Contains("password1234"))
+	require.True(t, d.Contains("PassWord1234"))
+	require.False(t, d.Contains("a perfectly fine passphrase"))
+}
+
+func TestDenyListLoadsFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	contents := "hunter2hunter2\n" +
+		// sha1("goosesareawesome")
+		"21AF429FFD3690A55AF292A187726B7F9C798153:12\n"
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+
+	d, err := utils.LoadDenyList(path)
+	require.NoError(t, err)
+
+	require.True(t, d.Contains("hunter2hunter2"))
+	require.True(t, d.Contains("goosesareawesome"))
+	require.True(t, d.Contains("password1234"))
+}
+
+func TestDenyListMissingFile(t *testing.T) {
+	t.Parallel()
+
+	_, err := utils.LoadDenyList(filepath.Join(t.TempDir(), "nope.txt"))
+	require.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"uwece.ca/app/imaging"
+)
+
+// Raw html in the source is dropped, so the output is safe to embed.
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM),
+	goldmark.WithParserOptions(parser.WithASTTransformers(util.Prioritized(responsiveImages{}, 100))),
+)
+
+func RenderMarkdown(src string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := markdown.Convert([]byte(src), &buf); err != nil {
+		return "", err
+	}
+
+	return template.HTML(buf.String()), nil //nolint:gosec // goldmark escapes raw html by default
+}
+
+// An upload on the blog being served, see services.MediaURL.
+var mediaPath = regexp.MustCompile(`^/media/[0-9a-f]{64}/[^?#]+$`)
+
+// Roughly the widest a theme's content column gets.
+const imageSizes = "(max-width: 48rem) 100vw, 48rem"
+
+// Lets browsers pick a resized copy of uploaded images for their screen, and
+// put off loading the ones out of view.
+type responsiveImages struct{}
+
+func (responsiveImages) Transform(doc *ast.Document, _ text.Reader, _ parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		img, ok := n.(*ast.Image)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+
+		img.SetAttributeString("loading", "lazy")
+
+		dest := string(img.Destination)
+		if !mediaPath.MatchString(dest) {
+			return ast.WalkContinue, nil
+		}
+
+		srcset := make([]string, len(imaging.Widths))
+		for i, w := range imaging.Widths {
+			srcset[i] = fmt.Sprintf("%s?w=%d %dw", dest, w, w)
+		}
+		img.SetAttributeString("srcset", strings.Join(srcset, ", "))
+		img.SetAttributeString("sizes", imageSizes)
+
+		return ast.WalkContinue, nil
+	})
+}
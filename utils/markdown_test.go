@@ -0,0 +1,39 @@
+package utils_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/utils"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	t.Parallel()
+
+	out, err := utils.RenderMarkdown("# Hello\n\nSee [the site](https://uwece.ca).")
+	require.NoError(t, err)
+	require.Contains(t, string(out), "<h1>Hello</h1>")
+	require.Contains(t, string(out), `<a href="https://uwece.ca">the site</a>`)
+}
+
+func TestRenderMarkdownDropsRawHTML(t *testing.T) {
+	t.Parallel()
+
+	out, err := utils.RenderMarkdown("<script>alert(1)</script>\n\n[x](javascript:alert(1))")
+	require.NoError(t, err)
+	require.NotContains(t, string(out), "<script>")
+	require.NotContains(t, string(out), "javascript:")
+}
+
+func TestRenderMarkdownResponsiveImages(t *testing.T) {
+	t.Parallel()
+
+	upload := "/media/" + strings.Repeat("ab", 32) + "/photo.jpg"
+	out, err := utils.RenderMarkdown("![Me](" + upload + ")\n\n![Elsewhere](https://example.com/a.png)")
+	require.NoError(t, err)
+
+	require.Contains(t, string(out), `srcset="`+upload+`?w=480 480w, `+upload+`?w=960 960w, `+upload+`?w=1600 1600w"`)
+	require.Contains(t, string(out), `<img src="https://example.com/a.png" alt="Elsewhere" loading="lazy">`)
+	require.Equal(t, 1, strings.Count(string(out), "srcset"))
+}
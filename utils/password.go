@@ -1,36 +1,113 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/matthewhartstonge/argon2"
 )
 
-var argon = argon2.DefaultConfig()
+var ErrPepperMissing = errors.New("password hash needs a pepper but none is set")
 
-func HashPassword(password string) string {
-	encoded, err := argon.HashEncoded([]byte(password))
+// Marks hashes made with a pepper, so each hash is only ever checked the one
+// way it was made.
+const pepperedPrefix = "peppered:"
+
+type PasswordParams struct {
+	MemoryKiB   uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+func DefaultPasswordParams() PasswordParams {
+	d := argon2.DefaultConfig()
+
+	return PasswordParams{
+		MemoryKiB:   d.MemoryCost,
+		Iterations:  d.TimeCost,
+		Parallelism: d.Parallelism,
+	}
+}
+
+type PasswordHasher struct {
+	argon  argon2.Config
+	pepper []byte
+}
+
+func NewPasswordHasher(params PasswordParams, pepper string) *PasswordHasher {
+	cfg := argon2.DefaultConfig()
+	cfg.MemoryCost = params.MemoryKiB
+	cfg.TimeCost = params.Iterations
+	cfg.Parallelism = params.Parallelism
+
+	return &PasswordHasher{
+		argon:  cfg,
+		pepper: []byte(pepper),
+	}
+}
+
+// Mix the pepper into the password, hashes made without a pepper are left alone.
+func (h *PasswordHasher) season(password string, peppered bool) []byte {
+	if !peppered {
+		return []byte(password)
+	}
+
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+func (h *PasswordHasher) Hash(password string) string {
+	peppered := len(h.pepper) != 0
+	encoded, err := h.argon.HashEncoded(h.season(password, peppered))
 	if err != nil {
 		panic(fmt.Sprintf("password hashing error: %v", err))
 	}
 
+	if peppered {
+		return pepperedPrefix + string(encoded)
+	}
+
 	return string(encoded)
 }
 
-func VerifyPassword(password, hash string) (bool, error) {
-	ok, err := argon2.VerifyEncoded([]byte(password), []byte(hash))
-	if err != nil {
-		return false, err
+// Check a password against its hash. rehash is set when the hash was made with
+// different parameters or without the current pepper, and should be replaced
+// with a fresh Hash of the password.
+func (h *PasswordHasher) Verify(password, hash string) (ok bool, rehash bool, err error) {
+	hash, peppered := strings.CutPrefix(hash, pepperedPrefix)
+	if peppered && len(h.pepper) == 0 {
+		return false, false, ErrPepperMissing
 	}
 
-	return ok, nil
-}
+	raw, err := argon2.Decode([]byte(hash))
+	if err != nil {
+		return false, false, err
+	}
 
-func MustVerifyPassword(password, hash string) bool {
-	ok, err := VerifyPassword(password, hash)
+	ok, err = raw.Verify(h.season(password, peppered))
 	if err != nil {
-		panic(fmt.Sprintf("password verification error: %v", err))
+		return false, false, err
 	}
 
-	return ok
+	// Hashes from before a pepper was configured are replaced with peppered
+	// ones as their owners log in.
+	outdated := h.outdated(raw) || peppered != (len(h.pepper) != 0)
+
+	return ok, ok && outdated, nil
+}
+
+func (h *PasswordHasher) outdated(raw argon2.Raw) bool {
+	c := raw.Config
+
+	return c.Mode != h.argon.Mode ||
+		c.Version != h.argon.Version ||
+		c.MemoryCost != h.argon.MemoryCost ||
+		c.TimeCost != h.argon.TimeCost ||
+		c.Parallelism != h.argon.Parallelism ||
+		c.HashLength != h.argon.HashLength ||
+		uint32(len(raw.Salt)) < h.argon.SaltLength
 }
@@ -7,12 +7,76 @@ import (
 	"uwece.ca/app/utils"
 )
 
-func TestPasswordHashing(t *testing.T) {
+var cheapParams = utils.PasswordParams{MemoryKiB: 1024, Iterations: 1, Parallelism: 1}
+
+func TestPasswordHasherVerifies(t *testing.T) {
+	t.Parallel()
+
+	h := utils.NewPasswordHasher(cheapParams, "pepper")
+	hash := h.Hash("hello_123453124")
+
+	ok, rehash, err := h.Verify("hello_123453124", hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.False(t, rehash)
+
+	ok, _, err = h.Verify("wrong_password", hash)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestPasswordHasherWantsRehashOnNewParams(t *testing.T) {
+	t.Parallel()
+
+	hash := utils.NewPasswordHasher(cheapParams, "").Hash("hello_123453124")
+
+	stronger := cheapParams
+	stronger.Iterations = 2
+	ok, rehash, err := utils.NewPasswordHasher(stronger, "").Verify("hello_123453124", hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, rehash)
+}
+
+func TestPasswordHasherMigratesUnpepperedHashes(t *testing.T) {
 	t.Parallel()
 
-	password := "hello_123453124"
+	hash := utils.NewPasswordHasher(cheapParams, "").Hash("hello_123453124")
+
+	h := utils.NewPasswordHasher(cheapParams, "pepper")
+	ok, rehash, err := h.Verify("hello_123453124", hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, rehash)
+
+	ok, rehash, err = h.Verify("wrong_password", hash)
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.False(t, rehash)
+
+	// Rehashed, the password only verifies with the pepper.
+	hash = h.Hash("hello_123453124")
+	ok, rehash, err = h.Verify("hello_123453124", hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.False(t, rehash)
+
+	ok, _, err = utils.NewPasswordHasher(cheapParams, "other").Verify("hello_123453124", hash)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, _, err = utils.NewPasswordHasher(cheapParams, "").Verify("hello_123453124", hash)
+	require.ErrorIs(t, err, utils.ErrPepperMissing)
+}
+
+func TestPasswordHasherReadsDefaultHashes(t *testing.T) {
+	t.Parallel()
 
-	hash := utils.HashPassword(password)
+	h := utils.NewPasswordHasher(utils.DefaultPasswordParams(), "")
+	hash := h.Hash("hello_123453124")
 
-	require.True(t, utils.MustVerifyPassword(password, hash))
+	ok, rehash, err := h.Verify("hello_123453124", hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.False(t, rehash)
 }
@@ -0,0 +1,41 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"uwece.ca/app/utils"
+)
+
+func TestSignerRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	s := utils.NewSigner("secret")
+
+	signed := s.Sign("unsubscribe", "42")
+	value, ok := s.Verify("unsubscribe", signed)
+	require.True(t, ok)
+	require.Equal(t, "42", value)
+}
+
+func TestSignerRejectsTampering(t *testing.T) {
+	t.Parallel()
+
+	s := utils.NewSigner("secret")
+	signed := s.Sign("unsubscribe", "42")
+
+	_, ok := s.Verify("unsubscribe", "43"+signed[2:])
+	require.False(t, ok)
+
+	_, ok = s.Verify("something-else", signed)
+	require.False(t, ok)
+
+	_, ok = utils.NewSigner("other secret").Verify("unsubscribe", signed)
+	require.False(t, ok)
+
+	_, ok = s.Verify("unsubscribe", "42")
+	require.False(t, ok)
+
+	_, ok = s.Verify("unsubscribe", "42.!!")
+	require.False(t, ok)
+}
@@ -69,3 +69,51 @@ func DeleteSession(w http.ResponseWriter) {
 
 	http.SetCookie(w, cookie)
 }
+
+const (
+	flowCookieName = "uwececa_auth_flow_v1"
+	flowExpiry     = 10 * time.Minute
+)
+
+// Remember an in-progress external login between the redirect out and the callback.
+// This has to be lax, the callback is a cross site navigation.
+func AddAuthFlow(w http.ResponseWriter, value string) {
+	cookie := &http.Cookie{
+		Name:   flowCookieName,
+		Value:  value,
+		Quoted: false,
+
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		Secure:   true,
+		HttpOnly: true,
+		Expires:  time.Now().Add(flowExpiry),
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+func GetAuthFlow(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(flowCookieName)
+	if err != nil {
+		return "", false
+	}
+
+	return cookie.Value, true
+}
+
+func DeleteAuthFlow(w http.ResponseWriter) {
+	cookie := &http.Cookie{
+		Name:   flowCookieName,
+		Value:  "deleted",
+		Quoted: false,
+
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		Expires:  time.Now(),
+	}
+
+	http.SetCookie(w, cookie)
+}